@@ -0,0 +1,599 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// formatAAB is the Format of builds uploaded as Android App Bundles. A
+// bundle cannot be installed itself; when bundletool is configured, a
+// universal APK is generated from it for testers.
+const formatAAB = "aab"
+
+// Paths inside an App Bundle. The manifest and the resource table of the
+// base module are stored in aapt2's protobuf format rather than binary XML.
+const (
+	bundleManifestPath  = "base/manifest/AndroidManifest.xml"
+	bundleResourcesPath = "base/resources.pb"
+)
+
+// bundleMaxProtoSize bounds the manifest and resource table read into memory
+const bundleMaxProtoSize = 32 << 20
+
+// bundletoolTimeout bounds one universal APK build
+const bundletoolTimeout = 10 * time.Minute
+
+// universalAPKSuffix replaces the .aab extension in the stored name of the
+// universal APK generated from a bundle
+const universalAPKSuffix = "-universal.apk"
+
+// isAppBundle reports whether the zip archive at path is an Android App
+// Bundle, recognized by its protobuf base manifest
+func isAppBundle(path string) bool {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return false
+	}
+	defer archive.Close()
+	for _, file := range archive.File {
+		if file.Name == bundleManifestPath {
+			return true
+		}
+	}
+	return false
+}
+
+// packageExt returns the extension of the stored file of a build
+func packageExt(details ApkDetails) string {
+	switch {
+	case details.Format == formatAAB:
+		return ".aab"
+	case details.Platform == platformHarmony && details.Format == formatHarmonyApp:
+		return ".app"
+	}
+	return platformExt(details.Platform)
+}
+
+// universalAPKName returns the stored name of the universal APK of the
+// bundle stored as bundleFileName
+func universalAPKName(bundleFileName string) string {
+	return strings.TrimSuffix(bundleFileName, filepath.Ext(bundleFileName)) + universalAPKSuffix
+}
+
+// installableURL returns the download URL testers install a build from:
+// the universal APK of a bundle when one was generated
+func installableURL(build BuildInfo) string {
+	if build.UniversalAPK != "" {
+		return "/downloads/" + build.UniversalAPK
+	}
+	return build.DownloadURL
+}
+
+// protoXMLElement is an element of a manifest in aapt2's XmlNode format
+type protoXMLElement struct {
+	name     string
+	attrs    []protoXMLAttr
+	children []protoXMLElement
+}
+
+// protoXMLAttr is an attribute of a protoXMLElement. Value is the source
+// text; compiled references and integers are kept besides it.
+type protoXMLAttr struct {
+	name   string
+	value  string
+	ref    uint32 // resource ID of a reference such as @string/app_name
+	number int64
+	isInt  bool
+}
+
+// attr returns the attribute called name, ignoring its namespace
+func (e *protoXMLElement) attr(name string) (protoXMLAttr, bool) {
+	for _, a := range e.attrs {
+		if a.name == name {
+			return a, true
+		}
+	}
+	return protoXMLAttr{}, false
+}
+
+// child returns the first child element called name
+func (e *protoXMLElement) child(name string) (*protoXMLElement, bool) {
+	for i := range e.children {
+		if e.children[i].name == name {
+			return &e.children[i], true
+		}
+	}
+	return nil, false
+}
+
+// intValue returns the attribute as an integer, from its text or its
+// compiled value
+func (a protoXMLAttr) intValue() (int64, bool) {
+	if n, err := strconv.ParseInt(strings.TrimSpace(a.value), 0, 64); err == nil {
+		return n, true
+	}
+	return a.number, a.isInt
+}
+
+// walkProto calls fn for every field of the protobuf message b. Length
+// delimited fields pass their bytes, varints their value.
+func walkProto(b []byte, fn func(num protowire.Number, data []byte, value uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var err error
+		switch typ {
+		case protowire.BytesType:
+			var data []byte
+			data, n = protowire.ConsumeBytes(b)
+			if n >= 0 {
+				err = fn(num, data, 0)
+			}
+		case protowire.VarintType:
+			var value uint64
+			value, n = protowire.ConsumeVarint(b)
+			if n >= 0 {
+				err = fn(num, nil, value)
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// decodeProtoXMLNode decodes an XmlNode; text nodes yield a nil element
+func decodeProtoXMLNode(b []byte) (*protoXMLElement, error) {
+	var element *protoXMLElement
+	err := walkProto(b, func(num protowire.Number, data []byte, _ uint64) error {
+		if num != 1 { // XmlNode.element
+			return nil
+		}
+		element = &protoXMLElement{}
+		return decodeProtoXMLElement(data, element)
+	})
+	return element, err
+}
+
+func decodeProtoXMLElement(b []byte, element *protoXMLElement) error {
+	return walkProto(b, func(num protowire.Number, data []byte, _ uint64) error {
+		switch num {
+		case 3: // XmlElement.name
+			element.name = string(data)
+		case 4: // XmlElement.attribute
+			attr, err := decodeProtoXMLAttr(data)
+			if err != nil {
+				return err
+			}
+			element.attrs = append(element.attrs, attr)
+		case 5: // XmlElement.child
+			child, err := decodeProtoXMLNode(data)
+			if err != nil {
+				return err
+			}
+			if child != nil {
+				element.children = append(element.children, *child)
+			}
+		}
+		return nil
+	})
+}
+
+func decodeProtoXMLAttr(b []byte) (protoXMLAttr, error) {
+	var attr protoXMLAttr
+	err := walkProto(b, func(num protowire.Number, data []byte, _ uint64) error {
+		switch num {
+		case 2: // XmlAttribute.name
+			attr.name = string(data)
+		case 3: // XmlAttribute.value
+			attr.value = string(data)
+		case 6: // XmlAttribute.compiled_item
+			return walkProto(data, func(num protowire.Number, data []byte, _ uint64) error {
+				switch num {
+				case 1: // Item.ref
+					return walkProto(data, func(num protowire.Number, _ []byte, value uint64) error {
+						if num == 2 { // Reference.id
+							attr.ref = uint32(value)
+						}
+						return nil
+					})
+				case 7: // Item.prim
+					return walkProto(data, func(num protowire.Number, _ []byte, value uint64) error {
+						if num == 6 || num == 7 { // int_decimal_value, int_hexadecimal_value
+							attr.number, attr.isInt = int64(int32(value)), true
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return attr, err
+}
+
+// bundleResource is one configuration's value of a resource in the
+// resource table of a bundle: a string or a file path
+type bundleResource struct {
+	locale string
+	str    string
+	file   string // relative to the module, e.g. res/mipmap-xxhdpi-v4/ic_launcher.png
+}
+
+// decodeBundleResources indexes the string and file values of a
+// ResourceTable by resource ID
+func decodeBundleResources(b []byte) (map[uint32][]bundleResource, error) {
+	resources := map[uint32][]bundleResource{}
+	err := walkProto(b, func(num protowire.Number, pkg []byte, _ uint64) error {
+		if num != 2 { // ResourceTable.package
+			return nil
+		}
+		var packageID uint32
+		var types [][]byte
+		err := walkProto(pkg, func(num protowire.Number, data []byte, _ uint64) error {
+			switch num {
+			case 1: // Package.package_id
+				packageID = protoID(data)
+			case 3: // Package.type
+				types = append(types, data)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, typ := range types {
+			if err := decodeBundleType(typ, packageID, resources); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return resources, err
+}
+
+func decodeBundleType(b []byte, packageID uint32, resources map[uint32][]bundleResource) error {
+	var typeID uint32
+	var entries [][]byte
+	err := walkProto(b, func(num protowire.Number, data []byte, _ uint64) error {
+		switch num {
+		case 1: // Type.type_id
+			typeID = protoID(data)
+		case 3: // Type.entry
+			entries = append(entries, data)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		var entryID uint32
+		var values []bundleResource
+		err := walkProto(entry, func(num protowire.Number, data []byte, _ uint64) error {
+			switch num {
+			case 1: // Entry.entry_id
+				entryID = protoID(data)
+			case 6: // Entry.config_value
+				value, err := decodeBundleConfigValue(data)
+				if err == nil && (value.str != "" || value.file != "") {
+					values = append(values, value)
+				}
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		resources[packageID<<24|typeID<<16|entryID] = values
+	}
+	return nil
+}
+
+// decodeBundleConfigValue reads the locale of a ConfigValue and its String
+// or FileReference item
+func decodeBundleConfigValue(b []byte) (bundleResource, error) {
+	var value bundleResource
+	err := walkProto(b, func(num protowire.Number, data []byte, _ uint64) error {
+		switch num {
+		case 1: // ConfigValue.config
+			return walkProto(data, func(num protowire.Number, data []byte, _ uint64) error {
+				if num == 3 { // Configuration.locale
+					value.locale = string(data)
+				}
+				return nil
+			})
+		case 2: // ConfigValue.value
+			return walkProto(data, func(num protowire.Number, data []byte, _ uint64) error {
+				if num != 4 { // Value.item
+					return nil
+				}
+				return walkProto(data, func(num protowire.Number, data []byte, _ uint64) error {
+					field := &value.str
+					switch num {
+					case 2, 3: // Item.str, Item.raw_str
+					case 5: // Item.file
+						field = &value.file
+					default:
+						return nil
+					}
+					return walkProto(data, func(num protowire.Number, data []byte, _ uint64) error {
+						if num == 1 { // String.value, RawString.value, FileReference.path
+							*field = string(data)
+						}
+						return nil
+					})
+				})
+			})
+		}
+		return nil
+	})
+	return value, err
+}
+
+// protoID reads the id field of the PackageId, TypeId and EntryId messages
+func protoID(b []byte) uint32 {
+	var id uint32
+	walkProto(b, func(num protowire.Number, _ []byte, value uint64) error {
+		if num == 1 {
+			id = uint32(value)
+		}
+		return nil
+	})
+	return id
+}
+
+// readBundleEntry reads a file of the bundle into memory
+func readBundleEntry(archive *zip.ReadCloser, name string) ([]byte, error) {
+	for _, file := range archive.File {
+		if file.Name != name {
+			continue
+		}
+		if file.UncompressedSize64 > bundleMaxProtoSize {
+			return nil, fmt.Errorf("%s 过大", name)
+		}
+		r, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(io.LimitReader(r, bundleMaxProtoSize))
+	}
+	return nil, fmt.Errorf("未找到 %s: %w", name, os.ErrNotExist)
+}
+
+// openBundle opens the bundle at path and decodes its base manifest and,
+// when present, its resource table
+func openBundle(bundlePath string) (*zip.ReadCloser, *protoXMLElement, map[uint32][]bundleResource, error) {
+	archive, err := zip.OpenReader(bundlePath)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("解析AAB失败: %w", err)
+	}
+	data, err := readBundleEntry(archive, bundleManifestPath)
+	if err != nil {
+		archive.Close()
+		return nil, nil, nil, fmt.Errorf("解析AAB失败: %w", err)
+	}
+	manifest, err := decodeProtoXMLNode(data)
+	if err != nil || manifest == nil || manifest.name != "manifest" {
+		archive.Close()
+		return nil, nil, nil, fmt.Errorf("解析AAB的 AndroidManifest.xml 失败: %v", err)
+	}
+	resources := map[uint32][]bundleResource{}
+	if data, err := readBundleEntry(archive, bundleResourcesPath); err == nil {
+		if resources, err = decodeBundleResources(data); err != nil {
+			archive.Close()
+			return nil, nil, nil, fmt.Errorf("解析AAB的 resources.pb 失败: %w", err)
+		}
+	}
+	return archive, manifest, resources, nil
+}
+
+// bundleString resolves a manifest attribute to text: its literal value, or
+// the default-locale value of the string resource it references
+func bundleString(attr protoXMLAttr, resources map[uint32][]bundleResource) string {
+	if attr.ref == 0 {
+		if strings.HasPrefix(attr.value, "@") {
+			return ""
+		}
+		return strings.TrimSpace(attr.value)
+	}
+	var fallback string
+	for _, value := range resources[attr.ref] {
+		if value.str == "" {
+			continue
+		}
+		if value.locale == "" {
+			return value.str
+		}
+		if fallback == "" {
+			fallback = value.str
+		}
+	}
+	return fallback
+}
+
+// parseBundleDetails reads the manifest fields the catalog relies on from
+// the base module of an App Bundle, like extractApkDetails does for APKs.
+// A label that cannot be resolved falls back to the package name.
+func parseBundleDetails(bundlePath string) (ApkDetails, error) {
+	archive, manifest, resources, err := openBundle(bundlePath)
+	if err != nil {
+		return ApkDetails{}, err
+	}
+	archive.Close()
+
+	packageAttr, _ := manifest.attr("package")
+	packageName := strings.TrimSpace(packageAttr.value)
+	if packageName == "" {
+		return ApkDetails{}, errors.New("解析AAB包名失败或包名为空")
+	}
+	versionName, _ := manifest.attr("versionName")
+	version := bundleString(versionName, resources)
+	if version == "" {
+		return ApkDetails{}, errors.New("解析AAB版本名失败或版本名为空")
+	}
+	versionCodeAttr, _ := manifest.attr("versionCode")
+	versionCode, ok := versionCodeAttr.intValue()
+	if !ok || versionCode < 0 || versionCode > 1<<31-1 {
+		return ApkDetails{}, errors.New("解析AAB版本号(versionCode)失败")
+	}
+
+	details := ApkDetails{
+		Format:      formatAAB,
+		PackageName: packageName,
+		Version:     version,
+		VersionCode: int32(versionCode),
+	}
+	if sdk, ok := manifest.child("uses-sdk"); ok {
+		if minSDK, ok := sdk.attr("minSdkVersion"); ok {
+			if n, ok := minSDK.intValue(); ok {
+				details.MinSDK = int32(n)
+			}
+		}
+		details.TargetSDK = details.MinSDK
+		if targetSDK, ok := sdk.attr("targetSdkVersion"); ok {
+			if n, ok := targetSDK.intValue(); ok {
+				details.TargetSDK = int32(n)
+			}
+		}
+	}
+	for _, child := range manifest.children {
+		if child.name != "uses-permission" {
+			continue
+		}
+		if name, ok := child.attr("name"); ok && name.value != "" {
+			details.Permissions = append(details.Permissions, name.value)
+		}
+	}
+	if application, ok := manifest.child("application"); ok {
+		if label, ok := application.attr("label"); ok {
+			details.AppName = bundleString(label, resources)
+		}
+	}
+	if details.AppName == "" {
+		details.AppName = packageName
+	}
+	return details, nil
+}
+
+// bundleIcon returns the largest PNG of the icon resource of a bundle's
+// application. Adaptive icons defined only in XML have no PNG to show.
+func bundleIcon(bundlePath string) (image.Image, error) {
+	archive, manifest, resources, err := openBundle(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	application, ok := manifest.child("application")
+	if !ok {
+		return nil, errors.New("AAB 未声明 application")
+	}
+	icon, ok := application.attr("icon")
+	if !ok || icon.ref == 0 {
+		return nil, errors.New("AAB 未声明图标")
+	}
+	files := map[string]bool{}
+	for _, value := range resources[icon.ref] {
+		if strings.HasSuffix(strings.ToLower(value.file), ".png") {
+			files["base/"+value.file] = true
+		}
+	}
+	var best *zip.File
+	for _, file := range archive.File {
+		if files[file.Name] && file.UncompressedSize64 <= ipaMaxIconSize &&
+			(best == nil || file.UncompressedSize64 > best.UncompressedSize64) {
+			best = file
+		}
+	}
+	if best == nil {
+		return nil, errors.New("AAB 图标没有 PNG 版本")
+	}
+	r, err := best.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return png.Decode(io.LimitReader(r, ipaMaxIconSize))
+}
+
+// buildUniversalAPK runs bundletool to build a universal APK from the bundle
+// at bundlePath and returns its path in the incoming directory; the caller
+// removes it. Extra arguments such as the signing key come from
+// config.BundletoolArgs.
+func buildUniversalAPK(c *gin.Context, bundlePath string) (string, error) {
+	if err := os.MkdirAll(config.IncomingDir, 0755); err != nil {
+		return "", err
+	}
+	workDir, err := os.MkdirTemp(config.IncomingDir, "bundletool-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(workDir)
+	apksPath := filepath.Join(workDir, "universal.apks")
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), bundletoolTimeout)
+	defer cancel()
+	args := append([]string{"-jar", config.BundletoolJar, "build-apks",
+		"--bundle=" + bundlePath, "--output=" + apksPath, "--mode=universal"}, config.BundletoolArgs...)
+	if output, err := exec.CommandContext(ctx, "java", args...).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("bundletool 执行失败: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	apks, err := zip.OpenReader(apksPath)
+	if err != nil {
+		return "", err
+	}
+	defer apks.Close()
+	for _, file := range apks.File {
+		if file.Name != "universal.apk" {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		dst, err := os.CreateTemp(config.IncomingDir, "universal-*.apk")
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(dst, r)
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(dst.Name())
+			return "", err
+		}
+		return dst.Name(), nil
+	}
+	return "", errors.New("bundletool 输出中没有 universal.apk")
+}
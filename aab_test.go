@@ -0,0 +1,176 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protoField encodes a length-delimited field, protoVarintField a varint one
+func protoField(num protowire.Number, fields ...[]byte) []byte {
+	b := protowire.AppendTag(nil, num, protowire.BytesType)
+	return protowire.AppendBytes(b, bytes.Join(fields, nil))
+}
+
+func protoVarintField(num protowire.Number, value uint64) []byte {
+	return protowire.AppendVarint(protowire.AppendTag(nil, num, protowire.VarintType), value)
+}
+
+// protoAttr encodes an XmlAttribute with a literal value, or a reference
+// to resource ref
+func protoAttr(name, value string, ref uint32) []byte {
+	attr := [][]byte{protoField(2, []byte(name)), protoField(3, []byte(value))}
+	if ref != 0 {
+		attr = append(attr, protoField(6, protoField(1, protoVarintField(2, uint64(ref)))))
+	}
+	return protoField(4, attr...)
+}
+
+// protoElement encodes an XmlNode holding an element
+func protoElement(name string, parts ...[]byte) []byte {
+	return protoField(1, append([][]byte{protoField(3, []byte(name))}, parts...)...)
+}
+
+// fixtureBundle builds a minimal App Bundle of the fixture package: a
+// protobuf manifest whose label and icon reference the resource table
+func fixtureBundle(t *testing.T) []byte {
+	t.Helper()
+	const labelID, iconID = 0x7f010000, 0x7f020000
+	manifest := protoElement("manifest",
+		protoAttr("package", fixturePackage, 0),
+		protoAttr("versionCode", "7", 0),
+		protoAttr("versionName", "2.0", 0),
+		protoField(5, protoElement("uses-sdk", protoAttr("minSdkVersion", "24", 0), protoAttr("targetSdkVersion", "34", 0))),
+		protoField(5, protoElement("uses-permission", protoAttr("name", "android.permission.INTERNET", 0))),
+		protoField(5, protoElement("application",
+			protoAttr("label", "@string/app_name", labelID),
+			protoAttr("icon", "@mipmap/ic_launcher", iconID))),
+	)
+	configValue := func(locale string, item []byte) []byte {
+		return protoField(6, protoField(1, protoField(3, []byte(locale))), protoField(2, protoField(4, item)))
+	}
+	entry := func(id uint32, values ...[]byte) []byte {
+		return protoField(3, append([][]byte{protoField(1, protoVarintField(1, uint64(id)))}, values...)...)
+	}
+	resources := protoField(2,
+		protoField(1, protoVarintField(1, 0x7f)),
+		protoField(3, protoField(1, protoVarintField(1, 1)), protoField(2, []byte("string")),
+			entry(0, configValue("zh", protoField(2, protoField(1, []byte("你好")))),
+				configValue("", protoField(2, protoField(1, []byte("Hello Bundle")))))),
+		protoField(3, protoField(1, protoVarintField(1, 2)), protoField(2, []byte("mipmap")),
+			entry(0, configValue("", protoField(5, protoField(1, []byte("res/mipmap-xxhdpi-v4/ic_launcher.png")))))),
+	)
+
+	var icon bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 48, 48))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	img.Set(0, 0, color.RGBA{R: 0xff, A: 0xff})
+	png.Encode(&icon, img)
+
+	var out bytes.Buffer
+	w := zip.NewWriter(&out)
+	for name, data := range map[string][]byte{
+		bundleManifestPath:                          manifest,
+		bundleResourcesPath:                         resources,
+		"base/res/mipmap-xxhdpi-v4/ic_launcher.png": icon.Bytes(),
+		"base/dex/classes.dex":                      []byte("dex\n035"),
+	} {
+		f, _ := w.Create(name)
+		f.Write(data)
+	}
+	w.Close()
+	return out.Bytes()
+}
+
+func TestParseBundleDetails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.aab")
+	if err := os.WriteFile(path, fixtureBundle(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !isAppBundle(path) {
+		t.Fatal("fixture not recognized as a bundle")
+	}
+	details, err := parseBundleDetails(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.PackageName != fixturePackage || details.AppName != "Hello Bundle" || details.Version != "2.0" ||
+		details.VersionCode != 7 || details.MinSDK != 24 || details.TargetSDK != 34 || details.Format != formatAAB ||
+		len(details.Permissions) != 1 || details.Permissions[0] != "android.permission.INTERNET" {
+		t.Errorf("details = %+v", details)
+	}
+	if icon, err := bundleIcon(path); err != nil || icon.Bounds().Dx() != 48 {
+		t.Errorf("icon: %v", err)
+	}
+}
+
+func TestUploadBundle(t *testing.T) {
+	router := setupTestServer(t)
+
+	// Without bundletool the bundle is stored for download only
+	if rec := uploadFile(router, "app-release.aab", fixtureBundle(t), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	build := appBuilds(t, router, fixturePackage)[0]
+	if build.Format != formatAAB || !strings.HasSuffix(build.FileName, ".aab") || build.UniversalAPK != "" {
+		t.Fatalf("build = %+v", build)
+	}
+	if rec := serve(router, http.MethodGet, "/api/check-update?packageName="+fixturePackage+"&versionCode=1"); rec.Code != http.StatusNotFound {
+		t.Errorf("update check offered a bundle: status %d", rec.Code)
+	}
+
+	// A stand-in for java copies a prepared .apks archive to --output
+	dir := t.TempDir()
+	var apks bytes.Buffer
+	w := zip.NewWriter(&apks)
+	f, _ := w.Create("universal.apk")
+	f.Write(fixtureAPK(t))
+	w.Close()
+	apksPath := filepath.Join(dir, "fixture.apks")
+	os.WriteFile(apksPath, apks.Bytes(), 0644)
+	script := "#!/bin/sh\nfor arg; do case $arg in --output=*) cp \"$FIXTURE_APKS\" \"${arg#--output=}\";; esac; done\n"
+	if err := os.WriteFile(filepath.Join(dir, "java"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FIXTURE_APKS", apksPath)
+	config.BundletoolJar = "bundletool.jar"
+	// The fixture may hash the same as the first upload; store it again
+	// rather than referencing the stored bundle
+	config.DuplicateUploads = duplicateStore
+
+	if rec := uploadFile(router, "app-release.aab", fixtureBundle(t), "Demo", "beta"); rec.Code != http.StatusOK {
+		t.Fatalf("upload with bundletool: status %d: %s", rec.Code, rec.Body.String())
+	}
+	for _, b := range appBuilds(t, router, fixturePackage) {
+		if b.Channel == "beta" {
+			build = b
+		}
+	}
+	if build.UniversalAPK != universalAPKName(build.FileName) {
+		t.Fatalf("build = %+v", build)
+	}
+	rec := serve(router, http.MethodGet, "/api/apps/"+fixturePackage+"/install?fileName="+build.FileName)
+	if rec.Code != http.StatusFound || !strings.HasSuffix(rec.Header().Get("Location"), build.UniversalAPK) {
+		t.Errorf("install: status %d, Location %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// Deleting the build removes the universal APK too
+	if rec := serveAdmin(router, http.MethodDelete, "/api/builds/"+fixturePackage+"/"+build.FileName); rec.Code != http.StatusOK {
+		t.Fatalf("delete: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(config.UploadDir, build.UniversalAPK)); !os.IsNotExist(err) {
+		t.Errorf("universal APK left behind: %v", err)
+	}
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles of a member in an organization or project, from least to most
+// privileged: viewers see the project, uploaders also upload into it and
+// owners also delete builds and manage its members
+const (
+	roleViewer   = "viewer"
+	roleUploader = "uploader"
+	roleOwner    = "owner"
+)
+
+// minUserPasswordLen is the shortest user password accepted
+const minUserPasswordLen = 8
+
+// accountNamePattern restricts user and organization names, which appear
+// in URLs
+var accountNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// roleRank orders the roles; unknown roles rank 0 and grant nothing
+func roleRank(role string) int {
+	switch role {
+	case roleViewer:
+		return 1
+	case roleUploader:
+		return 2
+	case roleOwner:
+		return 3
+	}
+	return 0
+}
+
+// Member is a user's role in an organization or project
+type Member struct {
+	User string `json:"user"`
+	Role string `json:"role"`
+}
+
+// User is an account that logs in with its own password under
+// APPDIST_MULTI_TENANT. Only the bcrypt hash of the password is stored.
+type User struct {
+	Name         string `json:"name"`
+	PasswordHash string `json:"passwordHash,omitempty"`
+	CreatedAt    string `json:"createdAt"`
+}
+
+// Organization groups users; its members hold their role in every project
+// assigned to it, see Project.Organization
+type Organization struct {
+	Name      string   `json:"name"`
+	Members   []Member `json:"members"`
+	CreatedAt string   `json:"createdAt"`
+}
+
+// accountStore persists users and organizations in a JSON file next to the
+// metadata, with its own lock like tokenStore. It never takes the catalog
+// mutex, so it may be used while holding it.
+type accountStore struct {
+	mu            sync.Mutex
+	path          string
+	Users         []User         `json:"users"`
+	Organizations []Organization `json:"organizations"`
+}
+
+var accounts = &accountStore{}
+
+// load reads the account file at path; a missing file starts empty.
+func (s *accountStore) load(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	s.Users = []User{}
+	s.Organizations = []Organization{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, s)
+}
+
+// save writes the accounts to disk, readable by the owner only. The caller
+// must hold s.mu.
+func (s *accountStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	if err := writeFileSync(tmpPath, data); err != nil {
+		return err
+	}
+	os.Chmod(tmpPath, 0600)
+	return os.Rename(tmpPath, s.path)
+}
+
+// update applies change to the accounts and saves them, restoring the
+// previous state when change or the save fails
+func (s *accountStore) update(change func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := slices.Clone(s.Users)
+	organizations := slices.Clone(s.Organizations)
+	for i := range organizations {
+		organizations[i].Members = slices.Clone(organizations[i].Members)
+	}
+	err := change()
+	if err == nil {
+		err = s.save()
+	}
+	if err != nil {
+		s.Users, s.Organizations = users, organizations
+	}
+	return err
+}
+
+// findUser returns the index of the named user, or -1. The caller must hold s.mu.
+func (s *accountStore) findUser(name string) int {
+	return slices.IndexFunc(s.Users, func(user User) bool { return user.Name == name })
+}
+
+// findOrganization returns the index of the named organization, or -1. The
+// caller must hold s.mu.
+func (s *accountStore) findOrganization(name string) int {
+	return slices.IndexFunc(s.Organizations, func(org Organization) bool { return org.Name == name })
+}
+
+// authenticate reports whether name is a user with password
+func (s *accountStore) authenticate(name, password string) bool {
+	if name == "" || password == "" {
+		return false
+	}
+	s.mu.Lock()
+	var hash string
+	if i := s.findUser(name); i >= 0 {
+		hash = s.Users[i].PasswordHash
+	}
+	s.mu.Unlock()
+	return hash != "" && bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// exists reports whether name is a user
+func (s *accountStore) exists(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.findUser(name) >= 0
+}
+
+// organizationRole returns the role of user in the organization orgName,
+// "" when the user is not a member
+func (s *accountStore) organizationRole(orgName, user string) string {
+	if orgName == "" || user == "" {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.findOrganization(orgName)
+	if i < 0 {
+		return ""
+	}
+	return memberRole(s.Organizations[i].Members, user)
+}
+
+// memberRole returns the role of user among members, "" when absent
+func memberRole(members []Member, user string) string {
+	for _, member := range members {
+		if member.User == user {
+			return member.Role
+		}
+	}
+	return ""
+}
+
+// setMember adds user to members with role, or changes the role of an
+// existing member
+func setMember(members []Member, user, role string) []Member {
+	for i := range members {
+		if members[i].User == user {
+			members[i].Role = role
+			return members
+		}
+	}
+	return append(members, Member{User: user, Role: role})
+}
+
+// removeMember returns members without user and whether it was listed
+func removeMember(members []Member, user string) ([]Member, bool) {
+	i := slices.IndexFunc(members, func(member Member) bool { return member.User == user })
+	if i < 0 {
+		return members, false
+	}
+	return slices.Delete(slices.Clone(members), i, i+1), true
+}
+
+// bindMemberRole reads {"role": "..."} from the request, writing a 400
+// response when it is missing or unknown
+func bindMemberRole(c *gin.Context) (string, bool) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || roleRank(strings.TrimSpace(req.Role)) == 0 {
+		respondError(c, http.StatusBadRequest, "请求体格式错误，role 只能为 owner、uploader 或 viewer")
+		return "", false
+	}
+	return strings.TrimSpace(req.Role), true
+}
+
+// handleListUsers serves GET /api/admin/users, without password hashes
+func handleListUsers(c *gin.Context) {
+	accounts.mu.Lock()
+	users := make([]User, len(accounts.Users))
+	for i, user := range accounts.Users {
+		user.PasswordHash = ""
+		users[i] = user
+	}
+	accounts.mu.Unlock()
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+// handleCreateUser serves POST /api/admin/users with {"name": "...",
+// "password": "..."}; posting an existing name resets its password.
+func handleCreateUser(c *gin.Context) {
+	var req struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "请求体格式错误，需要 name 和 password 字段")
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if !accountNamePattern.MatchString(name) {
+		respondError(c, http.StatusBadRequest, "用户名只能包含字母、数字、点、下划线和连字符，且不超过 64 个字符")
+		return
+	}
+	if len(req.Password) < minUserPasswordLen {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("用户密码至少需要 %d 个字符", minUserPasswordLen))
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "无法设置该密码: "+err.Error())
+		return
+	}
+
+	created := false
+	err = accounts.update(func() error {
+		if i := accounts.findUser(name); i >= 0 {
+			accounts.Users[i].PasswordHash = string(hash)
+			return nil
+		}
+		created = true
+		accounts.Users = append(accounts.Users, User{Name: name, PasswordHash: string(hash), CreatedAt: timestamp(time.Now())})
+		return nil
+	})
+	if err != nil {
+		warnf(c, "保存用户 %s 失败: %v", name, err)
+		respondError(c, http.StatusInternalServerError, "保存用户失败")
+		return
+	}
+	status, detail := http.StatusOK, "password-reset"
+	if created {
+		status, detail = http.StatusCreated, "created"
+		logf(c, "已创建用户 %s", name)
+	} else {
+		// Sessions opened with the old password end
+		sessions.removeUser(name)
+		logf(c, "用户 %s 的密码已重置", name)
+	}
+	audit.record(c, AuditEntry{Action: auditSetUser, Detail: name + " " + detail})
+	c.JSON(status, gin.H{"name": name, "created": created})
+}
+
+// handleDeleteUser serves DELETE /api/admin/users/:name, ending the user's
+// sessions and removing it from every organization and project
+func handleDeleteUser(c *gin.Context) {
+	name := c.Param("name")
+	found := false
+	err := accounts.update(func() error {
+		i := accounts.findUser(name)
+		if i < 0 {
+			return nil
+		}
+		found = true
+		accounts.Users = slices.Delete(accounts.Users, i, i+1)
+		for j := range accounts.Organizations {
+			accounts.Organizations[j].Members, _ = removeMember(accounts.Organizations[j].Members, name)
+		}
+		return nil
+	})
+	if err != nil {
+		warnf(c, "删除用户 %s 失败: %v", name, err)
+		respondError(c, http.StatusInternalServerError, "删除用户失败")
+		return
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, "用户未找到")
+		return
+	}
+	sessions.removeUser(name)
+
+	for _, project := range repo.GetAll() {
+		if _, member := removeMember(project.Members, name); !member {
+			continue
+		}
+		_, err := repo.UpdateProject(project.ProjectName, func(project *Project) error {
+			project.Members, _ = removeMember(project.Members, name)
+			return nil
+		})
+		if err != nil && !errors.Is(err, errProjectNotFound) {
+			// The user is gone already, so its leftover memberships grant nothing
+			warnf(c, "移除用户 %s 在项目 %s 的成员身份失败: %v", name, project.ProjectName, err)
+		}
+	}
+
+	logf(c, "已删除用户 %s", name)
+	audit.record(c, AuditEntry{Action: auditSetUser, Detail: name + " deleted"})
+	c.JSON(http.StatusOK, gin.H{"message": "用户已删除"})
+}
+
+// OrganizationInfo is an organization as listed by GET /api/organizations
+type OrganizationInfo struct {
+	Organization
+	Projects []string `json:"projects"`
+	// Role is the role of the requesting user, empty for the admin
+	Role string `json:"role,omitempty"`
+}
+
+// handleListOrganizations serves GET /api/organizations: every organization
+// for the admin, the ones the user belongs to otherwise
+func handleListOrganizations(c *gin.Context) {
+	a := requestAccess(c)
+	if !a.admin && a.user == "" {
+		respondError(c, http.StatusUnauthorized, "需要登录")
+		return
+	}
+	accounts.mu.Lock()
+	var list []OrganizationInfo
+	for _, org := range accounts.Organizations {
+		role := memberRole(org.Members, a.user)
+		if !a.admin && role == "" {
+			continue
+		}
+		org.Members = slices.Clone(org.Members)
+		list = append(list, OrganizationInfo{Organization: org, Role: role, Projects: []string{}})
+	}
+	accounts.mu.Unlock()
+
+	mutex.Lock()
+	for _, project := range allProjects {
+		for i := range list {
+			if list[i].Name == project.Organization {
+				list[i].Projects = append(list[i].Projects, project.ProjectName)
+			}
+		}
+	}
+	mutex.Unlock()
+	if list == nil {
+		list = []OrganizationInfo{}
+	}
+	c.JSON(http.StatusOK, gin.H{"organizations": list})
+}
+
+// handleCreateOrganization serves POST /api/admin/organizations with
+// {"name": "...", "owner": "..."}; the optional owner becomes its first
+// member.
+func handleCreateOrganization(c *gin.Context) {
+	var req struct {
+		Name  string `json:"name"`
+		Owner string `json:"owner"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "请求体格式错误，需要 name 字段")
+		return
+	}
+	name, owner := strings.TrimSpace(req.Name), strings.TrimSpace(req.Owner)
+	if !accountNamePattern.MatchString(name) {
+		respondError(c, http.StatusBadRequest, "组织名只能包含字母、数字、点、下划线和连字符，且不超过 64 个字符")
+		return
+	}
+	status, message := 0, ""
+	org := Organization{Name: name, Members: []Member{}, CreatedAt: timestamp(time.Now())}
+	err := accounts.update(func() error {
+		if accounts.findOrganization(name) >= 0 {
+			status, message = http.StatusConflict, "组织已存在"
+			return nil
+		}
+		if owner != "" {
+			if accounts.findUser(owner) < 0 {
+				status, message = http.StatusBadRequest, "用户未找到: "+owner
+				return nil
+			}
+			org.Members = append(org.Members, Member{User: owner, Role: roleOwner})
+		}
+		accounts.Organizations = append(accounts.Organizations, org)
+		return nil
+	})
+	if err != nil {
+		warnf(c, "保存组织 %s 失败: %v", name, err)
+		respondError(c, http.StatusInternalServerError, "保存组织失败")
+		return
+	}
+	if status != 0 {
+		respondError(c, status, message)
+		return
+	}
+	logf(c, "已创建组织 %s", name)
+	audit.record(c, AuditEntry{Action: auditOrganization, Detail: name + " created"})
+	c.JSON(http.StatusCreated, org)
+}
+
+// handleDeleteOrganization serves DELETE /api/admin/organizations/:name.
+// Projects still assigned to the organization must be moved out first.
+func handleDeleteOrganization(c *gin.Context) {
+	name := c.Param("name")
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, project := range allProjects {
+		if project.Organization == name {
+			respondError(c, http.StatusConflict, fmt.Sprintf("项目 %s 仍属于该组织", project.ProjectName))
+			return
+		}
+	}
+	found := false
+	err := accounts.update(func() error {
+		if i := accounts.findOrganization(name); i >= 0 {
+			found = true
+			accounts.Organizations = slices.Delete(accounts.Organizations, i, i+1)
+		}
+		return nil
+	})
+	if err != nil {
+		warnf(c, "删除组织 %s 失败: %v", name, err)
+		respondError(c, http.StatusInternalServerError, "删除组织失败")
+		return
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, "组织未找到")
+		return
+	}
+	logf(c, "已删除组织 %s", name)
+	audit.record(c, AuditEntry{Action: auditOrganization, Detail: name + " deleted"})
+	c.JSON(http.StatusOK, gin.H{"message": "组织已删除"})
+}
+
+// checkOrganizationOwner lets the admin and the organization's owners
+// manage its members, writing a 401 or 403 response otherwise
+func checkOrganizationOwner(c *gin.Context, orgName string) bool {
+	a := requestAccess(c)
+	switch {
+	case a.admin:
+		return true
+	case a.user == "":
+		respondError(c, http.StatusUnauthorized, "需要管理员或组织所有者登录")
+		return false
+	case accounts.organizationRole(orgName, a.user) != roleOwner:
+		respondError(c, http.StatusForbidden, "只有组织所有者可以管理成员")
+		return false
+	}
+	return true
+}
+
+// handleSetOrganizationMember serves PUT /api/organizations/:name/members/:user
+// with {"role": "owner" | "uploader" | "viewer"}
+func handleSetOrganizationMember(c *gin.Context) {
+	orgName, user := c.Param("name"), c.Param("user")
+	if !checkOrganizationOwner(c, orgName) {
+		return
+	}
+	role, ok := bindMemberRole(c)
+	if !ok {
+		return
+	}
+	status, message := 0, ""
+	err := accounts.update(func() error {
+		i := accounts.findOrganization(orgName)
+		switch {
+		case i < 0:
+			status, message = http.StatusNotFound, "组织未找到"
+		case accounts.findUser(user) < 0:
+			status, message = http.StatusNotFound, "用户未找到"
+		case role != roleOwner && lastOwner(accounts.Organizations[i].Members, user):
+			status, message = http.StatusConflict, "组织至少需要保留一名所有者"
+		default:
+			accounts.Organizations[i].Members = setMember(accounts.Organizations[i].Members, user, role)
+		}
+		return nil
+	})
+	if err != nil {
+		warnf(c, "保存组织 %s 的成员失败: %v", orgName, err)
+		respondError(c, http.StatusInternalServerError, "保存组织成员失败")
+		return
+	}
+	if status != 0 {
+		respondError(c, status, message)
+		return
+	}
+	logf(c, "组织 %s 的成员 %s 已设置为 %s", orgName, user, role)
+	audit.record(c, AuditEntry{Action: auditOrganization, Detail: fmt.Sprintf("%s member %s %s", orgName, user, role)})
+	c.JSON(http.StatusOK, gin.H{"organization": orgName, "user": user, "role": role})
+}
+
+// handleRemoveOrganizationMember serves DELETE /api/organizations/:name/members/:user
+func handleRemoveOrganizationMember(c *gin.Context) {
+	orgName, user := c.Param("name"), c.Param("user")
+	if !checkOrganizationOwner(c, orgName) {
+		return
+	}
+	status, message := 0, ""
+	err := accounts.update(func() error {
+		i := accounts.findOrganization(orgName)
+		if i < 0 {
+			status, message = http.StatusNotFound, "组织未找到"
+			return nil
+		}
+		if lastOwner(accounts.Organizations[i].Members, user) {
+			status, message = http.StatusConflict, "组织至少需要保留一名所有者"
+			return nil
+		}
+		var removed bool
+		if accounts.Organizations[i].Members, removed = removeMember(accounts.Organizations[i].Members, user); !removed {
+			status, message = http.StatusNotFound, "该用户不是组织成员"
+		}
+		return nil
+	})
+	if err != nil {
+		warnf(c, "保存组织 %s 的成员失败: %v", orgName, err)
+		respondError(c, http.StatusInternalServerError, "保存组织成员失败")
+		return
+	}
+	if status != 0 {
+		respondError(c, status, message)
+		return
+	}
+	logf(c, "已将 %s 移出组织 %s", user, orgName)
+	audit.record(c, AuditEntry{Action: auditOrganization, Detail: fmt.Sprintf("%s member %s removed", orgName, user)})
+	c.JSON(http.StatusOK, gin.H{"message": "成员已移除"})
+}
+
+// lastOwner reports whether user is the only owner among members
+func lastOwner(members []Member, user string) bool {
+	if memberRole(members, user) != roleOwner {
+		return false
+	}
+	owners := 0
+	for _, member := range members {
+		if member.Role == roleOwner {
+			owners++
+		}
+	}
+	return owners == 1
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sessionCookieName holds the admin session of the web UI
+const sessionCookieName = "appdist_session"
+
+// adminPasswordHeader carries the admin password of scripted requests, which
+// unlike a query parameter stays out of access logs
+const adminPasswordHeader = "X-Admin-Password"
+
+// minProjectPasswordLen is the shortest project password accepted
+const minProjectPasswordLen = 8
+
+// session is a logged-in admin, or user under APPDIST_MULTI_TENANT
+type session struct {
+	user   string // "" for the admin
+	expiry time.Time
+}
+
+// sessionStore keeps the login sessions in memory; they end when the
+// process restarts
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]session // session ID -> session
+}
+
+var sessions = &sessionStore{sessions: map[string]session{}}
+
+// create starts a session of user, "" for the admin, valid for ttl and
+// returns its ID
+func (s *sessionStore) create(user string, ttl time.Duration) (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(random)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Drop expired sessions on the way, so the map does not grow unbounded
+	for other, existing := range s.sessions {
+		if now.After(existing.expiry) {
+			delete(s.sessions, other)
+		}
+	}
+	s.sessions[id] = session{user: user, expiry: now.Add(ttl)}
+	return id, nil
+}
+
+// lookup returns the user of the unexpired session id, "" for the admin
+func (s *sessionStore) lookup(id string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, ok := s.sessions[id]
+	if !ok || !time.Now().Before(existing.expiry) {
+		return "", false
+	}
+	return existing.user, true
+}
+
+func (s *sessionStore) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+// removeUser ends every session of user
+func (s *sessionStore) removeUser(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, existing := range s.sessions {
+		if existing.user == user {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// adminPasswordMatches checks password against APPDIST_ADMIN_PASSWORD_HASH.
+// Without a hash no password is the admin's.
+func adminPasswordMatches(password string) bool {
+	if password == "" || config.AdminPasswordHash == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(config.AdminPasswordHash), []byte(password)) == nil
+}
+
+// oneTimeAdminPassword generates a random admin password for a server
+// started without APPDIST_ADMIN_PASSWORD_HASH and returns it for the log.
+// It is only kept as a hash in memory, so it changes on every restart.
+func oneTimeAdminPassword() (string, error) {
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	password := hex.EncodeToString(random)
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	config.AdminPasswordHash = string(hash)
+	return password, nil
+}
+
+// requestPassword returns the password a request presents in the
+// X-Admin-Password header or HTTP basic auth
+func requestPassword(c *gin.Context) string {
+	if password := c.GetHeader(adminPasswordHeader); password != "" {
+		return password
+	}
+	if _, password, ok := c.Request.BasicAuth(); ok {
+		return password
+	}
+	return ""
+}
+
+// isAdmin reports whether the request carries a valid admin session cookie,
+// or the admin password in the X-Admin-Password header or HTTP basic auth
+func isAdmin(c *gin.Context) bool {
+	if id, err := c.Cookie(sessionCookieName); err == nil {
+		if user, ok := sessions.lookup(id); ok && user == "" {
+			return true
+		}
+	}
+	return adminPasswordMatches(requestPassword(c))
+}
+
+// checkAdmin verifies the admin credentials required by destructive and
+// admin endpoints, writing a 401 response when they are missing or wrong.
+func checkAdmin(c *gin.Context) bool {
+	if !isAdmin(c) {
+		respondError(c, http.StatusUnauthorized, "需要管理员登录或有效的管理密码")
+		return false
+	}
+	return true
+}
+
+// requireAdmin is middleware form of checkAdmin for route groups whose
+// every endpoint needs the admin.
+func requireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkAdmin(c) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// checkProjectAdmin is checkAdmin for deletes within projectName, which
+// also accept the project's own password where one is set, and under
+// APPDIST_MULTI_TENANT the project's owners
+func checkProjectAdmin(c *gin.Context, projectName string) bool {
+	if isAdmin(c) || hasProjectRole(c, projectName, roleOwner) {
+		return true
+	}
+	password := requestPassword(c)
+	mutex.Lock()
+	var hash string
+	if i := findProject(projectName); i >= 0 {
+		hash = allProjects[i].PasswordHash
+	}
+	mutex.Unlock()
+	if hash != "" && password != "" && bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+		return true
+	}
+	respondError(c, http.StatusUnauthorized, "需要管理员登录、有效的管理密码或该项目的密码")
+	return false
+}
+
+// handleSetProjectPassword sets the delete password of a project with
+// {"password": "..."}; an empty password removes it.
+func handleSetProjectPassword(c *gin.Context) {
+	if !checkAdmin(c) {
+		return
+	}
+	var req struct {
+		Password *string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Password == nil {
+		respondError(c, http.StatusBadRequest, "请求体格式错误，需要 password 字段")
+		return
+	}
+	var hash string
+	if password := strings.TrimSpace(*req.Password); password != "" {
+		if len(password) < minProjectPasswordLen {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("项目密码至少需要 %d 个字符", minProjectPasswordLen))
+			return
+		}
+		data, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "无法设置该密码: "+err.Error())
+			return
+		}
+		hash = string(data)
+	}
+	projectName := c.Param("projectName")
+
+	_, err := repo.UpdateProject(projectName, func(project *Project) error {
+		project.PasswordHash = hash
+		return nil
+	})
+	if err != nil {
+		respondRepositoryError(c, err)
+		return
+	}
+	detail := "set"
+	if hash == "" {
+		detail = "removed"
+		logf(c, "项目 %s 的密码已移除", projectName)
+	} else {
+		logf(c, "项目 %s 的密码已更新", projectName)
+	}
+	audit.record(c, AuditEntry{Action: auditProjectPassword, ProjectName: projectName, Detail: detail})
+	c.JSON(http.StatusOK, gin.H{"projectName": projectName, "passwordSet": hash != ""})
+}
+
+// sessionCookiePath scopes the session cookie to the configured base path
+func sessionCookiePath() string {
+	if config.BasePath == "" {
+		return "/"
+	}
+	return config.BasePath
+}
+
+// setSessionCookie writes the session cookie; a negative maxAge removes it
+func setSessionCookie(c *gin.Context, id string, maxAge int) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(sessionCookieName, id, maxAge, sessionCookiePath(), "", requestScheme(c) == "https", true)
+}
+
+// handleAdminLogin starts a session for {"password": "..."}, set as an
+// HttpOnly cookie. With a "username" under APPDIST_MULTI_TENANT it logs in
+// that user instead of the admin.
+func handleAdminLogin(c *gin.Context) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "请求体格式错误，需要 password 字段")
+		return
+	}
+	user := strings.TrimSpace(req.Username)
+	if user != "" {
+		if !config.MultiTenant || !accounts.authenticate(user, req.Password) {
+			warnf(c, "用户 %s 登录失败，来源 %s", user, c.ClientIP())
+			respondError(c, http.StatusUnauthorized, "用户名或密码错误")
+			return
+		}
+	} else if !adminPasswordMatches(strings.TrimSpace(req.Password)) {
+		warnf(c, "管理员登录失败，来源 %s", c.ClientIP())
+		respondError(c, http.StatusUnauthorized, "管理密码错误")
+		return
+	}
+	id, err := sessions.create(user, config.SessionTTL)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "无法创建会话")
+		return
+	}
+	setSessionCookie(c, id, int(config.SessionTTL.Seconds()))
+	if user != "" {
+		logf(c, "用户 %s 已登录，来源 %s", user, c.ClientIP())
+		c.JSON(http.StatusOK, gin.H{"message": "登录成功", "user": user, "expiresAt": timestamp(time.Now().Add(config.SessionTTL))})
+		return
+	}
+	logf(c, "管理员已登录，来源 %s", c.ClientIP())
+	c.JSON(http.StatusOK, gin.H{"message": "登录成功", "expiresAt": timestamp(time.Now().Add(config.SessionTTL))})
+}
+
+// handleAdminLogout ends the session of the request, if any
+func handleAdminLogout(c *gin.Context) {
+	if id, err := c.Cookie(sessionCookieName); err == nil {
+		sessions.remove(id)
+	}
+	setSessionCookie(c, "", -1)
+	c.JSON(http.StatusOK, gin.H{"message": "已退出登录"})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// login posts password to the admin login and returns the response
+func login(router *gin.Engine, password string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/login", strings.NewReader(`{"password":"`+password+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminSession(t *testing.T) {
+	router := setupTestServer(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.AdminPasswordHash = string(hash)
+
+	withCookie := func(method, target string, cookie *http.Cookie) int {
+		req := httptest.NewRequest(method, target, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Only the password of the configured hash is accepted
+	for _, password := range []string{testAdminPassword, "9527"} {
+		if rec := login(router, password); rec.Code != http.StatusUnauthorized {
+			t.Errorf("login with %q: status %d, want 401", password, rec.Code)
+		}
+	}
+	if rec := serveAdmin(router, http.MethodGet, "/api/admin/storage"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("password of another hash: status %d, want 401", rec.Code)
+	}
+
+	rec := login(router, "s3cret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: status %d: %s", rec.Code, rec.Body.String())
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookieName || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("session cookie = %+v", cookies)
+	}
+	session := cookies[0]
+	if code := withCookie(http.MethodGet, "/api/admin/storage", session); code != http.StatusOK {
+		t.Errorf("with session: status %d", code)
+	}
+	if code := withCookie(http.MethodGet, "/api/admin/storage", &http.Cookie{Name: sessionCookieName, Value: "forged"}); code != http.StatusUnauthorized {
+		t.Errorf("forged session: status %d, want 401", code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/storage", nil)
+	req.SetBasicAuth("admin", "s3cret")
+	basic := httptest.NewRecorder()
+	router.ServeHTTP(basic, req)
+	if basic.Code != http.StatusOK {
+		t.Errorf("basic auth: status %d", basic.Code)
+	}
+
+	if code := withCookie(http.MethodPost, "/api/admin/logout", session); code != http.StatusOK {
+		t.Fatalf("logout: status %d", code)
+	}
+	if code := withCookie(http.MethodGet, "/api/admin/storage", session); code != http.StatusUnauthorized {
+		t.Errorf("after logout: status %d, want 401", code)
+	}
+}
+
+func TestAdminWithoutPasswordHash(t *testing.T) {
+	router := setupTestServer(t)
+	config.AdminPasswordHash = ""
+
+	// No password, including the old default, is the admin's
+	for _, password := range []string{"9527", ""} {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/storage", nil)
+		req.Header.Set(adminPasswordHeader, password)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("password %q: status %d, want 401", password, rec.Code)
+		}
+		if rec := login(router, password); rec.Code != http.StatusUnauthorized {
+			t.Errorf("login with %q: status %d, want 401", password, rec.Code)
+		}
+	}
+
+	// Until the one-time password of the run is used
+	password, err := oneTimeAdminPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(password) < 16 || config.AdminPasswordHash == "" {
+		t.Fatalf("one-time password %q, hash %q", password, config.AdminPasswordHash)
+	}
+	if rec := login(router, password); rec.Code != http.StatusOK {
+		t.Errorf("login with the one-time password: status %d", rec.Code)
+	}
+	if other, _ := oneTimeAdminPassword(); other == password {
+		t.Error("two runs got the same one-time password")
+	}
+}
+
+func TestProjectPassword(t *testing.T) {
+	router := setupTestServer(t)
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	otherPackage := "com.example.other"
+	mustUpsert(t, jsonRepository{}, "Other", otherPackage, testBuild("other.apk", "stable"))
+
+	withPassword := func(method, target, password, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(adminPasswordHeader, password)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := withPassword(http.MethodPut, "/api/projects/Demo/password", "demo-team-pw", `{"password":"demo-team-pw"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("set password without admin: status %d, want 401", rec.Code)
+	}
+	if rec := withPassword(http.MethodPut, "/api/projects/Demo/password", testAdminPassword, `{"password":"short"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("short password: status %d, want 400", rec.Code)
+	}
+	if rec := withPassword(http.MethodPut, "/api/projects/Demo/password", testAdminPassword, `{"password":"demo-team-pw"}`); rec.Code != http.StatusOK {
+		t.Fatalf("set password: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve(router, http.MethodGet, "/api/projects"); strings.Contains(rec.Body.String(), "$2a$") {
+		t.Error("project listing exposes the password hash")
+	}
+
+	// The project password deletes in its own project only, and grants no
+	// other admin rights
+	otherBuild := appBuilds(t, router, otherPackage)[0]
+	if rec := withPassword(http.MethodDelete, "/api/builds/"+otherPackage+"/"+otherBuild.FileName, "demo-team-pw", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("delete in another project: status %d, want 401", rec.Code)
+	}
+	if rec := withPassword(http.MethodGet, "/api/admin/storage", "demo-team-pw", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("admin endpoint with project password: status %d, want 401", rec.Code)
+	}
+	build := appBuilds(t, router, fixturePackage)[0]
+	if rec := withPassword(http.MethodDelete, "/api/builds/"+fixturePackage+"/"+build.FileName+"?keepApp=true", "demo-team-pw", ""); rec.Code != http.StatusOK {
+		t.Fatalf("delete with project password: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := withPassword(http.MethodPut, "/api/projects/Demo/password", testAdminPassword, `{"password":""}`); rec.Code != http.StatusOK {
+		t.Fatalf("remove password: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := withPassword(http.MethodDelete, "/api/apps/"+fixturePackage, "demo-team-pw", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("delete with removed project password: status %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/shogo82148/androidbinary/apk"
+)
+
+// ApkDetails holds the manifest information parsed from an APK, or from the
+// Info.plist of an IPA, see parseIpaDetails
+type ApkDetails struct {
+	Platform    string `json:"platform,omitempty"` // "ios" for IPAs, "harmonyos" for HAPs, "" for APKs
+	Format      string `json:"format,omitempty"`   // formatAAB for App Bundles, formatHarmonyApp for .app
+	AppName     string `json:"appName"`
+	PackageName string `json:"packageName"`
+	Version     string `json:"version"`
+	VersionCode int32  `json:"versionCode"`
+	MinSDK      int32  `json:"minSdk"`
+	TargetSDK   int32  `json:"targetSdk,omitempty"`
+	// MinOSVersion is the MinimumOSVersion of an IPA, e.g. "13.0"
+	MinOSVersion string `json:"minOsVersion,omitempty"`
+	// Permissions lists the uses-permission entries of the manifest
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+// extractApkDetails reads the manifest fields the catalog relies on from an
+// opened APK, failing when any of them is missing.
+func extractApkDetails(pkg *apk.Apk) (ApkDetails, error) {
+	appName, err := pkg.Label(nil)
+	if err != nil || appName == "" {
+		return ApkDetails{}, fmt.Errorf("解析APK应用名失败或应用名为空: %v", err)
+	}
+	packageName := pkg.PackageName()
+	if packageName == "" {
+		return ApkDetails{}, fmt.Errorf("解析APK包名失败或包名为空")
+	}
+	version, err := pkg.Manifest().VersionName.String()
+	if err != nil || version == "" {
+		return ApkDetails{}, fmt.Errorf("解析APK版本名失败或版本名为空: %v", err)
+	}
+	versionCode, err := pkg.Manifest().VersionCode.Int32()
+	if err != nil {
+		return ApkDetails{}, fmt.Errorf("解析APK版本号(versionCode)失败: %v", err)
+	}
+	// minSdkVersion is optional in the manifest; absent means API level 1
+	minSDK, err := pkg.Manifest().SDK.Min.Int32()
+	if err != nil {
+		minSDK = 0
+	}
+	// targetSdkVersion defaults to minSdkVersion when absent
+	targetSDK, err := pkg.Manifest().SDK.Target.Int32()
+	if err != nil || targetSDK == 0 {
+		targetSDK = minSDK
+	}
+	var permissions []string
+	for _, permission := range pkg.Manifest().UsesPermissions {
+		if name, err := permission.Name.String(); err == nil && name != "" {
+			permissions = append(permissions, name)
+		}
+	}
+	return ApkDetails{
+		AppName:     appName,
+		PackageName: packageName,
+		Version:     version,
+		VersionCode: versionCode,
+		MinSDK:      minSDK,
+		TargetSDK:   targetSDK,
+		Permissions: permissions,
+	}, nil
+}
+
+// parseApkDetails returns the details of the APK at path, consulting the
+// parse cache first when the file hash is known.
+func parseApkDetails(path, fileHash string) (ApkDetails, error) {
+	if details, ok := parseCache.Get(fileHash); ok {
+		return details, nil
+	}
+	pkg, err := apk.OpenFile(path)
+	if err != nil {
+		return ApkDetails{}, fmt.Errorf("解析APK失败: %w", err)
+	}
+	defer pkg.Close()
+	details, err := extractApkDetails(pkg)
+	if err != nil {
+		return ApkDetails{}, err
+	}
+	parseCache.Add(fileHash, details)
+	return details, nil
+}
+
+// parseCache keeps recently parsed APK details keyed by file hash
+var parseCache = newApkCache(config.ParseCacheSize)
+
+// apkCache is a fixed-size LRU cache of ApkDetails keyed by file hash.
+// It is safe for concurrent use.
+type apkCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // front is most recently used
+	items    map[string]*list.Element
+}
+
+type apkCacheEntry struct {
+	hash    string
+	details ApkDetails
+}
+
+func newApkCache(capacity int) *apkCache {
+	return &apkCache{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the cached details for hash and marks them as recently used.
+func (c *apkCache) Get(hash string) (ApkDetails, bool) {
+	if hash == "" {
+		return ApkDetails{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[hash]
+	if !ok {
+		return ApkDetails{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*apkCacheEntry).details, true
+}
+
+// Add stores details for hash, evicting the least recently used entry when full.
+func (c *apkCache) Add(hash string, details ApkDetails) {
+	if hash == "" || c.capacity <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[hash]; ok {
+		elem.Value.(*apkCacheEntry).details = details
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[hash] = c.order.PushFront(&apkCacheEntry{hash: hash, details: details})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*apkCacheEntry).hash)
+	}
+}
+
+// Remove drops hash from the cache.
+func (c *apkCache) Remove(hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[hash]; ok {
+		c.order.Remove(elem)
+		delete(c.items, hash)
+	}
+}
+
+// Resize changes the capacity, evicting entries as needed.
+func (c *apkCache) Resize(capacity int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.capacity = capacity
+	for c.order.Len() > c.capacity && c.order.Len() > 0 {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*apkCacheEntry).hash)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// embeddedAssets holds the page templates and stylesheets, so the binary
+// runs from any directory. Icons are data written at runtime and stay on
+// disk below iconDir.
+//
+//go:embed templates/*.html static/*.css
+var embeddedAssets embed.FS
+
+// loadTemplates parses the embedded templates with funcs, then the
+// templates/*.html files of APPDIST_ASSETS_DIR, which replace the embedded
+// ones of the same name
+func loadTemplates(funcs template.FuncMap) (*template.Template, error) {
+	templates, err := template.New("").Funcs(funcs).ParseFS(embeddedAssets, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+	if config.AssetsDir == "" {
+		return templates, nil
+	}
+	overrides, err := filepath.Glob(filepath.Join(config.AssetsDir, "templates", "*.html"))
+	if err != nil || len(overrides) == 0 {
+		return templates, err
+	}
+	return templates.ParseFiles(overrides...)
+}
+
+// staticFiles is what /static serves: the static directory of
+// APPDIST_ASSETS_DIR, then the embedded stylesheets, then ./static on disk
+// for the icons. Directories are not listed.
+func staticFiles() http.FileSystem {
+	var layers layeredFS
+	if config.AssetsDir != "" {
+		layers = append(layers, os.DirFS(filepath.Join(config.AssetsDir, "static")))
+	}
+	embedded, _ := fs.Sub(embeddedAssets, "static")
+	layers = append(layers, embedded, os.DirFS(filepath.Dir(iconDir)))
+	return http.FS(layers)
+}
+
+// layeredFS opens a file from the first of its file systems that has it
+type layeredFS []fs.FS
+
+func (l layeredFS) Open(name string) (fs.File, error) {
+	for _, layer := range l {
+		f, err := layer.Open(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if info, err := f.Stat(); err != nil || info.IsDir() {
+			f.Close()
+			continue
+		}
+		return f, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
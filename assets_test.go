@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddedAssets(t *testing.T) {
+	// setupTestServer runs in an empty directory, so pages and styles can
+	// only come from the binary
+	router := setupTestServer(t)
+	if rec := serve(router, http.MethodGet, "/"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "/static/style.css") {
+		t.Fatalf("homepage: status %d", rec.Code)
+	}
+	style, err := embeddedAssets.ReadFile("static/style.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(router, http.MethodGet, "/static/style.css"); rec.Code != http.StatusOK || rec.Body.String() != string(style) {
+		t.Errorf("style.css: status %d", rec.Code)
+	}
+	// Icons are written at runtime and served from disk
+	if err := os.MkdirAll(iconDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(iconDir, "com.example.png"), []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(router, http.MethodGet, "/static/icons/com.example.png"); rec.Code != http.StatusOK || rec.Body.String() != "png" {
+		t.Errorf("icon: status %d %q", rec.Code, rec.Body.String())
+	}
+	for _, target := range []string{"/static/", "/static/icons/", "/static/missing.css"} {
+		if rec := serve(router, http.MethodGet, target); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", target, rec.Code)
+		}
+	}
+}
+
+func TestAssetsDirOverrides(t *testing.T) {
+	setupTestServer(t)
+	config.AssetsDir = t.TempDir()
+	for name, content := range map[string]string{
+		"templates/error.html": `<p>自定义错误页: {{.Message}}</p>`,
+		"static/style.css":     "body { color: red; }",
+	} {
+		path := filepath.Join(config.AssetsDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := newRouter()
+
+	rec := serve(router, http.MethodGet, "/static/style.css")
+	if rec.Body.String() != "body { color: red; }" {
+		t.Errorf("style.css = %q", rec.Body.String())
+	}
+	req := httptest.NewRequest(http.MethodGet, "/app/com.example.missing", nil)
+	req.Header.Set("Accept", "text/html")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound || rec.Body.String() != "<p>自定义错误页: 应用未找到</p>" {
+		t.Errorf("error page: status %d %q", rec.Code, rec.Body.String())
+	}
+	// Templates without an override stay embedded
+	if rec := serve(router, http.MethodGet, "/"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "/static/style.css") {
+		t.Errorf("homepage: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Actions recorded in the audit log
+const (
+	auditUpload          = "upload"
+	auditUploadExpansion = "upload-expansion"
+	auditDeleteBuild     = "delete-build"
+	auditDeleteApp       = "delete-app"
+	auditPromote         = "promote"
+	auditEditBuild       = "edit-build"
+	auditEditTags        = "edit-tags"
+	auditProtect         = "protect-build"
+	auditReparse         = "reparse"
+	auditSetPrivate      = "set-private"
+	auditPackagePrefix   = "set-package-prefix"
+	auditRetention       = "set-retention"
+	auditProjectPassword = "set-project-password"
+	auditQuota           = "set-quota"
+	auditCreateToken     = "create-token"
+	auditRevokeToken     = "revoke-token"
+	auditRejectInfected  = "reject-infected"
+	auditSetUser         = "set-user"
+	auditOrganization    = "organization"
+	auditProjectMember   = "project-member"
+	auditReconcile       = "reconcile"
+)
+
+// Who performed an audited action
+const (
+	actorAdmin     = "admin"     // admin session or password
+	actorProject   = "project"   // a project's delete password
+	actorUser      = "user"      // a user logged in under APPDIST_MULTI_TENANT
+	actorToken     = "token"     // an API token
+	actorAnonymous = "anonymous" // no credentials, e.g. uploads without required tokens
+	actorSystem    = "system"    // background jobs such as the retention janitor
+)
+
+// auditDefaultLimit and auditMaxLimit bound the entries GET /api/audit returns
+const (
+	auditDefaultLimit = 100
+	auditMaxLimit     = 1000
+)
+
+// AuditEntry is one line of the audit log
+type AuditEntry struct {
+	Time        string `json:"time"`
+	Action      string `json:"action"`
+	Actor       string `json:"actor"`
+	TokenID     string `json:"tokenId,omitempty"`
+	TokenName   string `json:"tokenName,omitempty"`
+	User        string `json:"user,omitempty"`
+	IP          string `json:"ip,omitempty"`
+	RequestID   string `json:"requestId,omitempty"`
+	ProjectName string `json:"projectName,omitempty"`
+	PackageName string `json:"packageName,omitempty"`
+	FileName    string `json:"fileName,omitempty"`
+	Channel     string `json:"channel,omitempty"`
+	Detail      string `json:"detail,omitempty"`
+}
+
+// auditLog appends entries as JSON lines to config.AuditLogPath. Entries
+// are never rewritten, so the file can be shipped to append-only storage.
+type auditLog struct {
+	mu sync.Mutex
+}
+
+var audit = &auditLog{}
+
+// record fills in who performed the action of c, nil for background jobs,
+// and appends entry. A failing write is logged but does not undo the
+// action, which has already happened.
+func (a *auditLog) record(c *gin.Context, entry AuditEntry) {
+	if config.AuditLogPath == "" {
+		return
+	}
+	entry.Time = timestamp(time.Now())
+	entry.Actor = actorSystem
+	if c != nil {
+		entry.Actor = auditActor(c)
+		entry.TokenID = c.GetString(apiTokenKey)
+		entry.TokenName = c.GetString(apiTokenNameKey)
+		if entry.Actor == actorUser {
+			entry.User = requestAccess(c).user
+		}
+		entry.IP = c.ClientIP()
+		entry.RequestID = requestID(c)
+	}
+	if err := a.append(entry); err != nil {
+		warnf(c, "写入审计日志失败: %v", err)
+	}
+}
+
+func (a *auditLog) append(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(config.AuditLogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// auditActor classifies the credentials of c
+func auditActor(c *gin.Context) string {
+	switch {
+	case c.GetString(apiTokenKey) != "":
+		return actorToken
+	case isAdmin(c):
+		return actorAdmin
+	case config.MultiTenant && requestAccess(c).user != "":
+		return actorUser
+	case requestPassword(c) != "":
+		// Only reached after checkProjectAdmin accepted the password
+		return actorProject
+	default:
+		return actorAnonymous
+	}
+}
+
+// auditFilter selects entries of the audit log; empty fields match all
+type auditFilter struct {
+	Action      string
+	Actor       string
+	ProjectName string
+	PackageName string
+	FileName    string
+	From, To    time.Time
+}
+
+func (f auditFilter) matches(entry AuditEntry) bool {
+	if (f.Action != "" && entry.Action != f.Action) ||
+		(f.Actor != "" && entry.Actor != f.Actor) ||
+		(f.ProjectName != "" && entry.ProjectName != f.ProjectName) ||
+		(f.PackageName != "" && entry.PackageName != f.PackageName) ||
+		(f.FileName != "" && entry.FileName != f.FileName) {
+		return false
+	}
+	if f.From.IsZero() && f.To.IsZero() {
+		return true
+	}
+	t, err := parseTimestamp(entry.Time)
+	if err != nil {
+		return false
+	}
+	return (f.From.IsZero() || !t.Before(f.From)) && (f.To.IsZero() || !t.After(f.To))
+}
+
+// query returns the newest limit entries matching filter, newest first.
+// Unreadable lines, e.g. one cut short by a crash, are skipped.
+func (a *auditLog) query(filter auditFilter, limit int) ([]AuditEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entries := []AuditEntry{}
+	if config.AuditLogPath == "" {
+		return entries, nil
+	}
+	f, err := os.Open(config.AuditLogPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil || !filter.matches(entry) {
+			continue
+		}
+		entries = append(entries, entry)
+		// Keep only the newest limit entries while reading
+		if len(entries) > 2*limit {
+			entries = append(entries[:0], entries[len(entries)-limit:]...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// handleAudit serves GET /api/audit with the newest audit entries. The
+// entries can be narrowed with action, actor, project, package, fileName
+// and a from/to window like GET /api/builds; limit defaults to 100.
+func handleAudit(c *gin.Context) {
+	filter := auditFilter{
+		Action:      c.Query("action"),
+		Actor:       c.Query("actor"),
+		ProjectName: c.Query("project"),
+		PackageName: c.Query("package"),
+		FileName:    c.Query("fileName"),
+	}
+	tz := c.Query("tz")
+	var err error
+	if value := c.Query("from"); value != "" {
+		if filter.From, err = parseRangeBound(value, tz, false); err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		if filter.To, err = parseRangeBound(value, tz, true); err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	limit := auditDefaultLimit
+	if value := c.Query("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > auditMaxLimit {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("limit 应为 1 到 %d 之间的整数", auditMaxLimit))
+			return
+		}
+	}
+
+	entries, err := audit.query(filter, limit)
+	if err != nil {
+		warnf(c, "读取审计日志失败: %v", err)
+		respondError(c, http.StatusInternalServerError, "读取审计日志失败")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries, "enabled": config.AuditLogPath != ""})
+}
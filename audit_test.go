@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	router := setupTestServer(t)
+
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	fileName := someBuildFile(fixturePackage)
+	if rec := serveAdmin(router, http.MethodDelete, "/api/builds/"+fixturePackage+"/"+fileName+"?channel=stable&keepApp=true"); rec.Code != http.StatusOK {
+		t.Fatalf("delete: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := serve(router, http.MethodGet, "/api/audit"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("audit without admin: status %d", rec.Code)
+	}
+
+	var result struct {
+		Entries []AuditEntry `json:"entries"`
+	}
+	rec := serveAdmin(router, http.MethodGet, "/api/audit")
+	decodeJSON(t, rec, &result)
+	if len(result.Entries) != 2 {
+		t.Fatalf("entries = %+v", result.Entries)
+	}
+	deleted, uploaded := result.Entries[0], result.Entries[1]
+	if deleted.Action != auditDeleteBuild || deleted.Actor != actorAdmin || deleted.FileName != fileName ||
+		deleted.ProjectName != "Demo" || deleted.IP == "" || deleted.RequestID == "" {
+		t.Errorf("delete entry = %+v", deleted)
+	}
+	if uploaded.Action != auditUpload || uploaded.Actor != actorAnonymous || uploaded.Channel != "stable" || uploaded.PackageName != fixturePackage {
+		t.Errorf("upload entry = %+v", uploaded)
+	}
+
+	rec = serveAdmin(router, http.MethodGet, "/api/audit?action=upload&limit=1")
+	decodeJSON(t, rec, &result)
+	if len(result.Entries) != 1 || result.Entries[0].Action != auditUpload {
+		t.Errorf("filtered entries = %+v", result.Entries)
+	}
+	rec = serveAdmin(router, http.MethodGet, "/api/audit?to=2000-01-01")
+	decodeJSON(t, rec, &result)
+	if len(result.Entries) != 0 {
+		t.Errorf("entries before 2000 = %+v", result.Entries)
+	}
+	if rec := serveAdmin(router, http.MethodGet, "/api/audit?limit=0"); rec.Code != http.StatusBadRequest {
+		t.Errorf("limit=0: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// siteContextKey is the gin context key siteBranding stores the Site under
+const siteContextKey = "site"
+
+// Site is the instance branding every page template receives as .Site
+type Site struct {
+	Title      string
+	FaviconURL string // empty when no favicon is configured
+}
+
+// currentSite returns the branding of the active configuration
+func currentSite() Site {
+	site := Site{Title: config.SiteTitle}
+	if config.FaviconPath != "" {
+		site.FaviconURL = withBasePath("/favicon.ico")
+	}
+	return site
+}
+
+// siteBranding makes the site branding available to renderHTML
+func siteBranding() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(siteContextKey, currentSite())
+		c.Next()
+	}
+}
+
+// renderHTML renders a page template with the site branding added to data
+// as .Site, so templates can show the configured title and favicon.
+func renderHTML(c *gin.Context, status int, name string, data gin.H) {
+	site, ok := c.Get(siteContextKey)
+	if !ok {
+		site = currentSite()
+	}
+	data["Site"] = site
+	c.HTML(status, name, data)
+}
+
+// handleFavicon serves the configured favicon file
+func handleFavicon(c *gin.Context) {
+	if config.FaviconPath == "" {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.File(config.FaviconPath)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BundleEntry describes one channel in the manifest of a bundle archive
+type BundleEntry struct {
+	Channel     string `json:"channel"`
+	Version     string `json:"version,omitempty"`
+	VersionCode int32  `json:"versionCode,omitempty"`
+	FileName    string `json:"fileName,omitempty"`
+	FileSize    int64  `json:"fileSize,omitempty"`
+	FileHash    string `json:"fileHash,omitempty"`
+	UploadTime  string `json:"uploadTime,omitempty"`
+	Included    bool   `json:"included"`
+	Reason      string `json:"reason,omitempty"` // why the file was omitted
+}
+
+// BundleManifest is stored as manifest.json inside a bundle archive
+type BundleManifest struct {
+	PackageName string        `json:"packageName"`
+	GeneratedAt string        `json:"generatedAt"`
+	Builds      []BundleEntry `json:"builds"`
+}
+
+// latestBuildsByChannel returns the newest build of packageName per channel
+// by config.BuildOrder, across every project, and the channels in order of
+// first appearance.
+func latestBuildsByChannel(packageName string) (map[string]BuildInfo, []string, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	latest := make(map[string]BuildInfo)
+	var channels []string
+	found := false
+	for _, project := range allProjects {
+		for _, app := range project.Apps {
+			if app.PackageName != packageName {
+				continue
+			}
+			found = true
+			for _, build := range app.Builds {
+				current, ok := latest[build.Channel]
+				if !ok {
+					channels = append(channels, build.Channel)
+				}
+				if !ok || newerBuild(build, current, config.BuildOrder) {
+					latest[build.Channel] = build
+				}
+			}
+		}
+	}
+	return latest, channels, found
+}
+
+// handleBundleZip streams the latest build of each selected channel plus a
+// manifest.json as a zip attachment. Files missing on disk are skipped and
+// noted in the manifest.
+func handleBundleZip(c *gin.Context) {
+	packageName := c.Param("packageName")
+	latest, channels, found := latestBuildsByChannel(packageName)
+	if !found {
+		respondError(c, http.StatusNotFound, "应用未找到")
+		return
+	}
+	if requested := c.Query("channels"); requested != "" {
+		channels = nil
+		for _, channel := range strings.Split(requested, ",") {
+			if channel = strings.TrimSpace(channel); channel != "" {
+				channels = appendUnique(channels, channel)
+			}
+		}
+	}
+
+	fileName := fmt.Sprintf("%s-bundle-%s.zip", packageName, time.Now().Format("20060102"))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	manifest := BundleManifest{
+		PackageName: packageName,
+		GeneratedAt: timestamp(time.Now()),
+		Builds:      []BundleEntry{},
+	}
+	written := make(map[string]bool)
+	for _, channel := range channels {
+		build, ok := latest[channel]
+		if !ok {
+			manifest.Builds = append(manifest.Builds, BundleEntry{Channel: channel, Reason: "该渠道没有构建版本"})
+			continue
+		}
+		entry := BundleEntry{
+			Channel:     channel,
+			Version:     build.Version,
+			VersionCode: build.VersionCode,
+			FileName:    build.FileName,
+			FileSize:    build.FileSize,
+			FileHash:    build.FileHash,
+			UploadTime:  build.UploadTime,
+		}
+		if written[build.FileName] {
+			// A promoted build shares its file with another channel
+			entry.Included = true
+		} else if err := addFileToZip(zw, build.FileName); err != nil {
+			if !os.IsNotExist(err) {
+				warnf(c, "打包 %s 中断: %v", build.FileName, err)
+				return
+			}
+			entry.Reason = "文件不存在"
+		} else {
+			written[build.FileName] = true
+			entry.Included = true
+		}
+		manifest.Builds = append(manifest.Builds, entry)
+	}
+
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: time.Now()})
+	if err == nil {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(manifest)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		warnf(c, "打包 %s 中断: %v", fileName, err)
+	}
+}
+
+// addFileToZip copies an uploaded file into the archive without compressing
+// it again, since APKs are already zip archives. Opening the file happens
+// before the entry is created, so a missing file leaves the archive intact.
+func addFileToZip(zw *zip.Writer, fileName string) error {
+	size, err := storage.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	f, err := storage.Get(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header := &zip.FileHeader{Name: fileName, Method: zip.Store, Modified: time.Now()}
+	header.UncompressedSize64 = uint64(size)
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
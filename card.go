@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleInstallCard renders a standalone install page for one build with
+// Open Graph and Twitter meta tags, so shared links unfurl in chat apps.
+// Without ?fileName= the newest build of the app is used. Every link is
+// absolute because crawlers fetch the card out of context.
+func handleInstallCard(c *gin.Context) {
+	packageName := c.Param("packageName")
+	fileName := c.Query("fileName")
+
+	var app AppEntry
+	var build BuildInfo
+	appFound, buildFound := false, false
+	mutex.Lock()
+	for _, project := range allProjects {
+		for _, candidate := range project.Apps {
+			if candidate.PackageName != packageName || buildFound {
+				continue
+			}
+			for _, b := range candidate.Builds {
+				if fileName == "" || b.FileName == fileName {
+					app, build, buildFound = candidate, b, true
+					break
+				}
+			}
+			if !appFound {
+				app, appFound = candidate, true
+			}
+		}
+	}
+	mutex.Unlock()
+
+	if !appFound {
+		respondText(c, http.StatusNotFound, "应用未找到")
+		return
+	}
+	if !buildFound {
+		respondText(c, http.StatusNotFound, "构建版本未找到")
+		return
+	}
+
+	baseURL := requestBaseURL(c)
+	install := baseURL + installURL(packageName, build.FileName)
+	var iconURL string
+	if icon := pickIcon(app, "xxhdpi"); icon != "" {
+		iconURL = baseURL + "/" + icon
+	}
+	cardURL := baseURL + "/app/" + url.PathEscape(packageName) + "/card?fileName=" + url.QueryEscape(build.FileName)
+
+	renderHTML(c, http.StatusOK, "card.html", gin.H{
+		"App":        app,
+		"Build":      build,
+		"IconURL":    iconURL,
+		"InstallURL": install,
+		"QRCodeURL":  baseURL + "/qr?url=" + url.QueryEscape(install),
+		"CardURL":    cardURL,
+		"DetailURL":  baseURL + "/app/" + url.PathEscape(packageName),
+		"TZ":         viewerTimezone(c),
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import "slices"
+
+// Lookup helpers for allProjects. They return indexes rather than pointers:
+// a pointer into allProjects or an Apps slice goes stale as soon as an
+// append reallocates the backing array, silently redirecting later writes.
+// The caller must hold the mutex, and indexes must not be kept across a
+// change to the slices they index.
+
+// findProject returns the index of the named project, or -1
+func findProject(projectName string) int {
+	for i := range allProjects {
+		if allProjects[i].ProjectName == projectName {
+			return i
+		}
+	}
+	return -1
+}
+
+// findApp returns the project and app indexes of the first app with
+// packageName
+func findApp(packageName string) (projectIndex, appIndex int, ok bool) {
+	for i := range allProjects {
+		if j := findAppInProject(i, packageName); j >= 0 {
+			return i, j, true
+		}
+	}
+	return -1, -1, false
+}
+
+// findAppInProject returns the index of packageName within the project at
+// projectIndex, or -1
+func findAppInProject(projectIndex int, packageName string) int {
+	for j := range allProjects[projectIndex].Apps {
+		if allProjects[projectIndex].Apps[j].PackageName == packageName {
+			return j
+		}
+	}
+	return -1
+}
+
+// clone returns a copy of the project and its apps that shares no slices or
+// maps with the catalog
+func (p Project) clone() Project {
+	p.Webhooks = slices.Clone(p.Webhooks)
+	p.Members = slices.Clone(p.Members)
+	apps := make([]AppEntry, len(p.Apps))
+	for j, app := range p.Apps {
+		apps[j] = app.clone()
+	}
+	p.Apps = apps
+	return p
+}
+
+// clone returns a copy of the app that shares no slices or maps with the
+// catalog, so it stays valid after the mutex is released
+func (a AppEntry) clone() AppEntry {
+	a.Builds = append([]BuildInfo(nil), a.Builds...)
+	for i, build := range a.Builds {
+		a.Builds[i].Tags = slices.Clone(build.Tags)
+		if build.Extra != nil {
+			extra := make(map[string]string, len(build.Extra))
+			for key, value := range build.Extra {
+				extra[key] = value
+			}
+			a.Builds[i].Extra = extra
+		}
+	}
+	if a.Icons != nil {
+		icons := make(map[string]string, len(a.Icons))
+		for density, path := range a.Icons {
+			icons[density] = path
+		}
+		a.Icons = icons
+	}
+	return a
+}
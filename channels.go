@@ -0,0 +1,56 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// channelGroup is one channel's section on the detail page
+type channelGroup struct {
+	Channel string
+	Builds  []BuildInfo // newest first by config.BuildOrder
+	// Downgrades maps the builds uploaded with a lower versionCode than an
+	// earlier build of the channel to that versionCode, see downgrades
+	Downgrades map[string]int32
+}
+
+// groupBuildsByChannel splits builds into per-channel groups. Channels
+// listed in config.ChannelOrder come first, in that order and compared
+// case-insensitively; the rest follow alphabetically. Channels without
+// builds are omitted. builds are newest upload first, like AppEntry.Builds.
+func groupBuildsByChannel(builds []BuildInfo) []channelGroup {
+	var groups []channelGroup
+	index := make(map[string]int)
+	for _, build := range builds {
+		i, ok := index[build.Channel]
+		if !ok {
+			i = len(groups)
+			index[build.Channel] = i
+			groups = append(groups, channelGroup{Channel: build.Channel})
+		}
+		groups[i].Builds = append(groups[i].Builds, build)
+	}
+	for i := range groups {
+		groups[i].Downgrades = downgrades(groups[i].Builds)
+		if config.BuildOrder == sortByVersion {
+			sortBuilds(groups[i].Builds, sortByVersion)
+		}
+	}
+
+	rank := func(channel string) int {
+		for i, ordered := range config.ChannelOrder {
+			if strings.EqualFold(ordered, channel) {
+				return i
+			}
+		}
+		return len(config.ChannelOrder)
+	}
+	sort.SliceStable(groups, func(a, b int) bool {
+		rankA, rankB := rank(groups[a].Channel), rank(groups[b].Channel)
+		if rankA != rankB {
+			return rankA < rankB
+		}
+		return strings.ToLower(groups[a].Channel) < strings.ToLower(groups[b].Channel)
+	})
+	return groups
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Chat robots a new-build card can be pushed to
+const (
+	chatBotDingTalk = "钉钉"
+	chatBotWeCom    = "企业微信"
+	chatBotFeishu   = "飞书"
+)
+
+// chatBotNotesRunes bounds the release notes quoted in a chat card
+const chatBotNotesRunes = 500
+
+// chatBot is a configured robot webhook
+type chatBot struct {
+	name   string // one of the chatBot constants
+	url    string
+	secret string // signing secret of DingTalk and Feishu robots, may be empty
+}
+
+// chatBots returns the robots configured in APPDIST_DINGTALK_WEBHOOK,
+// APPDIST_WECOM_WEBHOOK and APPDIST_FEISHU_WEBHOOK
+func chatBots() []chatBot {
+	var bots []chatBot
+	if config.DingTalkWebhook != "" {
+		bots = append(bots, chatBot{chatBotDingTalk, config.DingTalkWebhook, config.DingTalkSecret})
+	}
+	if config.WeComWebhook != "" {
+		bots = append(bots, chatBot{chatBotWeCom, config.WeComWebhook, ""})
+	}
+	if config.FeishuWebhook != "" {
+		bots = append(bots, chatBot{chatBotFeishu, config.FeishuWebhook, config.FeishuSecret})
+	}
+	return bots
+}
+
+// chatBotTitle is the headline of a new-build card
+func chatBotTitle(event BuildEvent) string {
+	return fmt.Sprintf("%s %s (%s) 已发布", event.AppName, event.Build.Version, event.Build.Channel)
+}
+
+// chatBotNotes returns the release notes quoted in a card, shortened to
+// chatBotNotesRunes
+func chatBotNotes(event BuildEvent) string {
+	notes := strings.TrimSpace(event.Build.ReleaseNotes)
+	if runes := []rune(notes); len(runes) > chatBotNotesRunes {
+		notes = strings.TrimRight(string(runes[:chatBotNotesRunes]), " \t\r\n") + "…"
+	}
+	return notes
+}
+
+// chatBotMarkdown is the card text of the DingTalk robot, whose markdown
+// renders the QR code image inline
+func chatBotMarkdown(event BuildEvent) string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "### %s\n\n", chatBotTitle(event))
+	fmt.Fprintf(&text, "- 项目：%s\n- 包名：%s\n- 版本：%s\n- 渠道：%s\n- 上传时间：%s\n\n",
+		event.ProjectName, event.PackageName, event.Build.Version, event.Build.Channel, formatTime(event.Build.UploadTime, ""))
+	if notes := chatBotNotes(event); notes != "" {
+		fmt.Fprintf(&text, "**更新说明：**\n\n> %s\n\n", strings.ReplaceAll(notes, "\n", "\n>\n> "))
+	}
+	fmt.Fprintf(&text, "![扫码安装](%s)\n\n", event.QRCodeURL)
+	fmt.Fprintf(&text, "[下载安装](%s) | [查看全部版本](%s)", event.DownloadURL, event.DetailURL)
+	return text.String()
+}
+
+// chatBotMessage returns the JSON message of the new-build card for bot.
+// WeCom markdown shows no images, so WeCom gets a news card with the QR code
+// as its picture; Feishu cards only take uploaded images, so the QR code is
+// a link there.
+func chatBotMessage(bot chatBot, event BuildEvent) map[string]any {
+	switch bot.name {
+	case chatBotWeCom:
+		description := fmt.Sprintf("%s · %s", event.ProjectName, event.PackageName)
+		if notes := chatBotNotes(event); notes != "" {
+			description += "\n" + notes
+		}
+		return map[string]any{
+			"msgtype": "news",
+			"news": map[string]any{"articles": []map[string]string{{
+				"title":       chatBotTitle(event),
+				"description": description,
+				"url":         event.DetailURL,
+				"picurl":      event.QRCodeURL,
+			}}},
+		}
+	case chatBotFeishu:
+		content := fmt.Sprintf("**项目：**%s\n**包名：**%s\n**版本：**%s\n**渠道：**%s\n**上传时间：**%s",
+			event.ProjectName, event.PackageName, event.Build.Version, event.Build.Channel, formatTime(event.Build.UploadTime, ""))
+		if notes := chatBotNotes(event); notes != "" {
+			content += "\n**更新说明：**\n" + notes
+		}
+		button := func(text, link, kind string) map[string]any {
+			return map[string]any{"tag": "button", "type": kind, "url": link,
+				"text": map[string]string{"tag": "plain_text", "content": text}}
+		}
+		return map[string]any{
+			"msg_type": "interactive",
+			"card": map[string]any{
+				"header": map[string]any{
+					"template": "blue",
+					"title":    map[string]string{"tag": "plain_text", "content": chatBotTitle(event)},
+				},
+				"elements": []map[string]any{
+					{"tag": "markdown", "content": content},
+					{"tag": "action", "actions": []map[string]any{
+						button("下载安装", event.DownloadURL, "primary"),
+						button("扫码安装", event.QRCodeURL, "default"),
+						button("查看全部版本", event.DetailURL, "default"),
+					}},
+				},
+			},
+		}
+	default:
+		return map[string]any{
+			"msgtype":  "markdown",
+			"markdown": map[string]string{"title": chatBotTitle(event), "text": chatBotMarkdown(event)},
+		}
+	}
+}
+
+// signChatBot adds the signature of a robot with "加签" security to its
+// request: DingTalk takes it in the URL, Feishu in the body.
+func signChatBot(bot chatBot, body map[string]any, now time.Time) string {
+	if bot.secret == "" {
+		return bot.url
+	}
+	switch bot.name {
+	case chatBotDingTalk:
+		ts := strconv.FormatInt(now.UnixMilli(), 10)
+		mac := hmac.New(sha256.New, []byte(bot.secret))
+		mac.Write([]byte(ts + "\n" + bot.secret))
+		separator := "?"
+		if strings.Contains(bot.url, "?") {
+			separator = "&"
+		}
+		return bot.url + separator + "timestamp=" + ts + "&sign=" + url.QueryEscape(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	case chatBotFeishu:
+		ts := strconv.FormatInt(now.Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(ts+"\n"+bot.secret))
+		body["timestamp"] = ts
+		body["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	return bot.url
+}
+
+// notifyChatBots pushes the new-build card to every configured robot
+func notifyChatBots(event BuildEvent) {
+	client := &http.Client{Timeout: config.WebhookTimeout}
+	for _, bot := range chatBots() {
+		go deliverChatBot(client, bot, event)
+	}
+}
+
+// deliverChatBot posts the card to bot, retrying with backoff like webhook
+// deliveries. Failures are logged only.
+func deliverChatBot(client *http.Client, bot chatBot, event BuildEvent) {
+	var err error
+	for attempt := 1; attempt <= webhookRetryAttempts; attempt++ {
+		if err = postChatBot(client, bot, event); err == nil {
+			return
+		}
+		slog.Warn("推送机器人消息失败", "bot", bot.name, "attempt", attempt, "error", err)
+		if attempt < webhookRetryAttempts {
+			time.Sleep(time.Duration(attempt) * webhookRetryDelay)
+		}
+	}
+	slog.Error("机器人消息最终推送失败", "bot", bot.name, "error", err)
+}
+
+// postChatBot makes one delivery attempt. The robots answer failures such
+// as a bad signature with HTTP 200 and a non-zero code in the body.
+func postChatBot(client *http.Client, bot chatBot, event BuildEvent) error {
+	// Signatures are timestamped, so every attempt is signed anew
+	message := chatBotMessage(bot, event)
+	target := signChatBot(bot, message, time.Now())
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	var result struct {
+		ErrCode *int   `json:"errcode"` // DingTalk, WeCom
+		ErrMsg  string `json:"errmsg"`
+		Code    *int   `json:"code"` // Feishu
+		Msg     string `json:"msg"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil
+	}
+	if result.ErrCode != nil && *result.ErrCode != 0 {
+		return fmt.Errorf("错误码 %d: %s", *result.ErrCode, result.ErrMsg)
+	}
+	if result.Code != nil && *result.Code != 0 {
+		return fmt.Errorf("错误码 %d: %s", *result.Code, result.Msg)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChatBotNotifications(t *testing.T) {
+	router := setupTestServer(t)
+	webhookRetryDelay = time.Millisecond
+	t.Cleanup(func() { webhookRetryDelay = 2 * time.Second })
+
+	type message struct {
+		path  string
+		query string
+		body  map[string]any
+	}
+	messages := make(chan message, 4)
+	var dingTalkAttempts atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// DingTalk rejects the first attempt the way it rejects a bad
+		// signature, with HTTP 200 and an error code
+		if r.URL.Path == "/dingtalk" && dingTalkAttempts.Add(1) == 1 {
+			io.WriteString(w, `{"errcode":310000,"errmsg":"sign not match"}`)
+			return
+		}
+		raw, _ := io.ReadAll(r.Body)
+		var body map[string]any
+		json.Unmarshal(raw, &body)
+		messages <- message{r.URL.Path, r.URL.RawQuery, body}
+		if r.URL.Path == "/feishu" {
+			io.WriteString(w, `{"code":0,"msg":"success"}`)
+			return
+		}
+		io.WriteString(w, `{"errcode":0,"errmsg":"ok"}`)
+	}))
+	defer receiver.Close()
+
+	config.DingTalkWebhook = receiver.URL + "/dingtalk?access_token=abc"
+	config.DingTalkSecret = "SECdemo"
+	config.WeComWebhook = receiver.URL + "/wecom"
+	config.FeishuWebhook = receiver.URL + "/feishu"
+	config.FeishuSecret = "feishu-secret"
+
+	rec := uploadFixture(router, fixtureAPK(t), "Demo", "beta")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	received := map[string]message{}
+	for len(received) < 3 {
+		select {
+		case m := <-messages:
+			received[m.path] = m
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d of 3 robot messages", len(received))
+		}
+	}
+	if n := dingTalkAttempts.Load(); n != 2 {
+		t.Errorf("DingTalk attempts = %d, want 2", n)
+	}
+
+	dingTalk := received["/dingtalk"]
+	if !strings.Contains(dingTalk.query, "access_token=abc&timestamp=") || !strings.Contains(dingTalk.query, "&sign=") {
+		t.Errorf("DingTalk query = %q, want the token and a signature", dingTalk.query)
+	}
+	markdown, _ := dingTalk.body["markdown"].(map[string]any)
+	text, _ := markdown["text"].(string)
+	for _, want := range []string{"beta", "![扫码安装](http://example.com/qr?url=", fixturePackage} {
+		if !strings.Contains(text, want) {
+			t.Errorf("DingTalk markdown lacks %q:\n%s", want, text)
+		}
+	}
+
+	wecom := received["/wecom"]
+	if wecom.body["msgtype"] != "news" || !strings.Contains(toJSON(t, wecom.body), `"picurl":"http://example.com/qr?url=`) {
+		t.Errorf("WeCom message = %s, want a news card with the QR code", toJSON(t, wecom.body))
+	}
+
+	feishu := received["/feishu"]
+	ts, _ := feishu.body["timestamp"].(string)
+	mac := hmac.New(sha256.New, []byte(ts+"\n"+"feishu-secret"))
+	if want := base64.StdEncoding.EncodeToString(mac.Sum(nil)); feishu.body["sign"] != want {
+		t.Errorf("Feishu sign = %v, want %s", feishu.body["sign"], want)
+	}
+	if feishu.body["msg_type"] != "interactive" {
+		t.Errorf("Feishu msg_type = %v, want interactive", feishu.body["msg_type"])
+	}
+}
+
+func TestChatBotNotes(t *testing.T) {
+	event := BuildEvent{Build: BuildInfo{ReleaseNotes: "  " + strings.Repeat("新", chatBotNotesRunes+10) + "\n"}}
+	notes := chatBotNotes(event)
+	if got := len([]rune(notes)); got != chatBotNotesRunes+1 || !strings.HasSuffix(notes, "…") {
+		t.Errorf("notes of %d runes, want %d ending in an ellipsis", got, chatBotNotesRunes+1)
+	}
+	event.Build.ReleaseNotes = " 修复崩溃 "
+	if notes := chatBotNotes(event); notes != "修复崩溃" {
+		t.Errorf("notes = %q", notes)
+	}
+}
+
+func toJSON(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
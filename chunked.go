@@ -0,0 +1,436 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Large packages can be uploaded in chunks that survive a dropped
+// connection: the client creates an upload, appends chunks at the offset the
+// server reports, resuming from there after a failure, and completes it,
+// which publishes the package like a regular upload. Offsets are exchanged
+// in the Upload-Offset header, as in the tus protocol.
+//
+// Unfinished uploads live in the chunked subdirectory of the incoming
+// directory, which survives restarts, as <id>.part with the data and
+// <id>.json with the chunkedUpload.
+
+// chunkedUploadIDLen is the length of the hex upload IDs
+const chunkedUploadIDLen = 32
+
+// errChunkTooLarge reports a chunk running past the declared size
+var errChunkTooLarge = errors.New("chunk exceeds the declared size")
+
+// chunkedUpload is the stored state of an unfinished chunked upload. The
+// received size is that of the data file.
+type chunkedUpload struct {
+	ID        string        `json:"id"`
+	FileName  string        `json:"fileName"`
+	Size      int64         `json:"size"`
+	Request   uploadRequest `json:"request"`
+	TokenID   string        `json:"tokenId,omitempty"` // the API token that created it
+	ExpiresAt string        `json:"expiresAt"`
+}
+
+// ChunkedUploadStatus is the response of the chunked upload endpoints
+type ChunkedUploadStatus struct {
+	ID        string `json:"id"`
+	FileName  string `json:"fileName"`
+	Size      int64  `json:"size"`
+	Offset    int64  `json:"offset"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+// chunkedUploadRequest is the body that creates a chunked upload
+type chunkedUploadRequest struct {
+	FileName          string            `json:"fileName"`
+	Size              int64             `json:"size"`
+	ProjectName       string            `json:"projectName"`
+	Channel           string            `json:"channel"`
+	ReleaseNotes      string            `json:"releaseNotes"`
+	AllowDowngrade    bool              `json:"allowDowngrade"`
+	Force             bool              `json:"force"` // same as AllowDowngrade
+	AllowSignerChange bool              `json:"allowSignerChange"`
+	Extra             map[string]string `json:"extra"`
+}
+
+// chunkedBusy holds the IDs of uploads a request is writing or completing,
+// so two requests never write the same file at once
+var chunkedBusy = struct {
+	sync.Mutex
+	ids map[string]bool
+}{ids: map[string]bool{}}
+
+func chunkedDir() string {
+	return filepath.Join(config.IncomingDir, "chunked")
+}
+
+func chunkedDataPath(id string) string {
+	return filepath.Join(chunkedDir(), id+".part")
+}
+
+func chunkedInfoPath(id string) string {
+	return filepath.Join(chunkedDir(), id+".json")
+}
+
+// validChunkedUploadID reports whether id can name an upload, which also
+// keeps it from escaping the directory
+func validChunkedUploadID(id string) bool {
+	if len(id) != chunkedUploadIDLen {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// loadChunkedUpload reads the state of upload id and its received size
+func loadChunkedUpload(id string) (chunkedUpload, int64, error) {
+	var upload chunkedUpload
+	data, err := os.ReadFile(chunkedInfoPath(id))
+	if err != nil {
+		return upload, 0, err
+	}
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return upload, 0, err
+	}
+	info, err := os.Stat(chunkedDataPath(id))
+	if err != nil {
+		return upload, 0, err
+	}
+	return upload, info.Size(), nil
+}
+
+// saveChunkedUpload writes the state of upload, replacing the previous one
+func saveChunkedUpload(upload chunkedUpload) error {
+	data, err := json.MarshalIndent(upload, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := chunkedInfoPath(upload.ID)
+	if err := writeFileSync(path+".tmp", data); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// removeChunkedUpload deletes the state and data of upload id
+func removeChunkedUpload(id string) {
+	os.Remove(chunkedInfoPath(id))
+	os.Remove(chunkedDataPath(id))
+}
+
+// sweepChunkedUploads removes uploads that expired, and data files whose
+// state is missing
+func sweepChunkedUploads() {
+	entries, err := os.ReadDir(chunkedDir())
+	if err != nil {
+		return
+	}
+	now := timestamp(time.Now())
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".part")
+		if !ok || !validChunkedUploadID(id) || !lockChunkedUpload(id) {
+			continue
+		}
+		upload, _, err := loadChunkedUpload(id)
+		if err != nil || upload.ExpiresAt < now {
+			removeChunkedUpload(id)
+			slog.Info("已清理过期或损坏的分块上传", "id", id)
+		}
+		unlockChunkedUpload(id)
+	}
+}
+
+// lockChunkedUpload claims upload id for the calling request, failing when
+// another request holds it
+func lockChunkedUpload(id string) bool {
+	chunkedBusy.Lock()
+	defer chunkedBusy.Unlock()
+	if chunkedBusy.ids[id] {
+		return false
+	}
+	chunkedBusy.ids[id] = true
+	return true
+}
+
+func unlockChunkedUpload(id string) {
+	chunkedBusy.Lock()
+	defer chunkedBusy.Unlock()
+	delete(chunkedBusy.ids, id)
+}
+
+func chunkedStatus(upload chunkedUpload, offset int64) ChunkedUploadStatus {
+	return ChunkedUploadStatus{
+		ID:        upload.ID,
+		FileName:  upload.FileName,
+		Size:      upload.Size,
+		Offset:    offset,
+		ExpiresAt: upload.ExpiresAt,
+	}
+}
+
+// openChunkedUpload loads the upload named in the request path for the token
+// of the request and locks it. On failure it writes the error response.
+func openChunkedUpload(c *gin.Context) (chunkedUpload, int64, bool) {
+	id := c.Param("id")
+	if !validChunkedUploadID(id) {
+		respondError(c, http.StatusNotFound, "分块上传不存在或已过期")
+		return chunkedUpload{}, 0, false
+	}
+	if !lockChunkedUpload(id) {
+		respondError(c, http.StatusConflict, "该分块上传正被另一个请求使用")
+		return chunkedUpload{}, 0, false
+	}
+	upload, offset, err := loadChunkedUpload(id)
+	if err == nil && upload.ExpiresAt < timestamp(time.Now()) {
+		removeChunkedUpload(id)
+		err = os.ErrNotExist
+	}
+	// Uploads only continue with the token that created them
+	if err == nil && upload.TokenID != c.GetString(apiTokenKey) {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		unlockChunkedUpload(id)
+		if !errors.Is(err, os.ErrNotExist) {
+			warnf(c, "读取分块上传 %s 失败: %v", id, err)
+		}
+		respondError(c, http.StatusNotFound, "分块上传不存在或已过期")
+		return chunkedUpload{}, 0, false
+	}
+	return upload, offset, true
+}
+
+// handleCreateChunkedUpload serves POST /api/upload/chunked. The body
+// carries the fields of a regular upload plus the file name and size, which
+// are checked before any data is sent.
+func handleCreateChunkedUpload(c *gin.Context) {
+	var req chunkedUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "请求体格式错误: "+err.Error())
+		return
+	}
+	req.Extra = normalizeExtra(req.Extra)
+	errs := append(validateBuildFields(req.ProjectName, req.Channel, req.ReleaseNotes), validateExtraFields(req.Extra)...)
+	if req.FileName == "" {
+		errs = append(errs, FieldError{"fileName", "文件名不能为空"})
+	} else if config.FilenameGuard {
+		if err := checkUploadName(req.FileName); err != nil {
+			errs = append(errs, FieldError{"fileName", err.Error()})
+		}
+	}
+	if req.Size <= 0 {
+		errs = append(errs, FieldError{"size", "文件大小必须大于 0"})
+	}
+	if len(errs) > 0 {
+		body := errorBody(c, "表单字段校验失败")
+		body["fields"] = errs
+		c.JSON(http.StatusBadRequest, body)
+		return
+	}
+	if req.Size > config.ChunkedUploadMaxSize {
+		respondError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("文件超过分块上传大小上限 %s", formatSize(config.ChunkedUploadMaxSize)))
+		return
+	}
+
+	if msg := uploadProjectError(c, strings.TrimSpace(req.ProjectName)); msg != "" {
+		respondError(c, http.StatusForbidden, msg)
+		return
+	}
+
+	sweepChunkedUploads()
+	random := make([]byte, chunkedUploadIDLen/2)
+	if _, err := rand.Read(random); err != nil {
+		respondError(c, http.StatusInternalServerError, "无法创建分块上传")
+		return
+	}
+	upload := chunkedUpload{
+		ID:       hex.EncodeToString(random),
+		FileName: req.FileName,
+		Size:     req.Size,
+		Request: uploadRequest{
+			Platform:          uploadPlatform(req.FileName),
+			ProjectName:       strings.TrimSpace(req.ProjectName),
+			Channel:           strings.TrimSpace(req.Channel),
+			ReleaseNotes:      req.ReleaseNotes,
+			AllowDowngrade:    req.AllowDowngrade || req.Force,
+			AllowSignerChange: req.AllowSignerChange,
+			Extra:             req.Extra,
+		},
+		TokenID:   c.GetString(apiTokenKey),
+		ExpiresAt: timestamp(time.Now().Add(config.ChunkedUploadExpiry)),
+	}
+	if err := os.MkdirAll(chunkedDir(), 0755); err != nil {
+		respondError(c, http.StatusInternalServerError, "无法创建分块上传目录")
+		return
+	}
+	if err := os.WriteFile(chunkedDataPath(upload.ID), nil, 0644); err != nil {
+		respondError(c, http.StatusInternalServerError, "无法创建分块上传")
+		return
+	}
+	if err := saveChunkedUpload(upload); err != nil {
+		removeChunkedUpload(upload.ID)
+		respondError(c, http.StatusInternalServerError, "无法创建分块上传")
+		return
+	}
+	logf(c, "已创建分块上传 %s: %q, 大小: %d", upload.ID, upload.FileName, upload.Size)
+	c.Header("Location", withBasePath("/api/upload/chunked/"+upload.ID))
+	c.Header("Upload-Offset", "0")
+	c.JSON(http.StatusCreated, chunkedStatus(upload, 0))
+}
+
+// handleChunkedUploadStatus serves GET and HEAD /api/upload/chunked/:id with
+// the offset to resume from
+func handleChunkedUploadStatus(c *gin.Context) {
+	upload, offset, ok := openChunkedUpload(c)
+	if !ok {
+		return
+	}
+	defer unlockChunkedUpload(upload.ID)
+	c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, chunkedStatus(upload, offset))
+}
+
+// handleAppendChunk serves PATCH /api/upload/chunked/:id: the body is
+// appended at the Upload-Offset header, which must match the received size.
+// Whatever arrives before a connection drops is kept.
+func handleAppendChunk(c *gin.Context) {
+	upload, offset, ok := openChunkedUpload(c)
+	if !ok {
+		return
+	}
+	defer unlockChunkedUpload(upload.ID)
+
+	claimed, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "缺少或无效的 Upload-Offset 请求头")
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+	if claimed != offset {
+		body := errorBody(c, fmt.Sprintf("Upload-Offset 与已接收的 %d 字节不符", offset))
+		body["offset"] = offset
+		c.JSON(http.StatusConflict, body)
+		return
+	}
+
+	f, err := os.OpenFile(chunkedDataPath(upload.ID), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "无法写入分块上传")
+		return
+	}
+	remaining := upload.Size - offset
+	written, copyErr := io.Copy(f, io.LimitReader(c.Request.Body, remaining+1))
+	if written > remaining {
+		// Keep the data up to the declared size, reject the rest
+		f.Truncate(upload.Size)
+		written = remaining
+		copyErr = errChunkTooLarge
+	}
+	if err := f.Close(); copyErr == nil {
+		copyErr = err
+	}
+	offset += written
+	c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+
+	upload.ExpiresAt = timestamp(time.Now().Add(config.ChunkedUploadExpiry))
+	if err := saveChunkedUpload(upload); err != nil {
+		warnf(c, "更新分块上传 %s 失败: %v", upload.ID, err)
+	}
+	if errors.Is(copyErr, errChunkTooLarge) {
+		respondError(c, http.StatusRequestEntityTooLarge, "数据超出创建上传时声明的大小")
+		return
+	}
+	if copyErr != nil {
+		logf(c, "分块上传 %s 在 %d 字节处中断: %v", upload.ID, offset, copyErr)
+		respondError(c, http.StatusBadRequest, "接收数据中断，请从 Upload-Offset 继续")
+		return
+	}
+	c.JSON(http.StatusOK, chunkedStatus(upload, offset))
+}
+
+// handleCompleteChunkedUpload serves POST /api/upload/chunked/:id/complete:
+// once every byte arrived, the package is published like a regular upload
+// and the chunked upload is gone. When the server is too busy to parse it,
+// the data is kept so the client can simply retry.
+func handleCompleteChunkedUpload(c *gin.Context) {
+	upload, offset, ok := openChunkedUpload(c)
+	if !ok {
+		return
+	}
+	defer unlockChunkedUpload(upload.ID)
+	if offset != upload.Size {
+		body := errorBody(c, fmt.Sprintf("上传尚未完成: 已接收 %d / %d 字节", offset, upload.Size))
+		body["offset"] = offset
+		c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+		c.JSON(http.StatusConflict, body)
+		return
+	}
+
+	dataPath := chunkedDataPath(upload.ID)
+	if config.FilenameGuard {
+		if err := checkDownloadedAPK(dataPath); err != nil {
+			removeChunkedUpload(upload.ID)
+			respondText(c, http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+	}
+	fileHash, err := hashFile(dataPath)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "无法读取分块上传")
+		return
+	}
+	// Publish a link to the data, so the data itself survives a busy server
+	dst, err := createIncomingFile(upload.FileName)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "无法暂存分块上传")
+		return
+	}
+	incomingPath := dst.Name()
+	dst.Close()
+	os.Remove(incomingPath)
+	if err := os.Link(dataPath, incomingPath); err != nil {
+		respondError(c, http.StatusInternalServerError, "无法暂存分块上传")
+		return
+	}
+
+	logf(c, "完成分块上传 %s: %q", upload.ID, upload.FileName)
+	result, err := publishUpload(c, incomingPath, fileHash, upload.Size, upload.Request)
+	if err != nil && asUploadError(err).status == http.StatusServiceUnavailable {
+		respondUploadError(c, err)
+		return
+	}
+	removeChunkedUpload(upload.ID)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Upload successful", "warnings": result.Warnings})
+}
+
+// handleAbortChunkedUpload serves DELETE /api/upload/chunked/:id
+func handleAbortChunkedUpload(c *gin.Context) {
+	upload, _, ok := openChunkedUpload(c)
+	if !ok {
+		return
+	}
+	defer unlockChunkedUpload(upload.ID)
+	removeChunkedUpload(upload.ID)
+	logf(c, "已取消分块上传 %s", upload.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "分块上传已取消"})
+}
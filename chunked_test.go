@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sendChunk appends data to a chunked upload at offset
+func sendChunk(router *gin.Engine, id string, offset int, data []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPatch, "/api/upload/chunked/"+id, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestChunkedUpload(t *testing.T) {
+	router := setupTestServer(t)
+	apk := fixtureAPK(t)
+
+	body := fmt.Sprintf(`{"fileName":"app.apk","size":%d,"projectName":"Demo","channel":"stable","releaseNotes":"chunked"}`, len(apk))
+	req := httptest.NewRequest(http.MethodPost, "/api/upload/chunked", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var status ChunkedUploadStatus
+	decodeJSON(t, rec, &status)
+	id := status.ID
+
+	half := len(apk) / 2
+	if rec := sendChunk(router, id, 0, apk[:half]); rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("first chunk: status %d, offset %q", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+	// Completing early and appending at a stale offset both fail
+	if rec := serve(router, http.MethodPost, "/api/upload/chunked/"+id+"/complete"); rec.Code != http.StatusConflict {
+		t.Errorf("early complete: status %d, want 409", rec.Code)
+	}
+	if rec := sendChunk(router, id, 0, apk[:half]); rec.Code != http.StatusConflict {
+		t.Errorf("stale offset: status %d, want 409", rec.Code)
+	}
+
+	// A client that lost track resumes from the reported offset
+	rec = serve(router, http.MethodGet, "/api/upload/chunked/"+id)
+	decodeJSON(t, rec, &status)
+	if status.Offset != int64(half) || status.Size != int64(len(apk)) {
+		t.Fatalf("status = %+v", status)
+	}
+	if rec := sendChunk(router, id, half, append(apk[half:], 'x')); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized chunk: status %d, want 413", rec.Code)
+	}
+	if rec := serve(router, http.MethodPost, "/api/upload/chunked/"+id+"/complete"); rec.Code != http.StatusOK {
+		t.Fatalf("complete: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	builds := appBuilds(t, router, fixturePackage)
+	if len(builds) != 1 || builds[0].ReleaseNotes != "chunked" || builds[0].FileSize != int64(len(apk)) {
+		t.Fatalf("builds = %+v", builds)
+	}
+	if rec := serve(router, http.MethodGet, "/api/upload/chunked/"+id); rec.Code != http.StatusNotFound {
+		t.Errorf("status after completion: %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Values of BuildInfo.ScanStatus
+const (
+	scanClean     = "clean"     // clamd found nothing
+	scanUnscanned = "unscanned" // clamd failed and Config.ClamAVFailOpen let the upload through
+)
+
+// clamdChunkSize is the size of the chunks a file is streamed to clamd in
+const clamdChunkSize = 64 << 10
+
+// clamdDial connects to the clamd at address, a unix socket path or
+// host:port
+func clamdDial(ctx context.Context, address string) (net.Conn, error) {
+	var dialer net.Dialer
+	if strings.HasPrefix(address, "/") {
+		return dialer.DialContext(ctx, "unix", address)
+	}
+	return dialer.DialContext(ctx, "tcp", address)
+}
+
+// clamdScan streams the file at path to the clamd at address with the
+// INSTREAM command. It returns the name of the signature found, "" when
+// the file is clean. clamd refuses streams longer than its StreamMaxLength
+// (25 MB by default), which is reported as an error.
+func clamdScan(ctx context.Context, address, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	conn, err := clamdDial(ctx, address)
+	if err != nil {
+		return "", fmt.Errorf("无法连接 clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	w := bufio.NewWriterSize(conn, clamdChunkSize+4)
+	w.WriteString("zINSTREAM\x00")
+	chunk := make([]byte, clamdChunkSize)
+	for {
+		n, readErr := f.Read(chunk)
+		if n > 0 {
+			binary.Write(w, binary.BigEndian, uint32(n))
+			if _, err := w.Write(chunk[:n]); err != nil {
+				// clamd closes the connection once the stream is too long;
+				// its reply tells why
+				break
+			}
+		}
+		if readErr == io.EOF {
+			binary.Write(w, binary.BigEndian, uint32(0))
+			break
+		}
+		if readErr != nil {
+			return "", readErr
+		}
+	}
+	w.Flush()
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && (reply == "" || !errors.Is(err, io.EOF)) {
+		return "", fmt.Errorf("读取 clamd 响应失败: %w", err)
+	}
+	return parseClamdReply(reply)
+}
+
+// parseClamdReply interprets a reply such as "stream: OK" or
+// "stream: Win.Test.EICAR_HDB-1 FOUND"
+func parseClamdReply(reply string) (string, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd 扫描失败: %s", reply)
+	}
+}
+
+// scanUpload scans the upload at incomingPath when Config.ClamAVAddress is
+// set and returns the scan status to record on the build, "" when scanning
+// is disabled. Infected uploads are discarded like other rejected uploads,
+// i.e. quarantined when Config.QuarantineDir is set, and answered with 422.
+// When clamd cannot scan the file the upload is removed and answered with
+// 503, unless Config.ClamAVFailOpen lets it through unscanned. Rejections
+// are returned as an *uploadError.
+func scanUpload(c *gin.Context, incomingPath string, req uploadRequest) (string, error) {
+	if config.ClamAVAddress == "" {
+		return "", nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.ClamAVTimeout)
+	defer cancel()
+	start := time.Now()
+	signature, err := clamdScan(ctx, config.ClamAVAddress, incomingPath)
+	switch {
+	case err != nil:
+		warnf(c, "病毒扫描失败: %v", err)
+		if config.ClamAVFailOpen {
+			return scanUnscanned, nil
+		}
+		os.Remove(incomingPath)
+		return "", &uploadError{status: http.StatusServiceUnavailable, message: "病毒扫描服务暂不可用，请稍后重试", retryAfter: 60}
+	case signature != "":
+		warnf(c, "上传的文件感染病毒 %s，已拒绝", signature)
+		discardUpload(c, incomingPath)
+		audit.record(c, AuditEntry{
+			Action:      auditRejectInfected,
+			ProjectName: req.ProjectName,
+			Channel:     req.Channel,
+			Detail:      signature,
+		})
+		return "", &uploadError{status: http.StatusUnprocessableEntity, message: "安装包未通过病毒扫描，检测到: " + signature}
+	}
+	logf(c, "病毒扫描通过，耗时 %s", time.Since(start).Round(time.Millisecond))
+	return scanClean, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeClamd answers INSTREAM scans like clamd, reporting a signature while
+// infected is set, and returns its address
+func fakeClamd(t *testing.T, infected *atomic.Bool) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				if command, err := r.ReadString(0); err != nil || command != "zINSTREAM\x00" {
+					io.WriteString(conn, "UNKNOWN COMMAND\x00")
+					return
+				}
+				for {
+					var size uint32
+					if binary.Read(r, binary.BigEndian, &size) != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+						return
+					}
+				}
+				if infected.Load() {
+					io.WriteString(conn, "stream: Win.Test.EICAR_HDB-1 FOUND\x00")
+				} else {
+					io.WriteString(conn, "stream: OK\x00")
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestVirusScan(t *testing.T) {
+	router := setupTestServer(t)
+	var infected atomic.Bool
+	config.ClamAVAddress = fakeClamd(t, &infected)
+	config.QuarantineDir = "quarantine"
+	config.AuditLogPath = "audit.log"
+	config.DuplicateUploads = duplicateStore
+
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("clean upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	builds := appBuilds(t, router, fixturePackage)
+	if len(builds) != 1 || builds[0].ScanStatus != scanClean || builds[0].ScannedAt == "" {
+		t.Fatalf("builds = %+v", builds)
+	}
+	if rec := serve(router, http.MethodGet, "/app/"+fixturePackage); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "已扫描") {
+		t.Errorf("detail page without the scan badge: status %d", rec.Code)
+	}
+
+	infected.Store(true)
+	rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable")
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "Win.Test.EICAR_HDB-1") {
+		t.Errorf("infected upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if quarantined, _ := filepath.Glob("quarantine/*"); len(quarantined) != 1 {
+		t.Errorf("quarantined files = %v", quarantined)
+	}
+	if builds := appBuilds(t, router, fixturePackage); len(builds) != 1 {
+		t.Errorf("infected upload was published: %+v", builds)
+	}
+	entries, err := audit.query(auditFilter{Action: auditRejectInfected}, 10)
+	if err != nil || len(entries) != 1 || entries[0].Detail != "Win.Test.EICAR_HDB-1" {
+		t.Errorf("audit entries = %+v, %v", entries, err)
+	}
+
+	// Without clamd uploads wait, unless they may go through unscanned
+	config.ClamAVAddress = filepath.Join(t.TempDir(), "missing.sock")
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("clamd down: status %d: %s", rec.Code, rec.Body.String())
+	}
+	config.ClamAVFailOpen = true
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("clamd down, fail open: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if builds := appBuilds(t, router, fixturePackage); builds[0].ScanStatus != scanUnscanned {
+		t.Errorf("fail-open build = %+v", builds[0])
+	}
+	if entries, _ := os.ReadDir(config.IncomingDir); len(entries) != 0 {
+		t.Errorf("incoming files left: %v", entries)
+	}
+}
+
+func TestParseClamdReply(t *testing.T) {
+	for reply, want := range map[string]string{
+		"stream: OK\x00":                     "",
+		"stream: Eicar-Signature FOUND\x00":  "Eicar-Signature",
+		"stream: Win.Trojan.Agent-1 FOUND\n": "Win.Trojan.Agent-1",
+	} {
+		if got, err := parseClamdReply(reply); err != nil || got != want {
+			t.Errorf("parseClamdReply(%q) = %q, %v", reply, got, err)
+		}
+	}
+	if _, err := parseClamdReply("INSTREAM size limit exceeded. ERROR\x00"); err == nil {
+		t.Error("size limit reply was not an error")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// client calls the HTTP API of an app-distributor server
+type client struct {
+	server   string // base URL, including any base path
+	token    string // API token for uploads
+	password string // admin or project password for deletes
+	http     *http.Client
+}
+
+// apiError is a non-2xx response of the server
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("服务器返回 HTTP %d: %s", e.Status, e.Message)
+}
+
+// endpoint returns the absolute URL of an API path with query
+func (c *client) endpoint(path string, query url.Values) string {
+	target := strings.TrimRight(c.server, "/") + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	return target
+}
+
+// do sends req and decodes a JSON response into out, unless out is nil.
+// Error responses are JSON with an "error" field or plain text.
+func (c *client) do(req *http.Request, out any) error {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.password != "" {
+		req.Header.Set("X-Admin-Password", c.password)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		var failure struct {
+			Error string `json:"error"`
+		}
+		message := strings.TrimSpace(string(body))
+		if json.Unmarshal(body, &failure) == nil && failure.Error != "" {
+			message = failure.Error
+		}
+		return &apiError{Status: resp.StatusCode, Message: message}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// get fetches a JSON document
+func (c *client) get(path string, query url.Values, out any) error {
+	req, err := http.NewRequest(http.MethodGet, c.endpoint(path, query), nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, out)
+}
+
+// del sends a DELETE request
+func (c *client) del(path string, query url.Values, out any) error {
+	req, err := http.NewRequest(http.MethodDelete, c.endpoint(path, query), nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, out)
+}
+
+// uploadResult is the response of POST /api/upload
+type uploadResult struct {
+	Message  string   `json:"message"`
+	Warnings []string `json:"warnings"`
+}
+
+// upload posts the package at path with the form fields. The file is
+// streamed, so large packages are not read into memory.
+func (c *client) upload(path string, fields map[string]string) (uploadResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return uploadResult{}, err
+	}
+	defer f.Close()
+
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		for name, value := range fields {
+			if err := form.WriteField(name, value); err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+		}
+		part, err := form.CreateFormFile("file", filepath.Base(path))
+		if err == nil {
+			_, err = io.Copy(part, f)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, c.endpoint("/api/upload", nil), body)
+	if err != nil {
+		body.Close()
+		return uploadResult{}, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	var result uploadResult
+	err = c.do(req, &result)
+	// Stop the writer should the server answer before reading everything
+	body.Close()
+	return result, err
+}
@@ -0,0 +1,432 @@
+// Command uploadsctl uploads and manages builds on an app-distributor
+// server through its HTTP API, for CI scripts and the terminal.
+//
+//	uploadsctl [-server URL] [-token T] [-password P] [-json] <command> [flags] [args]
+//
+// The global flags default to UPLOADSCTL_SERVER, UPLOADSCTL_TOKEN and
+// UPLOADSCTL_PASSWORD. Command flags go before the arguments.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// defaultServer is used without -server and UPLOADSCTL_SERVER, the address
+// the server listens on by default
+const defaultServer = "http://localhost:1234"
+
+const usage = `用法: uploadsctl [全局参数] <命令> [命令参数] [参数]
+
+命令:
+  upload [-project P] [-channel C] [-notes N | -notes-file F] [-extra k=v]... <文件>
+                                上传 APK、AAB 或 IPA
+  projects                      列出项目
+  apps <项目>                   列出项目中的应用
+  builds [-channel C] <包名>     列出应用的构建
+  delete [-channel C] [-keep-app] [-dry-run] [-force] <包名> <文件名>
+                                删除构建
+  qr [-channel C] [-file F] <包名>
+                                在终端打印构建的安装二维码
+
+全局参数:
+`
+
+// command is a subcommand; it returns an error for failures and
+// errUsage for bad arguments
+type command func(app *cli, args []string) error
+
+var errUsage = errors.New("参数错误")
+
+var commands = map[string]command{
+	"upload":   runUpload,
+	"projects": runProjects,
+	"apps":     runApps,
+	"builds":   runBuilds,
+	"delete":   runDelete,
+	"qr":       runQR,
+}
+
+// cli holds the state shared by the commands
+type cli struct {
+	client     *client
+	jsonOutput bool
+	stdout     io.Writer
+	stderr     io.Writer
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command line args and returns the exit status
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("uploadsctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	server := flags.String("server", envDefault("UPLOADSCTL_SERVER", defaultServer), "服务器地址，包含部署路径前缀")
+	token := flags.String("token", os.Getenv("UPLOADSCTL_TOKEN"), "上传用的 API 令牌")
+	password := flags.String("password", os.Getenv("UPLOADSCTL_PASSWORD"), "管理员密码或项目密码，删除时需要")
+	jsonOutput := flags.Bool("json", false, "输出服务器返回的原始 JSON")
+	timeout := flags.Duration("timeout", 0, "单个请求的超时时间，0 表示不限（上传大文件时）")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "未知命令: %s\n\n", flags.Arg(0))
+		flags.Usage()
+		return 2
+	}
+
+	app := &cli{
+		client: &client{
+			server:   *server,
+			token:    *token,
+			password: *password,
+			http:     &http.Client{Timeout: *timeout},
+		},
+		jsonOutput: *jsonOutput,
+		stdout:     stdout,
+		stderr:     stderr,
+	}
+	if err := cmd(app, flags.Args()[1:]); err != nil {
+		if !errors.Is(err, errUsage) {
+			fmt.Fprintf(stderr, "错误: %v\n", err)
+			return 1
+		}
+		return 2
+	}
+	return 0
+}
+
+func envDefault(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// parseCommand parses the flags of a command and checks that it got n
+// positional arguments
+func (app *cli) parseCommand(flags *flag.FlagSet, args []string, n int, names string) error {
+	flags.SetOutput(app.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(app.stderr, "用法: uploadsctl %s [参数] %s\n", flags.Name(), names)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != n {
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// output prints raw as indented JSON with -json, and otherwise decodes it
+// into v and calls table with a tab-separated writer
+func (app *cli) output(raw json.RawMessage, v any, table func(w io.Writer)) error {
+	if app.jsonOutput {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, raw, "", "  "); err != nil {
+			return err
+		}
+		fmt.Fprintln(app.stdout, indented.String())
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(app.stdout, 0, 4, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// extraFlags collects repeated -extra key=value flags
+type extraFlags map[string]string
+
+func (e extraFlags) String() string { return "" }
+
+func (e extraFlags) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("需要 key=value 格式: %q", value)
+	}
+	e[key] = val
+	return nil
+}
+
+func runUpload(app *cli, args []string) error {
+	flags := flag.NewFlagSet("upload", flag.ContinueOnError)
+	project := flags.String("project", "", "项目名称（必填）")
+	channel := flags.String("channel", "", "渠道（必填）")
+	notes := flags.String("notes", "", "更新说明")
+	notesFile := flags.String("notes-file", "", "从文件读取更新说明，- 表示标准输入")
+	allowDowngrade := flags.Bool("allow-downgrade", false, "允许 versionCode 低于已有构建")
+	allowSignerChange := flags.Bool("allow-signer-change", false, "允许签名证书与已有构建不同")
+	extra := extraFlags{}
+	flags.Var(extra, "extra", "自定义字段 key=value，可重复")
+	if err := app.parseCommand(flags, args, 1, "<文件>"); err != nil {
+		return err
+	}
+	if *project == "" || *channel == "" {
+		fmt.Fprintln(app.stderr, "upload 需要 -project 和 -channel")
+		return errUsage
+	}
+
+	releaseNotes := *notes
+	if *notesFile != "" {
+		var data []byte
+		var err error
+		if *notesFile == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(*notesFile)
+		}
+		if err != nil {
+			return fmt.Errorf("读取更新说明失败: %w", err)
+		}
+		releaseNotes = string(data)
+	}
+
+	// Wait for the build to be published rather than a background job
+	fields := map[string]string{
+		"projectName":  *project,
+		"channel":      *channel,
+		"releaseNotes": releaseNotes,
+		"async":        "false",
+	}
+	if *allowDowngrade {
+		fields["allowDowngrade"] = "true"
+	}
+	if *allowSignerChange {
+		fields["allowSignerChange"] = "true"
+	}
+	for key, value := range extra {
+		fields["extra_"+key] = value
+	}
+
+	started := time.Now()
+	result, err := app.client.upload(flags.Arg(0), fields)
+	if err != nil {
+		return err
+	}
+	if app.jsonOutput {
+		return json.NewEncoder(app.stdout).Encode(result)
+	}
+	fmt.Fprintf(app.stdout, "上传成功（%s）\n", time.Since(started).Round(time.Millisecond))
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(app.stdout, "警告: %s\n", warning)
+	}
+	return nil
+}
+
+func runProjects(app *cli, args []string) error {
+	flags := flag.NewFlagSet("projects", flag.ContinueOnError)
+	if err := app.parseCommand(flags, args, 0, ""); err != nil {
+		return err
+	}
+	var raw json.RawMessage
+	if err := app.client.get("/api/projects", nil, &raw); err != nil {
+		return err
+	}
+	var listing struct {
+		Projects []struct {
+			ProjectName  string `json:"projectName"`
+			AppCount     int    `json:"appCount"`
+			BuildCount   int    `json:"buildCount"`
+			LatestUpload string `json:"latestUpload"`
+		} `json:"projects"`
+	}
+	return app.output(raw, &listing, func(w io.Writer) {
+		fmt.Fprintln(w, "项目\t应用数\t构建数\t最近上传")
+		for _, p := range listing.Projects {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", p.ProjectName, p.AppCount, p.BuildCount, p.LatestUpload)
+		}
+	})
+}
+
+func runApps(app *cli, args []string) error {
+	flags := flag.NewFlagSet("apps", flag.ContinueOnError)
+	if err := app.parseCommand(flags, args, 1, "<项目>"); err != nil {
+		return err
+	}
+	var raw json.RawMessage
+	if err := app.client.get("/api/projects/"+url.PathEscape(flags.Arg(0))+"/apps", nil, &raw); err != nil {
+		return err
+	}
+	var listing struct {
+		Apps []struct {
+			AppName       string `json:"appName"`
+			PackageName   string `json:"packageName"`
+			Platform      string `json:"platform"`
+			BuildCount    int    `json:"buildCount"`
+			LatestVersion string `json:"latestVersion"`
+			LatestUpload  string `json:"latestUpload"`
+		} `json:"apps"`
+	}
+	return app.output(raw, &listing, func(w io.Writer) {
+		fmt.Fprintln(w, "应用\t包名\t平台\t构建数\t最新版本\t最近上传")
+		for _, a := range listing.Apps {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", a.AppName, a.PackageName, a.Platform, a.BuildCount, a.LatestVersion, a.LatestUpload)
+		}
+	})
+}
+
+// build is the part of a build entry the commands use
+type build struct {
+	Version     string `json:"version"`
+	VersionCode int32  `json:"versionCode"`
+	Channel     string `json:"channel"`
+	FileName    string `json:"fileName"`
+	FileSize    int64  `json:"fileSize"`
+	UploadTime  string `json:"uploadTime"`
+}
+
+// listBuilds fetches the builds of packageName, newest first, in channel
+// unless it is empty
+func (app *cli) listBuilds(packageName, channel string) (json.RawMessage, []build, error) {
+	query := url.Values{}
+	if channel != "" {
+		query.Set("channel", channel)
+	}
+	var raw json.RawMessage
+	if err := app.client.get("/api/apps/"+url.PathEscape(packageName)+"/builds", query, &raw); err != nil {
+		return nil, nil, err
+	}
+	var listing struct {
+		Builds []build `json:"builds"`
+	}
+	if err := json.Unmarshal(raw, &listing); err != nil {
+		return nil, nil, err
+	}
+	return raw, listing.Builds, nil
+}
+
+func runBuilds(app *cli, args []string) error {
+	flags := flag.NewFlagSet("builds", flag.ContinueOnError)
+	channel := flags.String("channel", "", "只列出该渠道的构建")
+	if err := app.parseCommand(flags, args, 1, "<包名>"); err != nil {
+		return err
+	}
+	raw, builds, err := app.listBuilds(flags.Arg(0), *channel)
+	if err != nil {
+		return err
+	}
+	return app.output(raw, &struct{}{}, func(w io.Writer) {
+		fmt.Fprintln(w, "版本\tversionCode\t渠道\t大小\t上传时间\t文件名")
+		for _, b := range builds {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", b.Version, b.VersionCode, b.Channel, formatSize(b.FileSize), b.UploadTime, b.FileName)
+		}
+	})
+}
+
+func runDelete(app *cli, args []string) error {
+	flags := flag.NewFlagSet("delete", flag.ContinueOnError)
+	channel := flags.String("channel", "", "只删除该渠道的条目（推广过的构建在多个渠道中列出）")
+	keepApp := flags.Bool("keep-app", false, "删除最后一个构建后保留应用")
+	dryRun := flags.Bool("dry-run", false, "只预演，不删除")
+	force := flags.Bool("force", false, "同时删除受保护的构建")
+	if err := app.parseCommand(flags, args, 2, "<包名> <文件名>"); err != nil {
+		return err
+	}
+	query := url.Values{}
+	if *channel != "" {
+		query.Set("channel", *channel)
+	}
+	if *keepApp {
+		query.Set("keepApp", "true")
+	}
+	if *dryRun {
+		query.Set("dryRun", "true")
+	}
+	if *force {
+		query.Set("force", "true")
+	}
+	var raw json.RawMessage
+	path := "/api/builds/" + url.PathEscape(flags.Arg(0)) + "/" + url.PathEscape(flags.Arg(1))
+	if err := app.client.del(path, query, &raw); err != nil {
+		return err
+	}
+	var result struct {
+		Message string `json:"message"`
+	}
+	return app.output(raw, &result, func(w io.Writer) {
+		fmt.Fprintln(w, result.Message)
+	})
+}
+
+func runQR(app *cli, args []string) error {
+	flags := flag.NewFlagSet("qr", flag.ContinueOnError)
+	channel := flags.String("channel", "", "取该渠道最新的构建")
+	fileName := flags.String("file", "", "指定构建的文件名，默认取最新的构建")
+	if err := app.parseCommand(flags, args, 1, "<包名>"); err != nil {
+		return err
+	}
+	packageName := flags.Arg(0)
+	_, builds, err := app.listBuilds(packageName, *channel)
+	if err != nil {
+		return err
+	}
+	var target *build
+	for i := range builds {
+		if *fileName == "" || builds[i].FileName == *fileName {
+			target = &builds[i]
+			break
+		}
+	}
+	if target == nil {
+		return errors.New("未找到匹配的构建")
+	}
+
+	// The link the detail page encodes, which counts the install
+	link := app.client.endpoint("/api/apps/"+url.PathEscape(packageName)+"/install", url.Values{"fileName": {target.FileName}})
+	qr, err := qrcode.New(link, qrcode.Medium)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(app.stdout, qr.ToSmallString(false))
+	fmt.Fprintf(app.stdout, "%s %s (%s)\n%s\n", packageName, target.Version, target.Channel, link)
+	return nil
+}
+
+// formatSize prints a byte count like the web UI
+func formatSize(size int64) string {
+	const (
+		_  = iota
+		KB = 1 << (10 * iota)
+		MB
+		GB
+	)
+	switch {
+	case size >= GB:
+		return fmt.Sprintf("%.2f GB", float64(size)/GB)
+	case size >= MB:
+		return fmt.Sprintf("%.2f MB", float64(size)/MB)
+	case size >= KB:
+		return fmt.Sprintf("%.2f KB", float64(size)/KB)
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
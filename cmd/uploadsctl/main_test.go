@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeServer answers the API calls uploadsctl makes, recording what it got
+func fakeServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/upload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer apd_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, "上传需要 API 令牌")
+			return
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("upload without file: %v", err)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		if header.Filename != "app.apk" || string(data) != "apk bytes" ||
+			r.FormValue("projectName") != "Demo" || r.FormValue("channel") != "beta" ||
+			r.FormValue("releaseNotes") != "修复崩溃" || r.FormValue("extra_commit") != "abc123" || r.FormValue("async") != "false" {
+			t.Errorf("upload form = %v, file %q %q", r.MultipartForm.Value, header.Filename, data)
+		}
+		io.WriteString(w, `{"message":"Upload successful","warnings":["图标已变化"]}`)
+	})
+	mux.HandleFunc("GET /api/apps/com.example.demo/builds", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"packageName":"com.example.demo","builds":[
+			{"version":"1.1","versionCode":11,"channel":"beta","fileName":"demo-1.1.apk","fileSize":2097152,"uploadTime":"2026-10-02T10:00:00Z"},
+			{"version":"1.0","versionCode":10,"channel":"stable","fileName":"demo-1.0.apk","fileSize":1024,"uploadTime":"2026-10-01T10:00:00Z"}]}`)
+	})
+	mux.HandleFunc("DELETE /api/builds/com.example.demo/demo-1.0.apk", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Admin-Password") != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"error":"需要管理员登录或有效的管理密码"}`)
+			return
+		}
+		if r.URL.Query().Get("keepApp") != "true" {
+			t.Errorf("delete query = %s, want keepApp", r.URL.RawQuery)
+		}
+		io.WriteString(w, `{"message":"构建版本已删除","appRemoved":false}`)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// runCLI runs uploadsctl against server and returns its exit status and output
+func runCLI(server *httptest.Server, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(append([]string{"-server", server.URL}, args...), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestUpload(t *testing.T) {
+	server := fakeServer(t)
+	path := filepath.Join(t.TempDir(), "app.apk")
+	if err := os.WriteFile(path, []byte("apk bytes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	code, stdout, stderr := runCLI(server, "-token", "apd_test", "upload", "-project", "Demo", "-channel", "beta",
+		"-notes", "修复崩溃", "-extra", "commit=abc123", path)
+	if code != 0 || !strings.Contains(stdout, "上传成功") || !strings.Contains(stdout, "警告: 图标已变化") {
+		t.Errorf("upload: exit %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+
+	code, _, stderr = runCLI(server, "upload", "-project", "Demo", "-channel", "beta", path)
+	if code != 1 || !strings.Contains(stderr, "HTTP 401: 上传需要 API 令牌") {
+		t.Errorf("upload without token: exit %d, stderr %q", code, stderr)
+	}
+	if code, _, _ := runCLI(server, "upload", path); code != 2 {
+		t.Errorf("upload without project: exit %d, want 2", code)
+	}
+}
+
+func TestBuildsDeleteAndQR(t *testing.T) {
+	server := fakeServer(t)
+
+	code, stdout, _ := runCLI(server, "builds", "com.example.demo")
+	if code != 0 || !strings.Contains(stdout, "2.00 MB") || strings.Index(stdout, "demo-1.1.apk") > strings.Index(stdout, "demo-1.0.apk") {
+		t.Errorf("builds: exit %d:\n%s", code, stdout)
+	}
+	code, stdout, _ = runCLI(server, "-json", "builds", "com.example.demo")
+	if code != 0 || !strings.Contains(stdout, `"fileName": "demo-1.0.apk"`) {
+		t.Errorf("builds -json: exit %d:\n%s", code, stdout)
+	}
+
+	code, _, stderr := runCLI(server, "delete", "-keep-app", "com.example.demo", "demo-1.0.apk")
+	if code != 1 || !strings.Contains(stderr, "需要管理员登录") {
+		t.Errorf("delete without password: exit %d, stderr %q", code, stderr)
+	}
+	code, stdout, stderr = runCLI(server, "-password", "s3cret", "delete", "-keep-app", "com.example.demo", "demo-1.0.apk")
+	if code != 0 || strings.TrimSpace(stdout) != "构建版本已删除" {
+		t.Errorf("delete: exit %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+
+	code, stdout, stderr = runCLI(server, "qr", "-file", "demo-1.0.apk", "com.example.demo")
+	wantLink := server.URL + "/api/apps/com.example.demo/install?fileName=demo-1.0.apk"
+	if code != 0 || !strings.Contains(stdout, "█") || !strings.Contains(stdout, wantLink) {
+		t.Errorf("qr: exit %d, stdout:\n%s\nstderr %q", code, stdout, stderr)
+	}
+	if code, _, stderr := runCLI(server, "qr", "-file", "missing.apk", "com.example.demo"); code != 1 {
+		t.Errorf("qr of a missing build: exit %d, stderr %q", code, stderr)
+	}
+}
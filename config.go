@@ -0,0 +1,786 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Config holds the runtime settings of the server.
+// Every field can be overridden with the environment variable noted next to
+// it, or with the matching key of the config file, see configFile.
+type Config struct {
+	Port int // APPDIST_PORT: HTTP port to listen on
+	// APPDIST_SHUTDOWN_TIMEOUT: how long a shutdown waits for in-flight
+	// requests and background uploads before exiting anyway
+	ShutdownTimeout time.Duration
+	// APPDIST_LOG_LEVEL: lowest level logged, "debug", "info" (default),
+	// "warn" or "error"; APPDIST_LOG_FORMAT: "text" (default) or "json" for
+	// log shippers such as Loki or Elasticsearch
+	LogLevel  string
+	LogFormat string
+	UploadDir string // APPDIST_UPLOAD_DIR: directory of the stored packages with local storage
+	// APPDIST_ADMIN_PASSWORD_HASH: bcrypt hash of the admin password
+	// required by destructive and admin endpoints, see checkAdmin. Without
+	// it main sets a random one-time password, see oneTimeAdminPassword.
+	AdminPasswordHash string
+	SessionTTL        time.Duration // APPDIST_SESSION_TTL: lifetime of an admin login session
+
+	ParseCacheSize int   // APPDIST_PARSE_CACHE_SIZE: parsed APKs kept in memory, 0 disables the cache
+	MaxUploadSize  int64 // APPDIST_MAX_UPLOAD_SIZE: largest accepted package in bytes, 0 means unlimited
+	// APPDIST_MAX_CONCURRENT_PARSES: APKs parsed at once, further uploads wait
+	// up to APPDIST_PARSE_QUEUE_TIMEOUT and then get 503; 0 means unlimited
+	MaxConcurrentParses int
+	ParseQueueTimeout   time.Duration
+	// APPDIST_ASYNC_UPLOADS: POST /api/upload answers 202 with a processing
+	// build once the file is stored and parses it in the background; clients
+	// can choose per request with async=true or async=false
+	AsyncUploads bool
+	// APPDIST_UPLOAD_WORKERS: background upload jobs processed at once
+	UploadWorkers int
+	// APPDIST_UPLOAD_QUEUE_SIZE: jobs waiting for a worker before further
+	// background uploads get 503
+	UploadQueueSize int
+	// APPDIST_MAX_OBB_SIZE: largest accepted expansion (OBB) file in bytes, 0 means unlimited
+	MaxExpansionSize int64
+	// APPDIST_PROJECT_QUOTA: bytes the files of each project may take, 0 means
+	// unlimited; PUT /api/projects/:projectName/quota overrides it per project
+	ProjectQuota int64
+	// APPDIST_MAX_RELEASE_NOTES: longest accepted release notes in characters,
+	// 0 means unlimited; APPDIST_RELEASE_NOTES_POLICY decides whether longer
+	// notes are cut ("truncate", default) or the upload fails ("reject")
+	MaxReleaseNotes    int
+	ReleaseNotesPolicy string
+	MinSDK             int // APPDIST_MIN_SDK: lowest accepted minSdkVersion, 0 disables the check
+	// APPDIST_MARKDOWN_NOTES: render release notes as sanitized Markdown
+	// rather than plain text (default true)
+	MarkdownNotes bool
+	// APPDIST_FILENAME_GUARD: reject uploads with double extensions, a non-.apk
+	// name or a content type that does not match (default true)
+	FilenameGuard bool
+	// APPDIST_DOWNGRADE_POLICY: what to do when an upload's versionCode is lower
+	// than the app's highest: "off", "warn" (default) or "strict" (reject with 409)
+	DowngradePolicy string
+	// APPDIST_DOWNGRADE_SCOPE: "package" (default) compares with every build
+	// of the app, "channel" only with the builds of the upload's channel
+	DowngradeScope string
+	// APPDIST_DUPLICATE_UPLOADS: what happens to an upload whose SHA-256
+	// matches a stored build, "reference" lists it sharing the stored file,
+	// "reject" refuses it and "store" keeps another copy
+	DuplicateUploads string
+	// APPDIST_SIGNER_POLICY: what to do when an upload is signed with a different
+	// certificate than the app's previous builds: "off", "warn" (default) or
+	// "strict" (reject with 409 unless allowSignerChange=true)
+	SignerPolicy string
+	// APPDIST_PACKAGE_PREFIXES: comma-separated "project=prefix" items such as
+	// "Acme=com.acme."; uploads into a listed project must have a package name
+	// starting with its prefix. PUT /api/projects/:projectName/package-prefix
+	// overrides it per project.
+	PackagePrefixes map[string]string
+	// APPDIST_CHANNEL_POLICIES: comma-separated "channel:rule;rule" items adding
+	// upload rules per channel, e.g. "stable:requireNotes;increaseVersion;maxSize=104857600";
+	// rules are requireNotes, increaseVersion, maxSize=<bytes> and minSdk=<level>
+	ChannelPolicies map[string]ChannelPolicy
+
+	// APPDIST_CHANNEL_ORDER: comma-separated channels listed first, in this
+	// order, on the detail page; other channels follow alphabetically
+	ChannelOrder []string
+
+	// APPDIST_FROM_URL_HOSTS: comma-separated hosts POST /api/upload/from-url may
+	// download from; "*.example.com" matches its subdomains. Empty disables it.
+	FromURLHosts   []string
+	FromURLTimeout time.Duration // APPDIST_FROM_URL_TIMEOUT: limit for the whole download
+	FromURLMaxSize int64         // APPDIST_FROM_URL_MAX_SIZE: largest downloaded package in bytes
+
+	// APPDIST_CHUNKED_UPLOAD_MAX_SIZE: largest package accepted through the
+	// resumable chunked upload API, in bytes
+	ChunkedUploadMaxSize int64
+	// APPDIST_CHUNKED_UPLOAD_EXPIRY: unfinished chunked uploads are removed
+	// after this long without a new chunk
+	ChunkedUploadExpiry time.Duration
+
+	// APPDIST_MAX_EVENT_SUBSCRIBERS: concurrent GET /api/events streams, further
+	// clients get 503; 0 means unlimited
+	MaxEventSubscribers int
+
+	// APPDIST_KEEP_EMPTY_APPS: keep an app and its icon when its last build is
+	// deleted instead of removing it; ?keepApp= overrides per request
+	KeepEmptyApps bool
+
+	// APPDIST_READ_ONLY: start in maintenance mode, rejecting uploads and
+	// deletes; toggled at runtime with POST /api/admin/maintenance
+	ReadOnly bool
+
+	SiteTitle string // APPDIST_SITE_TITLE: name shown in page titles and headers
+	// APPDIST_FAVICON_PATH: image file served as /favicon.ico and linked from
+	// every page; empty serves no favicon
+	FaviconPath string
+	// APPDIST_ASSETS_DIR: directory whose templates/*.html and static/ files
+	// replace the embedded ones of the same name; empty uses the embedded
+	// pages only
+	AssetsDir string
+
+	// APPDIST_BASE_PATH: URL prefix when served below a sub path behind a
+	// reverse proxy, e.g. "/apps"; empty serves from the root
+	BasePath string
+	// APPDIST_EXTERNAL_URL: scheme and host clients reach the server at, such
+	// as "https://apps.example.com"; the base path is appended. Links and QR
+	// codes use it instead of guessing from the request.
+	ExternalURL string
+	// APPDIST_TRUSTED_PROXIES: comma separated IPs or CIDRs of reverse proxies
+	// whose X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-For headers
+	// are believed; empty trusts none
+	TrustedProxies []string
+	trustedProxies []netip.Prefix
+
+	// APPDIST_TLS_CERT and APPDIST_TLS_KEY: PEM certificate chain and key to
+	// serve HTTPS on APPDIST_PORT with; the files are reloaded when they
+	// change, so renewed certificates need no restart
+	TLSCert string
+	TLSKey  string
+	// APPDIST_AUTOCERT_DOMAINS: comma separated domains to obtain and renew
+	// Let's Encrypt certificates for, instead of TLSCert and TLSKey
+	AutocertDomains  []string
+	AutocertEmail    string // APPDIST_AUTOCERT_EMAIL: contact address of the ACME account
+	AutocertCacheDir string // APPDIST_AUTOCERT_CACHE_DIR: where certificates and the account key are kept
+	// APPDIST_HTTP_REDIRECT_PORT: with HTTPS, a plain HTTP port redirecting
+	// to HTTPS that also answers ACME HTTP-01 challenges; 0 disables it
+	HTTPRedirectPort int
+
+	// APPDIST_DISPLAY_TIMEZONE: IANA time zone such as "Asia/Shanghai" that
+	// pages render timestamps in; "Local" (default) uses the server's zone.
+	// Viewers can override it with ?tz= or a tz cookie.
+	DisplayTimezone string
+	displayLocation *time.Location
+
+	// APPDIST_INCOMING_DIR: unserved directory uploads are written to while
+	// they are checked; keep it on the same file system as uploads so accepted
+	// packages are moved rather than copied
+	IncomingDir string
+	// APPDIST_QUARANTINE_DIR: where rejected uploads are moved for inspection;
+	// empty deletes them
+	QuarantineDir string
+	// APPDIST_CLAMAV_ADDRESS: clamd uploads are scanned with, a unix socket
+	// path or host:port; empty disables scanning
+	ClamAVAddress string
+	ClamAVTimeout time.Duration // APPDIST_CLAMAV_TIMEOUT: limit for scanning one upload
+	// APPDIST_CLAMAV_FAIL_OPEN: accept uploads unscanned when clamd cannot
+	// scan them, instead of answering 503
+	ClamAVFailOpen bool
+
+	// APPDIST_DELTA_DIR: cache of generated delta patches, keyed by the file
+	// hashes; it may be cleared at any time
+	DeltaDir string
+
+	// APPDIST_STORAGE: where build packages are kept, "local" (the uploads
+	// directory, default) or "s3"; the APPDIST_S3_* options configure the
+	// bucket, see s3Storage
+	Storage     string
+	S3Endpoint  string        // APPDIST_S3_ENDPOINT: e.g. "https://s3.amazonaws.com" or "http://minio:9000"
+	S3Region    string        // APPDIST_S3_REGION
+	S3Bucket    string        // APPDIST_S3_BUCKET
+	S3Prefix    string        // APPDIST_S3_PREFIX: prepended to object keys, e.g. "packages/"
+	S3AccessKey string        // APPDIST_S3_ACCESS_KEY
+	S3SecretKey string        // APPDIST_S3_SECRET_KEY
+	S3PublicURL string        // APPDIST_S3_PUBLIC_URL: base URL of publicly readable objects, e.g. a CDN
+	S3URLExpiry time.Duration // APPDIST_S3_URL_EXPIRY: validity of presigned download URLs
+
+	MetadataPath     string        // APPDIST_METADATA_PATH: location of the catalog JSON file
+	SnapshotDir      string        // APPDIST_SNAPSHOT_DIR: directory for timestamped metadata snapshots
+	SnapshotInterval time.Duration // APPDIST_SNAPSHOT_INTERVAL: e.g. "6h", 0 disables periodic snapshots
+	SnapshotRetain   int           // APPDIST_SNAPSHOT_RETAIN: snapshots kept before the oldest are pruned
+
+	// APPDIST_METADATA_STORE: "json" (default, the file at MetadataPath) or
+	// "sqlite"; a new SQLite database imports MetadataPath once
+	MetadataStore string
+	SQLitePath    string // APPDIST_SQLITE_PATH: location of the SQLite database
+
+	// APPDIST_ICON_FORMAT: "png" (default), "jpeg", "webp" (lossless) or "auto",
+	// which keeps PNG for icons with transparency and uses JPEG otherwise
+	IconFormat  string
+	IconQuality int // APPDIST_ICON_QUALITY: JPEG quality from 1 to 100
+
+	HomepageBuilds int // APPDIST_HOMEPAGE_BUILDS: recent builds listed per app on the homepage
+	// APPDIST_BUILD_ORDER: how pages and GET /api/apps/:packageName/builds
+	// order builds by default, "upload" (newest upload first) or "version"
+	// (highest versionCode first, see buildBefore)
+	BuildOrder string
+	// APPDIST_ICON_CHANGE_THRESHOLD: icon hash distance (out of 64 bits) above
+	// which an upload warns that the icon changed, 0 disables the check
+	IconChangeThreshold int
+
+	StatsPath string // APPDIST_STATS_PATH: location of the download/install counters file
+	// APPDIST_INSTALL_DEDUP_WINDOW: repeated install clicks from the same browser
+	// within this window are counted once, 0 counts every click
+	InstallDedupWindow time.Duration
+
+	SMTPHost        string   // APPDIST_SMTP_HOST: mail server, empty disables email notifications
+	SMTPPort        int      // APPDIST_SMTP_PORT
+	SMTPUsername    string   // APPDIST_SMTP_USERNAME: empty sends without authentication
+	SMTPPassword    string   // APPDIST_SMTP_PASSWORD
+	SMTPFrom        string   // APPDIST_SMTP_FROM: sender address
+	EmailRecipients []string // APPDIST_EMAIL_RECIPIENTS: comma-separated list of addresses
+
+	// Chat robot webhooks that receive a card for every new build; empty
+	// disables the robot. The secrets sign requests of robots with "加签"
+	// security enabled.
+	DingTalkWebhook string // APPDIST_DINGTALK_WEBHOOK
+	DingTalkSecret  string // APPDIST_DINGTALK_SECRET
+	WeComWebhook    string // APPDIST_WECOM_WEBHOOK
+	FeishuWebhook   string // APPDIST_FEISHU_WEBHOOK
+	FeishuSecret    string // APPDIST_FEISHU_SECRET
+
+	// APPDIST_UPLOAD_TOKENS_REQUIRED: the upload API only accepts requests
+	// with an API token, see requireUploadToken
+	UploadTokensRequired bool
+	TokensPath           string // APPDIST_TOKENS_PATH: location of the API token file
+	// APPDIST_MULTI_TENANT: users log in with their own password and only
+	// see the projects they are members of, directly or through an
+	// organization; see requireMembership
+	MultiTenant bool
+	UsersPath   string // APPDIST_USERS_PATH: location of the user and organization file
+	// APPDIST_AUDIT_LOG_PATH: append-only JSON lines file recording uploads,
+	// deletes, promotions and metadata edits; empty disables the audit log
+	AuditLogPath string
+
+	// APPDIST_WEBHOOK_TIMEOUT: how long one webhook delivery attempt may take
+	WebhookTimeout time.Duration
+
+	// APPDIST_BUNDLETOOL_JAR: bundletool jar used to build a universal APK
+	// from uploaded App Bundles; empty stores bundles without one
+	BundletoolJar string
+	// APPDIST_BUNDLETOOL_ARGS: comma-separated extra build-apks arguments,
+	// e.g. the signing key "--ks=release.jks,--ks-key-alias=upload,--ks-pass=file:ks.pass"
+	BundletoolArgs []string
+
+	// APPDIST_LINK_SIGNING_KEY: HMAC key of the signed download links of
+	// private apps; empty uses a random key, so links end with the process
+	LinkSigningKey string
+	MaxLinkTTL     time.Duration // APPDIST_MAX_LINK_TTL: longest lifetime of a signed download link
+
+	// APPDIST_RETENTION_INTERVAL: how often project retention policies are
+	// applied, 0 disables the janitor
+	RetentionInterval time.Duration
+	// APPDIST_RETENTION_EXEMPT_TAGS: comma-separated build tags that protect
+	// a build from retention
+	RetentionExemptTags []string
+
+	// APPDIST_RECONCILE_INTERVAL: how often the uploads and icon directories
+	// are compared with the metadata, 0 disables the check; orphaned files
+	// are reported, and deleted when APPDIST_RECONCILE_DELETE is set
+	ReconcileInterval time.Duration
+	ReconcileDelete   bool
+	// APPDIST_RECONCILE_GRACE: files modified more recently are never
+	// orphans, as they may belong to an upload in progress
+	ReconcileGrace time.Duration
+}
+
+// config is the active configuration, populated by loadConfig at startup
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		Port:            1234,
+		ShutdownTimeout: time.Minute,
+		LogLevel:        "info",
+		LogFormat:       logFormatText,
+		UploadDir:       "uploads",
+		SessionTTL:      12 * time.Hour,
+
+		ParseCacheSize:   128,
+		DowngradePolicy:  downgradeWarn,
+		DowngradeScope:   downgradeScopePackage,
+		DuplicateUploads: duplicateReference,
+		SignerPolicy:     signerWarn,
+		FilenameGuard:    true,
+		FromURLTimeout:   5 * time.Minute,
+		FromURLMaxSize:   1 << 30,
+		IncomingDir:      "incoming",
+		ClamAVTimeout:    2 * time.Minute,
+		DeltaDir:         "deltas",
+		MetadataPath:     "metadata.json",
+		SnapshotDir:      "backups",
+		SnapshotRetain:   10,
+
+		AutocertCacheDir: "certs",
+
+		MetadataStore: metadataStoreJSON,
+		SQLitePath:    "metadata.db",
+
+		SMTPPort:        587,
+		SiteTitle:       "应用分发平台",
+		DisplayTimezone: "Local",
+		displayLocation: time.Local,
+
+		IconFormat:          iconFormatPNG,
+		IconQuality:         85,
+		HomepageBuilds:      1,
+		BuildOrder:          sortByUpload,
+		IconChangeThreshold: 12,
+
+		MaxExpansionSize:   4 << 30,
+		MaxReleaseNotes:    5000,
+		ReleaseNotesPolicy: notesTruncate,
+		MarkdownNotes:      true,
+
+		ChannelOrder: []string{"stable", "release", "prod", "beta", "alpha", "dev"},
+
+		MaxEventSubscribers: 100,
+
+		Storage:     storageLocal,
+		S3Region:    "us-east-1",
+		S3URLExpiry: time.Hour,
+
+		MaxConcurrentParses: runtime.NumCPU(),
+		ParseQueueTimeout:   30 * time.Second,
+		UploadWorkers:       2,
+		UploadQueueSize:     100,
+
+		StatsPath:          "stats.json",
+		InstallDedupWindow: time.Hour,
+
+		UploadTokensRequired: true,
+		TokensPath:           "tokens.json",
+		UsersPath:            "users.json",
+		AuditLogPath:         "audit.log",
+
+		ChunkedUploadMaxSize: 4 << 30,
+		ChunkedUploadExpiry:  24 * time.Hour,
+
+		WebhookTimeout: 10 * time.Second,
+
+		MaxLinkTTL: 7 * 24 * time.Hour,
+
+		RetentionInterval:   time.Hour,
+		RetentionExemptTags: []string{"pinned", "protected"},
+
+		ReconcileInterval: 24 * time.Hour,
+		ReconcileGrace:    time.Hour,
+	}
+}
+
+// loadConfig builds the configuration from the defaults and the environment.
+func loadConfig() (Config, error) {
+	cfg := defaultConfig()
+	var err error
+	if settingsFile, err = openConfigFile(); err != nil {
+		return cfg, err
+	}
+	if cfg.Port, err = envInt("APPDIST_PORT", cfg.Port); err != nil {
+		return cfg, err
+	}
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		return cfg, fmt.Errorf("环境变量 APPDIST_PORT 取值无效: %d", cfg.Port)
+	}
+	if cfg.ShutdownTimeout, err = envDuration("APPDIST_SHUTDOWN_TIMEOUT", cfg.ShutdownTimeout); err != nil {
+		return cfg, err
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		return cfg, fmt.Errorf("环境变量 APPDIST_SHUTDOWN_TIMEOUT 取值无效: %s", cfg.ShutdownTimeout)
+	}
+	cfg.LogLevel = strings.ToLower(envString("APPDIST_LOG_LEVEL", cfg.LogLevel))
+	if _, ok := logLevels[cfg.LogLevel]; !ok {
+		return cfg, fmt.Errorf("环境变量 APPDIST_LOG_LEVEL 取值无效: %s", cfg.LogLevel)
+	}
+	cfg.LogFormat = strings.ToLower(envString("APPDIST_LOG_FORMAT", cfg.LogFormat))
+	if cfg.LogFormat != logFormatText && cfg.LogFormat != logFormatJSON {
+		return cfg, fmt.Errorf("环境变量 APPDIST_LOG_FORMAT 取值无效: %s", cfg.LogFormat)
+	}
+	cfg.UploadDir = envString("APPDIST_UPLOAD_DIR", cfg.UploadDir)
+	// The plain-text password was replaced by the hash; refuse to start
+	// rather than ignore it silently
+	if envString("APPDIST_DELETE_PASSWORD", "") != "" {
+		return cfg, errors.New("环境变量 APPDIST_DELETE_PASSWORD 已不再支持，请改用 APPDIST_ADMIN_PASSWORD_HASH 配置管理员密码的 bcrypt 哈希")
+	}
+	cfg.AdminPasswordHash = envString("APPDIST_ADMIN_PASSWORD_HASH", cfg.AdminPasswordHash)
+	if cfg.AdminPasswordHash != "" {
+		if _, err := bcrypt.Cost([]byte(cfg.AdminPasswordHash)); err != nil {
+			return cfg, fmt.Errorf("环境变量 APPDIST_ADMIN_PASSWORD_HASH 不是有效的 bcrypt 哈希: %w", err)
+		}
+	}
+	if cfg.SessionTTL, err = envDuration("APPDIST_SESSION_TTL", cfg.SessionTTL); err != nil {
+		return cfg, err
+	}
+	if cfg.SessionTTL <= 0 {
+		return cfg, fmt.Errorf("环境变量 APPDIST_SESSION_TTL 取值无效: %s", cfg.SessionTTL)
+	}
+	if cfg.ParseCacheSize, err = envInt("APPDIST_PARSE_CACHE_SIZE", cfg.ParseCacheSize); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxConcurrentParses, err = envInt("APPDIST_MAX_CONCURRENT_PARSES", cfg.MaxConcurrentParses); err != nil {
+		return cfg, err
+	}
+	if cfg.ParseQueueTimeout, err = envDuration("APPDIST_PARSE_QUEUE_TIMEOUT", cfg.ParseQueueTimeout); err != nil {
+		return cfg, err
+	}
+	if cfg.AsyncUploads, err = envBool("APPDIST_ASYNC_UPLOADS", cfg.AsyncUploads); err != nil {
+		return cfg, err
+	}
+	if cfg.UploadWorkers, err = envInt("APPDIST_UPLOAD_WORKERS", cfg.UploadWorkers); err != nil {
+		return cfg, err
+	}
+	if cfg.UploadQueueSize, err = envInt("APPDIST_UPLOAD_QUEUE_SIZE", cfg.UploadQueueSize); err != nil {
+		return cfg, err
+	}
+	maxUploadSize, err := envInt("APPDIST_MAX_UPLOAD_SIZE", int(cfg.MaxUploadSize))
+	if err != nil {
+		return cfg, err
+	}
+	cfg.MaxUploadSize = int64(maxUploadSize)
+	maxExpansionSize, err := envInt("APPDIST_MAX_OBB_SIZE", int(cfg.MaxExpansionSize))
+	if err != nil {
+		return cfg, err
+	}
+	cfg.MaxExpansionSize = int64(maxExpansionSize)
+	projectQuota, err := envInt("APPDIST_PROJECT_QUOTA", int(cfg.ProjectQuota))
+	if err != nil {
+		return cfg, err
+	}
+	if projectQuota < 0 {
+		return cfg, fmt.Errorf("环境变量 APPDIST_PROJECT_QUOTA 取值无效: %d", projectQuota)
+	}
+	cfg.ProjectQuota = int64(projectQuota)
+	if cfg.MaxReleaseNotes, err = envInt("APPDIST_MAX_RELEASE_NOTES", cfg.MaxReleaseNotes); err != nil {
+		return cfg, err
+	}
+	cfg.ReleaseNotesPolicy = strings.ToLower(envString("APPDIST_RELEASE_NOTES_POLICY", cfg.ReleaseNotesPolicy))
+	switch cfg.ReleaseNotesPolicy {
+	case notesTruncate, notesReject:
+	default:
+		return cfg, fmt.Errorf("环境变量 APPDIST_RELEASE_NOTES_POLICY 取值无效: %s", cfg.ReleaseNotesPolicy)
+	}
+	if cfg.MarkdownNotes, err = envBool("APPDIST_MARKDOWN_NOTES", cfg.MarkdownNotes); err != nil {
+		return cfg, err
+	}
+	if cfg.MinSDK, err = envInt("APPDIST_MIN_SDK", cfg.MinSDK); err != nil {
+		return cfg, err
+	}
+	if cfg.FilenameGuard, err = envBool("APPDIST_FILENAME_GUARD", cfg.FilenameGuard); err != nil {
+		return cfg, err
+	}
+	cfg.DowngradePolicy = strings.ToLower(envString("APPDIST_DOWNGRADE_POLICY", cfg.DowngradePolicy))
+	switch cfg.DowngradePolicy {
+	case downgradeOff, downgradeWarn, downgradeStrict:
+	default:
+		return cfg, fmt.Errorf("环境变量 APPDIST_DOWNGRADE_POLICY 取值无效: %s", cfg.DowngradePolicy)
+	}
+	cfg.DowngradeScope = strings.ToLower(envString("APPDIST_DOWNGRADE_SCOPE", cfg.DowngradeScope))
+	switch cfg.DowngradeScope {
+	case downgradeScopePackage, downgradeScopeChannel:
+	default:
+		return cfg, fmt.Errorf("环境变量 APPDIST_DOWNGRADE_SCOPE 取值无效: %s", cfg.DowngradeScope)
+	}
+	cfg.DuplicateUploads = strings.ToLower(envString("APPDIST_DUPLICATE_UPLOADS", cfg.DuplicateUploads))
+	switch cfg.DuplicateUploads {
+	case duplicateReference, duplicateReject, duplicateStore:
+	default:
+		return cfg, fmt.Errorf("环境变量 APPDIST_DUPLICATE_UPLOADS 取值无效: %s", cfg.DuplicateUploads)
+	}
+	if cfg.PackagePrefixes, err = parsePackagePrefixes(envList("APPDIST_PACKAGE_PREFIXES", nil)); err != nil {
+		return cfg, err
+	}
+	if cfg.ChannelPolicies, err = parseChannelPolicies(envList("APPDIST_CHANNEL_POLICIES", nil)); err != nil {
+		return cfg, err
+	}
+	cfg.ChannelOrder = envList("APPDIST_CHANNEL_ORDER", cfg.ChannelOrder)
+	cfg.FromURLHosts = envList("APPDIST_FROM_URL_HOSTS", cfg.FromURLHosts)
+	if cfg.FromURLTimeout, err = envDuration("APPDIST_FROM_URL_TIMEOUT", cfg.FromURLTimeout); err != nil {
+		return cfg, err
+	}
+	fromURLMaxSize, err := envInt("APPDIST_FROM_URL_MAX_SIZE", int(cfg.FromURLMaxSize))
+	if err != nil {
+		return cfg, err
+	}
+	cfg.FromURLMaxSize = int64(fromURLMaxSize)
+	chunkedUploadMaxSize, err := envInt("APPDIST_CHUNKED_UPLOAD_MAX_SIZE", int(cfg.ChunkedUploadMaxSize))
+	if err != nil {
+		return cfg, err
+	}
+	cfg.ChunkedUploadMaxSize = int64(chunkedUploadMaxSize)
+	if cfg.ChunkedUploadExpiry, err = envDuration("APPDIST_CHUNKED_UPLOAD_EXPIRY", cfg.ChunkedUploadExpiry); err != nil {
+		return cfg, err
+	}
+	if cfg.ChunkedUploadExpiry <= 0 {
+		return cfg, fmt.Errorf("环境变量 APPDIST_CHUNKED_UPLOAD_EXPIRY 取值无效: %s", cfg.ChunkedUploadExpiry)
+	}
+	if cfg.WebhookTimeout, err = envDuration("APPDIST_WEBHOOK_TIMEOUT", cfg.WebhookTimeout); err != nil {
+		return cfg, err
+	}
+	cfg.BundletoolJar = envString("APPDIST_BUNDLETOOL_JAR", cfg.BundletoolJar)
+	cfg.BundletoolArgs = envList("APPDIST_BUNDLETOOL_ARGS", cfg.BundletoolArgs)
+	cfg.LinkSigningKey = envString("APPDIST_LINK_SIGNING_KEY", cfg.LinkSigningKey)
+	if cfg.MaxLinkTTL, err = envDuration("APPDIST_MAX_LINK_TTL", cfg.MaxLinkTTL); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxLinkTTL <= 0 {
+		return cfg, fmt.Errorf("环境变量 APPDIST_MAX_LINK_TTL 取值无效: %s", cfg.MaxLinkTTL)
+	}
+	if cfg.RetentionInterval, err = envDuration("APPDIST_RETENTION_INTERVAL", cfg.RetentionInterval); err != nil {
+		return cfg, err
+	}
+	cfg.RetentionExemptTags = envList("APPDIST_RETENTION_EXEMPT_TAGS", cfg.RetentionExemptTags)
+	for i, tag := range cfg.RetentionExemptTags {
+		cfg.RetentionExemptTags[i] = strings.ToLower(tag)
+	}
+	if cfg.ReconcileInterval, err = envDuration("APPDIST_RECONCILE_INTERVAL", cfg.ReconcileInterval); err != nil {
+		return cfg, err
+	}
+	if cfg.ReconcileDelete, err = envBool("APPDIST_RECONCILE_DELETE", cfg.ReconcileDelete); err != nil {
+		return cfg, err
+	}
+	if cfg.ReconcileGrace, err = envDuration("APPDIST_RECONCILE_GRACE", cfg.ReconcileGrace); err != nil {
+		return cfg, err
+	}
+	cfg.SignerPolicy = strings.ToLower(envString("APPDIST_SIGNER_POLICY", cfg.SignerPolicy))
+	switch cfg.SignerPolicy {
+	case signerOff, signerWarn, signerStrict:
+	default:
+		return cfg, fmt.Errorf("环境变量 APPDIST_SIGNER_POLICY 取值无效: %s", cfg.SignerPolicy)
+	}
+	if cfg.MaxEventSubscribers, err = envInt("APPDIST_MAX_EVENT_SUBSCRIBERS", cfg.MaxEventSubscribers); err != nil {
+		return cfg, err
+	}
+	if cfg.KeepEmptyApps, err = envBool("APPDIST_KEEP_EMPTY_APPS", cfg.KeepEmptyApps); err != nil {
+		return cfg, err
+	}
+	if cfg.ReadOnly, err = envBool("APPDIST_READ_ONLY", cfg.ReadOnly); err != nil {
+		return cfg, err
+	}
+	cfg.SiteTitle = envString("APPDIST_SITE_TITLE", cfg.SiteTitle)
+	cfg.FaviconPath = envString("APPDIST_FAVICON_PATH", cfg.FaviconPath)
+	if cfg.FaviconPath != "" {
+		if _, err := os.Stat(cfg.FaviconPath); err != nil {
+			return cfg, fmt.Errorf("环境变量 APPDIST_FAVICON_PATH 取值无效: %w", err)
+		}
+	}
+	cfg.AssetsDir = envString("APPDIST_ASSETS_DIR", cfg.AssetsDir)
+	if cfg.AssetsDir != "" {
+		if info, err := os.Stat(cfg.AssetsDir); err != nil {
+			return cfg, fmt.Errorf("环境变量 APPDIST_ASSETS_DIR 取值无效: %w", err)
+		} else if !info.IsDir() {
+			return cfg, fmt.Errorf("环境变量 APPDIST_ASSETS_DIR 取值无效: %s 不是目录", cfg.AssetsDir)
+		}
+	}
+	cfg.BasePath = normalizeBasePath(envString("APPDIST_BASE_PATH", cfg.BasePath))
+	if cfg.ExternalURL, err = normalizeExternalURL(envString("APPDIST_EXTERNAL_URL", cfg.ExternalURL)); err != nil {
+		return cfg, fmt.Errorf("环境变量 APPDIST_EXTERNAL_URL 取值无效: %w", err)
+	}
+	cfg.TrustedProxies = envList("APPDIST_TRUSTED_PROXIES", cfg.TrustedProxies)
+	if cfg.trustedProxies, err = parseTrustedProxies(cfg.TrustedProxies); err != nil {
+		return cfg, fmt.Errorf("环境变量 APPDIST_TRUSTED_PROXIES 取值无效: %w", err)
+	}
+	cfg.TLSCert = envString("APPDIST_TLS_CERT", cfg.TLSCert)
+	cfg.TLSKey = envString("APPDIST_TLS_KEY", cfg.TLSKey)
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return cfg, fmt.Errorf("环境变量 APPDIST_TLS_CERT 与 APPDIST_TLS_KEY 必须同时设置")
+	}
+	cfg.AutocertDomains = envList("APPDIST_AUTOCERT_DOMAINS", cfg.AutocertDomains)
+	if len(cfg.AutocertDomains) > 0 && cfg.TLSCert != "" {
+		return cfg, fmt.Errorf("环境变量 APPDIST_AUTOCERT_DOMAINS 不能与 APPDIST_TLS_CERT 同时设置")
+	}
+	cfg.AutocertEmail = envString("APPDIST_AUTOCERT_EMAIL", cfg.AutocertEmail)
+	cfg.AutocertCacheDir = envString("APPDIST_AUTOCERT_CACHE_DIR", cfg.AutocertCacheDir)
+	if cfg.HTTPRedirectPort, err = envInt("APPDIST_HTTP_REDIRECT_PORT", cfg.HTTPRedirectPort); err != nil {
+		return cfg, err
+	}
+	if cfg.HTTPRedirectPort < 0 || cfg.HTTPRedirectPort > 65535 || cfg.HTTPRedirectPort == cfg.Port {
+		return cfg, fmt.Errorf("环境变量 APPDIST_HTTP_REDIRECT_PORT 取值无效: %d", cfg.HTTPRedirectPort)
+	}
+	cfg.DisplayTimezone = envString("APPDIST_DISPLAY_TIMEZONE", cfg.DisplayTimezone)
+	if cfg.displayLocation, err = time.LoadLocation(cfg.DisplayTimezone); err != nil {
+		return cfg, fmt.Errorf("环境变量 APPDIST_DISPLAY_TIMEZONE 取值无效: %s", cfg.DisplayTimezone)
+	}
+	cfg.IncomingDir = envString("APPDIST_INCOMING_DIR", cfg.IncomingDir)
+	cfg.QuarantineDir = envString("APPDIST_QUARANTINE_DIR", cfg.QuarantineDir)
+	cfg.ClamAVAddress = envString("APPDIST_CLAMAV_ADDRESS", cfg.ClamAVAddress)
+	if cfg.ClamAVTimeout, err = envDuration("APPDIST_CLAMAV_TIMEOUT", cfg.ClamAVTimeout); err != nil {
+		return cfg, err
+	}
+	if cfg.ClamAVTimeout <= 0 {
+		return cfg, fmt.Errorf("环境变量 APPDIST_CLAMAV_TIMEOUT 取值无效: %s", cfg.ClamAVTimeout)
+	}
+	if cfg.ClamAVFailOpen, err = envBool("APPDIST_CLAMAV_FAIL_OPEN", cfg.ClamAVFailOpen); err != nil {
+		return cfg, err
+	}
+	cfg.DeltaDir = envString("APPDIST_DELTA_DIR", cfg.DeltaDir)
+	cfg.Storage = strings.ToLower(envString("APPDIST_STORAGE", cfg.Storage))
+	cfg.S3Endpoint = envString("APPDIST_S3_ENDPOINT", cfg.S3Endpoint)
+	cfg.S3Region = envString("APPDIST_S3_REGION", cfg.S3Region)
+	cfg.S3Bucket = envString("APPDIST_S3_BUCKET", cfg.S3Bucket)
+	cfg.S3Prefix = envString("APPDIST_S3_PREFIX", cfg.S3Prefix)
+	cfg.S3AccessKey = envString("APPDIST_S3_ACCESS_KEY", cfg.S3AccessKey)
+	cfg.S3SecretKey = envString("APPDIST_S3_SECRET_KEY", cfg.S3SecretKey)
+	cfg.S3PublicURL = envString("APPDIST_S3_PUBLIC_URL", cfg.S3PublicURL)
+	if cfg.S3URLExpiry, err = envDuration("APPDIST_S3_URL_EXPIRY", cfg.S3URLExpiry); err != nil {
+		return cfg, err
+	}
+	switch cfg.Storage {
+	case storageLocal:
+	case storageS3:
+		required := []struct{ key, value string }{
+			{"APPDIST_S3_ENDPOINT", cfg.S3Endpoint},
+			{"APPDIST_S3_BUCKET", cfg.S3Bucket},
+			{"APPDIST_S3_ACCESS_KEY", cfg.S3AccessKey},
+			{"APPDIST_S3_SECRET_KEY", cfg.S3SecretKey},
+		}
+		for _, option := range required {
+			if option.value == "" {
+				return cfg, fmt.Errorf("APPDIST_STORAGE=s3 时必须设置环境变量 %s", option.key)
+			}
+		}
+		if cfg.S3URLExpiry < time.Second || cfg.S3URLExpiry > s3MaxURLExpiry {
+			return cfg, fmt.Errorf("环境变量 APPDIST_S3_URL_EXPIRY 取值无效: %s（应在 1s 到 168h 之间）", cfg.S3URLExpiry)
+		}
+	default:
+		return cfg, fmt.Errorf("环境变量 APPDIST_STORAGE 取值无效: %s", cfg.Storage)
+	}
+	cfg.MetadataPath = envString("APPDIST_METADATA_PATH", cfg.MetadataPath)
+	cfg.MetadataStore = strings.ToLower(envString("APPDIST_METADATA_STORE", cfg.MetadataStore))
+	if cfg.MetadataStore != metadataStoreJSON && cfg.MetadataStore != metadataStoreSQLite {
+		return cfg, fmt.Errorf("环境变量 APPDIST_METADATA_STORE 取值无效: %s", cfg.MetadataStore)
+	}
+	cfg.SQLitePath = envString("APPDIST_SQLITE_PATH", cfg.SQLitePath)
+	cfg.SnapshotDir = envString("APPDIST_SNAPSHOT_DIR", cfg.SnapshotDir)
+	if cfg.SnapshotInterval, err = envDuration("APPDIST_SNAPSHOT_INTERVAL", cfg.SnapshotInterval); err != nil {
+		return cfg, err
+	}
+	if cfg.SnapshotRetain, err = envInt("APPDIST_SNAPSHOT_RETAIN", cfg.SnapshotRetain); err != nil {
+		return cfg, err
+	}
+	cfg.IconFormat = strings.ToLower(envString("APPDIST_ICON_FORMAT", cfg.IconFormat))
+	if cfg.IconFormat == "jpg" {
+		cfg.IconFormat = iconFormatJPEG
+	}
+	if _, ok := iconExtensions[cfg.IconFormat]; !ok && cfg.IconFormat != iconFormatAuto {
+		return cfg, fmt.Errorf("环境变量 APPDIST_ICON_FORMAT 取值无效: %s", cfg.IconFormat)
+	}
+	if cfg.IconQuality, err = envInt("APPDIST_ICON_QUALITY", cfg.IconQuality); err != nil {
+		return cfg, err
+	}
+	if cfg.IconQuality < 1 || cfg.IconQuality > 100 {
+		return cfg, fmt.Errorf("环境变量 APPDIST_ICON_QUALITY 应在 1 到 100 之间: %d", cfg.IconQuality)
+	}
+	if cfg.HomepageBuilds, err = envInt("APPDIST_HOMEPAGE_BUILDS", cfg.HomepageBuilds); err != nil {
+		return cfg, err
+	}
+	cfg.BuildOrder = strings.ToLower(envString("APPDIST_BUILD_ORDER", cfg.BuildOrder))
+	if cfg.BuildOrder != sortByUpload && cfg.BuildOrder != sortByVersion {
+		return cfg, fmt.Errorf("环境变量 APPDIST_BUILD_ORDER 取值无效: %s", cfg.BuildOrder)
+	}
+	if cfg.IconChangeThreshold, err = envInt("APPDIST_ICON_CHANGE_THRESHOLD", cfg.IconChangeThreshold); err != nil {
+		return cfg, err
+	}
+	cfg.StatsPath = envString("APPDIST_STATS_PATH", cfg.StatsPath)
+	if cfg.InstallDedupWindow, err = envDuration("APPDIST_INSTALL_DEDUP_WINDOW", cfg.InstallDedupWindow); err != nil {
+		return cfg, err
+	}
+	cfg.SMTPHost = envString("APPDIST_SMTP_HOST", cfg.SMTPHost)
+	if cfg.SMTPPort, err = envInt("APPDIST_SMTP_PORT", cfg.SMTPPort); err != nil {
+		return cfg, err
+	}
+	cfg.SMTPUsername = envString("APPDIST_SMTP_USERNAME", cfg.SMTPUsername)
+	cfg.SMTPPassword = envString("APPDIST_SMTP_PASSWORD", cfg.SMTPPassword)
+	cfg.SMTPFrom = envString("APPDIST_SMTP_FROM", cfg.SMTPFrom)
+	cfg.EmailRecipients = envList("APPDIST_EMAIL_RECIPIENTS", cfg.EmailRecipients)
+	cfg.DingTalkWebhook = envString("APPDIST_DINGTALK_WEBHOOK", cfg.DingTalkWebhook)
+	cfg.DingTalkSecret = envString("APPDIST_DINGTALK_SECRET", cfg.DingTalkSecret)
+	cfg.WeComWebhook = envString("APPDIST_WECOM_WEBHOOK", cfg.WeComWebhook)
+	cfg.FeishuWebhook = envString("APPDIST_FEISHU_WEBHOOK", cfg.FeishuWebhook)
+	cfg.FeishuSecret = envString("APPDIST_FEISHU_SECRET", cfg.FeishuSecret)
+	if cfg.UploadTokensRequired, err = envBool("APPDIST_UPLOAD_TOKENS_REQUIRED", cfg.UploadTokensRequired); err != nil {
+		return cfg, err
+	}
+	cfg.TokensPath = envString("APPDIST_TOKENS_PATH", cfg.TokensPath)
+	if cfg.MultiTenant, err = envBool("APPDIST_MULTI_TENANT", cfg.MultiTenant); err != nil {
+		return cfg, err
+	}
+	cfg.UsersPath = envString("APPDIST_USERS_PATH", cfg.UsersPath)
+	cfg.AuditLogPath = envString("APPDIST_AUDIT_LOG_PATH", cfg.AuditLogPath)
+	if settingsFile != nil {
+		if unknown := settingsFile.unknownKeys(); len(unknown) > 0 {
+			return cfg, fmt.Errorf("配置文件 %s 中有未知配置项: %s", settingsFile.path, strings.Join(unknown, ", "))
+		}
+	}
+	return cfg, nil
+}
+
+// normalizeBasePath turns "apps/", "/apps" or "/apps/" into "/apps" and "/" into "".
+func normalizeBasePath(p string) string {
+	p = strings.Trim(strings.TrimSpace(p), "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// The env helpers below read an option from the environment, or from the
+// config file when the variable is unset, see lookupSetting.
+
+// envString reads a string environment variable, falling back to def when unset.
+func envString(key, def string) string {
+	if value, ok := lookupSetting(key); ok && value != "" {
+		return value
+	}
+	return def
+}
+
+// envList reads a comma-separated environment variable, dropping empty items.
+func envList(key string, def []string) []string {
+	value, ok := lookupSetting(key)
+	if !ok || value == "" {
+		return def
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envInt reads an integer environment variable, falling back to def when unset.
+func envInt(key string, def int) (int, error) {
+	value, ok := lookupSetting(key)
+	if !ok || value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return def, fmt.Errorf("环境变量 %s 不是有效整数: %w", key, err)
+	}
+	return n, nil
+}
+
+// envBool reads a boolean environment variable such as "true" or "0".
+func envBool(key string, def bool) (bool, error) {
+	value, ok := lookupSetting(key)
+	if !ok || value == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return def, fmt.Errorf("环境变量 %s 不是有效布尔值: %w", key, err)
+	}
+	return b, nil
+}
+
+// envDuration reads a duration environment variable such as "90s" or "6h".
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	value, ok := lookupSetting(key)
+	if !ok || value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return def, fmt.Errorf("环境变量 %s 不是有效时长: %w", key, err)
+	}
+	return d, nil
+}
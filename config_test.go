@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigFile(t *testing.T) {
+	t.Cleanup(func() { settingsFile = nil })
+	path := filepath.Join(t.TempDir(), "appdist.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("APPDIST_CONFIG", path)
+
+	write("port: 8080\nupload_dir: /srv/packages\nlog_level: warn\nchannel_order: [beta, stable]\nmax_upload_size: 1048576\n")
+	t.Setenv("APPDIST_LOG_LEVEL", "error")
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 8080 || cfg.UploadDir != "/srv/packages" || cfg.MaxUploadSize != 1<<20 ||
+		strings.Join(cfg.ChannelOrder, ",") != "beta,stable" {
+		t.Errorf("config = %+v", cfg)
+	}
+	// The environment overrides the file
+	if cfg.LogLevel != "error" {
+		t.Errorf("LogLevel = %q, want the environment's", cfg.LogLevel)
+	}
+
+	// The plain-text admin password is gone; it is refused, not ignored
+	write("delete_password: \"9527\"\n")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "APPDIST_ADMIN_PASSWORD_HASH") {
+		t.Errorf("delete_password: err = %v", err)
+	}
+
+	write("prot: 8080\n")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "prot") {
+		t.Errorf("misspelled key: err = %v", err)
+	}
+	write("port: 70000\n")
+	if _, err := loadConfig(); err == nil {
+		t.Error("out of range port accepted")
+	}
+	t.Setenv("APPDIST_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+	if _, err := loadConfig(); err == nil {
+		t.Error("missing APPDIST_CONFIG file accepted")
+	}
+}
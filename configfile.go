@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFile is read at startup when present; APPDIST_CONFIG names
+// another file, which then must exist
+const defaultConfigFile = "config.yaml"
+
+// configFile holds the settings of a YAML config file. Its keys are the
+// environment variable names without the APPDIST_ prefix, in lower case:
+// "max_upload_size" stands for APPDIST_MAX_UPLOAD_SIZE. A set environment
+// variable overrides the file.
+type configFile struct {
+	path   string
+	values map[string]string // keyed by environment variable name
+	used   map[string]bool
+}
+
+// settingsFile is the config file loadConfig reads besides the environment,
+// nil when there is none
+var settingsFile *configFile
+
+// openConfigFile reads the file named by APPDIST_CONFIG, or config.yaml if
+// it exists, and returns nil without either.
+func openConfigFile() (*configFile, error) {
+	path, explicit := os.LookupEnv("APPDIST_CONFIG")
+	if path == "" {
+		path, explicit = defaultConfigFile, false
+	}
+	file, err := readConfigFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil, nil
+	}
+	return file, err
+}
+
+// readConfigFile parses a YAML mapping of scalars or lists; lists are read
+// like the comma-separated environment variables.
+func readConfigFile(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("配置文件 %s 格式错误: %w", path, err)
+	}
+	file := &configFile{path: path, values: make(map[string]string, len(raw)), used: map[string]bool{}}
+	for key, value := range raw {
+		envKey := "APPDIST_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		switch v := value.(type) {
+		case nil:
+			continue
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				if _, ok := item.(map[string]any); ok || item == nil {
+					return nil, fmt.Errorf("配置文件 %s 中 %s 的列表项必须是字符串或数字", path, key)
+				}
+				items[i] = fmt.Sprint(item)
+			}
+			file.values[envKey] = strings.Join(items, ",")
+		case map[string]any:
+			return nil, fmt.Errorf("配置文件 %s 中 %s 必须是字符串、数字、布尔值或列表", path, key)
+		default:
+			file.values[envKey] = fmt.Sprint(v)
+		}
+	}
+	return file, nil
+}
+
+// lookup returns the value the file sets for the environment variable key
+func (f *configFile) lookup(key string) (string, bool) {
+	value, ok := f.values[key]
+	if ok {
+		f.used[key] = true
+	}
+	return value, ok
+}
+
+// unknownKeys lists the settings of the file no option read, in the file's
+// lower-case spelling, so that typos do not go unnoticed
+func (f *configFile) unknownKeys() []string {
+	var keys []string
+	for key := range f.values {
+		if !f.used[key] {
+			keys = append(keys, strings.ToLower(strings.TrimPrefix(key, "APPDIST_")))
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// lookupSetting returns the value of an option from the environment, or
+// else from the config file
+func lookupSetting(key string) (string, bool) {
+	var fileValue string
+	inFile := false
+	if settingsFile != nil {
+		fileValue, inFile = settingsFile.lookup(key)
+	}
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value, true
+	}
+	return fileValue, inFile
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BuildWithContext is a build together with the project and app it belongs to
+type BuildWithContext struct {
+	ProjectName string    `json:"projectName"`
+	AppName     string    `json:"appName"`
+	PackageName string    `json:"packageName"`
+	Build       BuildInfo `json:"build"`
+}
+
+// parseRangeBound parses a from/to query value given as a date
+// ("2006-01-02", in the display time zone) or an RFC 3339 timestamp. A date
+// as the upper bound covers the whole day.
+func parseRangeBound(value, tz string, upper bool) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, displayLocation(tz)); err == nil {
+		if upper {
+			t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("无法解析日期 %q，应为 YYYY-MM-DD 或 RFC 3339 格式", value)
+}
+
+// handleBuildsInRange serves GET /api/builds?from=&to=&project= with every
+// build uploaded in the window, oldest first. to defaults to now.
+func handleBuildsInRange(c *gin.Context) {
+	fromValue := c.Query("from")
+	if fromValue == "" {
+		respondError(c, http.StatusBadRequest, "缺少 from 参数")
+		return
+	}
+	tz := c.Query("tz")
+	from, err := parseRangeBound(fromValue, tz, false)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	to := time.Now()
+	if toValue := c.Query("to"); toValue != "" {
+		if to, err = parseRangeBound(toValue, tz, true); err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if to.Before(from) {
+		respondError(c, http.StatusBadRequest, "to 不能早于 from")
+		return
+	}
+	projectFilter := c.Query("project")
+
+	type timedBuild struct {
+		BuildWithContext
+		uploadedAt time.Time
+	}
+	var matches []timedBuild
+	projectFound := projectFilter == ""
+	mutex.Lock()
+	for _, project := range allProjects {
+		if (projectFilter != "" && project.ProjectName != projectFilter) || !canViewProject(c, project) {
+			continue
+		}
+		projectFound = true
+		for _, app := range project.Apps {
+			for _, build := range app.Builds {
+				uploadedAt, err := parseTimestamp(build.UploadTime)
+				if err != nil || uploadedAt.Before(from) || uploadedAt.After(to) {
+					continue
+				}
+				matches = append(matches, timedBuild{
+					BuildWithContext: BuildWithContext{
+						ProjectName: project.ProjectName,
+						AppName:     app.AppName,
+						PackageName: app.PackageName,
+						Build:       build,
+					},
+					uploadedAt: uploadedAt,
+				})
+			}
+		}
+	}
+	mutex.Unlock()
+
+	if !projectFound {
+		respondError(c, http.StatusNotFound, "项目未找到")
+		return
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].uploadedAt.Before(matches[j].uploadedAt)
+	})
+	builds := make([]BuildWithContext, len(matches))
+	for i, match := range matches {
+		builds[i] = match.BuildWithContext
+		builds[i].Build.ReleaseNotesHTML = notesHTML(match.Build.ReleaseNotes)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"from":   from.Format(time.RFC3339),
+		"to":     to.Format(time.RFC3339),
+		"count":  len(builds),
+		"builds": builds,
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Values of Config.DuplicateUploads, what happens to an upload whose
+// content hash matches a stored build
+const (
+	duplicateReference = "reference" // list it as a new entry sharing the stored file
+	duplicateReject    = "reject"    // refuse it with 409
+	duplicateStore     = "store"     // store another copy
+)
+
+// DuplicateBuild describes the stored build an upload duplicates
+type DuplicateBuild struct {
+	ProjectName string    `json:"projectName"`
+	PackageName string    `json:"packageName"`
+	Build       BuildInfo `json:"build"`
+}
+
+// findDuplicate looks up a stored build with content hash fileHash, which
+// an upload to channel of projectName could reference. listed reports that
+// the project already lists the file in that channel, so a new entry would
+// add nothing.
+func findDuplicate(fileHash, projectName, channel string) (duplicate DuplicateBuild, listed, found bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, project := range allProjects {
+		for _, app := range project.Apps {
+			for _, build := range app.Builds {
+				if build.FileHash != fileHash {
+					continue
+				}
+				if !found {
+					duplicate = DuplicateBuild{ProjectName: project.ProjectName, PackageName: app.PackageName, Build: build}
+					found = true
+				}
+				if project.ProjectName == projectName && build.Channel == channel {
+					return DuplicateBuild{ProjectName: project.ProjectName, PackageName: app.PackageName, Build: build}, true, true
+				}
+			}
+		}
+	}
+	return duplicate, false, found
+}
+
+// reusableFile reports whether the stored file of duplicate still holds
+// size bytes, so a new entry can share it instead of storing the upload
+func reusableFile(duplicate DuplicateBuild, size int64) bool {
+	stored, err := storage.Stat(duplicate.Build.FileName)
+	return err == nil && stored == size
+}
+
+// duplicateError rejects an upload of content already stored with 409,
+// describing the stored build
+func duplicateError(message string, duplicate DuplicateBuild) *uploadError {
+	return &uploadError{status: http.StatusConflict, message: "重复的构建: " + message,
+		fields: gin.H{"duplicate": duplicate}, title: "重复的构建", details: []string{message}}
+}
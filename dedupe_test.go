@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDuplicateUploads(t *testing.T) {
+	router := setupTestServer(t)
+	storedFiles := func() int {
+		t.Helper()
+		entries, err := os.ReadDir(config.UploadDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries)
+	}
+
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The same content in another channel shares the stored file
+	rec := uploadFixture(router, fixtureAPK(t), "Demo", "beta")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "已复用其文件") {
+		t.Fatalf("duplicate upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	builds := appBuilds(t, router, fixturePackage)
+	if len(builds) != 2 || builds[0].FileName != builds[1].FileName || builds[0].Channel != "beta" {
+		t.Fatalf("builds = %+v, want two entries sharing one file", builds)
+	}
+	if n := storedFiles(); n != 1 {
+		t.Errorf("%d stored files, want 1", n)
+	}
+
+	// Listing the file again in the same channel adds nothing
+	rec = uploadFixture(router, fixtureAPK(t), "Demo", "beta")
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), `"duplicate"`) {
+		t.Errorf("repeated upload: status %d: %s, want 409", rec.Code, rec.Body.String())
+	}
+
+	config.DuplicateUploads = duplicateReject
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "alpha"); rec.Code != http.StatusConflict {
+		t.Errorf("duplicate upload when rejecting: status %d, want 409", rec.Code)
+	}
+
+	config.DuplicateUploads = duplicateStore
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "alpha"); rec.Code != http.StatusOK {
+		t.Fatalf("duplicate upload when storing: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if n := storedFiles(); n != 2 {
+		t.Errorf("%d stored files, want 2", n)
+	}
+
+	// Deleting one of the sharing entries keeps the file for the other
+	if rec := serveAdmin(router, http.MethodDelete, "/api/builds/"+fixturePackage+"/"+builds[0].FileName+"?channel=beta"); rec.Code != http.StatusOK {
+		t.Fatalf("delete: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve(router, http.MethodGet, builds[1].DownloadURL); rec.Code != http.StatusOK {
+		t.Errorf("download of the remaining entry: status %d", rec.Code)
+	}
+}
+
+func TestBuildByHashPicksEarliestUpload(t *testing.T) {
+	router := setupTestServer(t)
+	hash := strings.Repeat("ab", 32)
+	build := func(fileName, channel, uploadTime string) BuildInfo {
+		b := testBuild(fileName, channel)
+		b.FileHash = hash
+		b.UploadTime = uploadTime
+		return b
+	}
+	mutex.Lock()
+	allProjects = []Project{
+		{ProjectName: "Alpha", Apps: []AppEntry{{
+			AppName:     "Demo",
+			PackageName: "com.example.demo",
+			Builds:      []BuildInfo{build("demo.apk", "stable", "2026-01-02T00:00:00Z"), build("demo.apk", "beta", "2026-01-01T00:00:00Z")},
+		}}},
+		{ProjectName: "Beta", Apps: []AppEntry{{
+			AppName:     "Demo",
+			PackageName: "com.example.demo",
+			Builds:      []BuildInfo{build("demo.apk", "stable", "2026-03-01T00:00:00Z")},
+		}}},
+	}
+	rebuildIndexes()
+	mutex.Unlock()
+
+	// The answer does not depend on where the entries sit in the catalog
+	for i := 0; i < 2; i++ {
+		rec := serve(router, http.MethodGet, "/api/builds/by-hash/"+hash)
+		var entry hashIndexEntry
+		decodeJSON(t, rec, &entry)
+		if rec.Code != http.StatusOK || entry.ProjectName != "Alpha" || entry.Build.Channel != "beta" {
+			t.Errorf("by-hash: status %d, entry %+v, want the beta build of Alpha", rec.Code, entry)
+		}
+		mutex.Lock()
+		allProjects[0], allProjects[1] = allProjects[1], allProjects[0]
+		rebuildIndexes()
+		mutex.Unlock()
+	}
+}
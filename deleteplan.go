@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeletePlan lists what a delete request changes. With ?dryRun=true the
+// delete endpoints compute it the same way as a real delete, then restore
+// the catalog and return it without touching any file or the metadata.
+type DeletePlan struct {
+	Builds         []BuildInfo `json:"builds"`              // catalog entries removed
+	Files          []string    `json:"files"`               // package files deleted
+	KeptFiles      []string    `json:"keptFiles,omitempty"` // files still referenced by other entries
+	Icons          []string    `json:"icons"`               // icon files deleted
+	AppRemoved     bool        `json:"appRemoved"`          // the app entry is removed
+	ProjectRemoved bool        `json:"projectRemoved"`      // the project is removed with its last app
+}
+
+// isDryRun reports whether a delete request only asks for its DeletePlan
+func isDryRun(value string) bool {
+	return value == "1" || strings.EqualFold(value, "true")
+}
+
+// planFileRemovals splits the files of removed builds into those no build
+// references any more and those still referenced, e.g. by a promoted entry.
+// It must run after the builds are taken out of the catalog.
+// The caller must hold the mutex.
+func planFileRemovals(plan *DeletePlan, removed []BuildInfo) {
+	plan.Files = []string{}
+	seen := make(map[string]bool)
+	for _, build := range removed {
+		if seen[build.FileName] {
+			continue
+		}
+		seen[build.FileName] = true
+		filePath := filepath.Join(config.UploadDir, build.FileName)
+		if fileReferenceCount(build.FileName) > 0 {
+			plan.KeptFiles = append(plan.KeptFiles, filePath)
+			continue
+		}
+		plan.Files = append(plan.Files, filePath)
+		// Expansion files and the universal APK of a bundle go with the
+		// package they belong to
+		for _, expansion := range build.Expansions {
+			plan.Files = append(plan.Files, expansionPath(build.FileName, expansion.FileName))
+		}
+		if build.UniversalAPK != "" {
+			plan.Files = append(plan.Files, filepath.Join(config.UploadDir, build.UniversalAPK))
+		}
+	}
+}
+
+// removePlannedFile removes a file listed in a DeletePlan. Packages are
+// listed below uploads for display but live in the configured storage;
+// expansion files always sit on local disk.
+func removePlannedFile(filePath string) error {
+	if filepath.Dir(filePath) == filepath.Clean(config.UploadDir) {
+		return storage.Delete(filepath.Base(filePath))
+	}
+	return os.Remove(filePath)
+}
+
+// applyDeletePlan removes the files of a committed delete. Failures are
+// logged rather than returned, since the metadata is already saved.
+func applyDeletePlan(c *gin.Context, plan DeletePlan) {
+	unreferenced := make(map[string]bool, len(plan.Files))
+	for _, filePath := range plan.Files {
+		unreferenced[filePath] = true
+		if err := removePlannedFile(filePath); err != nil {
+			warnf(c, "删除文件 %s 失败: %v", filePath, err)
+		}
+	}
+	for _, filePath := range plan.KeptFiles {
+		logf(c, "文件 %s 仍被其他构建引用，保留文件", filePath)
+	}
+	for _, build := range plan.Builds {
+		filePath := filepath.Join(config.UploadDir, build.FileName)
+		if !unreferenced[filePath] {
+			continue
+		}
+		delete(unreferenced, filePath)
+		parseCache.Remove(build.FileHash)
+		stats.forget(build.FileName)
+		if len(build.Expansions) > 0 {
+			os.Remove(expansionDir(build.FileName)) // only succeeds once empty
+		}
+	}
+	for _, iconPath := range plan.Icons {
+		if err := os.Remove(iconPath); err != nil && !os.IsNotExist(err) {
+			warnf(c, "删除图标 %s 失败: %v", iconPath, err)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A delta rebuilds a target APK from a base APK the device already has. The
+// patch is a gzip stream of
+//
+//	"APKDELTA1\n" uvarint(target size) op... 'E'
+//
+// where an op is 'C' uvarint(base offset) uvarint(length), copying bytes of
+// the base, or 'L' uvarint(length) followed by that many literal bytes. The
+// client applies the ops in order and checks the result against the target's
+// SHA-256, see applyDelta.
+const deltaMagic = "APKDELTA1\n"
+
+// Delta operations
+const (
+	deltaCopy    = 'C'
+	deltaLiteral = 'L'
+	deltaEnd     = 'E'
+)
+
+// deltaBlockSize is the granularity at which unchanged data is found: runs
+// shorter than this are sent as literals
+const deltaBlockSize = 64
+
+// deltaMaxInput bounds the base and target size; both are held in memory
+// while the delta is computed
+const deltaMaxInput = 512 << 20
+
+// deltaPrime is the multiplier of the rolling block hash
+const deltaPrime = 16777619
+
+// deltaLocks serializes the generation of each delta, so concurrent
+// requests for the same pair compute it once
+var deltaLocks sync.Map // cache file name -> *sync.Mutex
+
+// blockHash returns the rolling hash of block
+func blockHash(block []byte) uint32 {
+	var h uint32
+	for _, b := range block {
+		h = h*deltaPrime + uint32(b)
+	}
+	return h
+}
+
+// deltaWriter emits the operations of a patch
+type deltaWriter struct {
+	w       *bufio.Writer
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (d *deltaWriter) uvarint(v uint64) {
+	n := binary.PutUvarint(d.scratch[:], v)
+	d.w.Write(d.scratch[:n])
+}
+
+func (d *deltaWriter) literal(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	d.w.WriteByte(deltaLiteral)
+	d.uvarint(uint64(len(data)))
+	d.w.Write(data)
+}
+
+func (d *deltaWriter) copy(offset, length int) {
+	d.w.WriteByte(deltaCopy)
+	d.uvarint(uint64(offset))
+	d.uvarint(uint64(length))
+}
+
+// writeDelta writes a patch turning base into target. Blocks of the base are
+// indexed by hash; the target is scanned with a rolling hash, and every hit
+// is extended in both directions into a copy. APKs change in few entries
+// between builds and everything else is found again, shifted or not.
+func writeDelta(w io.Writer, base, target []byte) error {
+	index := make(map[uint32]int, len(base)/deltaBlockSize+1)
+	for offset := 0; offset+deltaBlockSize <= len(base); offset += deltaBlockSize {
+		h := blockHash(base[offset : offset+deltaBlockSize])
+		if _, ok := index[h]; !ok {
+			index[h] = offset
+		}
+	}
+
+	// Weight of the byte leaving the window
+	var outWeight uint32 = 1
+	for i := 1; i < deltaBlockSize; i++ {
+		outWeight *= deltaPrime
+	}
+
+	gz := gzip.NewWriter(w)
+	d := &deltaWriter{w: bufio.NewWriter(gz)}
+	d.w.WriteString(deltaMagic)
+	d.uvarint(uint64(len(target)))
+
+	literalStart := 0
+	pos := 0
+	var h uint32
+	if len(target) >= deltaBlockSize {
+		h = blockHash(target[:deltaBlockSize])
+	}
+	for pos+deltaBlockSize <= len(target) {
+		offset, ok := index[h]
+		if ok && bytes.Equal(base[offset:offset+deltaBlockSize], target[pos:pos+deltaBlockSize]) {
+			start, baseStart := pos, offset
+			for start > literalStart && baseStart > 0 && target[start-1] == base[baseStart-1] {
+				start--
+				baseStart--
+			}
+			end, baseEnd := pos+deltaBlockSize, offset+deltaBlockSize
+			for end < len(target) && baseEnd < len(base) && target[end] == base[baseEnd] {
+				end++
+				baseEnd++
+			}
+			d.literal(target[literalStart:start])
+			d.copy(baseStart, end-start)
+			literalStart, pos = end, end
+			if pos+deltaBlockSize <= len(target) {
+				h = blockHash(target[pos : pos+deltaBlockSize])
+			}
+			continue
+		}
+		if pos+deltaBlockSize < len(target) {
+			h = (h-uint32(target[pos])*outWeight)*deltaPrime + uint32(target[pos+deltaBlockSize])
+		}
+		pos++
+	}
+	d.literal(target[literalStart:])
+	d.w.WriteByte(deltaEnd)
+
+	if err := d.w.Flush(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// applyDelta rebuilds the target of a patch from base. It is what clients
+// have to implement, and lets tests check the patches written here.
+func applyDelta(base []byte, patch io.Reader) ([]byte, error) {
+	gz, err := gzip.NewReader(patch)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(gz)
+	magic := make([]byte, len(deltaMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != deltaMagic {
+		return nil, errors.New("not a delta patch")
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > deltaMaxInput {
+		return nil, errors.New("invalid target size")
+	}
+	target := make([]byte, 0, size)
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case deltaCopy:
+			offset, err1 := binary.ReadUvarint(r)
+			length, err2 := binary.ReadUvarint(r)
+			if err1 != nil || err2 != nil || offset+length > uint64(len(base)) || uint64(len(target))+length > size {
+				return nil, errors.New("invalid copy")
+			}
+			target = append(target, base[offset:offset+length]...)
+		case deltaLiteral:
+			length, err := binary.ReadUvarint(r)
+			if err != nil || uint64(len(target))+length > size {
+				return nil, errors.New("invalid literal")
+			}
+			start := len(target)
+			target = append(target, make([]byte, length)...)
+			if _, err := io.ReadFull(r, target[start:]); err != nil {
+				return nil, err
+			}
+		case deltaEnd:
+			if uint64(len(target)) != size {
+				return nil, errors.New("truncated patch")
+			}
+			return target, nil
+		default:
+			return nil, fmt.Errorf("unknown operation %q", op)
+		}
+	}
+}
+
+// deltaCachePath is where the patch between two files, identified by their
+// SHA-256, is kept
+func deltaCachePath(fromHash, toHash string) string {
+	return filepath.Join(config.DeltaDir, fromHash+"-"+toHash+".delta")
+}
+
+// cachedDelta returns the path of the patch from one stored file to another,
+// computing it unless it is cached. Patches depend only on the file
+// contents, so a cached one stays valid for as long as it exists.
+func cachedDelta(fromFile, fromHash, toFile, toHash string) (string, error) {
+	path := deltaCachePath(fromHash, toHash)
+	lock, _ := deltaLocks.LoadOrStore(path, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	base, err := readDeltaInput(fromFile)
+	if err != nil {
+		return "", err
+	}
+	target, err := readDeltaInput(toFile)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(config.DeltaDir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(config.DeltaDir, "delta-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	err = writeDelta(tmp, base, target)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp.Name(), path)
+}
+
+// readDeltaInput reads a stored build file for diffing
+func readDeltaInput(fileName string) ([]byte, error) {
+	size, err := storage.Stat(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if size > deltaMaxInput {
+		return nil, fmt.Errorf("文件 %s 过大，无法生成差分包", fileName)
+	}
+	r, err := storage.Get(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(io.LimitReader(r, deltaMaxInput+1))
+}
+
+// handleDelta serves GET /api/apps/:packageName/delta?from=<fileName>&to=<fileName>:
+// a patch that turns the build file "from" into "to", the newest build when
+// omitted. The response is the patch itself; X-Checksum-SHA256 is its
+// SHA-256, and X-Delta-Target-SHA256 the one the rebuilt APK must have.
+func handleDelta(c *gin.Context) {
+	packageName := c.Param("packageName")
+	fromFile, toFile := c.Query("from"), c.Query("to")
+	if fromFile == "" {
+		respondError(c, http.StatusBadRequest, "缺少 from 参数")
+		return
+	}
+
+	_, app, found := repo.FindApp(packageName)
+	if !found {
+		respondError(c, http.StatusNotFound, "应用未找到")
+		return
+	}
+	if latest, ok := firstBuild(app.Builds); toFile == "" && ok {
+		toFile = latest.FileName
+	}
+	hashes := map[string]string{}
+	for _, build := range app.Builds {
+		if build.FileName == fromFile || build.FileName == toFile {
+			hashes[build.FileName] = build.FileHash
+		}
+	}
+	for _, fileName := range []string{fromFile, toFile} {
+		if _, ok := hashes[fileName]; !ok {
+			respondError(c, http.StatusNotFound, "构建版本未找到: "+fileName)
+			return
+		}
+	}
+	if fromFile == toFile {
+		respondError(c, http.StatusBadRequest, "from 与 to 是同一个构建")
+		return
+	}
+	// Files stored before hashes were recorded are hashed on demand
+	for fileName, hash := range hashes {
+		if hash != "" {
+			continue
+		}
+		var err error
+		if hashes[fileName], err = hashStored(fileName); err != nil {
+			respondError(c, http.StatusNotFound, "构建文件不存在: "+fileName)
+			return
+		}
+	}
+
+	path, err := cachedDelta(fromFile, hashes[fromFile], toFile, hashes[toFile])
+	if err != nil {
+		warnf(c, "生成 %s 到 %s 的差分包失败: %v", fromFile, toFile, err)
+		respondError(c, http.StatusInternalServerError, "无法生成差分包: "+err.Error())
+		return
+	}
+	patchHash, err := hashFile(path)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "无法读取差分包")
+		return
+	}
+
+	c.Header("X-Checksum-SHA256", patchHash)
+	c.Header("X-Delta-From", fromFile)
+	c.Header("X-Delta-To", toFile)
+	c.Header("X-Delta-Target-SHA256", hashes[toFile])
+	if size, err := storage.Stat(toFile); err == nil {
+		c.Header("X-Delta-Target-Size", strconv.FormatInt(size, 10))
+	}
+	c.Header("Content-Type", "application/octet-stream")
+	c.FileAttachment(path, fmt.Sprintf("%s-%s.delta", shortHash(hashes[fromFile]), shortHash(hashes[toFile])))
+}
+
+// shortHash abbreviates a SHA-256 for file names
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestDeltaRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	base := make([]byte, 200_000)
+	rng.Read(base)
+	noise := make([]byte, 3000)
+	rng.Read(noise)
+
+	// Inserted, replaced and removed data, like an APK with a few changed entries
+	edited := append([]byte(nil), base[:50_000]...)
+	edited = append(edited, noise[:1000]...)
+	edited = append(edited, base[50_000:120_000]...)
+	edited = append(edited, noise[1000:]...)
+	edited = append(edited, base[125_000:]...)
+
+	cases := []struct {
+		name     string
+		base     []byte
+		target   []byte
+		maxPatch int // 0 skips the size check
+	}{
+		{"edited", base, edited, 5000},
+		{"identical", base, base, 1000},
+		{"unrelated", base, noise, 0},
+		{"empty base", nil, noise, 0},
+		{"empty target", base, nil, 0},
+		{"shorter than a block", base[:10], base[:20], 0},
+	}
+	for _, tc := range cases {
+		var patch bytes.Buffer
+		if err := writeDelta(&patch, tc.base, tc.target); err != nil {
+			t.Fatalf("%s: writeDelta: %v", tc.name, err)
+		}
+		if tc.maxPatch > 0 && patch.Len() > tc.maxPatch {
+			t.Errorf("%s: patch is %d bytes, want at most %d", tc.name, patch.Len(), tc.maxPatch)
+		}
+		got, err := applyDelta(tc.base, bytes.NewReader(patch.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: applyDelta: %v", tc.name, err)
+		}
+		if !bytes.Equal(got, tc.target) {
+			t.Errorf("%s: rebuilt %d bytes differ from the %d byte target", tc.name, len(got), len(tc.target))
+		}
+	}
+}
+
+func TestDeltaRejectsCorruptPatch(t *testing.T) {
+	base := bytes.Repeat([]byte("base data "), 100)
+	var patch bytes.Buffer
+	if err := writeDelta(&patch, base, base[10:]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := applyDelta(base[:100], bytes.NewReader(patch.Bytes())); err == nil {
+		t.Error("patch applied to the wrong base")
+	}
+	if _, err := applyDelta(base, bytes.NewReader([]byte("not gzip"))); err == nil {
+		t.Error("garbage accepted as a patch")
+	}
+}
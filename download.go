@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checksumSuffix marks the companion checksum file of a download
+const checksumSuffix = ".sha256"
+
+// storedFileHash returns the FileHash recorded for fileName in the catalog,
+// or "" when the file is unknown or was stored before hashes were recorded.
+func storedFileHash(fileName string) string {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, project := range allProjects {
+		for _, app := range project.Apps {
+			for _, build := range app.Builds {
+				if build.FileName == fileName && build.FileHash != "" {
+					return build.FileHash
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// downloadVisible reports whether c may see a project listing the stored
+// file fileName, as a build or the universal APK of a bundle. Under
+// APPDIST_MULTI_TENANT the packages of other projects are only served
+// through signed links. Files no build lists are left to the storage.
+func downloadVisible(c *gin.Context, fileName string) bool {
+	if !config.MultiTenant {
+		return true
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	listed := false
+	for _, project := range allProjects {
+		for _, app := range project.Apps {
+			for _, build := range app.Builds {
+				if build.FileName != fileName && build.UniversalAPK != fileName {
+					continue
+				}
+				// Duplicate uploads share the file across projects
+				if canViewProject(c, project) {
+					return true
+				}
+				listed = true
+			}
+		}
+	}
+	return !listed
+}
+
+// handleDownload serves the stored packages below /downloads. The response
+// carries the recorded SHA-256 in X-Checksum-SHA256, and "<file>.sha256"
+// returns it in the "<hash>  <filename>" format of sha256sum, so clients
+// can verify the package with `sha256sum -c`.
+func handleDownload(c *gin.Context) {
+	name := c.Param("fileName")
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	// fileName is the package whose checksum is requested; an uploaded file
+	// that really ends in .sha256 is served as is
+	fileName := name
+	if base, ok := strings.CutSuffix(name, checksumSuffix); ok {
+		if _, err := storage.Stat(name); err != nil {
+			fileName = base
+		}
+	}
+	// Projects c may not see do not exist for it, not even the checksums of
+	// their packages, unless it follows a signed link to the package
+	if !downloadVisible(c, fileName) && linkSignatureError(c, "/downloads/"+fileName) != "" {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	// The checksum of a private package needs a signed link to the package
+	// too, or it would confirm the file and its hash to anyone
+	private := privateDownload(fileName)
+	if private && !checkLinkSignature(c, "/downloads/"+fileName) {
+		return
+	}
+
+	if fileName != name {
+		serveChecksum(c, fileName, storedFileHash(fileName), func() (string, error) {
+			return hashStored(fileName)
+		})
+		return
+	}
+	if hash := storedFileHash(name); hash != "" {
+		c.Header("X-Checksum-SHA256", hash)
+	}
+	if path, ok := localStoragePath(name); ok {
+		if serveStoredFile(c, path) {
+			countDownload(c, name)
+		}
+		return
+	}
+	// Remote storage serves the package itself
+	if _, err := storage.Stat(name); err != nil {
+		c.Writer.Header().Del("X-Checksum-SHA256")
+		if !errors.Is(err, os.ErrNotExist) {
+			warnf(c, "查询存储中的 %s 失败: %v", name, err)
+		}
+		c.Status(http.StatusNotFound)
+		return
+	}
+	countDownload(c, name)
+	// Projects of a multi-tenant server are only visible to their members,
+	// so their packages are not published under a lasting URL either
+	c.Redirect(http.StatusFound, storage.URL(name, private || config.MultiTenant))
+}
+
+// countDownload records a download of fileName in the stats. HEAD requests
+// and Range requests resuming past the first byte are not counted, so a
+// download manager fetching one file in pieces counts once.
+func countDownload(c *gin.Context, fileName string) {
+	if c.Request.Method != http.MethodGet {
+		return
+	}
+	if r := c.GetHeader("Range"); r != "" && !strings.HasPrefix(r, "bytes=0-") {
+		return
+	}
+	// The universal APK of a bundle counts toward the bundle's build
+	if bundleName, ok := strings.CutSuffix(fileName, universalAPKSuffix); ok {
+		fileName = bundleName + ".aab"
+	}
+	stats.recordDownload(fileName)
+}
+
+// serveStoredFile serves a regular file below uploads, with Range support,
+// and never lists a directory such as uploads/obb. It reports whether the
+// file existed.
+func serveStoredFile(c *gin.Context, path string) bool {
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		c.Writer.Header().Del("X-Checksum-SHA256")
+		c.Status(http.StatusNotFound)
+		return false
+	}
+	c.File(path)
+	return true
+}
+
+// serveChecksum writes the checksum line of the file fileName. Without a
+// recorded hash, e.g. for files stored before hashes were recorded, the
+// file is hashed on demand by compute.
+func serveChecksum(c *gin.Context, fileName, hash string, compute func() (string, error)) {
+	if hash == "" {
+		var err error
+		if hash, err = compute(); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				c.String(http.StatusNotFound, "文件未找到\n")
+				return
+			}
+			warnf(c, "计算 %s 的校验和失败: %v", fileName, err)
+			c.String(http.StatusInternalServerError, "无法计算校验和\n")
+			return
+		}
+	}
+	c.Header("X-Checksum-SHA256", hash)
+	c.String(http.StatusOK, fmt.Sprintf("%s  %s\n", hash, fileName))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// catalogVersion counts the saves of the catalog and is guarded by mutex.
+// It starts at the load time in nanoseconds rather than 0, so an ETag read
+// before a restart never matches the catalog after it.
+var catalogVersion uint64
+
+// writeGate lets conditional writes exclude every other write between their
+// If-Match check and their save. Unconditional writes share it and keep
+// running concurrently.
+var writeGate sync.RWMutex
+
+// resetCatalogVersion starts a new version sequence for a freshly loaded
+// catalog. The caller must hold the mutex.
+func resetCatalogVersion() {
+	catalogVersion = uint64(time.Now().UnixNano())
+}
+
+// catalogVersionETag returns the strong ETag of the catalog's current
+// version. The caller must hold the mutex.
+func catalogVersionETag() string {
+	return `"` + strconv.FormatUint(catalogVersion, 10) + `"`
+}
+
+func currentCatalogETag() string {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return catalogVersionETag()
+}
+
+// etagMatches reports whether an If-Match header value lists etag or is "*"
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// catalogETag adds the catalog version to read responses as ETag. It is set
+// before the handler reads the catalog, so it is never newer than the data
+// and a write based on the response fails rather than losing an update.
+func catalogETag() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			c.Header("ETag", currentCatalogETag())
+		}
+		c.Next()
+	}
+}
+
+// checkIfMatch guards write routes: a request with If-Match only proceeds
+// while the catalog is still at that version and answers 412 otherwise.
+// Any catalog change, by any client, moves the version.
+func checkIfMatch() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ifMatch := c.GetHeader("If-Match")
+		if ifMatch == "" {
+			writeGate.RLock()
+			defer writeGate.RUnlock()
+			c.Next()
+			return
+		}
+
+		writeGate.Lock()
+		defer writeGate.Unlock()
+		if etag := currentCatalogETag(); !etagMatches(ifMatch, etag) {
+			c.Header("ETag", etag)
+			respondError(c, http.StatusPreconditionFailed, "目录已被修改，请重新读取后再提交")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// editBuildRequest is the JSON body accepted by handleEditBuild; omitted
+// fields are left unchanged
+type editBuildRequest struct {
+	ReleaseNotes *string           `json:"releaseNotes"`
+	Extra        map[string]string `json:"extra"` // replaces every custom field; {} clears them
+}
+
+// handleEditBuild changes the release notes and custom fields of a build
+// after upload. channel picks one entry of a promoted build; without it
+// every entry of the file is edited. Send If-Match with the ETag of an
+// earlier read to make sure nobody edited the catalog in between.
+func handleEditBuild(c *gin.Context) {
+	if !checkAdmin(c) {
+		return
+	}
+	packageName := c.Param("packageName")
+	fileName := c.Param("fileName")
+	channel := c.Query("channel")
+
+	var req editBuildRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "请求体格式错误: "+err.Error())
+		return
+	}
+	if req.ReleaseNotes == nil && req.Extra == nil {
+		respondError(c, http.StatusBadRequest, "请求体需要 releaseNotes 或 extra 字段")
+		return
+	}
+	var errs []FieldError
+	var releaseNotes string
+	if req.ReleaseNotes != nil {
+		if fieldErr, tooLong := releaseNotesFieldError(*req.ReleaseNotes); tooLong {
+			errs = append(errs, fieldErr)
+		}
+		releaseNotes, _ = limitReleaseNotes(*req.ReleaseNotes)
+	}
+	extra := normalizeExtra(req.Extra)
+	errs = append(errs, validateExtraFields(extra)...)
+	if len(errs) > 0 {
+		body := errorBody(c, "表单字段校验失败")
+		body["fields"] = errs
+		c.JSON(http.StatusBadRequest, body)
+		return
+	}
+
+	projectName, edited, err := updateBuilds(packageName, fileName, channel, func(build *BuildInfo) error {
+		if req.ReleaseNotes != nil {
+			build.ReleaseNotes = releaseNotes
+		}
+		if req.Extra != nil {
+			build.Extra = extra
+		}
+		return nil
+	})
+	if err != nil {
+		respondRepositoryError(c, err)
+		return
+	}
+	logf(c, "已编辑构建 %s 的 %d 个条目", fileName, len(edited))
+	audit.record(c, AuditEntry{Action: auditEditBuild, ProjectName: projectName, PackageName: packageName, FileName: fileName, Channel: channel})
+
+	c.Header("ETag", catalogVersionETag())
+	c.JSON(http.StatusOK, gin.H{"message": "构建信息已更新", "builds": withNotesHTML(edited)})
+}
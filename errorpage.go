@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// wantsHTML reports whether the client is a browser that should get a styled
+// error page rather than JSON or plain text: the upload form marks its posts
+// with source=web, and browser navigations accept text/html explicitly.
+func wantsHTML(c *gin.Context) bool {
+	if c.PostForm("source") == "web" {
+		return true
+	}
+	return strings.Contains(c.GetHeader("Accept"), "text/html")
+}
+
+// errorBackLink returns where the error page's back link points: the upload
+// form for failed web uploads, otherwise the referring page on this site or
+// the homepage.
+func errorBackLink(c *gin.Context) string {
+	if c.PostForm("source") == "web" {
+		return withBasePath("/upload")
+	}
+	if referer, err := url.Parse(c.GetHeader("Referer")); err == nil && referer.Host == c.Request.Host && referer.Path != c.Request.URL.Path {
+		return referer.RequestURI()
+	}
+	return withBasePath("/")
+}
+
+// renderErrorPage renders templates/error.html with the message, optional
+// details such as policy violations, and the request ID
+func renderErrorPage(c *gin.Context, status int, message string, details []string) {
+	renderHTML(c, status, "error.html", gin.H{
+		"Status":     status,
+		"StatusText": http.StatusText(status),
+		"Message":    message,
+		"Details":    details,
+		"RequestID":  requestID(c),
+		"BackURL":    errorBackLink(c),
+	})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Types of catalog events
+const (
+	eventUpload  = "upload"
+	eventDelete  = "delete"
+	eventPromote = "promote"
+)
+
+// eventKeepAlive is how often an idle event stream gets a comment line, so
+// proxies do not close it and disconnected clients are noticed
+const eventKeepAlive = 25 * time.Second
+
+// eventBuffer is how many events a slow subscriber may lag behind before
+// further events are dropped for it
+const eventBuffer = 16
+
+// CatalogEvent is sent to GET /api/events subscribers after the catalog
+// changed. FileName and Channel are empty when a whole app was deleted.
+type CatalogEvent struct {
+	Type        string `json:"type"` // "upload", "delete" or "promote"
+	ProjectName string `json:"projectName,omitempty"`
+	PackageName string `json:"packageName"`
+	FileName    string `json:"fileName,omitempty"`
+	Channel     string `json:"channel,omitempty"`
+	Time        string `json:"time"`
+}
+
+// eventHub fans catalog events out to the connected event streams
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan CatalogEvent]struct{}
+}
+
+var events = &eventHub{subscribers: map[chan CatalogEvent]struct{}{}}
+
+// subscribe registers a new stream, or returns false once
+// config.MaxEventSubscribers streams are connected
+func (h *eventHub) subscribe() (chan CatalogEvent, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if config.MaxEventSubscribers > 0 && len(h.subscribers) >= config.MaxEventSubscribers {
+		return nil, false
+	}
+	ch := make(chan CatalogEvent, eventBuffer)
+	h.subscribers[ch] = struct{}{}
+	return ch, true
+}
+
+func (h *eventHub) unsubscribe(ch chan CatalogEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, ch)
+}
+
+// closeAll ends every connected stream, which would otherwise hold up a
+// graceful shutdown until it times out
+func (h *eventHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		close(ch)
+		delete(h.subscribers, ch)
+	}
+}
+
+// publish sends event to every stream without blocking; a stream whose
+// buffer is full misses it rather than holding up the change.
+func (h *eventHub) publish(event CatalogEvent) {
+	event.Time = timestamp(time.Now())
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// handleEvents streams catalog events as server-sent events until the
+// client disconnects. Every event is a "catalog" event whose data is a
+// CatalogEvent in JSON, which lets open homepages refresh themselves.
+func handleEvents(c *gin.Context) {
+	ch, ok := events.subscribe()
+	if !ok {
+		c.Header("Retry-After", "30")
+		respondError(c, http.StatusServiceUnavailable, "实时更新的连接数已达上限")
+		return
+	}
+	defer events.unsubscribe(ch)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	// Keep reverse proxies such as nginx from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	io.WriteString(c.Writer, "retry: 5000\n\n")
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		case event, ok := <-ch:
+			if !ok {
+				return false
+			}
+			mutex.Lock()
+			visible := projectVisible(c, event.ProjectName)
+			mutex.Unlock()
+			if !visible {
+				return true
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				return true
+			}
+			_, err = io.WriteString(w, "event: catalog\ndata: "+string(data)+"\n\n")
+			return err == nil
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var exportCSVHeader = []string{
+	"project", "appName", "packageName", "version", "versionCode",
+	"channel", "fileSize", "uploadTime", "downloads",
+}
+
+// handleExportCSV streams every build of the catalog, optionally limited to
+// one project, as a CSV attachment. Projects are copied one at a time so the
+// lock is never held while writing to a slow client.
+func handleExportCSV(c *gin.Context) {
+	projectFilter := c.Query("project")
+
+	mutex.Lock()
+	var projectNames []string
+	for _, project := range allProjects {
+		if (projectFilter == "" || project.ProjectName == projectFilter) && canViewProject(c, project) {
+			projectNames = append(projectNames, project.ProjectName)
+		}
+	}
+	mutex.Unlock()
+
+	if projectFilter != "" && len(projectNames) == 0 {
+		respondError(c, http.StatusNotFound, "项目未找到")
+		return
+	}
+
+	fileName := fmt.Sprintf("builds-%s.csv", time.Now().Format("20060102"))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+	c.Status(http.StatusOK)
+
+	// A UTF-8 BOM lets spreadsheet tools detect the encoding of Chinese names
+	c.Writer.WriteString("\ufeff")
+	w := csv.NewWriter(c.Writer)
+	w.Write(exportCSVHeader)
+
+	for _, name := range projectNames {
+		apps, ok := copyProjectApps(name)
+		if !ok {
+			continue // removed while exporting
+		}
+		for _, app := range apps {
+			for _, build := range app.Builds {
+				w.Write(csvRow(
+					name,
+					app.AppName,
+					app.PackageName,
+					build.Version,
+					strconv.Itoa(int(build.VersionCode)),
+					build.Channel,
+					strconv.FormatInt(build.FileSize, 10),
+					build.UploadTime,
+					strconv.FormatInt(stats.get(build.FileName).Downloads, 10),
+				))
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			warnf(c, "导出 CSV 中断: %v", err)
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// csvRow escapes the cells of an export row. Names, versions and channels
+// come from uploaded packages, so a cell a spreadsheet would read as a
+// formula is prefixed with a single quote to keep it text.
+func csvRow(cells ...string) []string {
+	for i, cell := range cells {
+		if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+			cells[i] = "'" + cell
+		}
+	}
+	return cells
+}
+
+// copyProjectApps returns a copy of the apps of the named project.
+func copyProjectApps(projectName string) ([]AppEntry, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, project := range allProjects {
+		if project.ProjectName == projectName {
+			apps := make([]AppEntry, len(project.Apps))
+			for i, app := range project.Apps {
+				apps[i] = app
+				apps[i].Builds = append([]BuildInfo(nil), app.Builds...)
+			}
+			return apps, true
+		}
+	}
+	return nil, false
+}
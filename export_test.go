@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestExportCSVEscapesFormulas(t *testing.T) {
+	router := setupTestServer(t)
+	app := AppInfo{AppName: "=HYPERLINK(\"http://evil\")", PackageName: "com.example.csv"}
+	build := BuildInfo{Version: "+1.0", VersionCode: 3, Channel: "@beta", FileName: "csv.apk", UploadTime: "-1"}
+	if err := repo.UpsertBuild("Demo", app, build); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(router, http.MethodGet, "/api/export.csv")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	rows, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(rec.Body.String(), "\ufeff"))).ReadAll()
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("rows = %q, err = %v", rows, err)
+	}
+	row := rows[1]
+	want := []string{"Demo", "'=HYPERLINK(\"http://evil\")", "com.example.csv", "'+1.0", "3", "'@beta", "0", "'-1", "0"}
+	if strings.Join(row, "|") != strings.Join(want, "|") {
+		t.Errorf("row = %q, want %q", row, want)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Custom build fields arrive as "extra_<key>" form fields or as a JSON
+// object in the "extra" form field, e.g. {"commit": "abc123"}
+const (
+	extraFieldPrefix = "extra_"
+	extraQueryPrefix = "extra."
+)
+
+// Limits on custom build fields, so they stay labels rather than payloads
+const (
+	maxExtraFields   = 20
+	maxExtraKeyLen   = 64
+	maxExtraValueLen = 1024
+)
+
+// validExtraKey reports whether key may name a custom build field: letters,
+// digits, "-" and "_", as keys appear in form field names and query strings
+func validExtraKey(key string) bool {
+	return key != "" && strings.IndexFunc(key, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_'
+	}) < 0
+}
+
+// validateExtraFields checks the custom fields of a new build, however they
+// arrive, and returns every problem at once.
+func validateExtraFields(extra map[string]string) []FieldError {
+	var errs []FieldError
+	if len(extra) > maxExtraFields {
+		errs = append(errs, FieldError{"extra", fmt.Sprintf("自定义字段过多（%d 个），上限为 %d 个", len(extra), maxExtraFields)})
+	}
+	keys := make([]string, 0, len(extra))
+	for key := range extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field := extraFieldPrefix + key
+		switch {
+		case utf8.RuneCountInString(key) > maxExtraKeyLen:
+			errs = append(errs, FieldError{field, "自定义字段名过长"})
+		case !validExtraKey(key):
+			errs = append(errs, FieldError{field, "自定义字段名只能包含字母、数字、- 和 _"})
+		case utf8.RuneCountInString(extra[key]) > maxExtraValueLen:
+			errs = append(errs, FieldError{field, fmt.Sprintf("自定义字段 %s 的值过长，上限为 %d 字", key, maxExtraValueLen)})
+		case strings.IndexFunc(extra[key], unicode.IsControl) >= 0:
+			errs = append(errs, FieldError{field, fmt.Sprintf("自定义字段 %s 的值包含控制字符", key)})
+		}
+	}
+	return errs
+}
+
+// extraFromForm collects and validates the custom fields of an upload form.
+// Keys from the "extra" JSON object are overridden by "extra_<key>" fields;
+// empty values are dropped. A nil map means the build has no custom fields.
+func extraFromForm(c *gin.Context) (map[string]string, []FieldError) {
+	extra := map[string]string{}
+	if blob := strings.TrimSpace(c.PostForm("extra")); blob != "" {
+		if err := json.Unmarshal([]byte(blob), &extra); err != nil {
+			return nil, []FieldError{{"extra", "extra 必须是字符串值的 JSON 对象"}}
+		}
+	}
+	// c.PostForm above parsed the body, multipart or not
+	for name, values := range c.Request.PostForm {
+		if key, ok := strings.CutPrefix(name, extraFieldPrefix); ok && len(values) > 0 {
+			extra[key] = values[0]
+		}
+	}
+	extra = normalizeExtra(extra)
+	return extra, validateExtraFields(extra)
+}
+
+// normalizeExtra trims the values of custom fields and drops empty ones
+func normalizeExtra(extra map[string]string) map[string]string {
+	normalized := make(map[string]string, len(extra))
+	for key, value := range extra {
+		if value = strings.TrimSpace(value); value != "" {
+			normalized[strings.TrimSpace(key)] = value
+		}
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+	return normalized
+}
+
+// handleAppBuilds serves GET /api/apps/:packageName/builds, newest upload
+// first or with ?sort=version highest versionCode first, paginated by
+// ?page= and ?pageSize=. ?channel=, any number of ?tag= and
+// ?extra.<key>=<value> narrow the list to builds matching all of them, e.g.
+// ?tag=qa-approved&extra.commit=abc123.
+func handleAppBuilds(c *gin.Context) {
+	p, ok := parsePageRequest(c)
+	if !ok {
+		return
+	}
+	packageName := c.Param("packageName")
+	appName, builds, found := buildsOfPackage(packageName)
+	if !found {
+		respondError(c, http.StatusNotFound, "应用未找到")
+		return
+	}
+
+	channel := c.Query("channel")
+	tags := c.QueryArray("tag")
+	for i, tag := range tags {
+		tags[i] = normalizeTag(tag)
+	}
+	wanted := map[string]string{}
+	for name, values := range c.Request.URL.Query() {
+		if key, ok := strings.CutPrefix(name, extraQueryPrefix); ok && len(values) > 0 {
+			wanted[key] = values[0]
+		}
+	}
+
+	matching := []BuildInfo{}
+	for _, build := range builds {
+		if channel != "" && build.Channel != channel {
+			continue
+		}
+		match := true
+		for _, tag := range tags {
+			if !slices.Contains(build.Tags, tag) {
+				match = false
+				break
+			}
+		}
+		for key, value := range wanted {
+			if actual, ok := build.Extra[key]; !ok || actual != value {
+				match = false
+				break
+			}
+		}
+		if match {
+			matching = append(matching, build)
+		}
+	}
+	sortBuilds(matching, parseBuildOrder(c.Query("sort")))
+
+	c.JSON(http.StatusOK, p.addPageFields(gin.H{
+		"packageName": packageName,
+		"appName":     appName,
+		"builds":      withNotesHTML(pageOf(matching, p)),
+	}, len(matching)))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// maxUploadNameLen caps the sanitized client file name used in temp paths
+const maxUploadNameLen = 100
+
+// zipMagic starts every APK and IPA, since both are zip archives
+var zipMagic = []byte("PK\x03\x04")
+
+// suspiciousExtensions are extensions that must not hide inside an upload
+// name, e.g. "app.exe.apk" or "app.apk.exe"
+var suspiciousExtensions = map[string]bool{
+	"exe": true, "com": true, "bat": true, "cmd": true, "scr": true, "pif": true,
+	"msi": true, "dll": true, "js": true, "jse": true, "vbs": true, "vbe": true,
+	"wsf": true, "ps1": true, "sh": true, "jar": true, "hta": true, "lnk": true,
+}
+
+// allowedUploadTypes are the Content-Type values browsers and tools send for
+// APKs, IPAs and HarmonyOS packages
+var allowedUploadTypes = map[string]bool{
+	"application/x-ios-app":                   true,
+	"application/x-itunes-ipa":                true,
+	"application/vnd.android.package-archive": true,
+	"application/octet-stream":                true,
+	"application/zip":                         true,
+	"application/x-zip-compressed":            true,
+	"application/java-archive":                true,
+}
+
+// sanitizeUploadName reduces a client supplied file name to a safe base name:
+// directories (both separators) are stripped, control and bidi override
+// characters dropped, and anything outside [A-Za-z0-9._-] replaced with "_".
+func sanitizeUploadName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case unicode.IsControl(r) || unicode.Is(unicode.Cf, r):
+			continue
+		case r < 0x80 && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' || r == '_'):
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	clean := strings.TrimLeft(b.String(), ".")
+	if len(clean) > maxUploadNameLen {
+		clean = clean[len(clean)-maxUploadNameLen:]
+	}
+	if clean == "" {
+		clean = "upload.apk"
+	}
+	return clean
+}
+
+// checkUploadFile rejects uploads whose name or declared type does not look
+// like an APK, AAB or IPA: a final extension other than those, a suspicious inner
+// extension, bidi override characters, a mismatching Content-Type, or content
+// that is not a zip archive. It is a no-op when config.FilenameGuard is off.
+func checkUploadFile(file *multipart.FileHeader) error {
+	if !config.FilenameGuard {
+		return nil
+	}
+	if err := checkUploadName(file.Filename); err != nil {
+		return err
+	}
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(file.Filename)), ".")
+
+	contentType := file.Header.Get("Content-Type")
+	if contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || !allowedUploadTypes[mediaType] {
+			return fmt.Errorf("文件类型 %s 与 .%s 扩展名不符", contentType, ext)
+		}
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return checkZipMagic(f)
+}
+
+// checkUploadName applies the file name rules of checkUploadFile
+func checkUploadName(fileName string) error {
+	for _, r := range fileName {
+		if unicode.Is(unicode.Cf, r) || unicode.IsControl(r) {
+			return fmt.Errorf("文件名包含不可见或控制字符")
+		}
+	}
+	name := strings.ToLower(path.Base(strings.ReplaceAll(fileName, `\`, "/")))
+	parts := strings.Split(name, ".")
+	ext := parts[len(parts)-1]
+	if len(parts) < 2 || (ext != "apk" && ext != "aab" && ext != "ipa" && ext != "hap" && ext != "app") {
+		return fmt.Errorf("文件扩展名必须为 .apk、.aab、.ipa、.hap 或 .app")
+	}
+	for _, ext := range parts[1 : len(parts)-1] {
+		if suspiciousExtensions[ext] {
+			return fmt.Errorf("文件名包含可疑的双重扩展名 .%s", ext)
+		}
+	}
+	return nil
+}
+
+// checkZipMagic fails unless r starts like a zip archive
+func checkZipMagic(r io.Reader) error {
+	magic := make([]byte, len(zipMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, zipMagic) {
+		return fmt.Errorf("文件内容不是有效的 APK/IPA (zip) 格式")
+	}
+	return nil
+}
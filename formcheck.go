@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxProjectNameLen = 100
+	maxChannelLen     = 50
+)
+
+// FieldError describes one missing or invalid upload form field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validateUploadForm checks the required upload fields before anything is
+// stored and returns every problem at once. The channel ends up in stored
+// file names, so it is limited to letters, digits, "-" and "_".
+func validateUploadForm(c *gin.Context) []FieldError {
+	errs := validateBuildFields(c.PostForm("projectName"), c.PostForm("channel"), c.PostForm("releaseNotes"))
+	_, extraErrs := extraFromForm(c)
+	errs = append(errs, extraErrs...)
+	if file, err := c.FormFile("file"); err != nil {
+		errs = append(errs, FieldError{"file", "请选择要上传的 APK 文件"})
+	} else if file.Size == 0 {
+		errs = append(errs, FieldError{"file", "上传的文件为空"})
+	}
+	return errs
+}
+
+// validateBuildFields checks the project name, channel and release notes of
+// a new build, however it arrives.
+func validateBuildFields(projectName, channel, releaseNotes string) []FieldError {
+	var errs []FieldError
+
+	projectName = strings.TrimSpace(projectName)
+	switch {
+	case projectName == "":
+		errs = append(errs, FieldError{"projectName", "项目名称不能为空"})
+	case utf8.RuneCountInString(projectName) > maxProjectNameLen:
+		errs = append(errs, FieldError{"projectName", "项目名称过长"})
+	case strings.IndexFunc(projectName, unicode.IsControl) >= 0:
+		errs = append(errs, FieldError{"projectName", "项目名称包含控制字符"})
+	}
+
+	if message := channelError(strings.TrimSpace(channel)); message != "" {
+		errs = append(errs, FieldError{"channel", message})
+	}
+
+	if fieldErr, tooLong := releaseNotesFieldError(releaseNotes); tooLong {
+		errs = append(errs, fieldErr)
+	}
+	return errs
+}
+
+// channelError describes what is wrong with a channel name, "" when it is
+// valid
+func channelError(channel string) string {
+	switch {
+	case channel == "":
+		return "渠道不能为空"
+	case utf8.RuneCountInString(channel) > maxChannelLen:
+		return "渠道名称过长"
+	case strings.IndexFunc(channel, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_'
+	}) >= 0:
+		return "渠道只能包含字母、数字、- 和 _"
+	}
+	return ""
+}
+
+// respondFieldErrors reports form validation failures: web form submissions
+// are sent back to the upload page with the messages and their input, API
+// clients get a 400 listing every field.
+func respondFieldErrors(c *gin.Context, errs []FieldError) {
+	if c.PostForm("source") == "web" {
+		query := url.Values{
+			"projectName": {c.PostForm("projectName")},
+			"channel":     {c.PostForm("channel")},
+		}
+		for _, e := range errs {
+			query.Add("error", e.Message)
+		}
+		c.Redirect(http.StatusFound, withBasePath("/upload")+"?"+query.Encode())
+		return
+	}
+	body := errorBody(c, "表单字段校验失败")
+	body["fields"] = errs
+	c.JSON(http.StatusBadRequest, body)
+}
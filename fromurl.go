@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxFromURLRedirects bounds the redirects followed when downloading
+const maxFromURLRedirects = 5
+
+// fromURLRequest is the JSON body accepted by handleUploadFromURL and
+// handleUploadURL
+type fromURLRequest struct {
+	URL string `json:"url"`
+	// FileName names the package when the URL path does not end in one,
+	// e.g. "app.ipa", see fromURLFileName
+	FileName       string `json:"fileName"`
+	ProjectName    string `json:"projectName"`
+	Channel        string `json:"channel"`
+	ReleaseNotes   string `json:"releaseNotes"`
+	AllowDowngrade bool   `json:"allowDowngrade"`
+	Force          bool   `json:"force"` // same as AllowDowngrade
+	// AllowSignerChange accepts a different signer under the strict policy
+	AllowSignerChange bool              `json:"allowSignerChange"`
+	Extra             map[string]string `json:"extra"`
+}
+
+// downloadError is a failed download together with the status to report
+type downloadError struct {
+	status  int
+	message string
+}
+
+func (e *downloadError) Error() string { return e.message }
+
+// fromURLHostAllowed reports whether host matches config.FromURLHosts
+func fromURLHostAllowed(host string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range config.FromURLHosts {
+		allowed = strings.ToLower(allowed)
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// handleUploadFromURL downloads an APK that already lives on another
+// allowlisted host and publishes it like a regular upload, which saves
+// re-uploading large files when migrating.
+func handleUploadFromURL(c *gin.Context) {
+	if !checkAdmin(c) {
+		return
+	}
+	req, source, ok := bindFromURLRequest(c)
+	if !ok {
+		return
+	}
+	fileName, ok := fromURLFileName(c, req, source)
+	if !ok {
+		return
+	}
+
+	logf(c, "开始从 URL 下载构建: %s", source.Redacted())
+	incomingPath, fileHash, size, err := downloadAPK(c, source, fileName, nil)
+	if err != nil {
+		respondDownloadError(c, source, err)
+		return
+	}
+	logf(c, "下载完成: %s, 大小: %d", incomingPath, size)
+
+	result, err := publishUpload(c, incomingPath, fileHash, size, uploadRequest{
+		ProjectName:       strings.TrimSpace(req.ProjectName),
+		Channel:           strings.TrimSpace(req.Channel),
+		ReleaseNotes:      req.ReleaseNotes,
+		AllowDowngrade:    req.AllowDowngrade || req.Force,
+		AllowSignerChange: req.AllowSignerChange,
+		Extra:             req.Extra,
+		Platform:          uploadPlatform(fileName),
+	})
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Upload successful", "warnings": result.Warnings})
+}
+
+// fromURLFileName returns the name of the package req downloads from
+// source, which tells its platform: the fileName field, else the last
+// element of the URL path. It answers c with 400 and returns false when the
+// name fails checkUploadName.
+func fromURLFileName(c *gin.Context, req fromURLRequest, source *url.URL) (string, bool) {
+	fileName := strings.TrimSpace(req.FileName)
+	if fileName == "" {
+		fileName = path.Base(source.Path)
+	}
+	if config.FilenameGuard {
+		if err := checkUploadName(fileName); err != nil {
+			respondError(c, http.StatusBadRequest, "文件检查未通过: "+err.Error()+"（可通过 fileName 字段指定文件名）")
+			return "", false
+		}
+	}
+	return fileName, true
+}
+
+// bindFromURLRequest reads and validates the body of a download request,
+// answering c and returning false when it is invalid or its host is not in
+// APPDIST_FROM_URL_HOSTS
+func bindFromURLRequest(c *gin.Context) (fromURLRequest, *url.URL, bool) {
+	var req fromURLRequest
+	if len(config.FromURLHosts) == 0 {
+		respondError(c, http.StatusForbidden, "未配置允许下载的主机 (APPDIST_FROM_URL_HOSTS)")
+		return req, nil, false
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "请求体格式错误: "+err.Error())
+		return req, nil, false
+	}
+	req.Extra = normalizeExtra(req.Extra)
+	errs := append(validateBuildFields(req.ProjectName, req.Channel, req.ReleaseNotes), validateExtraFields(req.Extra)...)
+	if len(errs) > 0 {
+		body := errorBody(c, "表单字段校验失败")
+		body["fields"] = errs
+		c.JSON(http.StatusBadRequest, body)
+		return req, nil, false
+	}
+	source, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (source.Scheme != "http" && source.Scheme != "https") || source.Hostname() == "" {
+		respondError(c, http.StatusBadRequest, "url 必须是有效的 http 或 https 地址")
+		return req, nil, false
+	}
+	if !fromURLHostAllowed(source.Hostname()) {
+		respondError(c, http.StatusForbidden, fmt.Sprintf("不允许从主机 %s 下载", source.Hostname()))
+		return req, nil, false
+	}
+	return req, source, true
+}
+
+// respondDownloadError answers a failed downloadAPK, with 502 unless the
+// error carries another status
+func respondDownloadError(c *gin.Context, source *url.URL, err error) {
+	respondUploadError(c, downloadFailure(c, source, err))
+}
+
+// downloadFailure logs the failed download from source and returns the
+// uploadError it is answered with
+func downloadFailure(c *gin.Context, source *url.URL, err error) *uploadError {
+	warnf(c, "从 %s 下载失败: %v", source.Redacted(), err)
+	status := http.StatusBadGateway
+	var dlErr *downloadError
+	if errors.As(err, &dlErr) {
+		status = dlErr.status
+	}
+	return &uploadError{status: status, message: err.Error(), asJSON: true}
+}
+
+// progressReader reports the bytes read so far after every read
+type progressReader struct {
+	r        io.Reader
+	received int64
+	total    int64
+	report   func(received, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.received += int64(n)
+		p.report(p.received, p.total)
+	}
+	return n, err
+}
+
+// downloadAPK fetches source into the incoming directory under name and
+// returns its path, hash and size, enforcing config.FromURLTimeout and
+// config.FromURLMaxSize and refusing redirects to hosts outside the
+// allowlist. progress, when set, is called as the body arrives with the
+// bytes received and the announced size. The caller must publish or
+// discard the file.
+func downloadAPK(c *gin.Context, source *url.URL, name string, progress func(received, total int64)) (string, string, int64, error) {
+	client := &http.Client{
+		Timeout: config.FromURLTimeout,
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			if len(via) >= maxFromURLRedirects {
+				return errors.New("重定向次数过多")
+			}
+			if !fromURLHostAllowed(r.URL.Hostname()) {
+				return fmt.Errorf("重定向到不允许的主机 %s", r.URL.Hostname())
+			}
+			return nil
+		},
+	}
+	httpReq, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, source.String(), nil)
+	if err != nil {
+		return "", "", 0, &downloadError{http.StatusBadRequest, "url 无效: " + err.Error()}
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return "", "", 0, &downloadError{http.StatusBadGateway, "下载失败: " + err.Error()}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", 0, &downloadError{http.StatusBadGateway, "下载失败: 远端返回 " + resp.Status}
+	}
+	tooLarge := &downloadError{http.StatusRequestEntityTooLarge, fmt.Sprintf("文件超过下载大小上限 %s", formatSize(config.FromURLMaxSize))}
+	if resp.ContentLength > config.FromURLMaxSize {
+		return "", "", 0, tooLarge
+	}
+
+	f, err := createIncomingFile(name)
+	if err != nil {
+		return "", "", 0, err
+	}
+	incomingPath := f.Name()
+	var body io.Reader = resp.Body
+	if progress != nil {
+		total := max(resp.ContentLength, 0)
+		progress(0, total)
+		body = &progressReader{r: resp.Body, total: total, report: progress}
+	}
+	size, fileHash, err := writeIncoming(f, body, config.FromURLMaxSize)
+	switch {
+	case err != nil:
+		err = &downloadError{http.StatusBadGateway, "下载失败: " + err.Error()}
+	case size > config.FromURLMaxSize:
+		err = tooLarge
+	case size == 0:
+		err = &downloadError{http.StatusBadRequest, "下载的文件为空"}
+	}
+	if err == nil {
+		err = checkDownloadedAPK(incomingPath)
+	}
+	if err != nil {
+		discardUpload(c, incomingPath)
+		return "", "", 0, err
+	}
+	return incomingPath, fileHash, size, nil
+}
+
+// checkDownloadedAPK rejects downloads that are not zip archives, such as an
+// HTML login page served with status 200
+func checkDownloadedAPK(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := checkZipMagic(f); err != nil {
+		return &downloadError{http.StatusBadRequest, "文件检查未通过: " + err.Error()}
+	}
+	return nil
+}
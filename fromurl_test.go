@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUploadFromURL(t *testing.T) {
+	router := setupTestServer(t)
+	apk := fixtureAPK(t)
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/builds/app-release.apk" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(apk)
+	}))
+	defer source.Close()
+	config.FromURLHosts = []string{"127.0.0.1"}
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/upload/from-url", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(adminPasswordHeader, testAdminPassword)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The platform comes from the name of the downloaded file
+	rec := post(`{"url": "` + source.URL + `/builds/app-release.apk", "projectName": "Demo", "channel": "stable"}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Upload successful") {
+		t.Fatalf("from-url: status %d: %s", rec.Code, rec.Body.String())
+	}
+	builds := appBuilds(t, router, fixturePackage)
+	if len(builds) != 1 || buildPlatform(builds[0]) != platformAndroid || builds[0].Channel != "stable" {
+		t.Errorf("builds = %+v", builds)
+	}
+
+	// Names without a package extension are refused before downloading
+	if rec := post(`{"url": "` + source.URL + `/builds/", "projectName": "Demo", "channel": "stable"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("no package name: status %d, want 400", rec.Code)
+	}
+	if rec := post(`{"url": "` + source.URL + `/missing.apk", "projectName": "Demo", "channel": "beta"}`); rec.Code != http.StatusBadGateway {
+		t.Errorf("missing file: status %d, want 502", rec.Code)
+	}
+}
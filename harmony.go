@@ -0,0 +1,395 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// formatHarmonyApp is the Format of HarmonyOS builds uploaded as .app
+// packages, which bundle the HAP modules of an app for distribution. A
+// single .hap module has no Format, like an APK.
+const formatHarmonyApp = "app"
+
+// Files inside HarmonyOS packages. Stage model modules describe themselves
+// in module.json, older FA model modules in config.json; an .app lists its
+// modules in pack.info.
+const (
+	harmonyModuleJSON    = "module.json"
+	harmonyConfigJSON    = "config.json"
+	harmonyPackInfo      = "pack.info"
+	harmonyResourceIndex = "resources.index"
+)
+
+// harmonyMaxEntrySize bounds the descriptions, resource index and icons
+// read into memory
+const harmonyMaxEntrySize = 16 << 20
+
+// harmonyMaxModuleSize bounds a compressed HAP inside an .app that has to
+// be inflated into memory to be read; stored ones are read in place
+const harmonyMaxModuleSize = 256 << 20
+
+// harmonyModule holds the fields of module.json and config.json the
+// catalog relies on. The two models use different names, so one struct
+// decodes both.
+type harmonyModule struct {
+	App struct {
+		BundleName    string `json:"bundleName"`
+		VersionCode   int64  `json:"versionCode"`
+		VersionName   string `json:"versionName"`
+		MinAPIVersion int32  `json:"minAPIVersion"`
+		Label         string `json:"label"`
+		Icon          string `json:"icon"`
+		// FA model
+		Version struct {
+			Code int64  `json:"code"`
+			Name string `json:"name"`
+		} `json:"version"`
+		APIVersion struct {
+			Compatible int32 `json:"compatible"`
+		} `json:"apiVersion"`
+	} `json:"app"`
+	Module struct {
+		Abilities []struct {
+			Label string `json:"label"`
+			Icon  string `json:"icon"`
+		} `json:"abilities"`
+		RequestPermissions []struct {
+			Name string `json:"name"`
+		} `json:"requestPermissions"`
+		// FA model
+		ReqPermissions []struct {
+			Name string `json:"name"`
+		} `json:"reqPermissions"`
+	} `json:"module"`
+}
+
+// harmonyPack is the part of the pack.info of an .app naming its modules
+type harmonyPack struct {
+	Packages []struct {
+		Name       string `json:"name"`
+		ModuleType string `json:"moduleType"`
+	} `json:"packages"`
+}
+
+// harmonyPackage is an opened HarmonyOS package: the HAP the details are
+// read from, the entry module when the upload is an .app
+type harmonyPackage struct {
+	file   *os.File
+	hap    *zip.Reader
+	module harmonyModule
+	format string
+}
+
+func (p *harmonyPackage) Close() error {
+	return p.file.Close()
+}
+
+// openHarmony opens the .hap or .app at pkgPath and decodes the module
+// description of the HAP, or of the entry HAP of an .app
+func openHarmony(pkgPath string) (*harmonyPackage, error) {
+	f, err := os.Open(pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	archive, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("解析HarmonyOS安装包失败: %w", err)
+	}
+
+	pkg := &harmonyPackage{file: f, hap: archive}
+	if entry := harmonyEntryModule(archive); entry != nil {
+		if pkg.hap, err = openNestedZip(f, entry); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("解析 .app 中的 %s 失败: %w", entry.Name, err)
+		}
+		pkg.format = formatHarmonyApp
+	}
+	if pkg.module, err = readHarmonyModule(pkg.hap); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// harmonyEntryModule returns the entry HAP of an .app: the module pack.info
+// marks as entry, or else the first HAP. It returns nil for a HAP.
+func harmonyEntryModule(archive *zip.Reader) *zip.File {
+	haps := map[string]*zip.File{}
+	var first *zip.File
+	isApp := false
+	for _, file := range archive.File {
+		switch {
+		case file.Name == harmonyPackInfo:
+			isApp = true
+		case path.Dir(file.Name) == "." && strings.EqualFold(path.Ext(file.Name), ".hap"):
+			haps[strings.TrimSuffix(file.Name, path.Ext(file.Name))] = file
+			if first == nil {
+				first = file
+			}
+		}
+	}
+	if !isApp || first == nil {
+		return nil
+	}
+	if data, err := readZipEntry(archive, harmonyPackInfo); err == nil {
+		var pack harmonyPack
+		if json.Unmarshal(data, &pack) == nil {
+			for _, p := range pack.Packages {
+				if file, ok := haps[p.Name]; ok && p.ModuleType == "entry" {
+					return file
+				}
+			}
+		}
+	}
+	return first
+}
+
+// openNestedZip opens a zip archive stored inside the archive read from
+// outer. Stored entries are read in place; compressed ones are inflated
+// into memory up to harmonyMaxModuleSize.
+func openNestedZip(outer io.ReaderAt, file *zip.File) (*zip.Reader, error) {
+	size := int64(file.UncompressedSize64)
+	if file.Method == zip.Store {
+		offset, err := file.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		return zip.NewReader(io.NewSectionReader(outer, offset, size), size)
+	}
+	if size > harmonyMaxModuleSize {
+		return nil, errors.New("文件过大")
+	}
+	r, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, harmonyMaxModuleSize))
+	if err != nil {
+		return nil, err
+	}
+	return zip.NewReader(bytes.NewReader(data), int64(len(data)))
+}
+
+// readZipEntry reads the file name of archive, up to harmonyMaxEntrySize
+func readZipEntry(archive *zip.Reader, name string) ([]byte, error) {
+	for _, file := range archive.File {
+		if file.Name == name {
+			return readZipFile(file)
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+func readZipFile(file *zip.File) ([]byte, error) {
+	if file.UncompressedSize64 > harmonyMaxEntrySize {
+		return nil, errors.New("文件过大")
+	}
+	r, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(io.LimitReader(r, harmonyMaxEntrySize))
+}
+
+// readHarmonyModule decodes module.json, or config.json of FA model HAPs
+func readHarmonyModule(hap *zip.Reader) (harmonyModule, error) {
+	var module harmonyModule
+	data, err := readZipEntry(hap, harmonyModuleJSON)
+	if errors.Is(err, os.ErrNotExist) {
+		data, err = readZipEntry(hap, harmonyConfigJSON)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return module, errors.New("解析HarmonyOS安装包失败: 未找到 module.json 或 config.json")
+	}
+	if err != nil {
+		return module, fmt.Errorf("解析HarmonyOS安装包的模块描述失败: %w", err)
+	}
+	if err := json.Unmarshal(data, &module); err != nil {
+		return module, fmt.Errorf("解析HarmonyOS安装包的模块描述失败: %w", err)
+	}
+	return module, nil
+}
+
+// labelAndIcon returns the app label and icon references, falling back to
+// those of the first ability as FA model modules declare them there
+func (m harmonyModule) labelAndIcon() (label, icon string) {
+	label, icon = m.App.Label, m.App.Icon
+	for _, ability := range m.Module.Abilities {
+		if label == "" {
+			label = ability.Label
+		}
+		if icon == "" {
+			icon = ability.Icon
+		}
+	}
+	return label, icon
+}
+
+// harmonyReference returns the resource name of a reference such as
+// "$string:app_name" to a resource of kind
+func harmonyReference(value, kind string) (string, bool) {
+	return strings.CutPrefix(value, "$"+kind+":")
+}
+
+// harmonyIndexString looks up the string resource name in a compiled
+// resources.index. Each resource record ends with its value and its name,
+// both NUL-terminated and preceded by their 16-bit length, so the record
+// is found by its name without decoding the whole index. Where several
+// locales define the string, the first record wins.
+func harmonyIndexString(index []byte, name string) string {
+	key := append([]byte(name), 0)
+	for offset := 0; offset < len(index); {
+		i := bytes.Index(index[offset:], key)
+		if i < 0 {
+			return ""
+		}
+		pos := offset + i
+		offset = pos + 1
+		if pos < 2 || int(binary.LittleEndian.Uint16(index[pos-2:])) != len(key) {
+			continue
+		}
+		valueEnd := pos - 2
+		if valueEnd < 1 || index[valueEnd-1] != 0 {
+			continue
+		}
+		// Walk back over the value, which holds no NUL, until the length
+		// in front of it matches; n counts the terminating NUL
+		for n := 2; n <= 1<<16-1 && valueEnd-n-2 >= 0; n++ {
+			if index[valueEnd-n] == 0 {
+				break
+			}
+			if int(binary.LittleEndian.Uint16(index[valueEnd-n-2:])) != n {
+				continue
+			}
+			if value := index[valueEnd-n : valueEnd-1]; utf8.Valid(value) {
+				return strings.TrimSpace(string(value))
+			}
+			break
+		}
+	}
+	return ""
+}
+
+// parseHarmonyDetails reads the bundle name, versions, API levels and
+// permissions of a .hap, or of the entry module of an .app. The bundle name
+// plays the role of the package name. A label that cannot be resolved
+// falls back to the bundle name.
+func parseHarmonyDetails(pkgPath string) (ApkDetails, error) {
+	pkg, err := openHarmony(pkgPath)
+	if err != nil {
+		return ApkDetails{}, err
+	}
+	defer pkg.Close()
+
+	app := pkg.module.App
+	bundleName := strings.TrimSpace(app.BundleName)
+	if bundleName == "" {
+		return ApkDetails{}, errors.New("解析HarmonyOS安装包的 bundleName 失败或为空")
+	}
+	version, versionCode := app.VersionName, app.VersionCode
+	if version == "" {
+		version, versionCode = app.Version.Name, app.Version.Code
+	}
+	version = strings.TrimSpace(version)
+	if version == "" {
+		return ApkDetails{}, errors.New("解析HarmonyOS安装包的 versionName 失败或为空")
+	}
+	if versionCode < 0 || versionCode > 1<<31-1 {
+		return ApkDetails{}, errors.New("解析HarmonyOS安装包的 versionCode 失败")
+	}
+	minAPI := app.MinAPIVersion
+	if minAPI == 0 {
+		minAPI = app.APIVersion.Compatible
+	}
+
+	details := ApkDetails{
+		Platform:    platformHarmony,
+		Format:      pkg.format,
+		PackageName: bundleName,
+		Version:     version,
+		VersionCode: int32(versionCode),
+	}
+	if minAPI > 0 {
+		details.MinOSVersion = strconv.Itoa(int(minAPI))
+	}
+	for _, permissions := range [][]struct {
+		Name string `json:"name"`
+	}{pkg.module.Module.RequestPermissions, pkg.module.Module.ReqPermissions} {
+		for _, permission := range permissions {
+			if permission.Name != "" {
+				details.Permissions = append(details.Permissions, permission.Name)
+			}
+		}
+	}
+
+	label, _ := pkg.module.labelAndIcon()
+	if name, ok := harmonyReference(label, "string"); ok {
+		if index, err := readZipEntry(pkg.hap, harmonyResourceIndex); err == nil {
+			details.AppName = harmonyIndexString(index, name)
+		}
+	} else {
+		details.AppName = strings.TrimSpace(label)
+	}
+	if details.AppName == "" {
+		details.AppName = bundleName
+	}
+	return details, nil
+}
+
+// harmonyIcon returns the largest PNG of the icon media resource of a
+// HarmonyOS package. Layered icons defined only in JSON have no PNG to show.
+func harmonyIcon(pkgPath string) (image.Image, error) {
+	pkg, err := openHarmony(pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	defer pkg.Close()
+
+	_, icon := pkg.module.labelAndIcon()
+	name, ok := harmonyReference(icon, "media")
+	if !ok {
+		return nil, errors.New("HarmonyOS安装包未声明图标")
+	}
+	var best image.Image
+	for _, file := range pkg.hap.File {
+		dir, base := path.Split(file.Name)
+		if !strings.HasPrefix(dir, "resources/") || path.Base(dir) != "media" || base != name+".png" {
+			continue
+		}
+		data, err := readZipFile(file)
+		if err != nil {
+			continue
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			continue
+		}
+		if best == nil || img.Bounds().Dx() > best.Bounds().Dx() {
+			best = img
+		}
+	}
+	if best == nil {
+		return nil, errors.New("HarmonyOS安装包中没有可解码的图标")
+	}
+	return best, nil
+}
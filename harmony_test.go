@@ -0,0 +1,174 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// harmonyFixturePackage is the bundle name of the HarmonyOS fixtures
+const harmonyFixturePackage = "com.example.harmony"
+
+// harmonyModuleFixture is the module.json of fixtureHAP, as the stage model
+// build tools write it
+const harmonyModuleFixture = `{
+  "app": {
+    "bundleName": "com.example.harmony",
+    "versionCode": 1000002,
+    "versionName": "1.0.2",
+    "minAPIVersion": 9,
+    "targetAPIVersion": 10,
+    "icon": "$media:app_icon",
+    "label": "$string:app_name"
+  },
+  "module": {
+    "name": "entry",
+    "type": "entry",
+    "requestPermissions": [{"name": "ohos.permission.INTERNET"}]
+  }
+}`
+
+// harmonyIndexRecord encodes a string resource record of resources.index
+func harmonyIndexRecord(id uint32, name, value string) []byte {
+	var b bytes.Buffer
+	body := func(s string) {
+		binary.Write(&b, binary.LittleEndian, uint16(len(s)+1))
+		b.WriteString(s)
+		b.WriteByte(0)
+	}
+	binary.Write(&b, binary.LittleEndian, uint32(12+len(name)+len(value)+6))
+	binary.Write(&b, binary.LittleEndian, uint32(9))
+	binary.Write(&b, binary.LittleEndian, id)
+	body(value)
+	body(name)
+	return b.Bytes()
+}
+
+// writeZip writes files into a zip archive, storing them uncompressed when
+// store is set
+func writeZip(t *testing.T, files map[string][]byte, store bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, data := range files {
+		method := zip.Deflate
+		if store {
+			method = zip.Store
+		}
+		f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// fixtureHAP builds an entry HAP with a 64 pixel icon and a label defined
+// for two locales
+func fixtureHAP(t *testing.T) []byte {
+	t.Helper()
+	var icon bytes.Buffer
+	if err := png.Encode(&icon, image.NewRGBA(image.Rect(0, 0, 64, 64))); err != nil {
+		t.Fatal(err)
+	}
+	index := append([]byte("header, keys and configs"),
+		harmonyIndexRecord(0x01000000, "app_name", "Hello Harmony")...)
+	index = append(index, harmonyIndexRecord(0x01000001, "module_desc", "entry")...)
+	index = append(index, harmonyIndexRecord(0x01000000, "app_name", "你好鸿蒙")...)
+	return writeZip(t, map[string][]byte{
+		"module.json":                       []byte(harmonyModuleFixture),
+		"resources.index":                   index,
+		"resources/base/media/app_icon.png": icon.Bytes(),
+		"ets/modules.abc":                   []byte("bytecode"),
+	}, false)
+}
+
+// fixtureHarmonyApp wraps fixtureHAP as the entry module of an .app
+func fixtureHarmonyApp(t *testing.T) []byte {
+	t.Helper()
+	packInfo := `{"summary": {"app": {"bundleName": "com.example.harmony"}},
+		"packages": [{"name": "feature-default", "moduleType": "feature"}, {"name": "entry-default", "moduleType": "entry"}]}`
+	return writeZip(t, map[string][]byte{
+		"pack.info":           []byte(packInfo),
+		"feature-default.hap": writeZip(t, map[string][]byte{"module.json": []byte(`{"app": {"bundleName": "wrong"}}`)}, false),
+		"entry-default.hap":   fixtureHAP(t),
+	}, true)
+}
+
+func TestParseHarmonyDetails(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{"entry.hap": fixtureHAP(t), "app.app": fixtureHarmonyApp(t)} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		details, err := parseHarmonyDetails(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if details.Platform != platformHarmony || details.PackageName != harmonyFixturePackage || details.AppName != "Hello Harmony" ||
+			details.Version != "1.0.2" || details.VersionCode != 1000002 || details.MinOSVersion != "9" ||
+			len(details.Permissions) != 1 || details.Permissions[0] != "ohos.permission.INTERNET" {
+			t.Errorf("%s: details = %+v", name, details)
+		}
+		if wantFormat := map[string]string{"entry.hap": "", "app.app": formatHarmonyApp}[name]; details.Format != wantFormat {
+			t.Errorf("%s: format %q, want %q", name, details.Format, wantFormat)
+		}
+		if icon, err := harmonyIcon(path); err != nil || icon.Bounds().Dx() != 64 {
+			t.Errorf("%s: icon: %v", name, err)
+		}
+	}
+
+	// FA model modules declare their versions and label elsewhere
+	path := filepath.Join(dir, "fa.hap")
+	os.WriteFile(path, writeZip(t, map[string][]byte{"config.json": []byte(`{
+		"app": {"bundleName": "com.example.fa", "version": {"code": 3, "name": "3.0"}, "apiVersion": {"compatible": 7}},
+		"module": {"abilities": [{"label": "Legacy", "icon": "$media:icon"}], "reqPermissions": [{"name": "ohos.permission.CAMERA"}]}}`)}, false), 0644)
+	details, err := parseHarmonyDetails(path)
+	if err != nil || details.PackageName != "com.example.fa" || details.AppName != "Legacy" || details.Version != "3.0" ||
+		details.VersionCode != 3 || details.MinOSVersion != "7" || len(details.Permissions) != 1 {
+		t.Errorf("FA model: %+v, %v", details, err)
+	}
+}
+
+func TestUploadHarmony(t *testing.T) {
+	router := setupTestServer(t)
+
+	if rec := uploadFile(router, "entry-default-signed.hap", fixtureHAP(t), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("upload HAP: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := uploadFile(router, "harmony.app", fixtureHarmonyApp(t), "Demo", "beta"); rec.Code != http.StatusOK {
+		t.Fatalf("upload .app: status %d: %s", rec.Code, rec.Body.String())
+	}
+	builds := appBuilds(t, router, harmonyFixturePackage)
+	if len(builds) != 2 {
+		t.Fatalf("builds = %+v", builds)
+	}
+	for _, build := range builds {
+		wantExt := map[string]string{"stable": ".hap", "beta": ".app"}[build.Channel]
+		if build.Platform != platformHarmony || !strings.HasSuffix(build.FileName, wantExt) || build.MinOSVersion != "9" {
+			t.Errorf("build = %+v", build)
+		}
+	}
+
+	rec := serve(router, http.MethodGet, "/app/"+harmonyFixturePackage)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `<span class="platform-badge">HarmonyOS</span>`) ||
+		!strings.Contains(rec.Body.String(), "Hello Harmony") {
+		t.Errorf("detail page: status %d", rec.Code)
+	}
+	rec = serve(router, http.MethodGet, "/api/check-update?packageName="+harmonyFixturePackage+"&versionCode=1&platform=harmonyos")
+	if rec.Code != http.StatusOK {
+		t.Errorf("check-update: status %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+// Homepage layouts selectable with ?view=. The handler only precomputes what
+// the chosen layout shows.
+const (
+	viewList = "list" // cards with the recent builds and release notes, the default
+	viewGrid = "grid" // compact thumbnails with the latest version only
+)
+
+// parseHomeView returns the layout requested by a ?view= value, falling
+// back to viewList for empty or unknown values
+func parseHomeView(value string) string {
+	if value == viewGrid {
+		return viewGrid
+	}
+	return viewList
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/gin-gonic/gin"
+	"github.com/shogo82148/androidbinary"
+	"github.com/shogo82148/androidbinary/apk"
+)
+
+// Values of Config.IconFormat
+const (
+	iconFormatPNG  = "png"
+	iconFormatJPEG = "jpeg"
+	iconFormatWebP = "webp"
+	iconFormatAuto = "auto" // PNG for icons with transparency, JPEG otherwise
+)
+
+// iconExtensions maps every stored icon format to its file extension
+var iconExtensions = map[string]string{
+	iconFormatPNG:  ".png",
+	iconFormatJPEG: ".jpg",
+	iconFormatWebP: ".webp",
+}
+
+// iconDir is where app icons are stored, below the served static directory
+var iconDir = filepath.Join("static", "icons")
+
+// resolveIconFormat picks the concrete output format for img
+func resolveIconFormat(img image.Image) string {
+	if config.IconFormat != iconFormatAuto {
+		return config.IconFormat
+	}
+	if hasTransparency(img) {
+		return iconFormatPNG
+	}
+	return iconFormatJPEG
+}
+
+// hasTransparency reports whether any pixel of img is not fully opaque
+func hasTransparency(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// flattenOnWhite composites img onto a white background, since JPEG has no
+// alpha channel and transparent pixels would otherwise turn black
+func flattenOnWhite(img image.Image) image.Image {
+	bounds := img.Bounds()
+	out := image.NewRGBA(bounds)
+	draw.Draw(out, bounds, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(out, bounds, img, bounds.Min, draw.Over)
+	return out
+}
+
+// iconDensity is an Android screen density bucket
+type iconDensity struct {
+	Name  string
+	DPI   uint16
+	Scale string // srcset descriptor relative to mdpi
+}
+
+// iconDensities lists the densities extracted at upload, lowest first
+var iconDensities = []iconDensity{
+	{"mdpi", 160, "1x"},
+	{"hdpi", 240, "1.5x"},
+	{"xhdpi", 320, "2x"},
+	{"xxhdpi", 480, "3x"},
+}
+
+// saveIcon encodes the default icon of packageName in the configured format
+// and returns its slash-separated path relative to the working directory.
+// Icons stored earlier in another format are removed.
+func saveIcon(packageName string, img image.Image) (string, error) {
+	return saveIconFile(packageName, img)
+}
+
+// saveIconDensities stores the icon of packageName resolved for each density
+// in iconDensities and returns the stored paths by density name. Densities
+// the APK has no distinct icon for are left out, so the result is empty when
+// extraction fails and callers fall back to the default icon.
+func saveIconDensities(pkg *apk.Apk, packageName string) map[string]string {
+	icons := make(map[string]string)
+	var lastSize image.Point
+	for _, density := range iconDensities {
+		baseName := packageName + "-" + density.Name
+		img, err := pkg.Icon(&androidbinary.ResTableConfig{Density: density.DPI})
+		// The same resource for neighbouring densities adds nothing
+		if err != nil || img.Bounds().Size() == lastSize {
+			removeIconFiles(baseName)
+			continue
+		}
+		path, err := saveIconFile(baseName, img)
+		if err != nil {
+			slog.Warn("保存图标失败", "package", packageName, "density", density.Name, "error", err)
+			continue
+		}
+		lastSize = img.Bounds().Size()
+		icons[density.Name] = path
+	}
+	return icons
+}
+
+// iconSrcset builds an img srcset attribute from the density icons. The
+// paths are generated by saveIconFile, so the result is marked as trusted;
+// html/template would otherwise reject the "1.5x" descriptor.
+func iconSrcset(icons map[string]string) template.Srcset {
+	var parts []string
+	for _, density := range iconDensities {
+		if path, ok := icons[density.Name]; ok {
+			parts = append(parts, withBasePath("/"+path)+" "+density.Scale)
+		}
+	}
+	return template.Srcset(strings.Join(parts, ", "))
+}
+
+// pickIcon returns the stored icon for the requested density: the exact
+// bucket, else the nearest higher one, else the nearest lower one, else the
+// default icon.
+func pickIcon(app AppEntry, density string) string {
+	index := -1
+	for i, d := range iconDensities {
+		if d.Name == density {
+			index = i
+		}
+	}
+	if index >= 0 {
+		for i := index; i < len(iconDensities); i++ {
+			if path, ok := app.Icons[iconDensities[i].Name]; ok {
+				return path
+			}
+		}
+		for i := index - 1; i >= 0; i-- {
+			if path, ok := app.Icons[iconDensities[i].Name]; ok {
+				return path
+			}
+		}
+	}
+	return app.IconPath
+}
+
+// handleAppIcon redirects to the icon of an app for ?density=mdpi|hdpi|xhdpi|xxhdpi,
+// or to the default icon when the density is omitted or unknown.
+func handleAppIcon(c *gin.Context) {
+	packageName := c.Param("packageName")
+	var path string
+	found := false
+	mutex.Lock()
+	for _, project := range allProjects {
+		for _, app := range project.Apps {
+			if app.PackageName == packageName && !found {
+				path, found = pickIcon(app, c.Query("density")), true
+			}
+		}
+	}
+	mutex.Unlock()
+
+	if !found || path == "" {
+		respondError(c, http.StatusNotFound, "应用图标未找到")
+		return
+	}
+	c.Redirect(http.StatusFound, withBasePath("/"+path))
+}
+
+// saveIconFile encodes img as iconDir/baseName in the configured format
+func saveIconFile(baseName string, img image.Image) (string, error) {
+	if err := os.MkdirAll(iconDir, 0755); err != nil {
+		return "", fmt.Errorf("无法创建图标目录: %w", err)
+	}
+	format := resolveIconFormat(img)
+	path := filepath.Join(iconDir, baseName+iconExtensions[format])
+
+	// Encode to a private file and rename it into place, so concurrent
+	// uploads of the same package never interleave their writes
+	f, err := os.CreateTemp(iconDir, ".tmp-"+baseName+"-*")
+	if err != nil {
+		return "", fmt.Errorf("无法创建图标文件: %w", err)
+	}
+	switch format {
+	case iconFormatJPEG:
+		err = jpeg.Encode(f, flattenOnWhite(img), &jpeg.Options{Quality: config.IconQuality})
+	case iconFormatWebP:
+		err = nativewebp.Encode(f, img, nil)
+	default:
+		err = png.Encode(f, img)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("无法保存图标为 %s: %w", format, err)
+	}
+
+	for other, ext := range iconExtensions {
+		if other != format {
+			os.Remove(filepath.Join(iconDir, baseName+ext))
+		}
+	}
+	return filepath.ToSlash(path), nil
+}
+
+// removeIcons deletes every stored icon of packageName, in every density
+// and format
+func removeIcons(packageName string) error {
+	err := removeIconFiles(packageName)
+	for _, density := range iconDensities {
+		if densityErr := removeIconFiles(packageName + "-" + density.Name); err == nil {
+			err = densityErr
+		}
+	}
+	return err
+}
+
+// iconBaseNames returns the names, without extension, of every icon file
+// of packageName: the default icon and one per density
+func iconBaseNames(packageName string) []string {
+	baseNames := []string{packageName}
+	for _, density := range iconDensities {
+		baseNames = append(baseNames, packageName+"-"+density.Name)
+	}
+	return baseNames
+}
+
+// iconFiles lists the stored icon files removeIcons would delete
+func iconFiles(packageName string) []string {
+	files := []string{}
+	for _, baseName := range iconBaseNames(packageName) {
+		for _, ext := range iconExtensions {
+			path := filepath.Join(iconDir, baseName+ext)
+			if _, err := os.Stat(path); err == nil {
+				files = append(files, path)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// removeIconFiles deletes iconDir/baseName in every format
+func removeIconFiles(baseName string) error {
+	var firstErr error
+	for _, ext := range iconExtensions {
+		err := os.Remove(filepath.Join(iconDir, baseName+ext))
+		if err != nil && !os.IsNotExist(err) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
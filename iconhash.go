@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// iconHash computes a 64-bit difference hash (dHash) of an icon: the image is
+// reduced to a 9x8 grayscale grid and each bit records whether a cell is
+// brighter than its right-hand neighbour. Visually similar icons produce
+// hashes with a small Hamming distance regardless of size or encoding.
+func iconHash(img image.Image) string {
+	const w, h = 9, 8
+	bounds := img.Bounds()
+	var grid [h][w]float64
+	for y := 0; y < h; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/h
+		y1 := max(bounds.Min.Y+(y+1)*bounds.Dy()/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/w
+			x1 := max(bounds.Min.X+(x+1)*bounds.Dx()/w, x0+1)
+			grid[y][x] = averageLuma(img, x0, y0, x1, y1)
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if grid[y][x] > grid[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return fmt.Sprintf("%016x", hash)
+}
+
+// averageLuma returns the mean luminance of the rectangle [x0,x1)x[y0,y1).
+// Transparent pixels are treated as white so the background of an icon
+// does not dominate the hash.
+func averageLuma(img image.Image, x0, y0, x1, y1 int) float64 {
+	var sum float64
+	var n int
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			luma := 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			luma += float64(0xffff - a) // blend onto white
+			sum += luma
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// iconHashDistance returns the number of differing bits between two hashes,
+// or -1 when either cannot be parsed.
+func iconHashDistance(a, b string) int {
+	x, errA := strconv.ParseUint(a, 16, 64)
+	y, errB := strconv.ParseUint(b, 16, 64)
+	if errA != nil || errB != nil {
+		return -1
+	}
+	return bits.OnesCount64(x ^ y)
+}
+
+// storedIconHash returns the icon hash recorded for packageName, computing it
+// from the icon file on disk for apps uploaded before hashes were stored.
+func storedIconHash(packageName string) string {
+	var hash, iconPath string
+	mutex.Lock()
+	for _, project := range allProjects {
+		for _, app := range project.Apps {
+			if app.PackageName == packageName {
+				hash, iconPath = app.IconHash, app.IconPath
+			}
+		}
+	}
+	mutex.Unlock()
+
+	if hash != "" || iconPath == "" {
+		return hash
+	}
+	f, err := os.Open(filepath.FromSlash(iconPath))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return ""
+	}
+	return iconHash(img)
+}
+
+// detectIconChange compares a freshly extracted icon with the stored icon of
+// packageName and returns a warning when they differ beyond the configured
+// threshold.
+func detectIconChange(packageName, newHash string) string {
+	if config.IconChangeThreshold <= 0 || newHash == "" {
+		return ""
+	}
+	oldHash := storedIconHash(packageName)
+	if oldHash == "" {
+		return ""
+	}
+	distance := iconHashDistance(oldHash, newHash)
+	if distance < 0 || distance <= config.IconChangeThreshold {
+		return ""
+	}
+	return fmt.Sprintf("图标变化: 新图标与已存储图标差异较大（距离 %d/64），请确认是否上传了错误的应用", distance)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Uploads are written once into config.IncomingDir, which is not served,
+// hashed while they are written and parsed in place. Accepted packages are
+// renamed into the uploads directory; rejected ones are removed or moved to
+// config.QuarantineDir, so a package that failed its checks is never
+// downloadable.
+
+// createIncomingFile creates a uniquely named file in the incoming directory
+// whose name ends with the sanitized clientName.
+func createIncomingFile(clientName string) (*os.File, error) {
+	if err := os.MkdirAll(config.IncomingDir, 0755); err != nil {
+		return nil, fmt.Errorf("无法创建暂存目录: %w", err)
+	}
+	return os.CreateTemp(config.IncomingDir, "upload-*-"+sanitizeUploadName(clientName))
+}
+
+// writeIncoming copies r into f, closes f and returns the number of bytes
+// written and their SHA-256. It copies at most limit+1 bytes when limit > 0,
+// so callers can detect oversized input.
+func writeIncoming(f *os.File, r io.Reader, limit int64) (int64, string, error) {
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hasher), r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return size, hex.EncodeToString(hasher.Sum(nil)), err
+}
+
+// saveIncomingUpload stores an uploaded form file in the incoming directory
+// and returns its path and hash. The caller must publish or discard it.
+func saveIncomingUpload(c *gin.Context, file *multipart.FileHeader) (string, string, error) {
+	src, err := file.Open()
+	if err != nil {
+		return "", "", err
+	}
+	defer src.Close()
+
+	if spooled, ok := src.(*os.File); ok {
+		incomingPath, fileHash, err := adoptSpooledUpload(spooled, file.Filename)
+		if err == nil {
+			logf(c, "文件已移动到暂存目录: %s", incomingPath)
+			return incomingPath, fileHash, nil
+		}
+		logf(c, "无法移动上传临时文件，改为复制: %v", err)
+	}
+
+	dst, err := createIncomingFile(file.Filename)
+	if err != nil {
+		return "", "", err
+	}
+	incomingPath := dst.Name()
+	_, fileHash, err := writeIncoming(dst, src, 0)
+	if err != nil {
+		errorf(c, "保存上传文件到 %s 错误: %v", incomingPath, err)
+		os.Remove(incomingPath)
+		return "", "", err
+	}
+	logf(c, "文件已暂存到: %s", incomingPath)
+	return incomingPath, fileHash, nil
+}
+
+// adoptSpooledUpload moves the temporary file a large form file was spooled
+// to while parsing the request into the incoming directory, so a package is
+// written to disk once rather than copied a second time. It fails without
+// touching spooled when the two directories are on different filesystems.
+func adoptSpooledUpload(spooled *os.File, clientName string) (string, string, error) {
+	// Reserve a unique name, then replace the empty file with the upload
+	dst, err := createIncomingFile(clientName)
+	if err != nil {
+		return "", "", err
+	}
+	incomingPath := dst.Name()
+	dst.Close()
+	if err := os.Rename(spooled.Name(), incomingPath); err != nil {
+		os.Remove(incomingPath)
+		return "", "", err
+	}
+	fileHash, err := hashFile(incomingPath)
+	if err != nil {
+		os.Remove(incomingPath)
+		return "", "", err
+	}
+	return incomingPath, fileHash, nil
+}
+
+// discardUpload disposes of a rejected upload: it is moved to
+// config.QuarantineDir for inspection when one is configured and removed
+// otherwise. Errors are only logged since the request has already failed.
+func discardUpload(c *gin.Context, incomingPath string) {
+	if _, err := os.Stat(incomingPath); os.IsNotExist(err) {
+		return // already published or removed
+	}
+	if config.QuarantineDir != "" {
+		if err := os.MkdirAll(config.QuarantineDir, 0755); err == nil {
+			target := filepath.Join(config.QuarantineDir, filepath.Base(incomingPath))
+			if err := os.Rename(incomingPath, target); err == nil {
+				logf(c, "被拒绝的上传已移入隔离目录: %s", target)
+				return
+			}
+		}
+		warnf(c, "无法将 %s 移入隔离目录，直接删除", incomingPath)
+	}
+	if err := os.Remove(incomingPath); err != nil && !os.IsNotExist(err) {
+		warnf(c, "删除被拒绝的上传 %s 失败: %v", incomingPath, err)
+	}
+}
+
+// cleanIncoming removes uploads left in the incoming directory by a previous
+// run that stopped mid-request. It must only run before serving requests.
+func cleanIncoming() {
+	entries, err := os.ReadDir(config.IncomingDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(config.IncomingDir, entry.Name())
+		if err := os.Remove(path); err != nil {
+			slog.Warn("清理暂存文件失败", "path", path, "error", err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSaveIncomingUploadMovesSpooledFile(t *testing.T) {
+	setupTestServer(t)
+	spool := t.TempDir()
+	t.Setenv("TMPDIR", spool)
+	config.IncomingDir = filepath.Join(t.TempDir(), "incoming")
+
+	content := strings.Repeat("apk", 4096)
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "demo.apk")
+	part.Write([]byte(content))
+	form.Close()
+
+	for _, maxMemory := range []int64{1, 1 << 20} {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/upload", bytes.NewReader(body.Bytes()))
+		c.Request.Header.Set("Content-Type", form.FormDataContentType())
+		// A form file larger than maxMemory is spooled to a temporary file
+		if err := c.Request.ParseMultipartForm(maxMemory); err != nil {
+			t.Fatal(err)
+		}
+		file, err := c.FormFile("file")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		incomingPath, fileHash, err := saveIncomingUpload(c, file)
+		if err != nil {
+			t.Fatalf("maxMemory %d: %v", maxMemory, err)
+		}
+		if data, _ := os.ReadFile(incomingPath); string(data) != content {
+			t.Errorf("maxMemory %d: stored %d bytes, want the upload", maxMemory, len(data))
+		}
+		if want, _ := hashReader(strings.NewReader(content)); fileHash != want {
+			t.Errorf("maxMemory %d: hash = %s, want %s", maxMemory, fileHash, want)
+		}
+		if spooled, _ := os.ReadDir(spool); len(spooled) != 0 {
+			t.Errorf("maxMemory %d: %d spooled files left behind, want them moved", maxMemory, len(spooled))
+		}
+		c.Request.MultipartForm.RemoveAll()
+		os.Remove(incomingPath)
+	}
+}
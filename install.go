@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// devicePlatform tells the platform of the device behind a User-Agent, ""
+// for desktop browsers and anything unrecognised. HarmonyOS NEXT reports
+// OpenHarmony; earlier HarmonyOS releases run APKs and report Android.
+func devicePlatform(userAgent string) string {
+	switch {
+	case strings.Contains(userAgent, "OpenHarmony"):
+		return platformHarmony
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"), strings.Contains(userAgent, "iPod"):
+		return platformIOS
+	case strings.Contains(userAgent, "Android"):
+		return platformAndroid
+	default:
+		return ""
+	}
+}
+
+// handleInstallLanding serves GET /install/:packageName?channel=, one link
+// to share per app. Phones are redirected to the install link of the newest
+// build for their platform, which downloads the APK or for iOS opens the
+// itms-services link. Desktop browsers, and phones without a build for
+// them, get an install card whose QR code encodes this page, so scanning it
+// with a phone picks the right package.
+func handleInstallLanding(c *gin.Context) {
+	packageName, channel := c.Param("packageName"), c.Query("channel")
+	_, app, found := repo.FindApp(packageName)
+	if !found {
+		respondText(c, http.StatusNotFound, "应用未找到")
+		return
+	}
+	if !publicLinkAllowed(c, app) {
+		return
+	}
+	// The answer depends on the device and moves with every upload
+	c.Header("Vary", "User-Agent")
+	c.Header("Cache-Control", "no-cache")
+
+	hint := "使用手机扫描二维码，将自动下载适合该设备的安装包"
+	if platform := devicePlatform(c.Request.UserAgent()); platform != "" {
+		if build, ok := latestBuild(app.Builds, channel, platform); ok {
+			c.Redirect(http.StatusFound, withBasePath(installURL(packageName, build.FileName)))
+			return
+		}
+		hint = "暂无适用于此设备的安装包"
+	}
+
+	build, ok := latestBuild(app.Builds, channel, defaultPlatform(app))
+	if !ok {
+		respondText(c, http.StatusNotFound, "没有可用的构建版本")
+		return
+	}
+	baseURL := requestBaseURL(c)
+	landingURL := baseURL + "/install/" + url.PathEscape(packageName)
+	if channel != "" {
+		landingURL += "?channel=" + url.QueryEscape(channel)
+	}
+	var iconURL string
+	if icon := pickIcon(app, "xxhdpi"); icon != "" {
+		iconURL = baseURL + "/" + icon
+	}
+	renderHTML(c, http.StatusOK, "card.html", gin.H{
+		"App":        app,
+		"Build":      build,
+		"IconURL":    iconURL,
+		"InstallURL": baseURL + installURL(packageName, build.FileName),
+		"QRCodeURL":  baseURL + "/qr?url=" + url.QueryEscape(landingURL),
+		"CardURL":    landingURL,
+		"DetailURL":  baseURL + "/app/" + url.PathEscape(packageName),
+		"Hint":       hint,
+		"TZ":         viewerTimezone(c),
+	})
+}
+
+// handleLatestDownload serves GET /latest/:packageName/:channel with a
+// redirect to the file of the newest installable build in the channel, so
+// scripts and device farms can always fetch the current build. The
+// platform query value picks among builds of an app with several, like
+// GET /qr/latest.
+func handleLatestDownload(c *gin.Context) {
+	packageName, channel := c.Param("packageName"), c.Param("channel")
+	_, app, found := repo.FindApp(packageName)
+	if !found {
+		respondText(c, http.StatusNotFound, "应用未找到")
+		return
+	}
+	if !publicLinkAllowed(c, app) {
+		return
+	}
+	platform := c.Query("platform")
+	if platform == "" {
+		platform = defaultPlatform(app)
+	}
+	build, ok := latestBuild(app.Builds, channel, platformName(platform))
+	if !ok {
+		respondText(c, http.StatusNotFound, "该渠道没有可用的构建版本")
+		return
+	}
+	c.Header("Cache-Control", "no-cache")
+	c.Redirect(http.StatusFound, withBasePath(installableURL(build)))
+}
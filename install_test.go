@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDevicePlatform(t *testing.T) {
+	for userAgent, want := range map[string]string{
+		"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/126.0 Mobile Safari/537.36":  platformAndroid,
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148":      platformIOS,
+		"Mozilla/5.0 (iPad; CPU OS 17_5 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148":               platformIOS,
+		"Mozilla/5.0 (Phone; OpenHarmony 4.1) AppleWebKit/537.36 Chrome/114.0 ArkWeb/4.1.6.1 Mobile":     platformHarmony,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/126.0 Safari/537.36":        "",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_5) AppleWebKit/605.1.15 Version/17.5 Safari/605.1.15": "",
+	} {
+		if got := devicePlatform(userAgent); got != want {
+			t.Errorf("devicePlatform(%q) = %q, want %q", userAgent, got, want)
+		}
+	}
+}
+
+func TestInstallLanding(t *testing.T) {
+	router := setupTestServer(t)
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	fileName := someBuildFile(fixturePackage)
+
+	visit := func(target, userAgent string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("User-Agent", userAgent)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := visit("/install/"+fixturePackage, "Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != installURL(fixturePackage, fileName) {
+		t.Errorf("Android: status %d, Location %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// Desktops, and phones without a build for them, get the install card
+	for _, userAgent := range []string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/126.0",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) Mobile/15E148",
+	} {
+		rec := visit("/install/"+fixturePackage+"?channel=stable", userAgent)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "/qr?url=") ||
+			!strings.Contains(rec.Body.String(), "install%2F"+fixturePackage) {
+			t.Errorf("%s: status %d: %s", userAgent, rec.Code, rec.Body.String())
+		}
+	}
+
+	if rec := visit("/install/"+fixturePackage+"?channel=beta", "Mozilla/5.0 (Windows NT 10.0)"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown channel: status %d", rec.Code)
+	}
+	if rec := visit("/install/com.example.missing", "Mozilla/5.0 (Linux; Android 14)"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown app: status %d", rec.Code)
+	}
+}
+
+func TestLatestDownload(t *testing.T) {
+	router := setupTestServer(t)
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	build := appBuilds(t, router, fixturePackage)[0]
+
+	rec := serve(router, http.MethodGet, "/latest/"+fixturePackage+"/stable")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != build.DownloadURL {
+		t.Fatalf("latest: status %d, Location %q, want %q", rec.Code, rec.Header().Get("Location"), build.DownloadURL)
+	}
+	if rec := serve(router, http.MethodGet, rec.Header().Get("Location")); rec.Code != http.StatusOK {
+		t.Errorf("following the redirect: status %d", rec.Code)
+	}
+
+	for target, want := range map[string]int{
+		"/latest/" + fixturePackage + "/beta":                http.StatusNotFound,
+		"/latest/" + fixturePackage + "/stable?platform=ios": http.StatusNotFound,
+		"/latest/com.example.missing/stable":                 http.StatusNotFound,
+	} {
+		if rec := serve(router, http.MethodGet, target); rec.Code != want {
+			t.Errorf("%s: status %d, want %d", target, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Build platforms. Builds stored before iOS support have no platform and
+// are Android builds.
+const (
+	platformAndroid = "android"
+	platformIOS     = "ios"
+	platformHarmony = "harmonyos"
+)
+
+// ipaInfoPlist matches the Info.plist of the app bundle inside an IPA;
+// plists of nested bundles such as extensions sit deeper
+var ipaInfoPlist = regexp.MustCompile(`^Payload/[^/]+\.app/Info\.plist$`)
+
+// ipaMaxPlistSize bounds the Info.plist read into memory
+const ipaMaxPlistSize = 4 << 20
+
+// ipaMaxIconSize bounds the icon files considered as the app icon
+const ipaMaxIconSize = 4 << 20
+
+// platformName returns platform with the empty platform of APKs spelled out
+func platformName(platform string) string {
+	if platform == "" {
+		return platformAndroid
+	}
+	return platform
+}
+
+// buildPlatform returns the platform of a stored build
+func buildPlatform(build BuildInfo) string {
+	return platformName(build.Platform)
+}
+
+// appPlatform returns the platform of an app's builds, "multi" when it has
+// builds of more than one
+func appPlatform(app AppEntry) string {
+	platform := platformAndroid
+	for k, build := range app.Builds {
+		if k == 0 {
+			platform = buildPlatform(build)
+		} else if buildPlatform(build) != platform {
+			return "multi"
+		}
+	}
+	return platform
+}
+
+// defaultPlatform returns the platform of an app's builds, android for an
+// app with builds of more than one
+func defaultPlatform(app AppEntry) string {
+	if platform := appPlatform(app); platform != "multi" {
+		return platform
+	}
+	return platformAndroid
+}
+
+// uploadPlatform tells the platform of an upload from its file name
+func uploadPlatform(fileName string) string {
+	switch strings.ToLower(path.Ext(strings.ReplaceAll(fileName, `\`, "/"))) {
+	case ".ipa":
+		return platformIOS
+	case ".hap", ".app":
+		return platformHarmony
+	}
+	return platformAndroid
+}
+
+// platformExt returns the extension of stored build files of platform
+func platformExt(platform string) string {
+	switch platform {
+	case platformIOS:
+		return ".ipa"
+	case platformHarmony:
+		return ".hap"
+	}
+	return ".apk"
+}
+
+// parseBuildDetails returns the details of the package at path, an APK,
+// App Bundle, IPA or HarmonyOS package depending on platform and content,
+// consulting the parse cache first
+func parseBuildDetails(path, fileHash, platform string) (ApkDetails, error) {
+	bundle := platform == platformAndroid && isAppBundle(path)
+	if platform == platformAndroid && !bundle {
+		return parseApkDetails(path, fileHash)
+	}
+	if details, ok := parseCache.Get(fileHash); ok {
+		return details, nil
+	}
+	parse := parseIpaDetails
+	switch {
+	case bundle:
+		parse = parseBundleDetails
+	case platform == platformHarmony:
+		parse = parseHarmonyDetails
+	}
+	details, err := parse(path)
+	if err != nil {
+		return ApkDetails{}, err
+	}
+	parseCache.Add(fileHash, details)
+	return details, nil
+}
+
+// openIpa opens the IPA at path and decodes the Info.plist of its app
+// bundle, returning the archive, the bundle directory and the plist
+func openIpa(ipaPath string) (*zip.ReadCloser, string, map[string]any, error) {
+	archive, err := zip.OpenReader(ipaPath)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("解析IPA失败: %w", err)
+	}
+	for _, file := range archive.File {
+		if !ipaInfoPlist.MatchString(file.Name) {
+			continue
+		}
+		info, err := readIpaPlist(file)
+		if err != nil {
+			archive.Close()
+			return nil, "", nil, fmt.Errorf("解析IPA的 Info.plist 失败: %w", err)
+		}
+		return archive, path.Dir(file.Name), info, nil
+	}
+	archive.Close()
+	return nil, "", nil, errors.New("解析IPA失败: 未找到 Payload/*.app/Info.plist")
+}
+
+func readIpaPlist(file *zip.File) (map[string]any, error) {
+	if file.UncompressedSize64 > ipaMaxPlistSize {
+		return nil, errors.New("文件过大")
+	}
+	r, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, ipaMaxPlistSize))
+	if err != nil {
+		return nil, err
+	}
+	value, err := parsePlist(data)
+	if err != nil {
+		return nil, err
+	}
+	info, ok := value.(map[string]any)
+	if !ok {
+		return nil, errors.New("顶层不是字典")
+	}
+	return info, nil
+}
+
+// plistString returns the first non-empty string value among keys
+func plistString(info map[string]any, keys ...string) string {
+	for _, key := range keys {
+		if s, ok := info[key].(string); ok && strings.TrimSpace(s) != "" {
+			return strings.TrimSpace(s)
+		}
+	}
+	return ""
+}
+
+// parseIpaDetails reads the bundle ID, versions and display name from the
+// Info.plist of an IPA. The bundle ID plays the role of the package name,
+// so an iOS build joins the Android app with the same identifier.
+// CFBundleVersion becomes the versionCode when it is a plain number, or
+// from its first component, e.g. 42 for "42.1".
+func parseIpaDetails(ipaPath string) (ApkDetails, error) {
+	archive, _, info, err := openIpa(ipaPath)
+	if err != nil {
+		return ApkDetails{}, err
+	}
+	archive.Close()
+
+	bundleID := plistString(info, "CFBundleIdentifier")
+	if bundleID == "" {
+		return ApkDetails{}, errors.New("解析IPA的 CFBundleIdentifier 失败或为空")
+	}
+	appName := plistString(info, "CFBundleDisplayName", "CFBundleName", "CFBundleExecutable")
+	if appName == "" {
+		return ApkDetails{}, errors.New("解析IPA应用名失败或应用名为空")
+	}
+	version := plistString(info, "CFBundleShortVersionString", "CFBundleVersion")
+	if version == "" {
+		return ApkDetails{}, errors.New("解析IPA的 CFBundleShortVersionString 失败或为空")
+	}
+	var versionCode int32
+	build, _, _ := strings.Cut(plistString(info, "CFBundleVersion"), ".")
+	if n, err := strconv.ParseInt(build, 10, 32); err == nil && n > 0 {
+		versionCode = int32(n)
+	}
+	return ApkDetails{
+		Platform:     platformIOS,
+		AppName:      appName,
+		PackageName:  bundleID,
+		Version:      version,
+		VersionCode:  versionCode,
+		MinOSVersion: plistString(info, "MinimumOSVersion"),
+	}, nil
+}
+
+// ipaIconNames lists the icon file name prefixes declared by Info.plist
+func ipaIconNames(info map[string]any) []string {
+	var names []string
+	collect := func(icons any) {
+		dict, _ := icons.(map[string]any)
+		primary, _ := dict["CFBundlePrimaryIcon"].(map[string]any)
+		files, _ := primary["CFBundleIconFiles"].([]any)
+		for _, file := range files {
+			if name, ok := file.(string); ok && name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	collect(info["CFBundleIcons"])
+	collect(info["CFBundleIcons~ipad"])
+	if files, ok := info["CFBundleIconFiles"].([]any); ok {
+		for _, file := range files {
+			if name, ok := file.(string); ok && name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	if name := plistString(info, "CFBundleIconFile"); name != "" {
+		names = append(names, name)
+	}
+	return names
+}
+
+// ipaIcon returns the largest app icon of an IPA that decodes. Xcode
+// usually stores icons as Apple's "CgBI" PNG variant, which is not a valid
+// PNG; such builds simply have no icon here.
+func ipaIcon(ipaPath string) (image.Image, error) {
+	archive, bundleDir, info, err := openIpa(ipaPath)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	names := ipaIconNames(info)
+	var best image.Image
+	for _, file := range archive.File {
+		dir, base := path.Split(file.Name)
+		if strings.TrimSuffix(dir, "/") != bundleDir || !strings.HasSuffix(strings.ToLower(base), ".png") ||
+			file.UncompressedSize64 > ipaMaxIconSize {
+			continue
+		}
+		declared := false
+		for _, name := range names {
+			if strings.HasPrefix(base, strings.TrimSuffix(name, ".png")) {
+				declared = true
+				break
+			}
+		}
+		if !declared {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			continue
+		}
+		img, err := png.Decode(io.LimitReader(r, ipaMaxIconSize))
+		r.Close()
+		if err != nil {
+			continue
+		}
+		if best == nil || img.Bounds().Dx() > best.Bounds().Dx() {
+			best = img
+		}
+	}
+	if best == nil {
+		return nil, errors.New("IPA 中没有可解码的图标")
+	}
+	return best, nil
+}
+
+// iosManifestURL returns the absolute URL of the OTA manifest of a build
+func iosManifestURL(baseURL, packageName, fileName string) string {
+	return baseURL + "/api/apps/" + url.PathEscape(packageName) + "/manifest.plist?fileName=" + url.QueryEscape(fileName)
+}
+
+// itmsServicesURL returns the link that makes an iOS device install a build
+// over the air. iOS only accepts manifests and packages served over HTTPS
+// with a trusted certificate.
+func itmsServicesURL(baseURL, packageName, fileName string) string {
+	return "itms-services://?action=download-manifest&url=" + url.QueryEscape(iosManifestURL(baseURL, packageName, fileName))
+}
+
+// handleIosManifest serves GET /api/apps/:packageName/manifest.plist?fileName=,
+// the manifest an itms-services link points iOS devices to: where to
+// download the IPA and which bundle ID and version to expect.
+func handleIosManifest(c *gin.Context) {
+	packageName := c.Param("packageName")
+	fileName := c.Query("fileName")
+
+	_, app, found := repo.FindApp(packageName)
+	if !found {
+		respondError(c, http.StatusNotFound, "应用未找到")
+		return
+	}
+	var build BuildInfo
+	buildFound := false
+	for _, candidate := range app.Builds {
+		if candidate.FileName == fileName && buildPlatform(candidate) == platformIOS {
+			build, buildFound = candidate, true
+			break
+		}
+	}
+	if !buildFound {
+		respondError(c, http.StatusNotFound, "iOS 构建版本未找到")
+		return
+	}
+
+	baseURL := requestBaseURL(c)
+	var assets []string
+	assets = append(assets, plistAsset("software-package", baseURL+build.DownloadURL))
+	if app.IconPath != "" {
+		// Shown while the app installs
+		assets = append(assets, plistAsset("display-image", baseURL+"/"+app.IconPath))
+	}
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString(`<plist version="1.0"><dict><key>items</key><array><dict>` + "\n")
+	b.WriteString(`<key>assets</key><array>` + strings.Join(assets, "") + `</array>` + "\n")
+	b.WriteString(`<key>metadata</key><dict>`)
+	b.WriteString(`<key>bundle-identifier</key>` + plistText(packageName))
+	b.WriteString(`<key>bundle-version</key>` + plistText(build.Version))
+	b.WriteString(`<key>kind</key><string>software</string>`)
+	b.WriteString(`<key>title</key>` + plistText(app.AppName))
+	b.WriteString(`</dict>` + "\n")
+	b.WriteString(`</dict></array></dict></plist>` + "\n")
+	c.Data(http.StatusOK, "application/xml; charset=utf-8", b.Bytes())
+}
+
+// plistText returns s as an escaped <string> element
+func plistText(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return "<string>" + b.String() + "</string>"
+}
+
+func plistAsset(kind, assetURL string) string {
+	return `<dict><key>kind</key>` + plistText(kind) + `<key>url</key>` + plistText(assetURL) + `</dict>`
+}
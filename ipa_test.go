@@ -0,0 +1,174 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	_ "embed"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// infoBinaryPlist is the Info.plist of fixtureIPA in binary form, as Xcode
+// writes it
+//
+//go:embed testdata/Info.bplist
+var infoBinaryPlist []byte
+
+// infoXMLPlist is the same Info.plist in XML form
+const infoXMLPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CFBundleDisplayName</key>
+	<string>HelloWorld iOS</string>
+	<key>CFBundleIcons</key>
+	<dict>
+		<key>CFBundlePrimaryIcon</key>
+		<dict>
+			<key>CFBundleIconFiles</key>
+			<array>
+				<string>AppIcon60x60</string>
+			</array>
+		</dict>
+	</dict>
+	<key>CFBundleIdentifier</key>
+	<string>com.example.helloworld</string>
+	<key>CFBundleShortVersionString</key>
+	<string>1.2.0</string>
+	<key>CFBundleVersion</key>
+	<string>42</string>
+	<key>LSRequiresIPhoneOS</key>
+	<true/>
+	<key>MinimumOSVersion</key>
+	<string>13.0</string>
+	<key>UIDeviceFamily</key>
+	<array>
+		<integer>1</integer>
+		<integer>2</integer>
+	</array>
+</dict>
+</plist>
+`
+
+// fixtureIPA builds an IPA of the fixture app around infoPlist, with a
+// plain PNG app icon
+func fixtureIPA(t *testing.T, infoPlist []byte) []byte {
+	t.Helper()
+	icon := image.NewRGBA(image.Rect(0, 0, 120, 120))
+	for i := range icon.Pix {
+		icon.Pix[i] = 0xff
+	}
+	icon.Set(10, 10, color.RGBA{0xff, 0, 0, 0xff})
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, write := range map[string]func(*bytes.Buffer) error{
+		"Payload/HelloWorld.app/Info.plist":            func(b *bytes.Buffer) error { _, err := b.Write(infoPlist); return err },
+		"Payload/HelloWorld.app/AppIcon60x60@2x.png":   func(b *bytes.Buffer) error { return png.Encode(b, icon) },
+		"Payload/HelloWorld.app/PlugIns/X.appex/a.txt": func(b *bytes.Buffer) error { return nil },
+	} {
+		var content bytes.Buffer
+		if err := write(&content); err != nil {
+			t.Fatal(err)
+		}
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(content.Bytes())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParsePlistFormats(t *testing.T) {
+	fromXML, err := parsePlist([]byte(infoXMLPlist))
+	if err != nil {
+		t.Fatalf("XML plist: %v", err)
+	}
+	fromBinary, err := parsePlist(infoBinaryPlist)
+	if err != nil {
+		t.Fatalf("binary plist: %v", err)
+	}
+	if !reflect.DeepEqual(fromXML, fromBinary) {
+		t.Errorf("XML and binary plists differ:\n%#v\n%#v", fromXML, fromBinary)
+	}
+
+	// Truncated or garbled input fails instead of panicking
+	for n := 0; n < len(infoBinaryPlist); n += 7 {
+		parsePlist(infoBinaryPlist[:n])
+	}
+	garbled := append([]byte(nil), infoBinaryPlist...)
+	for i := len(binaryPlistMagic); i < len(garbled); i += 5 {
+		garbled[i] ^= 0xA5
+	}
+	parsePlist(garbled)
+}
+
+func TestIPAUploadAndOTAManifest(t *testing.T) {
+	router := setupTestServer(t)
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("APK upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	// The Android versionCode 1 is no reason to call iOS build 42 anything
+	config.DowngradePolicy = downgradeStrict
+	rec := uploadFile(router, "HelloWorld.ipa", fixtureIPA(t, infoBinaryPlist), "Demo", "stable")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("IPA upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Both builds belong to the app with the shared identifier
+	builds := appBuilds(t, router, fixturePackage)
+	if len(builds) != 2 {
+		t.Fatalf("builds = %+v, want the APK and the IPA", builds)
+	}
+	ipa := builds[0]
+	if ipa.Platform != platformIOS || ipa.Version != "1.2.0" || ipa.VersionCode != 42 || ipa.MinOSVersion != "13.0" ||
+		!strings.HasSuffix(ipa.FileName, ".ipa") {
+		t.Fatalf("IPA build = %+v", ipa)
+	}
+	if builds[1].Platform != "" {
+		t.Errorf("APK build platform = %q, want empty", builds[1].Platform)
+	}
+
+	rec = serve(router, http.MethodGet, "/api/apps/"+fixturePackage+"/install?fileName="+ipa.FileName)
+	if location := rec.Header().Get("Location"); rec.Code != http.StatusFound || !strings.HasPrefix(location, "itms-services://?action=download-manifest&url=") {
+		t.Fatalf("install: status %d, Location %q", rec.Code, location)
+	}
+
+	rec = serve(router, http.MethodGet, "/api/apps/"+fixturePackage+"/manifest.plist?fileName="+ipa.FileName)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("manifest: status %d: %s", rec.Code, rec.Body.String())
+	}
+	manifest, err := parsePlist(rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("manifest is not a plist: %v\n%s", err, rec.Body.String())
+	}
+	item := manifest.(map[string]any)["items"].([]any)[0].(map[string]any)
+	metadata := item["metadata"].(map[string]any)
+	if metadata["bundle-identifier"] != fixturePackage || metadata["bundle-version"] != "1.2.0" || metadata["kind"] != "software" {
+		t.Errorf("manifest metadata = %v", metadata)
+	}
+	asset := item["assets"].([]any)[0].(map[string]any)
+	if asset["kind"] != "software-package" || !strings.HasSuffix(asset["url"].(string), ipa.DownloadURL) {
+		t.Errorf("manifest package asset = %v", asset)
+	}
+
+	// APK builds have no OTA manifest
+	if rec := serve(router, http.MethodGet, "/api/apps/"+fixturePackage+"/manifest.plist?fileName="+builds[1].FileName); rec.Code != http.StatusNotFound {
+		t.Errorf("manifest of an APK: status %d, want 404", rec.Code)
+	}
+
+	// An IPA without a bundle ID is rejected
+	broken := strings.Replace(infoXMLPlist, "CFBundleIdentifier", "CFBundleIdentifierX", 1)
+	if rec := uploadFile(router, "broken.ipa", fixtureIPA(t, []byte(broken)), "Demo", "stable"); rec.Code != http.StatusBadRequest {
+		t.Errorf("IPA without bundle ID: status %d, want 400: %s", rec.Code, rec.Body.String())
+	}
+}
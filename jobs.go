@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// States of an upload job
+const (
+	jobQueued      = "queued"
+	jobDownloading = "downloading" // fetching the package, see handleUploadURL
+	jobProcessing  = "processing"
+	jobDone        = "done"
+	jobFailed      = "failed"
+)
+
+// States of the build of an upload job, see BuildInfo.State
+const (
+	buildProcessing = "processing"
+	buildFailed     = "failed"
+)
+
+// jobIDKey is the context key a job's context carries its ID under
+const jobIDKey = "jobID"
+
+// jobRetention is how long a finished job can still be queried
+const jobRetention = time.Hour
+
+// UploadJob is an upload whose parsing, icon extraction and publishing run
+// in the background. HTTPStatus and Response are what the upload request
+// would have answered had it waited; Error is the message of a failure.
+type UploadJob struct {
+	ID          string          `json:"id"`
+	Status      string          `json:"status"`
+	FileName    string          `json:"fileName"`
+	ProjectName string          `json:"projectName"`
+	Channel     string          `json:"channel"`
+	CreatedAt   string          `json:"createdAt"`
+	FinishedAt  string          `json:"finishedAt,omitempty"`
+	HTTPStatus  int             `json:"httpStatus,omitempty"`
+	Response    json.RawMessage `json:"response,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	// Progress counts the bytes of a package the job downloads
+	Progress *JobProgress `json:"progress,omitempty"`
+	// Build is the build the job publishes: what the request told in state
+	// buildProcessing until then, the published build once done, and in
+	// state buildFailed when the upload was rejected. PackageName is only
+	// known once the package was parsed.
+	Build       *BuildInfo `json:"build"`
+	PackageName string     `json:"packageName,omitempty"`
+
+	finished time.Time
+}
+
+// JobProgress is how much of its package a job has downloaded
+type JobProgress struct {
+	ReceivedBytes int64 `json:"receivedBytes"`
+	TotalBytes    int64 `json:"totalBytes,omitempty"` // 0 while the size is unknown
+}
+
+// jobTask is a queued job with the detached copy of the request context
+// it runs with
+type jobTask struct {
+	job     *UploadJob
+	ctx     *gin.Context
+	process func(c *gin.Context) (uploadResult, error)
+}
+
+// jobQueue runs upload jobs on config.UploadWorkers workers, started with
+// the first job. At most config.UploadQueueSize jobs wait for a worker.
+type jobQueue struct {
+	mu    sync.Mutex
+	jobs  map[string]*UploadJob
+	start sync.Once
+	tasks chan jobTask
+	// pending counts queued and running jobs, which shutdown waits for
+	pending sync.WaitGroup
+}
+
+var uploadJobs = &jobQueue{jobs: map[string]*UploadJob{}}
+
+// asyncUpload reports whether the upload of c runs as a job: the async
+// query or form value when given, else config.AsyncUploads
+func asyncUpload(c *gin.Context) bool {
+	value := c.Query("async")
+	if value == "" {
+		value = c.PostForm("async")
+	}
+	if async, err := strconv.ParseBool(value); err == nil {
+		return async
+	}
+	return config.AsyncUploads
+}
+
+// pendingBuild is the build of an upload job until it is published: what
+// req tells about it, in state buildProcessing
+func pendingBuild(req uploadRequest, fileSize int64, fileHash string) BuildInfo {
+	return BuildInfo{
+		Platform:     req.Platform,
+		Channel:      req.Channel,
+		ReleaseNotes: req.ReleaseNotes,
+		FileSize:     fileSize,
+		FileHash:     fileHash,
+		UploadTime:   timestamp(time.Now()),
+		Extra:        req.Extra,
+		State:        buildProcessing,
+	}
+}
+
+// enqueue queues process as a job for the upload of fileName described by
+// req, publishing build, and answers c with 202 and the job. process runs
+// with a read-only copy of c that outlives the request, see publishUpload,
+// and its result or error becomes the job's. When no job can be queued it
+// answers with an error and returns false; the caller then discards the
+// upload.
+func (q *jobQueue) enqueue(c *gin.Context, fileName string, req uploadRequest, build BuildInfo, process func(c *gin.Context) (uploadResult, error)) bool {
+	q.start.Do(func() {
+		q.tasks = make(chan jobTask, max(config.UploadQueueSize, 0))
+		for range max(config.UploadWorkers, 1) {
+			go q.work()
+		}
+	})
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		errorf(c, "生成任务 ID 失败: %v", err)
+		respondError(c, http.StatusInternalServerError, "无法创建上传任务")
+		return false
+	}
+	job := &UploadJob{
+		ID:          hex.EncodeToString(id),
+		Status:      jobQueued,
+		FileName:    fileName,
+		ProjectName: req.ProjectName,
+		Channel:     req.Channel,
+		CreatedAt:   build.UploadTime,
+		Build:       &build,
+	}
+
+	// The request context is recycled once the handler returns, and its
+	// request cancelled, so the job gets a copy whose request is not
+	ctx := c.Copy()
+	ctx.Request = c.Request.WithContext(context.WithoutCancel(c.Request.Context()))
+	ctx.Set(jobIDKey, job.ID)
+
+	q.mu.Lock()
+	q.prune(time.Now())
+	q.jobs[job.ID] = job
+	q.mu.Unlock()
+	q.pending.Add(1)
+	select {
+	case q.tasks <- jobTask{job: job, ctx: ctx, process: process}:
+	default:
+		q.pending.Done()
+		q.mu.Lock()
+		delete(q.jobs, job.ID)
+		q.mu.Unlock()
+		warnf(c, "上传处理队列已满 (%d)", config.UploadQueueSize)
+		c.Header("Retry-After", "30")
+		respondError(c, http.StatusServiceUnavailable, "服务器正忙于处理其他上传，请稍后重试")
+		return false
+	}
+	logf(c, "上传已排队处理，任务 ID: %s", job.ID)
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":   "上传已接收，正在后台处理",
+		"job":       q.get(job.ID),
+		"statusURL": withBasePath("/api/jobs/" + job.ID),
+	})
+	return true
+}
+
+func (q *jobQueue) work() {
+	for task := range q.tasks {
+		q.run(task)
+	}
+}
+
+// run processes one job and records its result
+func (q *jobQueue) run(task jobTask) {
+	defer q.pending.Done()
+	q.setStatus(task.job.ID, jobProcessing)
+	result, err := task.process(task.ctx)
+
+	var response gin.H
+	if err != nil {
+		response = asUploadError(err).body(task.ctx)
+	} else {
+		response = gin.H{"message": "Upload successful", "warnings": result.Warnings}
+	}
+	body, _ := json.Marshal(response)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job := task.job
+	job.finished = time.Now()
+	job.FinishedAt = timestamp(job.finished)
+	job.Response = body
+	if err != nil {
+		job.Status = jobFailed
+		job.HTTPStatus = asUploadError(err).status
+		job.Error = err.Error()
+		// Copies handed out by get share the pending build, so it is
+		// replaced rather than changed
+		failed := *job.Build
+		failed.State = buildFailed
+		job.Build = &failed
+		return
+	}
+	job.Status = jobDone
+	job.HTTPStatus = http.StatusOK
+	job.PackageName = result.PackageName
+	job.Build = &result.Build
+}
+
+// wait blocks until every queued and running job finished, or ctx is done
+func (q *jobQueue) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		q.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *jobQueue) setStatus(id, status string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if job, ok := q.jobs[id]; ok {
+		job.Status = status
+	}
+}
+
+// setProgress records that the job with id has received bytes of total
+func (q *jobQueue) setProgress(id string, received, total int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if job, ok := q.jobs[id]; ok {
+		job.Progress = &JobProgress{ReceivedBytes: received, TotalBytes: total}
+	}
+}
+
+// get returns a copy of the job with id
+func (q *jobQueue) get(id string) *UploadJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil
+	}
+	copied := *job
+	return &copied
+}
+
+// prune forgets jobs finished more than jobRetention ago. The caller must
+// hold q.mu.
+func (q *jobQueue) prune(now time.Time) {
+	for id, job := range q.jobs {
+		if !job.finished.IsZero() && now.Sub(job.finished) > jobRetention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// handleUploadJob serves GET /api/jobs/:id with the state of an upload job.
+// Only requests that may upload to the job's project see it; to the others
+// it does not exist.
+func handleUploadJob(c *gin.Context) {
+	job := uploadJobs.get(c.Param("id"))
+	if job == nil || uploadProjectError(c, job.ProjectName) != "" {
+		respondError(c, http.StatusNotFound, "任务未找到或已过期")
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// waitForJob polls the job behind an accepted upload until it finished
+func waitForJob(t *testing.T, router *gin.Engine, statusURL string) UploadJob {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var job UploadJob
+		decodeJSON(t, serve(router, http.MethodGet, statusURL), &job)
+		if job.Status == jobDone || job.Status == jobFailed {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job still %s", job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAsyncUpload(t *testing.T) {
+	router := setupTestServer(t)
+	config.AsyncUploads = true
+
+	var accepted struct {
+		Job       UploadJob `json:"job"`
+		StatusURL string    `json:"statusURL"`
+	}
+	rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	decodeJSON(t, rec, &accepted)
+	if accepted.Job.ID == "" || accepted.StatusURL != "/api/jobs/"+accepted.Job.ID || accepted.Job.ProjectName != "Demo" {
+		t.Fatalf("accepted = %+v", accepted)
+	}
+	if build := accepted.Job.Build; build == nil || build.State != buildProcessing || build.Channel != "stable" || build.FileHash == "" {
+		t.Errorf("pending build = %+v", build)
+	}
+	job := waitForJob(t, router, accepted.StatusURL)
+	if job.Status != jobDone || job.HTTPStatus != http.StatusOK || !strings.Contains(string(job.Response), "Upload successful") {
+		t.Errorf("job = %+v", job)
+	}
+	builds := appBuilds(t, router, fixturePackage)
+	if len(builds) != 1 {
+		t.Fatalf("builds after the job = %+v", builds)
+	}
+	if job.PackageName != fixturePackage || job.Build == nil || job.Build.State != "" || job.Build.FileName != builds[0].FileName {
+		t.Errorf("published build = %+v of %s, want %s", job.Build, job.PackageName, builds[0].FileName)
+	}
+
+	// A failing upload reports the answer the synchronous upload would give
+	rec = uploadFile(router, "broken.apk", []byte("PK\x03\x04 not really a zip"), "Demo", "stable")
+	decodeJSON(t, rec, &accepted)
+	job = waitForJob(t, router, accepted.StatusURL)
+	if job.Status != jobFailed || job.HTTPStatus < 400 || job.Error == "" || job.Build == nil || job.Build.State != buildFailed {
+		t.Errorf("failed job = %+v", job)
+	}
+	var response struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(job.Response, &response); err != nil || response.Error != job.Error {
+		t.Errorf("failed job response = %s", job.Response)
+	}
+
+	if rec := serve(router, http.MethodGet, "/api/jobs/unknown"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown job: status %d", rec.Code)
+	}
+}
+
+// TestUploadModes pins synchronous uploads as the default and async as an opt-in
+func TestUploadModes(t *testing.T) {
+	router := setupTestServer(t)
+	if config.AsyncUploads {
+		t.Fatal("uploads are async by default")
+	}
+	// Each upload goes to its own channel so none is a duplicate of another
+	upload := func(channel, query string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		w.WriteField("projectName", "Demo")
+		w.WriteField("channel", channel)
+		part, _ := w.CreateFormFile("file", "helloworld.apk")
+		part.Write(fixtureAPK(t))
+		w.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/upload"+query, &body)
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	accepted := func(name string, rec *httptest.ResponseRecorder) {
+		t.Helper()
+		if rec.Code != http.StatusAccepted {
+			t.Errorf("%s: status %d: %s", name, rec.Code, rec.Body.String())
+			return
+		}
+		var body struct {
+			StatusURL string `json:"statusURL"`
+		}
+		decodeJSON(t, rec, &body)
+		if job := waitForJob(t, router, body.StatusURL); job.Status != jobDone {
+			t.Errorf("%s: job = %+v", name, job)
+		}
+	}
+
+	if rec := upload("stable", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Upload successful") {
+		t.Errorf("default upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	accepted("async=true", upload("beta", "?async=true"))
+
+	config.AsyncUploads = true
+	accepted("APPDIST_ASYNC_UPLOADS upload", upload("alpha", ""))
+	if rec := upload("nightly", "?async=false"); rec.Code != http.StatusOK {
+		t.Errorf("async=false: status %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUploadJobAccess(t *testing.T) {
+	router := setupTestServer(t)
+	config.AsyncUploads = true
+	config.UploadTokensRequired = true
+	if err := tokens.load(filepath.Join(t.TempDir(), "tokens.json")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tokens.load("") })
+	_, other, err := tokens.create("other team", "Other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, demo, err := tokens.create("demo team", "Demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := uploadWithToken(t, router, demo)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var accepted struct {
+		StatusURL string `json:"statusURL"`
+	}
+	decodeJSON(t, rec, &accepted)
+	getJob := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, accepted.StatusURL, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := getJob(other); rec.Code != http.StatusNotFound {
+		t.Errorf("job of another project: status %d, want 404", rec.Code)
+	}
+	if rec := getJob(demo); rec.Code != http.StatusOK {
+		t.Errorf("job of the token's project: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := uploadJobs.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
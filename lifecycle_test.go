@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// decodeJSON unmarshals a JSON response body into v
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+}
+
+// appBuilds lists the builds of packageName through the API
+func appBuilds(t *testing.T, router *gin.Engine, packageName string) []BuildInfo {
+	t.Helper()
+	rec := serve(router, http.MethodGet, "/api/apps/"+packageName+"/builds")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("listing builds: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Builds []BuildInfo `json:"builds"`
+	}
+	decodeJSON(t, rec, &body)
+	return body.Builds
+}
+
+func TestUploadListDetailDeleteLifecycle(t *testing.T) {
+	router := setupTestServer(t)
+	apk := fixtureAPK(t)
+
+	if rec := uploadFixture(router, apk, "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The homepage lists the project and the app
+	rec := serve(router, http.MethodGet, "/")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("homepage: status %d", rec.Code)
+	}
+	for _, want := range []string{"Demo", "HelloWorld", fixturePackage} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("homepage does not mention %q", want)
+		}
+	}
+
+	builds := appBuilds(t, router, fixturePackage)
+	if len(builds) != 1 || builds[0].Channel != "stable" || builds[0].FileHash == "" {
+		t.Fatalf("builds = %+v, want one hashed stable build", builds)
+	}
+	build := builds[0]
+
+	// The detail page offers the build, and the download serves the upload
+	rec = serve(router, http.MethodGet, "/app/"+fixturePackage)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), build.FileName) {
+		t.Fatalf("detail page: status %d, lists %s: %v", rec.Code, build.FileName, strings.Contains(rec.Body.String(), build.FileName))
+	}
+	rec = serve(router, http.MethodGet, build.DownloadURL)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), apk) {
+		t.Fatalf("download: status %d, %d bytes", rec.Code, rec.Body.Len())
+	}
+	if got := rec.Header().Get("X-Checksum-SHA256"); got != build.FileHash {
+		t.Errorf("download checksum = %q, want %q", got, build.FileHash)
+	}
+
+	rec = serveAdmin(router, http.MethodDelete, "/api/builds/"+fixturePackage+"/"+build.FileName)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var deleted struct {
+		AppRemoved bool `json:"appRemoved"`
+	}
+	decodeJSON(t, rec, &deleted)
+	if !deleted.AppRemoved {
+		t.Error("deleting the only build kept the app")
+	}
+	if _, err := os.Stat(filepath.Join("uploads", build.FileName)); !os.IsNotExist(err) {
+		t.Errorf("deleted build file still exists: %v", err)
+	}
+	if rec := serve(router, http.MethodGet, "/app/"+fixturePackage); rec.Code != http.StatusNotFound {
+		t.Errorf("detail page of a deleted app: status %d, want 404", rec.Code)
+	}
+
+	// The change is persisted
+	if err := loadMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	if _, app, ok := repo.FindApp(fixturePackage); ok {
+		t.Errorf("reloaded catalog still lists the app: %+v", app)
+	}
+}
+
+func TestUploadRejectsInvalidRequests(t *testing.T) {
+	router := setupTestServer(t)
+
+	// Not a zip archive at all
+	if rec := uploadFixture(router, []byte("definitely not an apk"), "Demo", "stable"); rec.Code != http.StatusBadRequest {
+		t.Errorf("garbage upload: status %d, want 400: %s", rec.Code, rec.Body.String())
+	}
+
+	// Missing fields are reported together
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("channel", "bad channel")
+	w.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/upload", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("incomplete form: status %d, want 400", rec.Code)
+	}
+	var failed struct {
+		Fields []FieldError `json:"fields"`
+	}
+	decodeJSON(t, rec, &failed)
+	fields := map[string]bool{}
+	for _, fieldErr := range failed.Fields {
+		fields[fieldErr.Field] = true
+	}
+	for _, want := range []string{"projectName", "channel", "file"} {
+		if !fields[want] {
+			t.Errorf("incomplete form: no error for %s in %+v", want, failed.Fields)
+		}
+	}
+
+	if entries, _ := os.ReadDir("uploads"); len(entries) > 0 {
+		t.Errorf("rejected uploads left %d files in uploads", len(entries))
+	}
+}
+
+func TestDeleteFailures(t *testing.T) {
+	router := setupTestServer(t)
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("seed upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	fileName := someBuildFile(fixturePackage)
+
+	cases := []struct {
+		name     string
+		method   string
+		target   string
+		password string
+		want     int
+	}{
+		{"wrong password", http.MethodDelete, "/api/builds/" + fixturePackage + "/" + fileName, "wrong", http.StatusUnauthorized},
+		{"password in query", http.MethodDelete, "/api/builds/" + fixturePackage + "/" + fileName + "?password=" + testAdminPassword, "", http.StatusUnauthorized},
+		{"no password", http.MethodDelete, "/api/apps/" + fixturePackage, "", http.StatusUnauthorized},
+		{"missing build", http.MethodDelete, "/api/builds/" + fixturePackage + "/missing.apk", testAdminPassword, http.StatusNotFound},
+		{"missing app", http.MethodDelete, "/api/apps/com.example.missing", testAdminPassword, http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.target, nil)
+		if tc.password != "" {
+			req.Header.Set(adminPasswordHeader, tc.password)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d: %s", tc.name, rec.Code, tc.want, rec.Body.String())
+		}
+	}
+
+	// Nothing was removed by the failed requests
+	if builds := appBuilds(t, router, fixturePackage); len(builds) != 1 {
+		t.Errorf("builds after failed deletes = %d, want 1", len(builds))
+	}
+	if _, err := os.Stat(filepath.Join("uploads", fileName)); err != nil {
+		t.Errorf("build file after failed deletes: %v", err)
+	}
+}
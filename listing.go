@@ -0,0 +1,209 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Build orders selectable with ?sort= where builds are listed, defaulting to
+// config.BuildOrder
+const (
+	sortByUpload  = "upload"  // upload time, newest first
+	sortByVersion = "version" // versionCode, then version name, newest first, see buildBefore
+)
+
+// parseBuildOrder returns the build order requested by a ?sort= value,
+// falling back to config.BuildOrder for empty or unknown values
+func parseBuildOrder(value string) string {
+	if value == sortByUpload || value == sortByVersion {
+		return value
+	}
+	return config.BuildOrder
+}
+
+// newerBuild reports whether a is newer than b by order
+func newerBuild(a, b BuildInfo, order string) bool {
+	if order == sortByVersion {
+		return buildBefore(b, a)
+	}
+	return a.UploadTime > b.UploadTime
+}
+
+// sortBuilds orders builds in place, newest first by order
+func sortBuilds(builds []BuildInfo, order string) {
+	sort.SliceStable(builds, func(a, b int) bool { return newerBuild(builds[a], builds[b], order) })
+}
+
+// firstBuild returns the newest of builds by config.BuildOrder, the one
+// listings show first
+func firstBuild(builds []BuildInfo) (BuildInfo, bool) {
+	var first BuildInfo
+	found := false
+	for _, build := range builds {
+		if !found || newerBuild(build, first, config.BuildOrder) {
+			first, found = build, true
+		}
+	}
+	return first, found
+}
+
+// maxPageSize bounds ?pageSize= on the listing endpoints
+const maxPageSize = 500
+
+// pageRequest is the ?page= (from 1) and ?pageSize= of a listing. Without
+// pageSize the whole list is returned, as before pagination existed.
+type pageRequest struct {
+	Page int
+	Size int
+}
+
+// parsePageRequest reads the pagination parameters, writing a 400
+// response when they are not positive integers
+func parsePageRequest(c *gin.Context) (pageRequest, bool) {
+	p := pageRequest{Page: 1}
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{{"page", &p.Page}, {"pageSize", &p.Size}} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || (param.name == "pageSize" && n > maxPageSize) {
+			respondError(c, http.StatusBadRequest, "page 和 pageSize 参数必须是正整数，pageSize 最大为 "+strconv.Itoa(maxPageSize))
+			return p, false
+		}
+		*param.value = n
+	}
+	return p, true
+}
+
+// pageOf returns the items on page p of items
+func pageOf[T any](items []T, p pageRequest) []T {
+	if p.Size == 0 {
+		return items
+	}
+	start := min((p.Page-1)*p.Size, len(items))
+	end := min(start+p.Size, len(items))
+	return items[start:end]
+}
+
+// addPageFields adds the total, and the page when one was requested, to a
+// listing response
+func (p pageRequest) addPageFields(body gin.H, total int) gin.H {
+	body["total"] = total
+	if p.Size > 0 {
+		body["page"] = p.Page
+		body["pageSize"] = p.Size
+	}
+	return body
+}
+
+// ProjectSummary is one entry of GET /api/projects
+type ProjectSummary struct {
+	ProjectName   string `json:"projectName"`
+	PackagePrefix string `json:"packagePrefix,omitempty"` // the effective prefix, see projectPackagePrefix
+	AppCount      int    `json:"appCount"`
+	BuildCount    int    `json:"buildCount"`
+	LatestUpload  string `json:"latestUpload,omitempty"`
+	// Role is the requesting user's role under APPDIST_MULTI_TENANT
+	Role string `json:"role,omitempty"`
+}
+
+// AppSummary is one entry of GET /api/projects/:projectName/apps
+type AppSummary struct {
+	AppName       string `json:"appName"`
+	PackageName   string `json:"packageName"`
+	Platform      string `json:"platform"` // as in the manifest, see appPlatform
+	IconURL       string `json:"iconUrl,omitempty"`
+	BuildCount    int    `json:"buildCount"`
+	LatestVersion string `json:"latestVersion,omitempty"`
+	LatestUpload  string `json:"latestUpload,omitempty"`
+}
+
+// handleListProjects serves GET /api/projects, ordered by ?sort= like the
+// homepage
+func handleListProjects(c *gin.Context) {
+	p, ok := parsePageRequest(c)
+	if !ok {
+		return
+	}
+	order := parseCatalogSort(c.Query("sort"))
+
+	user := requestAccess(c).user
+	mutex.Lock()
+	projects := make([]ProjectSummary, 0, len(allProjects))
+	for _, project := range allProjects {
+		if !canViewProject(c, project) {
+			continue
+		}
+		summary := ProjectSummary{
+			ProjectName:   project.ProjectName,
+			PackagePrefix: projectPackagePrefix(project.ProjectName),
+			AppCount:      len(project.Apps),
+			Role:          projectRole(user, project),
+		}
+		for _, app := range project.Apps {
+			summary.BuildCount += len(app.Builds)
+			summary.LatestUpload = max(summary.LatestUpload, latestUpload(app.Builds))
+		}
+		projects = append(projects, summary)
+	}
+	mutex.Unlock()
+
+	sort.SliceStable(projects, func(a, b int) bool {
+		return catalogLess(order, projects[a].ProjectName, projects[b].ProjectName, projects[a].LatestUpload, projects[b].LatestUpload)
+	})
+	c.JSON(http.StatusOK, p.addPageFields(gin.H{"projects": pageOf(projects, p)}, len(projects)))
+}
+
+// handleListProjectApps serves GET /api/projects/:projectName/apps, ordered
+// by ?sort= like the homepage
+func handleListProjectApps(c *gin.Context) {
+	p, ok := parsePageRequest(c)
+	if !ok {
+		return
+	}
+	order := parseCatalogSort(c.Query("sort"))
+	projectName := c.Param("projectName")
+	baseURL := requestBaseURL(c)
+
+	mutex.Lock()
+	i := findProject(projectName)
+	var apps []AppSummary
+	if i >= 0 {
+		apps = make([]AppSummary, 0, len(allProjects[i].Apps))
+		for _, app := range allProjects[i].Apps {
+			summary := AppSummary{
+				AppName:      app.AppName,
+				PackageName:  app.PackageName,
+				Platform:     appPlatform(app),
+				BuildCount:   len(app.Builds),
+				LatestUpload: latestUpload(app.Builds),
+			}
+			if app.IconPath != "" {
+				summary.IconURL = baseURL + "/" + app.IconPath
+			}
+			for _, build := range app.Builds {
+				if build.UploadTime == summary.LatestUpload {
+					summary.LatestVersion = build.Version
+				}
+			}
+			apps = append(apps, summary)
+		}
+	}
+	mutex.Unlock()
+
+	if i < 0 {
+		respondError(c, http.StatusNotFound, "项目未找到")
+		return
+	}
+	sort.SliceStable(apps, func(a, b int) bool {
+		return catalogLess(order, apps[a].AppName, apps[b].AppName, apps[a].LatestUpload, apps[b].LatestUpload)
+	})
+	c.JSON(http.StatusOK, p.addPageFields(gin.H{"projectName": projectName, "apps": pageOf(apps, p)}, len(apps)))
+}
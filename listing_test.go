@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCatalogListing(t *testing.T) {
+	router := setupTestServer(t)
+	apk := fixtureAPK(t)
+	for i, channel := range []string{"stable", "beta", "dev"} {
+		if rec := uploadFixture(router, fixtureVariant(t, apk, i), "Demo", channel); rec.Code != http.StatusOK {
+			t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+	if rec := uploadFixture(router, apk, "Another", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var projects struct {
+		Total    int              `json:"total"`
+		Projects []ProjectSummary `json:"projects"`
+	}
+	decodeJSON(t, serve(router, http.MethodGet, "/api/projects"), &projects)
+	if projects.Total != 2 || len(projects.Projects) != 2 || projects.Projects[0].ProjectName != "Another" ||
+		projects.Projects[1].BuildCount != 3 || projects.Projects[1].AppCount != 1 {
+		t.Errorf("projects = %+v", projects)
+	}
+
+	var apps struct {
+		Total int          `json:"total"`
+		Apps  []AppSummary `json:"apps"`
+	}
+	decodeJSON(t, serve(router, http.MethodGet, "/api/projects/Demo/apps"), &apps)
+	if apps.Total != 1 || apps.Apps[0].PackageName != fixturePackage || apps.Apps[0].BuildCount != 3 || apps.Apps[0].LatestVersion == "" {
+		t.Errorf("apps = %+v", apps)
+	}
+	if rec := serve(router, http.MethodGet, "/api/projects/Nope/apps"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown project: status %d, want 404", rec.Code)
+	}
+
+	all := appBuilds(t, router, fixturePackage)
+	var page struct {
+		Total    int         `json:"total"`
+		Page     int         `json:"page"`
+		PageSize int         `json:"pageSize"`
+		Builds   []BuildInfo `json:"builds"`
+	}
+	decodeJSON(t, serve(router, http.MethodGet, "/api/apps/"+fixturePackage+"/builds?page=2&pageSize=3"), &page)
+	if page.Total != 4 || page.Page != 2 || page.PageSize != 3 || len(page.Builds) != 1 || page.Builds[0].FileName != all[3].FileName {
+		t.Errorf("second page = %+v", page)
+	}
+	decodeJSON(t, serve(router, http.MethodGet, "/api/apps/"+fixturePackage+"/builds?sort=version"), &page)
+	for i := 1; i < len(page.Builds); i++ {
+		if buildBefore(page.Builds[i-1], page.Builds[i]) {
+			t.Errorf("builds not ordered by version: %+v", page.Builds)
+		}
+	}
+	for _, query := range []string{"?page=0", "?pageSize=abc", "?pageSize=100000"} {
+		if rec := serve(router, http.MethodGet, "/api/projects"+query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Values of Config.LogFormat
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// logLevels maps Config.LogLevel to slog levels
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// setupLogging makes the default slog logger write to w in the level and
+// format of cfg. Code logs through slog's package functions, or through
+// logf and friends when a request is at hand.
+func setupLogging(w io.Writer, cfg Config) {
+	options := &slog.HandlerOptions{Level: logLevels[cfg.LogLevel]}
+	var handler slog.Handler = slog.NewTextHandler(w, options)
+	if cfg.LogFormat == logFormatJSON {
+		handler = slog.NewJSONHandler(w, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// requestAttrs describes the request of c in log records; nil for
+// background work
+func requestAttrs(c *gin.Context) []any {
+	if c == nil || c.Request == nil {
+		return nil
+	}
+	attrs := []any{
+		slog.String("requestId", requestID(c)),
+		slog.String("clientIp", c.ClientIP()),
+		slog.String("method", c.Request.Method),
+	}
+	if route := c.FullPath(); route != "" {
+		attrs = append(attrs, slog.String("route", route))
+	}
+	return attrs
+}
+
+// logAt logs the formatted message at level with the request of c
+func logAt(c *gin.Context, level slog.Level, format string, args ...any) {
+	ctx := context.Background()
+	if !slog.Default().Enabled(ctx, level) {
+		return
+	}
+	message := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+	slog.Log(ctx, level, message, requestAttrs(c)...)
+}
+
+// logf logs an informational message about the request of c
+func logf(c *gin.Context, format string, args ...any) {
+	logAt(c, slog.LevelInfo, format, args...)
+}
+
+// warnf logs a problem the request of c recovered from
+func warnf(c *gin.Context, format string, args ...any) {
+	logAt(c, slog.LevelWarn, format, args...)
+}
+
+// errorf logs a failure of the request of c
+func errorf(c *gin.Context, format string, args ...any) {
+	logAt(c, slog.LevelError, format, args...)
+}
+
+// requestLogger logs every request once it was answered, with its route,
+// status, size and latency. Server errors are logged as errors.
+func requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		level := slog.LevelInfo
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := append(requestAttrs(c),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", c.Writer.Status()),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.Duration("latency", time.Since(start)),
+		)
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("errors", c.Errors.String()))
+		}
+		slog.Log(context.Background(), level, "request", attrs...)
+	}
+}
+
+// recoverPanics answers a panicking request with 500 and logs the panic
+// with its stack
+func recoverPanics() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err any) {
+		slog.Error(fmt.Sprintf("处理请求时发生 panic: %v", err), append(requestAttrs(c), slog.String("stack", string(debug.Stack())))...)
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
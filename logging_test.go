@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLogs sends the logs of the test to a buffer in the format and
+// level of config
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	previous := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	var buf bytes.Buffer
+	setupLogging(&buf, config)
+	return &buf
+}
+
+// logRecords decodes JSON log lines
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestStructuredLogs(t *testing.T) {
+	router := setupTestServer(t)
+	config.LogFormat = logFormatJSON
+	buf := captureLogs(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/upload", nil)
+	req.Header.Set(requestIDHeader, "trace-42")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	var sawMessage, sawAccess bool
+	for _, record := range logRecords(t, buf) {
+		if record["requestId"] != "trace-42" || record["route"] != "/api/upload" || record["method"] != "POST" {
+			t.Errorf("record without request attributes: %v", record)
+		}
+		switch record["msg"] {
+		case "收到新的上传请求":
+			sawMessage = record["level"] == "INFO"
+		case "request":
+			sawAccess = record["status"] == float64(http.StatusBadRequest) && record["path"] == "/api/upload" &&
+				record["latency"] != nil && record["clientIp"] != ""
+		}
+	}
+	if !sawMessage || !sawAccess {
+		t.Errorf("missing handler or access log record:\n%s", buf)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	config = defaultConfig()
+	config.LogLevel = "warn"
+	buf := captureLogs(t)
+
+	logf(nil, "skipped %d", 1)
+	warnf(nil, "kept %d\n", 2)
+	if got := buf.String(); strings.Contains(got, "skipped") || !strings.Contains(got, `msg="kept 2"`) || !strings.Contains(got, "level=WARN") {
+		t.Errorf("logs = %q", got)
+	}
+}
@@ -1,516 +1,1456 @@
 package main
 
 import (
-	"encoding/json"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"html/template"
-	"image/png"
+	"image"
+	"io"
+	"log/slog"
+	"maps"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/shogo82148/androidbinary/apk"
-	"github.com/skip2/go-qrcode"
 )
 
 // BuildInfo represents a specific app build version
 type BuildInfo struct {
 	Version      string `json:"version"`
+	VersionCode  int32  `json:"versionCode,omitempty"`
+	MinSDK       int32  `json:"minSdk,omitempty"`
+	TargetSDK    int32  `json:"targetSdk,omitempty"`
 	Channel      string `json:"channel"`
 	ReleaseNotes string `json:"releaseNotes"`
-	FileName     string `json:"fileName"`
-	FileSize     int64  `json:"fileSize"`
-	UploadTime   string `json:"uploadTime"`
-	DownloadURL  string `json:"downloadURL"`
+	// ReleaseNotesHTML is ReleaseNotes rendered by renderMarkdown. It is
+	// only filled in API responses, see withNotesHTML, and never stored.
+	ReleaseNotesHTML string `json:"releaseNotesHtml,omitempty"`
+	FileName         string `json:"fileName"`
+	FileSize         int64  `json:"fileSize"`
+	UploadTime       string `json:"uploadTime"`
+	DownloadURL      string `json:"downloadURL"`
+	FileHash         string `json:"fileHash,omitempty"`
+	// Platform is "ios" for IPA builds and "harmonyos" for HarmonyOS builds;
+	// empty means Android, see buildPlatform
+	Platform string `json:"platform,omitempty"`
+	// MinOSVersion is the lowest iOS version an IPA build supports
+	MinOSVersion string `json:"minOsVersion,omitempty"`
+	// Format is formatAAB for App Bundles; UniversalAPK then names the
+	// installable APK generated from the bundle, if any
+	Format       string `json:"format,omitempty"`
+	UniversalAPK string `json:"universalApk,omitempty"`
+	// Permissions lists the permissions requested by the APK
+	Permissions []string `json:"permissions,omitempty"`
+	// SignerSHA256 is the SHA-256 digest of the signing certificate; builds
+	// can only be installed over each other when it matches
+	SignerSHA256 string `json:"signerSha256,omitempty"`
+	// Certificate details the signing certificate of APKs
+	Certificate *SigningCertificate `json:"certificate,omitempty"`
+	// Expansions are the APK expansion (OBB) files attached to the build,
+	// shared by every entry of the build file
+	Expansions []ExpansionFile `json:"expansions,omitempty"`
+	// Tags are free-form labels such as "hotfix", independent of the channel
+	Tags []string `json:"tags,omitempty"`
+	// Extra holds custom fields such as a ticket or commit, set at upload
+	// through "extra_<key>" form fields
+	Extra map[string]string `json:"extra,omitempty"`
+	// PromotedFrom is the channel this entry was promoted from. Promoted
+	// entries share the physical file of the original build.
+	PromotedFrom string `json:"promotedFrom,omitempty"`
+	// ScanStatus is the virus scan result, scanClean or scanUnscanned; empty
+	// when scanning was disabled at upload
+	ScanStatus string `json:"scanStatus,omitempty"`
+	ScannedAt  string `json:"scannedAt,omitempty"`
+	// Protected builds, such as release candidates, are kept by the
+	// retention policies and only deleted with ?force=true
+	Protected bool `json:"protected,omitempty"`
+	// State is buildProcessing or buildFailed for the build of an upload
+	// job, see UploadJob.Build; catalog builds are published and leave it
+	// empty
+	State string `json:"state,omitempty"`
 }
 
 // AppEntry represents a unique app (identified by package name)
 type AppEntry struct {
-	AppName     string      `json:"appName"`
-	PackageName string      `json:"packageName"`
-	IconPath    string      `json:"iconPath"`
-	Builds      []BuildInfo `json:"builds"`
+	AppName     string `json:"appName"`
+	PackageName string `json:"packageName"`
+	IconPath    string `json:"iconPath"`
+	IconHash    string `json:"iconHash,omitempty"` // perceptual hash of the icon, see iconHash
+	// SignerSHA256 is the signer of the most recent signed upload; new
+	// uploads are checked against it, see detectSignerChange
+	SignerSHA256 string `json:"signerSha256,omitempty"`
+	// Icons maps a density name (see iconDensities) to its stored icon;
+	// IconPath stays the default when it is empty or lacks a density
+	Icons map[string]string `json:"icons,omitempty"`
+	// Private apps are only downloadable through signed links, see
+	// handleBuildLink
+	Private bool        `json:"private,omitempty"`
+	Builds  []BuildInfo `json:"builds"`
 }
 
 // Project represents a project category
 type Project struct {
-	ProjectName string     `json:"projectName"`
-	Apps        []AppEntry `json:"apps"`
+	ProjectName string `json:"projectName"`
+	// PackagePrefix restricts uploads to packages whose name starts with it,
+	// see checkPackagePrefix; empty falls back to APPDIST_PACKAGE_PREFIXES
+	PackagePrefix string     `json:"packagePrefix,omitempty"`
+	Apps          []AppEntry `json:"apps"`
+	// Webhooks are notified of uploads and deletes in the project
+	Webhooks []Webhook `json:"webhooks,omitempty"`
+	// Retention expires old builds of the project, see startRetentionJanitor
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+	// PasswordHash is the bcrypt hash of the project's own delete password,
+	// see checkProjectAdmin
+	PasswordHash string `json:"passwordHash,omitempty"`
+	// QuotaBytes limits the storage the project's files take, see
+	// checkProjectQuota; 0 falls back to APPDIST_PROJECT_QUOTA and -1 lifts it
+	QuotaBytes int64 `json:"quotaBytes,omitempty"`
+	// Organization gives the members of that organization their role in
+	// the project, see projectRole
+	Organization string `json:"organization,omitempty"`
+	// Members are the users with a role in the project itself
+	Members []Member `json:"members,omitempty"`
 }
 
-const deletePassword = "9527"
+// saveRetryAttempts bounds how often a single metadata file operation is retried
+const saveRetryAttempts = 3
 
 var (
 	allProjects      []Project
 	mutex            = &sync.Mutex{}
 	metadataFilePath = "metadata.json"
+	// saveMutex serializes the file operations in saveMetadata independently
+	// of mutex, which guards the in-memory data.
+	saveMutex = &sync.Mutex{}
+	// buildsByHash indexes every build that has a FileHash, earliest upload
+	// first, since the same file can be listed by several projects, apps or
+	// channels. It is guarded by mutex and rebuilt by rebuildIndexes whenever
+	// allProjects is loaded or saved.
+	buildsByHash = map[string][]hashIndexEntry{}
 )
 
-// loadMetadata loads the metadata from the JSON file.
+// hashIndexEntry locates a build in the catalog by its content hash
+type hashIndexEntry struct {
+	ProjectName string    `json:"projectName"`
+	AppName     string    `json:"appName"`
+	PackageName string    `json:"packageName"`
+	Build       BuildInfo `json:"build"`
+}
+
+// loadMetadata loads the catalog from the metadata store.
 // It locks the mutex to ensure thread safety.
 func loadMetadata() error {
 	mutex.Lock()
 	defer mutex.Unlock()
-	data, err := os.ReadFile(metadataFilePath) // Use os.ReadFile instead of ioutil.ReadFile
+	projects, err := metadataStore.Load()
 	if err != nil {
-		if os.IsNotExist(err) {
-			allProjects = []Project{}
-			return nil
-		}
 		return err
 	}
-	return json.Unmarshal(data, &allProjects)
+	allProjects = projects
+	// Older catalogs stored upload times in server local time
+	if normalizeUploadTimes() {
+		slog.Info("已将旧格式的上传时间转换为 UTC，将在下次保存元数据时写入")
+	}
+	rebuildIndexes()
+	resetCatalogVersion()
+	return nil
 }
 
-// saveMetadata saves the metadata to the JSON file with a backup mechanism.
+// saveMetadata saves the catalog to the metadata store.
 // IMPORTANT: It does NOT lock the mutex, assuming the caller has already acquired a lock.
+// The store operations themselves are serialized by saveMutex, so a code path that
+// forgets the data lock still cannot interleave two saves.
 func saveMetadata() error {
-	// Create a backup before writing
-	backupPath := metadataFilePath + ".bak"
-	if _, err := os.Stat(metadataFilePath); err == nil {
-		if err := os.Rename(metadataFilePath, backupPath); err != nil {
-			return fmt.Errorf("创建元数据备份失败: %w", err)
-		}
-	}
+	saveMutex.Lock()
+	defer saveMutex.Unlock()
 
-	data, err := json.MarshalIndent(allProjects, "", "  ")
-	if err != nil {
-		// Attempt to restore from backup on marshaling error
-		os.Rename(backupPath, metadataFilePath)
+	// Keep the in-memory indexes in step with allProjects, even if the write fails
+	rebuildIndexes()
+
+	if err := metadataStore.Save(allProjects); err != nil {
 		return err
 	}
+	catalogVersion++
+	return nil
+}
 
-	err = os.WriteFile(metadataFilePath, data, 0644) // Use os.WriteFile instead of ioutil.WriteFile
+// writeFileSync writes data to path and flushes it to disk before returning.
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
-		// Attempt to restore from backup on write error
-		os.Rename(backupPath, metadataFilePath)
-		return fmt.Errorf("写入元数据文件失败: %w", err)
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
 	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
 
-	// If successful, remove the backup
-	os.Remove(backupPath)
-	return nil
+// retrySave runs op up to saveRetryAttempts times, backing off between
+// attempts, to ride out transient filesystem errors.
+func retrySave(op func() error) error {
+	var err error
+	for attempt := 1; attempt <= saveRetryAttempts; attempt++ {
+		if err = op(); err == nil {
+			return nil
+		}
+		if attempt < saveRetryAttempts {
+			slog.Warn("元数据保存失败，即将重试", "attempt", attempt, "error", err)
+			time.Sleep(time.Duration(attempt) * 50 * time.Millisecond)
+		}
+	}
+	return err
 }
 
 func main() {
+	cfg, err := loadConfig()
+	if err != nil {
+		panic("加载配置失败: " + err.Error())
+	}
+	config = cfg
+	setupLogging(os.Stdout, config)
+	if config.AdminPasswordHash == "" {
+		password, err := oneTimeAdminPassword()
+		if err != nil {
+			panic("生成管理员密码失败: " + err.Error())
+		}
+		slog.Warn("未配置 APPDIST_ADMIN_PASSWORD_HASH，本次运行的一次性管理员密码如下，重启后失效", "password", password)
+	}
+	parseCache.Resize(config.ParseCacheSize)
+	parseSlots = newParseLimiter(config.MaxConcurrentParses)
+	metadataFilePath = config.MetadataPath
+	if metadataStore, err = newMetadataStore(config); err != nil {
+		panic("打开元数据存储失败: " + err.Error())
+	}
+	if storage, err = newStorage(config); err != nil {
+		panic("初始化存储失败: " + err.Error())
+	}
+
 	if err := loadMetadata(); err != nil {
 		panic("加载元数据失败: " + err.Error())
 	}
+	cleanIncoming()
+	sweepChunkedUploads()
+	if err := stats.load(config.StatsPath); err != nil {
+		panic("加载统计数据失败: " + err.Error())
+	}
+	if err := tokens.load(config.TokensPath); err != nil {
+		panic("加载 API 令牌失败: " + err.Error())
+	}
+	if err := accounts.load(config.UsersPath); err != nil {
+		panic("加载用户与组织失败: " + err.Error())
+	}
+
+	if config.ReadOnly {
+		maintenance.set(true, "")
+	}
+	startSnapshotScheduler()
+	startRetentionJanitor()
+	startReconciler()
 
-	router := gin.Default()
+	router := newRouter()
+	if err := runServer(router); err != nil {
+		panic("服务器异常退出: " + err.Error())
+	}
+}
+
+// newRouter registers the middleware, templates and every route. Templates
+// and stylesheets are embedded, see loadTemplates and staticFiles.
+func newRouter() *gin.Engine {
+	router := gin.New()
+	// ClientIP only honors X-Forwarded-For from the configured proxies
+	if err := router.SetTrustedProxies(config.TrustedProxies); err != nil {
+		slog.Warn("设置可信代理失败", "error", err)
+	}
+	router.Use(requestIDMiddleware(), requestLogger(), recoverPanics(), siteBranding())
 
 	// Register custom template functions
-	router.SetFuncMap(template.FuncMap{
-		"formatSize": formatSize,
-		"first":      first,
+	templates, err := loadTemplates(template.FuncMap{
+		"formatSize":  formatSize,
+		"first":       first,
+		"fingerprint": shortFingerprint,
+		"installURL":  installURL,
+		"iconSrcset":  iconSrcset,
+		"formatTime":  formatTime,
+		"unixTime":    unixTime,
+		"markdown":    renderMarkdown,
+		"url":         withBasePath,
+		"basePath":    func() string { return config.BasePath },
 	})
+	if err != nil {
+		panic("加载页面模板失败: " + err.Error())
+	}
+	router.SetHTMLTemplate(templates)
 
-	router.LoadHTMLGlob("templates/*")
-	router.Static("/static", "./static")
-	router.Static("/downloads", "./uploads")
+	// Every route lives below the configured base path ("" for the root)
+	root := router.Group(config.BasePath, requireMembership())
+	root.StaticFS("/static", staticFiles())
+	root.GET("/downloads/:fileName", handleDownload)
+	root.HEAD("/downloads/:fileName", handleDownload)
+	root.GET("/downloads/obb/:build/:fileName", handleExpansionDownload)
+	root.HEAD("/downloads/obb/:build/:fileName", handleExpansionDownload)
+	root.GET("/favicon.ico", handleFavicon)
 
 	// Homepage route
-	router.GET("/", func(c *gin.Context) {
-		mutex.Lock() // Add mutex lock for thread-safe read
-		defer mutex.Unlock()
-		c.HTML(http.StatusOK, "index.html", gin.H{
-			"AllProjects":  allProjects,
-			"UploadStatus": c.Query("upload"),
-		})
-	})
+	root.GET("/", handleIndexPage)
 
 	// App Detail Page Route
-	router.GET("/app/:packageName", handleAppDetailPage)
+	root.GET("/app/:packageName", handleAppDetailPage)
+	root.GET("/app/:packageName/card", handleInstallCard)
+	root.GET("/install/:packageName", handleInstallLanding)
+	root.GET("/latest/:packageName/:channel", handleLatestDownload)
 
 	// Upload page
-	router.GET("/upload", func(c *gin.Context) {
-		c.HTML(http.StatusOK, "upload.html", nil)
+	root.GET("/upload", func(c *gin.Context) {
+		renderHTML(c, http.StatusOK, "upload.html", gin.H{
+			"Errors":        c.QueryArray("error"),
+			"ProjectName":   c.Query("projectName"),
+			"Channel":       c.Query("channel"),
+			"TokenRequired": config.UploadTokensRequired,
+		})
 	})
 
-	// QR Code generator
-	router.GET("/qr", func(c *gin.Context) {
-		urlToEncode := c.Query("url")
-		if urlToEncode == "" {
-			c.String(http.StatusBadRequest, "URL 参数缺失")
-			return
-		}
-		qr, err := qrcode.New(urlToEncode, qrcode.Medium)
-		if err != nil {
-			c.String(http.StatusInternalServerError, "无法生成二维码")
-			return
-		}
-		c.Writer.Header().Set("Content-Type", "image/png")
-		qr.Write(256, c.Writer)
-	})
+	// QR code generators
+	root.GET("/qr", handleQR)
+	root.GET("/qr/build/:packageName/:fileName", handleBuildQR)
+	root.GET("/qr/latest/:packageName/:channel", handleLatestQR)
 
 	// --- API Routes ---
-	api := router.Group("/api")
+	// Reads carry the catalog version as ETag; writes accept it in If-Match
+	api := root.Group("/api", catalogETag())
 	{
-		api.POST("/upload", handleApiUpload)
+		api.POST("/upload", requireUploadToken(), rejectDuringMaintenance(), checkIfMatch(), handleApiUpload)
+		api.POST("/upload/validate", requireUploadToken(), handleValidateUpload)
+		api.GET("/jobs/:id", requireUploadToken(), handleUploadJob)
+		api.POST("/upload/from-url", requireUploadToken(), rejectDuringMaintenance(), checkIfMatch(), handleUploadFromURL)
+		api.POST("/upload-url", requireUploadToken(), rejectDuringMaintenance(), checkIfMatch(), handleUploadURL)
+		api.POST("/upload/chunked", requireUploadToken(), rejectDuringMaintenance(), handleCreateChunkedUpload)
+		api.GET("/upload/chunked/:id", requireUploadToken(), handleChunkedUploadStatus)
+		api.HEAD("/upload/chunked/:id", requireUploadToken(), handleChunkedUploadStatus)
+		api.PATCH("/upload/chunked/:id", requireUploadToken(), rejectDuringMaintenance(), handleAppendChunk)
+		api.POST("/upload/chunked/:id/complete", requireUploadToken(), rejectDuringMaintenance(), checkIfMatch(), handleCompleteChunkedUpload)
+		api.DELETE("/upload/chunked/:id", requireUploadToken(), handleAbortChunkedUpload)
+		api.GET("/builds", handleBuildsInRange)
+		api.GET("/builds/by-hash/:hash", handleGetBuildByHash)
+		api.GET("/builds/:packageName/:fileName/notes", handleReleaseNotes)
+		api.GET("/search", handleSearch)
+		api.GET("/check-update", handleCheckUpdate)
+		api.GET("/events", handleEvents)
+		api.GET("/apps/:packageName/install", handleInstallRedirect)
+		api.GET("/apps/:packageName/manifest.plist", handleIosManifest)
+		api.GET("/apps/:packageName/bundle.zip", handleBundleZip)
+		api.GET("/projects", handleListProjects)
+		api.GET("/projects/:projectName/apps", handleListProjectApps)
+		api.GET("/apps/:packageName/builds", handleAppBuilds)
+		api.GET("/apps/:packageName/previous", handlePreviousBuild)
+		api.GET("/apps/:packageName/permissions", handlePermissionChanges)
+		api.GET("/apps/:packageName/delta", handleDelta)
+		api.GET("/apps/:packageName/timeline", handleAppTimeline)
+		api.GET("/apps/:packageName/icon", handleAppIcon)
+		api.GET("/stats/:packageName", handleAppStats)
+		api.GET("/export.csv", handleExportCSV)
+		api.GET("/manifest.json", handleManifest)
+		api.GET("/openapi.json", handleOpenAPI)
 		// NEW: Delete routes
-		api.DELETE("/apps/:packageName", handleDeleteApp)
-		api.DELETE("/builds/:packageName/:fileName", handleDeleteBuild)
+		api.DELETE("/apps/:packageName", rejectDuringMaintenance(), checkIfMatch(), handleDeleteApp)
+		api.DELETE("/builds/:packageName/:fileName", rejectDuringMaintenance(), checkIfMatch(), handleDeleteBuild)
+		api.PATCH("/builds/:packageName/:fileName", rejectDuringMaintenance(), checkIfMatch(), handleEditBuild)
+		api.POST("/builds/:packageName/:fileName/tags", rejectDuringMaintenance(), checkIfMatch(), handleEditTags)
+		api.PUT("/builds/:packageName/:fileName/protected", rejectDuringMaintenance(), checkIfMatch(), handleSetProtected)
+		api.POST("/builds/:packageName/:fileName/promote", rejectDuringMaintenance(), checkIfMatch(), handlePromoteBuild)
+		api.POST("/builds/:packageName/:fileName/reparse", rejectDuringMaintenance(), checkIfMatch(), handleReparseBuild)
+		api.POST("/builds/:packageName/:fileName/obb", rejectDuringMaintenance(), checkIfMatch(), handleUploadExpansion)
+		api.GET("/builds/:packageName/:fileName/link", handleBuildLink)
+		api.PUT("/apps/:packageName/private", rejectDuringMaintenance(), checkIfMatch(), handleSetPrivate)
+		api.PUT("/projects/:projectName/package-prefix", rejectDuringMaintenance(), checkIfMatch(), handleSetPackagePrefix)
+		api.GET("/projects/:projectName/webhooks", handleListWebhooks)
+		api.POST("/projects/:projectName/webhooks", rejectDuringMaintenance(), checkIfMatch(), handleCreateWebhook)
+		api.DELETE("/projects/:projectName/webhooks/:id", rejectDuringMaintenance(), checkIfMatch(), handleDeleteWebhook)
+		api.PUT("/projects/:projectName/retention", rejectDuringMaintenance(), checkIfMatch(), handleSetRetention)
+		api.PUT("/projects/:projectName/password", rejectDuringMaintenance(), checkIfMatch(), handleSetProjectPassword)
+		api.PUT("/projects/:projectName/quota", rejectDuringMaintenance(), checkIfMatch(), handleSetQuota)
+		api.PUT("/projects/:projectName/organization", rejectDuringMaintenance(), checkIfMatch(), handleSetProjectOrganization)
+		api.GET("/projects/:projectName/members", handleListProjectMembers)
+		api.PUT("/projects/:projectName/members/:user", rejectDuringMaintenance(), handleSetProjectMember)
+		api.DELETE("/projects/:projectName/members/:user", rejectDuringMaintenance(), handleRemoveProjectMember)
+		api.GET("/organizations", handleListOrganizations)
+		api.PUT("/organizations/:name/members/:user", handleSetOrganizationMember)
+		api.DELETE("/organizations/:name/members/:user", handleRemoveOrganizationMember)
+		api.GET("/me", handleWhoAmI)
+		api.GET("/storage/usage", handleStorageQuotaUsage)
+
+		api.POST("/admin/login", handleAdminLogin)
+		api.POST("/admin/logout", handleAdminLogout)
+		api.GET("/audit", requireAdmin(), handleAudit)
+
+		admin := api.Group("/admin", requireAdmin())
+		admin.POST("/snapshot", handleCreateSnapshot)
+		admin.GET("/snapshots", handleListSnapshots)
+		admin.GET("/storage", handleStorageUsage)
+		admin.GET("/missing-files", handleMissingFiles)
+		admin.GET("/maintenance", handleGetMaintenance)
+		admin.POST("/maintenance", handleSetMaintenance)
+		admin.GET("/tokens", handleListTokens)
+		admin.POST("/tokens", handleCreateToken)
+		admin.DELETE("/tokens/:id", handleRevokeToken)
+		admin.POST("/retention", rejectDuringMaintenance(), handleRunRetention)
+		admin.POST("/reconcile", rejectDuringMaintenance(), handleReconcile)
+		admin.GET("/reconcile", handleLastReconcile)
+		admin.GET("/users", handleListUsers)
+		admin.POST("/users", handleCreateUser)
+		admin.DELETE("/users/:name", handleDeleteUser)
+		admin.POST("/organizations", handleCreateOrganization)
+		admin.DELETE("/organizations/:name", handleDeleteOrganization)
 	}
+	return router
+}
 
-	fmt.Println("服务器已启动，监听端口:1234")
-	router.Run(":1234")
+// homeApp is the trimmed view of an app shown on the homepage
+type homeApp struct {
+	AppName      string
+	PackageName  string
+	IconPath     string
+	Icons        map[string]string
+	Builds       []BuildInfo // most recent first, at most config.HomepageBuilds
+	TotalBuilds  int
+	LatestUpload string // upload time of the newest build, for sorting
+	// NotesPreview is the start of the newest build's release notes;
+	// NotesTruncated offers the rest through handleReleaseNotes
+	NotesPreview   string
+	NotesTruncated bool
 }
 
-// Handler for the App Detail Page
-func handleAppDetailPage(c *gin.Context) {
-	packageName := c.Param("packageName")
-	var foundApp *AppEntry
-	var projectOwner *Project
+// homeProject groups the homepage view of a project's apps
+type homeProject struct {
+	ProjectName string
+	Apps        []homeApp
+}
 
-	mutex.Lock() // Add mutex lock for thread-safe read
-	defer mutex.Unlock()
+// Handler for the homepage. Only the most recent builds of each app are
+// passed to the template; the detail page lists the rest. Projects and apps
+// are sorted as selected by ?sort= (see parseCatalogSort), and ?view= picks
+// the layout (see parseHomeView): the grid gets the latest build alone and
+// no release notes.
+func handleIndexPage(c *gin.Context) {
+	limit := max(config.HomepageBuilds, 1)
+	order := parseCatalogSort(c.Query("sort"))
+	view := parseHomeView(c.Query("view"))
+	if view == viewGrid {
+		limit = 1
+	}
 
-	// Find the correct app across all projects
-	for i := range allProjects {
-		for j := range allProjects[i].Apps {
-			if allProjects[i].Apps[j].PackageName == packageName {
-				foundApp = &allProjects[i].Apps[j]
-				projectOwner = &allProjects[i]
-				break
-			}
+	catalog := repo.GetAll()
+	projects := make([]homeProject, 0, len(catalog))
+	for _, project := range catalog {
+		if !canViewProject(c, project) {
+			continue
 		}
-		if foundApp != nil {
-			break
+		listing := homeProject{ProjectName: project.ProjectName}
+		for _, app := range project.Apps {
+			// GetAll returns copies, which may be sorted in place
+			if config.BuildOrder == sortByVersion {
+				sortBuilds(app.Builds, sortByVersion)
+			}
+			recent := app.Builds[:min(len(app.Builds), limit)]
+			var preview string
+			var truncated bool
+			if len(recent) > 0 && view == viewList {
+				preview, truncated = notesPreview(recent[0].ReleaseNotes)
+			}
+			listing.Apps = append(listing.Apps, homeApp{
+				AppName:      app.AppName,
+				PackageName:  app.PackageName,
+				IconPath:     app.IconPath,
+				Icons:        app.Icons,
+				Builds:       recent,
+				TotalBuilds:  len(app.Builds),
+				LatestUpload: latestUpload(app.Builds),
+
+				NotesPreview:   preview,
+				NotesTruncated: truncated,
+			})
 		}
+		projects = append(projects, listing)
 	}
+	sortHomeProjects(projects, order)
+
+	renderHTML(c, http.StatusOK, "index.html", gin.H{
+		"AllProjects":    projects,
+		"UploadStatus":   c.Query("upload"),
+		"UploadWarnings": c.QueryArray("warning"),
+		"Maintenance":    maintenance.get(),
+		"TZ":             viewerTimezone(c),
+		"Sort":           order,
+		"View":           view,
+	})
+}
 
-	if foundApp == nil {
-		c.String(http.StatusNotFound, "应用未找到")
-		return
-	}
+// Handler for the App Detail Page
+func handleAppDetailPage(c *gin.Context) {
+	packageName := c.Param("packageName")
 
-	scheme := "http"
-	if c.Request.TLS != nil {
-		scheme = "https"
+	projectName, app, found := repo.FindApp(packageName)
+	if !found {
+		respondText(c, http.StatusNotFound, "应用未找到")
+		return
 	}
-	baseURL := fmt.Sprintf("%s://%s", scheme, c.Request.Host)
 
-	c.HTML(http.StatusOK, "details.html", gin.H{
-		"App":         foundApp,
-		"ProjectName": projectOwner.ProjectName,
-		"BaseURL":     baseURL,
+	renderHTML(c, http.StatusOK, "details.html", gin.H{
+		"App":         app,
+		"Admin":       isAdmin(c),
+		"Channels":    groupBuildsByChannel(app.Builds),
+		"ProjectName": projectName,
+		"BaseURL":     requestBaseURL(c),
+		"TZ":          viewerTimezone(c),
 	})
 }
 
+// withBasePath prefixes a root-relative path with the configured base path.
+// Stored URLs such as BuildInfo.DownloadURL stay root-relative so that the
+// base path can change without rewriting metadata.
+func withBasePath(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return config.BasePath + p
+}
+
 // AppInfo holds information extracted from an APK
 type AppInfo struct {
 	AppName     string
 	PackageName string
 	Version     string
 	IconPath    string
+	IconHash    string
+	Icons       map[string]string
+	// SignerSHA256 is the signer of the uploaded build, "" when unsigned
+	SignerSHA256 string
 }
 
 // --- API Handlers ---
 
 func handleApiUpload(c *gin.Context) {
-	fmt.Println("--- 收到新的上传请求 ---")
+	logf(c, "收到新的上传请求")
 
-	projectName := c.PostForm("projectName")
-	channel := c.PostForm("channel")
-	releaseNotes := c.PostForm("releaseNotes")
-	fmt.Printf("表单数据解析: 项目=%s, 渠道=%s\n", projectName, channel)
+	if errs := validateUploadForm(c); len(errs) > 0 {
+		warnf(c, "上传表单校验失败: %v", errs)
+		respondFieldErrors(c, errs)
+		return
+	}
+	req := uploadRequest{
+		ProjectName:       strings.TrimSpace(c.PostForm("projectName")),
+		Channel:           strings.TrimSpace(c.PostForm("channel")),
+		ReleaseNotes:      c.PostForm("releaseNotes"),
+		AllowDowngrade:    c.Query("allowDowngrade") == "true" || c.PostForm("allowDowngrade") == "true" || isForced(c.Query("force")) || isForced(c.PostForm("force")),
+		AllowSignerChange: c.Query("allowSignerChange") == "true" || c.PostForm("allowSignerChange") == "true",
+	}
+	// Already validated with the rest of the form
+	req.Extra, _ = extraFromForm(c)
+	logf(c, "表单数据解析: 项目=%s, 渠道=%s", req.ProjectName, req.Channel)
+	// publishUpload checks again, but a refused upload need not be stored
+	// or queued
+	if msg := uploadProjectError(c, req.ProjectName); msg != "" {
+		respondText(c, http.StatusForbidden, "%s", msg)
+		return
+	}
 
 	file, err := c.FormFile("file")
 	if err != nil {
-		c.String(http.StatusBadRequest, "获取表单文件错误: %s", err.Error())
+		respondText(c, http.StatusBadRequest, "获取表单文件错误: %s", err.Error())
 		return
 	}
-	fmt.Printf("文件已接收: %s, 大小: %d\n", file.Filename, file.Size)
-
-	tempSavePath := filepath.Join("uploads", fmt.Sprintf("temp-%d-%s", time.Now().UnixNano(), filepath.Base(file.Filename)))
-	if err := c.SaveUploadedFile(file, tempSavePath); err != nil {
-		fmt.Printf("保存临时文件到 %s 错误: %v\n", tempSavePath, err)
-		c.String(http.StatusInternalServerError, "保存文件错误: %s", err.Error())
+	logf(c, "文件已接收: %q, 大小: %d", file.Filename, file.Size)
+	req.Platform = uploadPlatform(file.Filename)
+	if err := checkUploadFile(file); err != nil {
+		warnf(c, "拒绝上传 %q: %v", file.Filename, err)
+		respondText(c, http.StatusBadRequest, "文件检查未通过: %s", err.Error())
 		return
 	}
-	fmt.Printf("文件成功临时保存到: %s\n", tempSavePath)
-	defer os.Remove(tempSavePath)
 
-	pkg, err := apk.OpenFile(tempSavePath)
+	incomingPath, fileHash, err := saveIncomingUpload(c, file)
 	if err != nil {
-		c.String(http.StatusInternalServerError, "解析APK失败: %s", err.Error())
+		respondText(c, http.StatusInternalServerError, "保存文件错误: %s", err.Error())
 		return
 	}
-	defer pkg.Close()
 
-	appName, err := pkg.Label(nil)
-	if err != nil || appName == "" {
-		c.String(http.StatusInternalServerError, "解析APK应用名失败或应用名为空: %v", err)
+	source := c.PostForm("source")
+	// Browsers wait for the result page, so only API uploads run as jobs
+	if source != "web" && asyncUpload(c) {
+		build := pendingBuild(req, file.Size, fileHash)
+		if !uploadJobs.enqueue(c, file.Filename, req, build, func(c *gin.Context) (uploadResult, error) {
+			return publishUpload(c, incomingPath, fileHash, file.Size, req)
+		}) {
+			os.Remove(incomingPath)
+		}
 		return
 	}
-	packageName := pkg.PackageName()
-	if packageName == "" {
-		c.String(http.StatusInternalServerError, "解析APK包名失败或包名为空")
+
+	result, err := publishUpload(c, incomingPath, fileHash, file.Size, req)
+	if err != nil {
+		respondUploadError(c, err)
 		return
 	}
-	version, err := pkg.Manifest().VersionName.String()
-	if err != nil || version == "" {
-		c.String(http.StatusInternalServerError, "解析APK版本名失败或版本名为空: %v", err)
-		return
+
+	if source == "web" {
+		query := url.Values{"upload": {"success"}}
+		for _, warning := range result.Warnings {
+			query.Add("warning", warning)
+		}
+		c.Redirect(http.StatusFound, withBasePath("/")+"?"+query.Encode())
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "Upload successful", "warnings": result.Warnings})
+	}
+}
+
+// uploadRequest holds the form values that accompany an uploaded APK or IPA
+type uploadRequest struct {
+	Platform       string // as told by uploadPlatform from the file name
+	ProjectName    string
+	Channel        string
+	ReleaseNotes   string
+	AllowDowngrade bool
+	// AllowSignerChange accepts a different signer under the strict policy
+	AllowSignerChange bool
+	Extra             map[string]string
+}
+
+// uploadResult is a published upload
+type uploadResult struct {
+	PackageName string
+	Build       BuildInfo
+	Warnings    []string
+}
+
+// uploadError is a rejected upload and how it is answered. API clients get
+// message as plain text, or as JSON with fields added when asJSON or fields
+// is set; browsers get the error page headed title, message when empty,
+// with details listed below it.
+type uploadError struct {
+	status  int
+	message string
+	asJSON  bool
+	fields  gin.H
+	title   string
+	details []string
+	// retryAfter is the Retry-After header in seconds, 0 for none
+	retryAfter int
+}
+
+func (e *uploadError) Error() string { return e.message }
+
+// body returns the JSON error body of e
+func (e *uploadError) body(c *gin.Context) gin.H {
+	body := errorBody(c, e.message)
+	maps.Copy(body, e.fields)
+	return body
+}
+
+// asUploadError returns err as an uploadError; other errors are answered
+// with 500
+func asUploadError(err error) *uploadError {
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) {
+		return uploadErr
 	}
+	return &uploadError{status: http.StatusInternalServerError, message: err.Error()}
+}
+
+// respondUploadError answers c with the rejected upload err
+func respondUploadError(c *gin.Context, err error) {
+	uploadErr := asUploadError(err)
+	if uploadErr.retryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(uploadErr.retryAfter))
+	}
+	switch {
+	case wantsHTML(c):
+		renderErrorPage(c, uploadErr.status, cmp.Or(uploadErr.title, uploadErr.message), uploadErr.details)
+	case uploadErr.asJSON || uploadErr.fields != nil:
+		c.JSON(uploadErr.status, uploadErr.body(c))
+	default:
+		respondText(c, uploadErr.status, "%s", uploadErr.message)
+	}
+}
 
-	uniqueFilename := fmt.Sprintf("%s-%s-%s-%d.apk", packageName, version, channel, time.Now().Unix())
-	finalSavePath := filepath.Join("uploads", uniqueFilename)
+// publishUpload parses the APK or IPA saved at incomingPath in place, applies the
+// upload policies and moves it into the uploads directory as a new build of
+// req.ProjectName. It leaves the response to the caller: on failure it
+// discards the file and returns an *uploadError, see respondUploadError.
+// c is only read, for logs, webhooks and the audit log, so it may be the
+// detached copy an upload job runs with.
+func publishUpload(c *gin.Context, incomingPath, fileHash string, fileSize int64, req uploadRequest) (uploadResult, error) {
+	projectName, channel := req.ProjectName, req.Channel
+	if msg := uploadProjectError(c, projectName); msg != "" {
+		os.Remove(incomingPath)
+		return uploadResult{}, &uploadError{status: http.StatusForbidden, message: msg}
+	}
+	// Callers tell the platform from the file name, see uploadPlatform
+	if req.Platform == "" {
+		os.Remove(incomingPath)
+		return uploadResult{}, &uploadError{status: http.StatusBadRequest, message: "无法识别安装包的平台"}
+	}
 
-	tempFileBytes, err := os.ReadFile(tempSavePath)
+	// Wait for a parse slot first; a busy server is not a rejected upload,
+	// so the file is not quarantined
+	release, ok := parseSlots.acquire(c)
+	if !ok {
+		os.Remove(incomingPath)
+		warnf(c, "等待解析槽位超时 (%s)", config.ParseQueueTimeout)
+		return uploadResult{}, parseBusyError()
+	}
+	defer release()
+
+	scanStatus, err := scanUpload(c, incomingPath, req)
 	if err != nil {
-		c.String(http.StatusInternalServerError, "无法读取临时文件: %s", err.Error())
-		return
+		return uploadResult{}, err
 	}
-	if err := os.WriteFile(finalSavePath, tempFileBytes, 0644); err != nil {
-		c.String(http.StatusInternalServerError, "无法保存最终文件: %s", err.Error())
-		return
+
+	published := false
+	defer func() {
+		if !published {
+			discardUpload(c, incomingPath)
+		}
+	}()
+
+	// IPAs and HarmonyOS packages carry no resources table and App Bundles
+	// no binary manifest, so pkg stays nil for them
+	var pkg *apk.Apk
+	var details ApkDetails
+	bundle := req.Platform == platformAndroid && isAppBundle(incomingPath)
+	if req.Platform != platformAndroid || bundle {
+		if details, err = parseBuildDetails(incomingPath, fileHash, req.Platform); err != nil {
+			return uploadResult{}, &uploadError{status: http.StatusBadRequest, message: err.Error()}
+		}
+	} else {
+		if pkg, err = apk.OpenFile(incomingPath); err != nil {
+			return uploadResult{}, &uploadError{status: http.StatusInternalServerError, message: "解析APK失败: " + err.Error()}
+		}
+		defer pkg.Close()
+
+		cached := false
+		if details, cached = parseCache.Get(fileHash); !cached {
+			if details, err = extractApkDetails(pkg); err != nil {
+				return uploadResult{}, &uploadError{status: http.StatusInternalServerError, message: err.Error()}
+			}
+			parseCache.Add(fileHash, details)
+		}
+	}
+	platform := details.Platform
+	appName, packageName, version := details.AppName, details.PackageName, details.Version
+
+	if violations := checkUploadPolicy(details, fileSize, channel, req.ReleaseNotes); len(violations) > 0 {
+		var messages []string
+		for _, violation := range violations {
+			messages = append(messages, violation.Message)
+		}
+		return uploadResult{}, &uploadError{status: http.StatusUnprocessableEntity, message: "上传未通过策略检查",
+			fields: gin.H{"violations": violations}, details: messages}
 	}
-	fmt.Printf("文件已保存为: %s\n", finalSavePath)
 
-	icon, err := pkg.Icon(nil)
-	var iconPath string
+	mutex.Lock()
+	err = checkPackagePrefix(projectName, packageName)
+	mutex.Unlock()
 	if err != nil {
-		fmt.Printf("警告: 无法提取应用 '%s' 的图标: %v\n", appName, err)
+		return uploadResult{}, &uploadError{status: http.StatusBadRequest, message: err.Error()}
+	}
+
+	warnings := []string{}
+	releaseNotes, warning := limitReleaseNotes(req.ReleaseNotes)
+	if warning != "" {
+		warnf(c, "%s", warning)
+		warnings = append(warnings, warning)
+	}
+	if warning, reject := detectDowngrade(packageName, platform, channel, details.VersionCode, req.AllowDowngrade); warning != "" {
+		warnf(c, "%s (%s)", warning, packageName)
+		if reject {
+			return uploadResult{}, &uploadError{status: http.StatusConflict, message: warning + "，如确需上传请附加 force=true", asJSON: true,
+				title: warning, details: []string{"如确需上传降级版本，请通过 API 附加 force=true"}}
+		}
+		warnings = append(warnings, warning)
+	}
+
+	// iOS builds are signed by their provisioning profile and HarmonyOS
+	// builds by their own scheme instead
+	var cert *SigningCertificate
+	if req.Platform == platformAndroid {
+		if cert, err = apkSigningCertificate(incomingPath); err != nil {
+			warnf(c, "无法读取 '%s' 的签名证书: %v", appName, err)
+		}
+	}
+	signer := certificateSHA256(cert)
+	if warning := debugSignerWarning(cert); warning != "" {
+		warnf(c, "%s (%s)", warning, packageName)
+		warnings = append(warnings, warning)
+	}
+	if warning, reject := detectSignerChange(packageName, cert, req.AllowSignerChange); warning != "" {
+		warnf(c, "%s (%s)", warning, packageName)
+		if reject {
+			return uploadResult{}, &uploadError{status: http.StatusConflict, message: warning + "，如确需上传请附加 allowSignerChange=true", asJSON: true,
+				title: warning, details: []string{"如确需上传不同签名的构建，请通过 API 附加 allowSignerChange=true"}}
+		}
+		warnings = append(warnings, warning)
+	}
+
+	// Content already stored is listed again rather than stored twice
+	var duplicate *DuplicateBuild
+	if config.DuplicateUploads != duplicateStore {
+		if existing, listed, found := findDuplicate(fileHash, projectName, channel); found {
+			message := fmt.Sprintf("与已有构建 %s（项目 %s，渠道 %s）内容相同", existing.Build.FileName, existing.ProjectName, existing.Build.Channel)
+			warnf(c, "%s", message)
+			if listed || config.DuplicateUploads == duplicateReject {
+				return uploadResult{}, duplicateError(message, existing)
+			}
+			if reusableFile(existing, fileSize) {
+				duplicate = &existing
+				warnings = append(warnings, message+"，已复用其文件")
+			}
+		}
+	}
+
+	// A reused file takes no more space
+	if duplicate == nil {
+		mutex.Lock()
+		err = checkProjectQuota(projectName, fileSize, 0)
+		mutex.Unlock()
+		var quotaErr *quotaExceededError
+		if errors.As(err, &quotaErr) {
+			warnf(c, "%s", quotaErr.Error())
+			return uploadResult{}, quotaError(quotaErr)
+		}
+	}
+
+	// A bundle cannot be installed itself, so testers get a universal APK
+	// built from it, which also provides the icons
+	var universalPath string
+	if bundle && config.BundletoolJar != "" && duplicate == nil {
+		if universalPath, err = buildUniversalAPK(c, incomingPath); err != nil {
+			warning := "无法生成通用 APK，该构建只能下载 AAB: " + err.Error()
+			warnf(c, "%s", warning)
+			warnings = append(warnings, warning)
+		} else {
+			defer os.Remove(universalPath)
+			if pkg, err = apk.OpenFile(universalPath); err != nil {
+				warnf(c, "解析通用 APK 失败: %v", err)
+				pkg = nil
+			} else {
+				defer pkg.Close()
+			}
+		}
+	}
+
+	// Read the icon while the package is still in the incoming directory
+	var icon image.Image
+	var iconErr error
+	switch {
+	case pkg != nil:
+		icon, iconErr = pkg.Icon(nil)
+	case bundle:
+		icon, iconErr = bundleIcon(incomingPath)
+	case req.Platform == platformHarmony:
+		icon, iconErr = harmonyIcon(incomingPath)
+	default:
+		icon, iconErr = ipaIcon(incomingPath)
+	}
+
+	var uniqueFilename, universalAPK string
+	var expansions []ExpansionFile
+	if duplicate != nil {
+		// The new entry shares the file, its universal APK and expansion
+		// files like a promoted entry
+		uniqueFilename, universalAPK, expansions = duplicate.Build.FileName, duplicate.Build.UniversalAPK, duplicate.Build.Expansions
+		os.Remove(incomingPath)
+		logf(c, "复用已存储的文件: %s", uniqueFilename)
+	} else {
+		if uniqueFilename, err = storeBuildFile(incomingPath, buildFileName(details, channel, time.Now())); err != nil {
+			return uploadResult{}, &uploadError{status: http.StatusInternalServerError, message: "无法保存最终文件: " + err.Error()}
+		}
+		logf(c, "文件已保存为: %s", uniqueFilename)
+		if universalPath != "" {
+			if universalAPK, err = storeBuildFile(universalPath, universalAPKName(uniqueFilename)); err != nil {
+				warnf(c, "无法保存通用 APK: %v", err)
+				warnings = append(warnings, "无法保存通用 APK，该构建只能下载 AAB")
+			} else {
+				logf(c, "通用 APK 已保存为: %s", universalAPK)
+			}
+		}
+	}
+
+	var iconPath, newIconHash string
+	var icons map[string]string
+	if iconErr != nil {
+		warnf(c, "无法提取应用 '%s' 的图标: %v", appName, iconErr)
 		iconPath = ""
 	} else {
-		iconDir := filepath.Join("static", "icons")
-		if err := os.MkdirAll(iconDir, 0755); err != nil {
-			c.String(http.StatusInternalServerError, "无法创建图标目录: %s", err.Error())
-			return
-		}
-		relativeIconPath := filepath.Join("static", "icons", fmt.Sprintf("%s.png", packageName))
-		fullIconPath := relativeIconPath
-		iconFile, err := os.Create(fullIconPath)
-		if err != nil {
-			c.String(http.StatusInternalServerError, "无法创建图标文件: %s", err.Error())
-			return
+		// Compare against the stored icon before it is overwritten below
+		newIconHash = iconHash(icon)
+		if warning := detectIconChange(packageName, newIconHash); warning != "" {
+			warnf(c, "%s (%s)", warning, packageName)
+			warnings = append(warnings, warning)
+		}
+
+		if iconPath, err = saveIcon(packageName, icon); err != nil {
+			return uploadResult{}, &uploadError{status: http.StatusInternalServerError, message: err.Error()}
 		}
-		defer iconFile.Close()
-		if err := png.Encode(iconFile, icon); err != nil {
-			c.String(http.StatusInternalServerError, "无法编码图标为PNG: %s", err.Error())
-			return
+		logf(c, "应用图标已保存到: %s", iconPath)
+		if pkg != nil {
+			icons = saveIconDensities(pkg, packageName)
 		}
-		iconPath = filepath.ToSlash(relativeIconPath)
-		fmt.Printf("应用图标已保存到: %s\n", fullIconPath)
 	}
 
-	appInfo := AppInfo{AppName: appName, PackageName: packageName, Version: version, IconPath: iconPath}
+	appInfo := AppInfo{AppName: appName, PackageName: packageName, Version: version, IconPath: iconPath, IconHash: newIconHash, Icons: icons, SignerSHA256: signer}
 	buildInfo := BuildInfo{
+		Platform:     platform,
 		Version:      appInfo.Version,
+		VersionCode:  details.VersionCode,
+		MinSDK:       details.MinSDK,
+		TargetSDK:    details.TargetSDK,
+		MinOSVersion: details.MinOSVersion,
+		Format:       details.Format,
+		UniversalAPK: universalAPK,
+		Expansions:   expansions,
+		Permissions:  details.Permissions,
+		SignerSHA256: signer,
+		Certificate:  cert,
 		Channel:      channel,
 		ReleaseNotes: releaseNotes,
 		FileName:     uniqueFilename,
-		FileSize:     file.Size,
-		UploadTime:   time.Now().Format("2006-01-02 15:04:05"),
+		FileSize:     fileSize,
+		UploadTime:   timestamp(time.Now()),
 		DownloadURL:  fmt.Sprintf("/downloads/%s", uniqueFilename),
+		FileHash:     fileHash,
+		Extra:        req.Extra,
+		ScanStatus:   scanStatus,
 	}
+	if scanStatus != "" {
+		buildInfo.ScannedAt = buildInfo.UploadTime
+	}
+
+	if err := repo.UpsertBuild(projectName, appInfo, buildInfo); err != nil {
+		errorf(c, "更新元数据错误: %v", err)
+		if duplicate == nil {
+			storage.Delete(uniqueFilename)
+			if universalAPK != "" {
+				storage.Delete(universalAPK)
+			}
+		}
+		// The prefix may have been set while the upload was processed
+		var prefixErr *packagePrefixError
+		if errors.As(err, &prefixErr) {
+			return uploadResult{}, &uploadError{status: http.StatusBadRequest, message: err.Error()}
+		}
+		return uploadResult{}, &uploadError{status: http.StatusInternalServerError, message: "更新元数据失败: " + err.Error()}
+	}
+
+	published = true
+	notifyNewBuild(newBuildEvent(requestBaseURL(c), projectName, appInfo, buildInfo))
+	events.publish(CatalogEvent{Type: eventUpload, ProjectName: projectName, PackageName: packageName, FileName: uniqueFilename, Channel: channel})
+	audit.record(c, AuditEntry{Action: auditUpload, ProjectName: projectName, PackageName: packageName, FileName: uniqueFilename, Channel: channel,
+		Detail: fmt.Sprintf("%s (%d)", buildInfo.Version, buildInfo.VersionCode)})
+	return uploadResult{PackageName: packageName, Build: buildInfo, Warnings: warnings}, nil
+}
 
-	if err := updateMetadata(projectName, appInfo, buildInfo); err != nil {
-		fmt.Printf("更新元数据错误: %v\n", err)
-		os.Remove(finalSavePath)
-		c.String(http.StatusInternalServerError, "更新元数据失败: %s", err.Error())
+// handleValidateUpload runs the same parsing and policy checks as
+// handleApiUpload and reports the result, without storing the file or
+// touching the metadata.
+func handleValidateUpload(c *gin.Context) {
+	if errs := validateUploadForm(c); len(errs) > 0 {
+		body := errorBody(c, "表单字段校验失败")
+		body["fields"] = errs
+		c.JSON(http.StatusBadRequest, body)
 		return
 	}
+	channel := strings.TrimSpace(c.PostForm("channel"))
 
-	source := c.PostForm("source")
-	if source == "web" {
-		c.Redirect(http.StatusFound, "/?upload=success")
-	} else {
-		c.JSON(http.StatusOK, gin.H{"message": "Upload successful"})
+	file, err := c.FormFile("file")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "获取表单文件错误: "+err.Error())
+		return
+	}
+	if err := checkUploadFile(file); err != nil {
+		respondError(c, http.StatusBadRequest, "文件检查未通过: "+err.Error())
+		return
 	}
-}
 
-func handleDeleteBuild(c *gin.Context) {
-	if c.Query("password") != deletePassword {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "删除密码错误"})
+	incomingPath, fileHash, err := saveIncomingUpload(c, file)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "保存文件错误: "+err.Error())
 		return
 	}
+	defer os.Remove(incomingPath)
 
-	packageName := c.Param("packageName")
-	fileName := c.Param("fileName")
+	release, ok := parseSlots.acquire(c)
+	if !ok {
+		respondParseBusy(c)
+		return
+	}
+	defer release()
+	details, err := parseBuildDetails(incomingPath, fileHash, uploadPlatform(file.Filename))
+	if err != nil {
+		body := errorBody(c, err.Error())
+		body["valid"] = false
+		c.JSON(http.StatusUnprocessableEntity, body)
+		return
+	}
 
+	violations := checkUploadPolicy(details, file.Size, channel, c.PostForm("releaseNotes"))
 	mutex.Lock()
-	defer mutex.Unlock()
+	err = checkPackagePrefix(strings.TrimSpace(c.PostForm("projectName")), details.PackageName)
+	mutex.Unlock()
+	if err != nil {
+		violations = append(violations, PolicyViolation{Rule: "packagePrefix", Message: err.Error()})
+	}
+	if violations == nil {
+		violations = []PolicyViolation{}
+	}
+	warnings := []string{}
+	if warning, _ := detectDowngrade(details.PackageName, details.Platform, channel, details.VersionCode, false); warning != "" {
+		warnings = append(warnings, warning)
+	}
+	cert, err := apkSigningCertificate(incomingPath)
+	if err != nil {
+		warnf(c, "无法读取签名证书: %v", err)
+	}
+	if warning := debugSignerWarning(cert); warning != "" {
+		warnings = append(warnings, warning)
+	}
+	if warning, _ := detectSignerChange(details.PackageName, cert, false); warning != "" {
+		warnings = append(warnings, warning)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"valid":       len(violations) == 0,
+		"metadata":    details,
+		"fileSize":    file.Size,
+		"fileHash":    fileHash,
+		"signer":      certificateSHA256(cert),
+		"certificate": cert,
+		"fileName":    buildFileName(details, channel, time.Now()),
+		"violations":  violations,
+		"warnings":    warnings,
+	})
+}
 
-	var appEntry *AppEntry
-	var project *Project
-	var buildFound bool
-
-	// Find the build and remove it
-	for i := range allProjects {
-		for j := range allProjects[i].Apps {
-			if allProjects[i].Apps[j].PackageName == packageName {
-				project = &allProjects[i]
-				appEntry = &allProjects[i].Apps[j]
-
-				newBuilds := []BuildInfo{}
-				for _, build := range appEntry.Builds {
-					if build.FileName == fileName {
-						buildFound = true
-					} else {
-						newBuilds = append(newBuilds, build)
-					}
-				}
-				appEntry.Builds = newBuilds
-				break
-			}
-		}
-		if appEntry != nil {
+// handleGetBuildByHash looks up a build by the SHA-256 of its file. When the
+// file is listed more than once it answers with the earliest upload the
+// caller can see.
+func handleGetBuildByHash(c *gin.Context) {
+	hash := strings.ToLower(c.Param("hash"))
+	if !isSHA256Hex(hash) {
+		respondError(c, http.StatusBadRequest, "哈希格式错误，应为 64 位十六进制 SHA-256")
+		return
+	}
+
+	var entry hashIndexEntry
+	ok := false
+	mutex.Lock()
+	for _, candidate := range buildsByHash[hash] {
+		if projectVisible(c, candidate.ProjectName) {
+			entry, ok = candidate, true
 			break
 		}
 	}
+	mutex.Unlock()
 
-	if !buildFound {
-		c.JSON(http.StatusNotFound, gin.H{"error": "构建版本未找到"})
+	if !ok {
+		respondError(c, http.StatusNotFound, "未找到匹配该哈希的构建版本")
 		return
 	}
+	entry.Build.ReleaseNotesHTML = notesHTML(entry.Build.ReleaseNotes)
+	c.JSON(http.StatusOK, entry)
+}
 
-	// If the app has no more builds, remove the app itself
-	if len(appEntry.Builds) == 0 {
-		newApps := []AppEntry{}
-		for _, app := range project.Apps {
-			if app.PackageName != packageName {
-				newApps = append(newApps, app)
-			}
-		}
-		project.Apps = newApps
+func handleDeleteBuild(c *gin.Context) {
+	packageName := c.Param("packageName")
+	fileName := c.Param("fileName")
+	opts := DeleteOptions{
+		// A promoted file is listed once per channel; channel narrows the
+		// delete to a single entry, otherwise every entry for the file is removed.
+		Channel: c.Query("channel"),
+		// keepApp retains the app entry and its icon once its last build is gone
+		KeepApp: config.KeepEmptyApps,
+		DryRun:  isDryRun(c.Query("dryRun")),
+		Force:   isForced(c.Query("force")),
+	}
+	if value := c.Query("keepApp"); value != "" {
+		opts.KeepApp = value == "true" || value == "1"
 	}
 
-	// Save metadata changes
-	if err := saveMetadata(); err != nil {
-		// This is tricky, a rollback would be complex. For now, log and return error.
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新元数据失败"})
+	projectName, app, _ := repo.FindApp(packageName)
+	if !checkProjectAdmin(c, projectName) {
 		return
 	}
-
-	// Delete the physical file
-	filePath := filepath.Join("uploads", fileName)
-	if err := os.Remove(filePath); err != nil {
-		fmt.Printf("警告: 删除文件 %s 失败: %v\n", filePath, err)
-		// Don't fail the whole request, but log it.
+	hooks := projectWebhooks(projectName)
+	plan, err := repo.DeleteBuild(packageName, fileName, opts)
+	if err != nil {
+		respondRepositoryError(c, err)
+		return
+	}
+	if opts.DryRun {
+		c.JSON(http.StatusOK, gin.H{"message": "预演: 未删除任何内容", "dryRun": true, "plan": plan})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "构建版本已删除"})
+	// Delete the physical file once no remaining build references it
+	applyDeletePlan(c, plan)
+	events.publish(CatalogEvent{Type: eventDelete, PackageName: packageName, FileName: fileName, Channel: opts.Channel})
+	audit.record(c, AuditEntry{Action: auditDeleteBuild, ProjectName: projectName, PackageName: packageName, FileName: fileName, Channel: opts.Channel,
+		Detail: forcedDetail(plan)})
+	notifyDelete(hooks, projectName, app, plan, false)
+	c.JSON(http.StatusOK, gin.H{"message": "构建版本已删除", "appRemoved": plan.AppRemoved})
 }
 
-func handleDeleteApp(c *gin.Context) {
-	if c.Query("password") != deletePassword {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "删除密码错误"})
+// Conflicts refusing a promotion
+var (
+	errAlreadyInChannel = errors.New("该构建版本已在目标渠道中")
+	errFileShared       = errors.New("该文件仍被其他渠道或项目的条目引用，无法重命名")
+)
+
+// promoteRequest is the JSON body accepted by handlePromoteBuild
+type promoteRequest struct {
+	Channel string `json:"channel"`
+	// From picks the source entry of a file listed in several channels;
+	// empty takes the newest
+	From string `json:"from"`
+	// Move removes the source entry instead of keeping it
+	Move bool `json:"move"`
+	// Rename stores the file under the name an upload to the target channel
+	// would get; it requires Move and no other entry, of any project,
+	// sharing the file
+	Rename bool `json:"rename"`
+}
+
+// handlePromoteBuild lists an existing build under another channel without
+// re-uploading it. The new entry shares the original file rather than
+// copying it; deletes only remove the file once no entry references it.
+// With "move" the source entry goes away, and "rename" then also renames
+// the file after the target channel, which changes its download URL.
+func handlePromoteBuild(c *gin.Context) {
+	if !checkAdmin(c) {
 		return
 	}
 
 	packageName := c.Param("packageName")
+	fileName := c.Param("fileName")
 
-	mutex.Lock()
-	defer mutex.Unlock()
-
-	var project *Project
-	var appFound bool
-	var buildsToDelete []BuildInfo
+	var req promoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "请求体格式错误: "+err.Error())
+		return
+	}
+	targetChannel := strings.TrimSpace(req.Channel)
+	if targetChannel == "" {
+		respondError(c, http.StatusBadRequest, "目标渠道不能为空")
+		return
+	}
+	if req.Rename && !req.Move {
+		respondError(c, http.StatusBadRequest, "rename 需要同时指定 move")
+		return
+	}
+	// The channel becomes part of the file name
+	if message := channelError(targetChannel); req.Rename && message != "" {
+		respondError(c, http.StatusBadRequest, message)
+		return
+	}
 
-	for i := range allProjects {
-		newApps := []AppEntry{}
-		for _, app := range allProjects[i].Apps {
-			if app.PackageName == packageName {
-				project = &allProjects[i]
-				appFound = true
-				buildsToDelete = app.Builds
-			} else {
-				newApps = append(newApps, app)
+	var source, promoted BuildInfo
+	var renameErr error
+	undoRename := func() {}
+	projectName, _, err := repo.UpdateApp(packageName, func(app *AppEntry) error {
+		sourceIndex, entries := -1, 0
+		for k, build := range app.Builds {
+			if build.FileName != fileName {
+				continue
+			}
+			entries++
+			if build.Channel == targetChannel {
+				return errAlreadyInChannel
+			}
+			if sourceIndex < 0 && (req.From == "" || build.Channel == req.From) {
+				sourceIndex = k
 			}
 		}
-		if appFound {
-			allProjects[i].Apps = newApps
-			break
+		if sourceIndex < 0 {
+			return errBuildNotFound
+		}
+		// Duplicate uploads of other apps and projects reference the file
+		// too, so count the whole catalog rather than this app
+		if req.Rename && (entries > 1 || fileReferenceCount(fileName) > 1) {
+			return errFileShared
 		}
-	}
 
-	if !appFound {
-		c.JSON(http.StatusNotFound, gin.H{"error": "应用未找到"})
-		return
-	}
+		source = app.Builds[sourceIndex]
+		promoted = source
+		promoted.PromotedFrom = source.Channel
+		promoted.Channel = targetChannel
+		promoted.UploadTime = timestamp(time.Now())
+		if req.Rename {
+			var renamed BuildInfo
+			if renamed, undoRename, renameErr = renameBuildFile(packageName, promoted, targetChannel); renameErr != nil {
+				return renameErr
+			}
+			promoted = renamed
+		}
 
-	// If the project has no more apps, remove the project itself
-	if len(project.Apps) == 0 {
-		newProjects := []Project{}
-		for _, p := range allProjects {
-			if p.ProjectName != project.ProjectName {
-				newProjects = append(newProjects, p)
+		builds := []BuildInfo{promoted}
+		for k, build := range app.Builds {
+			if !req.Move || k != sourceIndex {
+				builds = append(builds, build)
 			}
 		}
-		allProjects = newProjects
+		app.Builds = builds
+		return nil
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, errAlreadyInChannel), errors.Is(err, errFileShared):
+		respondError(c, http.StatusConflict, err.Error())
+		return
+	case renameErr != nil:
+		warnf(c, "重命名 %s 失败: %v", fileName, renameErr)
+		respondError(c, http.StatusInternalServerError, "重命名构建文件失败")
+		return
+	default:
+		undoRename()
+		respondRepositoryError(c, err)
+		return
 	}
+	if req.Rename {
+		stats.rename(fileName, promoted.FileName)
+		logf(c, "构建文件 %s 已重命名为 %s", fileName, promoted.FileName)
+	}
+
+	events.publish(CatalogEvent{Type: eventPromote, ProjectName: projectName, PackageName: packageName, FileName: promoted.FileName, Channel: targetChannel})
+	audit.record(c, AuditEntry{Action: auditPromote, ProjectName: projectName, PackageName: packageName, FileName: promoted.FileName, Channel: targetChannel,
+		Detail: fmt.Sprintf("from %s %s, move=%t", source.Channel, fileName, req.Move)})
+	c.JSON(http.StatusOK, gin.H{"message": "构建版本已推广", "build": promoted, "moved": req.Move})
+}
 
-	if err := saveMetadata(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新元数据失败"})
+func handleDeleteApp(c *gin.Context) {
+	packageName := c.Param("packageName")
+	dryRun := isDryRun(c.Query("dryRun"))
+	force := isForced(c.Query("force"))
+	projectName, app, _ := repo.FindApp(packageName)
+	if !checkProjectAdmin(c, projectName) {
 		return
 	}
-
-	// Delete all associated files
-	for _, build := range buildsToDelete {
-		filePath := filepath.Join("uploads", build.FileName)
-		if err := os.Remove(filePath); err != nil {
-			fmt.Printf("警告: 删除文件 %s 失败: %v\n", filePath, err)
-		}
+	hooks := projectWebhooks(projectName)
+	plan, err := repo.DeleteApp(packageName, dryRun, force)
+	if err != nil {
+		respondRepositoryError(c, err)
+		return
 	}
-	// Also delete the icon
-	iconPath := filepath.Join("static", "icons", fmt.Sprintf("%s.png", packageName))
-	if err := os.Remove(iconPath); err != nil {
-		fmt.Printf("警告: 删除图标 %s 失败: %v\n", iconPath, err)
+	if dryRun {
+		c.JSON(http.StatusOK, gin.H{"message": "预演: 未删除任何内容", "dryRun": true, "plan": plan})
+		return
 	}
 
+	// Delete all associated files that are no longer referenced, and the icon
+	applyDeletePlan(c, plan)
+	events.publish(CatalogEvent{Type: eventDelete, PackageName: packageName})
+	audit.record(c, AuditEntry{Action: auditDeleteApp, ProjectName: projectName, PackageName: packageName, Detail: forcedDetail(plan)})
+	notifyDelete(hooks, projectName, app, plan, true)
 	c.JSON(http.StatusOK, gin.H{"message": "应用已删除"})
 }
 
 // --- Metadata Logic ---
 
-func updateMetadata(projectName string, appInfo AppInfo, newBuild BuildInfo) error {
-	mutex.Lock()
-	defer mutex.Unlock()
+// rebuildIndexes regenerates every in-memory lookup structure derived from
+// allProjects. The caller must hold the mutex.
+func rebuildIndexes() {
+	rebuildHashIndex()
+	rebuildSearchIndex()
+}
 
-	var project *Project
-	for i := range allProjects {
-		if allProjects[i].ProjectName == projectName {
-			project = &allProjects[i]
-			break
+// rebuildHashIndex regenerates buildsByHash from allProjects.
+// The caller must hold the mutex.
+func rebuildHashIndex() {
+	index := make(map[string][]hashIndexEntry)
+	for _, project := range allProjects {
+		for _, app := range project.Apps {
+			for _, build := range app.Builds {
+				if build.FileHash == "" {
+					continue
+				}
+				index[build.FileHash] = append(index[build.FileHash], hashIndexEntry{
+					ProjectName: project.ProjectName,
+					AppName:     app.AppName,
+					PackageName: app.PackageName,
+					Build:       build,
+				})
+			}
 		}
 	}
-	if project == nil {
-		newProject := Project{ProjectName: projectName, Apps: []AppEntry{}}
-		allProjects = append(allProjects, newProject)
-		project = &allProjects[len(allProjects)-1]
+	for _, entries := range index {
+		sort.SliceStable(entries, func(i, j int) bool {
+			a, b := entries[i], entries[j]
+			if a.Build.UploadTime != b.Build.UploadTime {
+				return a.Build.UploadTime < b.Build.UploadTime
+			}
+			if a.ProjectName != b.ProjectName {
+				return a.ProjectName < b.ProjectName
+			}
+			if a.PackageName != b.PackageName {
+				return a.PackageName < b.PackageName
+			}
+			return a.Build.Channel < b.Build.Channel
+		})
 	}
+	buildsByHash = index
+}
 
-	var appEntry *AppEntry
-	for i := range project.Apps {
-		if project.Apps[i].PackageName == appInfo.PackageName {
-			appEntry = &project.Apps[i]
-			break
+// buildFileName returns the stored file name for a build of details in channel
+func buildFileName(details ApkDetails, channel string, uploadedAt time.Time) string {
+	return fmt.Sprintf("%s-%s-%s-%d%s", details.PackageName, details.Version, channel, uploadedAt.Unix(), packageExt(details))
+}
+
+// storeBuildFile moves the accepted upload at incomingPath into the storage
+// under fileName and returns the name actually used. Names are reserved
+// exclusively, so concurrent uploads of the same version and channel within
+// one second get a numeric suffix instead of overwriting each other.
+// In the local uploads directory the reserved name is then replaced by
+// renaming the upload over it, so the package is never copied; only when the
+// rename fails, e.g. because the incoming directory is on another file
+// system, is it copied instead.
+func storeBuildFile(incomingPath, fileName string) (string, error) {
+	ext := filepath.Ext(fileName)
+	base := strings.TrimSuffix(fileName, ext)
+	for n := 1; ; n++ {
+		name := fileName
+		if n > 1 {
+			name = fmt.Sprintf("%s-%d%s", base, n, ext)
 		}
-	}
-	if appEntry == nil {
-		newAppEntry := AppEntry{
-			AppName:     appInfo.AppName,
-			PackageName: appInfo.PackageName,
-			IconPath:    appInfo.IconPath,
-			Builds:      []BuildInfo{},
+		path, local := localStoragePath(name)
+		if !local {
+			err := putIncoming(incomingPath, name)
+			if errors.Is(err, os.ErrExist) {
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+			os.Remove(incomingPath)
+			return name, nil
 		}
-		project.Apps = append(project.Apps, newAppEntry)
-		appEntry = &project.Apps[len(project.Apps)-1]
-	} else {
-		appEntry.AppName = appInfo.AppName
-		if appInfo.IconPath != "" {
-			appEntry.IconPath = appInfo.IconPath
+		dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := os.Rename(incomingPath, path); err == nil {
+			dst.Close()
+			os.Chmod(path, 0644)
+			return name, nil
+		}
+		err = copyIncoming(incomingPath, dst)
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(path)
+			return "", err
 		}
+		os.Remove(incomingPath)
+		return name, nil
+	}
+}
+
+// putIncoming uploads the file at src to the storage as name
+func putIncoming(src, name string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("无法读取暂存文件: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return storage.Put(name, f, info.Size())
+}
+
+// copyIncoming copies the file at src into dst
+func copyIncoming(src string, dst io.Writer) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("无法读取暂存文件: %w", err)
+	}
+	defer f.Close()
+	_, err = io.Copy(dst, f)
+	return err
+}
+
+// hashFile returns the hex-encoded SHA-256 of the file at path
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
 	}
+	defer f.Close()
+	return hashReader(f)
+}
+
+// hashReader returns the hex-encoded SHA-256 of everything read from r
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func isSHA256Hex(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
 
-	appEntry.Builds = append([]BuildInfo{newBuild}, appEntry.Builds...)
+// packageExists reports whether any project still lists packageName.
+// The caller must hold the mutex.
+func packageExists(packageName string) bool {
+	for _, project := range allProjects {
+		for _, app := range project.Apps {
+			if app.PackageName == packageName {
+				return true
+			}
+		}
+	}
+	return false
+}
 
-	return saveMetadata()
+// fileReferenceCount returns how many builds in the catalog still point at the
+// physical file fileName. Builds that share a file (e.g. promoted entries)
+// always share FileName, so it is the key for reference counting.
+// The caller must hold the mutex.
+func fileReferenceCount(fileName string) int {
+	count := 0
+	for _, project := range allProjects {
+		for _, app := range project.Apps {
+			for _, build := range app.Builds {
+				if build.FileName == fileName {
+					count++
+				}
+			}
+		}
+	}
+	return count
 }
 
 // --- Template Helper Functions ---
@@ -533,6 +1473,11 @@ func formatSize(size int64) string {
 	}
 }
 
+// installURL returns the tracked install link of a build
+func installURL(packageName, fileName string) string {
+	return "/api/apps/" + url.PathEscape(packageName) + "/install?fileName=" + url.QueryEscape(fileName)
+}
+
 func first(s string) string {
 	if len(s) > 0 {
 		return string([]rune(s)[0])
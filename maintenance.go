@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultMaintenanceMessage is shown when maintenance is enabled without one
+const defaultMaintenanceMessage = "系统维护中，暂时无法上传或删除，应用仍可浏览和下载"
+
+// MaintenanceState is the read-only mode toggle. While enabled, routes that
+// change the catalog answer 503 and read routes keep working.
+type MaintenanceState struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+	Since   string `json:"since,omitempty"`
+}
+
+type maintenanceMode struct {
+	mu    sync.Mutex
+	state MaintenanceState
+}
+
+var maintenance maintenanceMode
+
+func (m *maintenanceMode) set(enabled bool, message string) MaintenanceState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !enabled {
+		m.state = MaintenanceState{}
+		return m.state
+	}
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	since := m.state.Since
+	if !m.state.Enabled {
+		since = timestamp(time.Now())
+	}
+	m.state = MaintenanceState{Enabled: true, Message: message, Since: since}
+	return m.state
+}
+
+func (m *maintenanceMode) get() MaintenanceState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+// rejectDuringMaintenance guards write routes with a 503 while the server is
+// in read-only mode
+func rejectDuringMaintenance() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if state := maintenance.get(); state.Enabled {
+			c.Header("Retry-After", "300")
+			if wantsHTML(c) {
+				renderErrorPage(c, http.StatusServiceUnavailable, state.Message, nil)
+			} else {
+				respondError(c, http.StatusServiceUnavailable, state.Message)
+			}
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// handleSetMaintenance toggles read-only mode with {"enabled": true, "message": "..."}
+func handleSetMaintenance(c *gin.Context) {
+	var req struct {
+		Enabled *bool  `json:"enabled"`
+		Message string `json:"message"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Enabled == nil {
+		respondError(c, http.StatusBadRequest, "请求体格式错误，需要 enabled 字段")
+		return
+	}
+	state := maintenance.set(*req.Enabled, req.Message)
+	logf(c, "维护模式已%s", map[bool]string{true: "开启", false: "关闭"}[state.Enabled])
+	c.JSON(http.StatusOK, state)
+}
+
+func handleGetMaintenance(c *gin.Context) {
+	c.JSON(http.StatusOK, maintenance.get())
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// manifestSchemaVersion is bumped on any incompatible change to the MDM
+// manifest. Adding optional fields does not require a new version.
+const manifestSchemaVersion = 1
+
+// Manifest is the MDM interop document served at /api/manifest.json.
+// Its shape is a public contract, deliberately separate from the internal
+// Project/AppEntry/BuildInfo JSON so refactors cannot break consumers.
+type Manifest struct {
+	SchemaVersion int           `json:"schemaVersion"`
+	GeneratedAt   string        `json:"generatedAt"` // RFC 3339
+	Apps          []ManifestApp `json:"apps"`
+}
+
+// ManifestApp is one application, identified by its package name
+type ManifestApp struct {
+	AppID    string            `json:"appId"`
+	Name     string            `json:"name"`
+	Platform string            `json:"platform"` // "android", "ios", "harmonyos", or "multi" with builds of several
+	Project  string            `json:"project"`
+	IconURL  string            `json:"iconUrl,omitempty"`
+	Versions []ManifestVersion `json:"versions"` // newest first
+}
+
+// ManifestVersion is one downloadable build of an app
+type ManifestVersion struct {
+	Version     string `json:"version"`
+	VersionCode int32  `json:"versionCode,omitempty"`
+	Channel     string `json:"channel"`
+	// MinOSVersion is the Android API level (minSdkVersion), omitted for
+	// iOS builds and builds uploaded before it was recorded
+	MinOSVersion int32  `json:"minOsVersion,omitempty"`
+	SizeBytes    int64  `json:"sizeBytes"`
+	SHA256       string `json:"sha256,omitempty"`
+	DownloadURL  string `json:"downloadUrl"`
+	UploadedAt   string `json:"uploadedAt,omitempty"` // RFC 3339
+	// Platform is "android", "ios" or "harmonyos"; MinIOSVersion is the
+	// MinimumOSVersion of an iOS build, e.g. "13.0". MinOSVersion of a
+	// HarmonyOS build is its minimum API version.
+	Platform      string `json:"platform"`
+	MinIOSVersion string `json:"minIosVersion,omitempty"`
+}
+
+// buildManifest converts the projects of the catalog c may see into the MDM
+// manifest, with absolute URLs below the request's base URL and apps in the
+// given catalog order
+func buildManifest(c *gin.Context, order string) Manifest {
+	baseURL := requestBaseURL(c)
+	manifest := Manifest{
+		SchemaVersion: manifestSchemaVersion,
+		GeneratedAt:   time.Now().Format(time.RFC3339),
+		Apps:          []ManifestApp{},
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, project := range allProjects {
+		if !canViewProject(c, project) {
+			continue
+		}
+		for _, app := range project.Apps {
+			entry := ManifestApp{
+				AppID:    app.PackageName,
+				Name:     app.AppName,
+				Platform: appPlatform(app),
+				Project:  project.ProjectName,
+				Versions: []ManifestVersion{},
+			}
+			if app.IconPath != "" {
+				entry.IconURL = baseURL + "/" + app.IconPath
+			}
+			for _, build := range app.Builds {
+				version := ManifestVersion{
+					Version:      build.Version,
+					VersionCode:  build.VersionCode,
+					Channel:      build.Channel,
+					MinOSVersion: build.MinSDK,
+					SizeBytes:    build.FileSize,
+					SHA256:       build.FileHash,
+					DownloadURL:  baseURL + build.DownloadURL,
+				}
+				version.Platform = buildPlatform(build)
+				switch version.Platform {
+				case platformIOS:
+					version.MinIOSVersion = build.MinOSVersion
+				case platformHarmony:
+					if api, err := strconv.Atoi(build.MinOSVersion); err == nil {
+						version.MinOSVersion = int32(api)
+					}
+				}
+				if uploadedAt, err := parseTimestamp(build.UploadTime); err == nil {
+					version.UploadedAt = timestamp(uploadedAt)
+				}
+				entry.Versions = append(entry.Versions, version)
+			}
+			manifest.Apps = append(manifest.Apps, entry)
+		}
+	}
+	sortManifestApps(manifest.Apps, order)
+	return manifest
+}
+
+func handleManifest(c *gin.Context) {
+	c.JSON(http.StatusOK, buildManifest(c, parseCatalogSort(c.Query("sort"))))
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Release notes are written by whoever may upload, so they are rendered by
+// a small Markdown subset that never passes HTML through: every character of
+// the source is escaped, the only tags in the output are the ones emitted
+// below, and links are limited to safe URL schemes. Raw HTML in the notes
+// shows up as text.
+//
+// Supported: paragraphs (single line breaks are kept), # headings, "-", "*"
+// and "1." lists (nested by indentation), > quotes, ``` code blocks, ---
+// rules, and inline `code`, **bold**, *italic*, [links](https://...).
+
+// markdownMaxDepth bounds the nesting of quotes and lists
+const markdownMaxDepth = 8
+
+var (
+	mdHeading     = regexp.MustCompile(`^(#{1,6})\s+(.*?)(?:\s+#+)?\s*$`)
+	mdRule        = regexp.MustCompile(`^(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	mdBullet      = regexp.MustCompile(`^[-*+]\s+`)
+	mdOrdered     = regexp.MustCompile(`^(\d{1,9})[.)]\s+`)
+	mdFence       = regexp.MustCompile("^(```|~~~)")
+	mdQuote       = regexp.MustCompile(`^>\s?`)
+	mdSafeSchemes = []string{"http", "https", "mailto"}
+)
+
+// mdEscapable lists the characters a backslash makes literal
+const mdEscapable = "\\`*_{}[]()#+-.!<>|~"
+
+// renderMarkdown turns release notes into sanitized HTML for templates and
+// the notes API. With config.MarkdownNotes off the notes are shown as plain
+// text with their line breaks.
+func renderMarkdown(source string) template.HTML {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	var b strings.Builder
+	if !config.MarkdownNotes {
+		b.WriteString(`<p>`)
+		b.WriteString(strings.ReplaceAll(html.EscapeString(strings.TrimSpace(source)), "\n", "<br>\n"))
+		b.WriteString(`</p>`)
+		return template.HTML(b.String())
+	}
+	renderBlocks(&b, strings.Split(source, "\n"), false, 0)
+	return template.HTML(b.String())
+}
+
+// renderBlocks writes the block elements of lines. In a tight list item the
+// paragraphs are written without <p>.
+func renderBlocks(b *strings.Builder, lines []string, tight bool, depth int) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			i++
+
+		case mdFence.MatchString(trimmed):
+			fence := trimmed[:3]
+			end := i + 1
+			for end < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[end]), fence) {
+				end++
+			}
+			b.WriteString("<pre><code>")
+			b.WriteString(html.EscapeString(strings.Join(lines[i+1:end], "\n")))
+			b.WriteString("</code></pre>\n")
+			i = end + 1
+
+		case mdHeading.MatchString(trimmed):
+			m := mdHeading.FindStringSubmatch(trimmed)
+			// Notes sit below the page's own headings
+			tag := "h" + strconv.Itoa(min(len(m[1])+2, 6))
+			b.WriteString("<" + tag + ">")
+			renderInline(b, m[2])
+			b.WriteString("</" + tag + ">\n")
+			i++
+
+		case mdRule.MatchString(trimmed):
+			b.WriteString("<hr>\n")
+			i++
+
+		case depth < markdownMaxDepth && mdQuote.MatchString(trimmed):
+			var quoted []string
+			for ; i < len(lines) && mdQuote.MatchString(strings.TrimSpace(lines[i])); i++ {
+				quoted = append(quoted, mdQuote.ReplaceAllString(strings.TrimSpace(lines[i]), ""))
+			}
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, quoted, false, depth+1)
+			b.WriteString("</blockquote>\n")
+
+		case depth < markdownMaxDepth && (mdBullet.MatchString(trimmed) || mdOrdered.MatchString(trimmed)):
+			i = renderList(b, lines, i, depth)
+
+		default:
+			end := i + 1
+			for end < len(lines) && !startsBlock(lines[end]) {
+				end++
+			}
+			if !tight {
+				b.WriteString("<p>")
+			}
+			for k, text := range lines[i:end] {
+				if k > 0 {
+					b.WriteString("<br>\n")
+				}
+				renderInline(b, strings.TrimSpace(text))
+			}
+			if !tight {
+				b.WriteString("</p>")
+			}
+			b.WriteString("\n")
+			i = end
+		}
+	}
+}
+
+// startsBlock reports whether line ends a paragraph
+func startsBlock(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || mdFence.MatchString(trimmed) || mdHeading.MatchString(trimmed) ||
+		mdRule.MatchString(trimmed) || mdQuote.MatchString(trimmed) ||
+		mdBullet.MatchString(trimmed) || mdOrdered.MatchString(trimmed)
+}
+
+// renderList writes the list starting at lines[start] and returns the index
+// of the first line after it. Lines indented deeper than an item's marker
+// belong to that item, which is how lists nest.
+func renderList(b *strings.Builder, lines []string, start, depth int) int {
+	indent := leadingSpaces(lines[start])
+	marker := mdBullet
+	tag := "ul"
+	if !mdBullet.MatchString(strings.TrimSpace(lines[start])) {
+		marker, tag = mdOrdered, "ol"
+	}
+
+	b.WriteString("<" + tag)
+	if tag == "ol" {
+		if m := mdOrdered.FindStringSubmatch(strings.TrimSpace(lines[start])); m[1] != "1" {
+			n, _ := strconv.Atoi(m[1])
+			b.WriteString(` start="` + strconv.Itoa(n) + `"`)
+		}
+	}
+	b.WriteString(">\n")
+
+	i := start
+	for i < len(lines) {
+		trimmed := strings.TrimSpace(lines[i])
+		if leadingSpaces(lines[i]) != indent || !marker.MatchString(trimmed) {
+			break
+		}
+		item := []string{marker.ReplaceAllString(trimmed, "")}
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			if strings.TrimSpace(line) == "" {
+				// A blank line only continues the item if indented text follows
+				if i+1 < len(lines) && leadingSpaces(lines[i+1]) > indent && strings.TrimSpace(lines[i+1]) != "" {
+					item = append(item, "")
+					continue
+				}
+				break
+			}
+			if leadingSpaces(line) <= indent && startsBlock(line) {
+				break
+			}
+			item = append(item, dedent(line, indent+2))
+		}
+		b.WriteString("<li>")
+		renderBlocks(b, item, true, depth+1)
+		b.WriteString("</li>\n")
+		// Blank lines between items of the same list
+		for i < len(lines) && strings.TrimSpace(lines[i]) == "" &&
+			i+1 < len(lines) && leadingSpaces(lines[i+1]) == indent && marker.MatchString(strings.TrimSpace(lines[i+1])) {
+			i++
+		}
+	}
+	b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// leadingSpaces counts the indentation of line, a tab counting as four
+func leadingSpaces(line string) int {
+	n := 0
+	for _, r := range line {
+		switch r {
+		case ' ':
+			n++
+		case '\t':
+			n += 4
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// dedent removes up to n columns of indentation from line
+func dedent(line string, n int) string {
+	for n > 0 && line != "" && (line[0] == ' ' || line[0] == '\t') {
+		if line[0] == '\t' {
+			n -= 4
+		} else {
+			n--
+		}
+		line = line[1:]
+	}
+	return line
+}
+
+// renderInline writes the inline elements of text, escaping everything else
+func renderInline(b *strings.Builder, text string) {
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte(mdEscapable, text[i+1]) >= 0:
+			b.WriteString(html.EscapeString(text[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			if end := strings.IndexByte(text[i+1:], '`'); end > 0 {
+				b.WriteString("<code>")
+				b.WriteString(html.EscapeString(text[i+1 : i+1+end]))
+				b.WriteString("</code>")
+				i += end + 2
+				continue
+			}
+
+		case (c == '*' || c == '_') && strings.HasPrefix(text[i:], strings.Repeat(string(c), 2)):
+			delim := text[i : i+2]
+			if end := strings.Index(text[i+2:], delim); end > 0 && emphasisBoundary(text, i, i+2+end+2, c) {
+				b.WriteString("<strong>")
+				renderInline(b, text[i+2:i+2+end])
+				b.WriteString("</strong>")
+				i += end + 4
+				continue
+			}
+
+		case c == '*' || c == '_':
+			if end := strings.IndexByte(text[i+1:], c); end > 0 && emphasisBoundary(text, i, i+1+end+1, c) {
+				b.WriteString("<em>")
+				renderInline(b, text[i+1:i+1+end])
+				b.WriteString("</em>")
+				i += end + 2
+				continue
+			}
+
+		case c == '[':
+			if label, href, n, ok := parseLink(text[i:]); ok {
+				b.WriteString(`<a href="` + html.EscapeString(href) + `" rel="nofollow noopener noreferrer" target="_blank">`)
+				renderInline(b, label)
+				b.WriteString("</a>")
+				i += n
+				continue
+			}
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		b.WriteString(html.EscapeString(text[i : i+size]))
+		i += size
+	}
+}
+
+// emphasisBoundary keeps underscores inside words, as in snake_case names,
+// from starting emphasis; start and end delimit the whole emphasized span
+func emphasisBoundary(text string, start, end int, delim byte) bool {
+	if delim != '_' {
+		return true
+	}
+	isWord := func(b byte) bool {
+		return b == '_' || b >= 0x80 || unicode.IsLetter(rune(b)) || unicode.IsDigit(rune(b))
+	}
+	return (start == 0 || !isWord(text[start-1])) && (end >= len(text) || !isWord(text[end]))
+}
+
+// parseLink parses "[label](url)" at the start of text and returns its parts
+// and length. Links with unsafe URLs are not links, so they stay text.
+func parseLink(text string) (string, string, int, bool) {
+	closeLabel := strings.Index(text, "](")
+	if closeLabel < 1 {
+		return "", "", 0, false
+	}
+	closeURL := strings.IndexByte(text[closeLabel+2:], ')')
+	if closeURL < 0 {
+		return "", "", 0, false
+	}
+	href := strings.TrimSpace(text[closeLabel+2 : closeLabel+2+closeURL])
+	if !safeLinkURL(href) {
+		return "", "", 0, false
+	}
+	return text[1:closeLabel], href, closeLabel + 2 + closeURL + 1, true
+}
+
+// safeLinkURL allows http, https and mailto links and relative ones, and
+// rejects javascript:, data: and every other scheme
+func safeLinkURL(href string) bool {
+	if href == "" || strings.ContainsAny(href, " \t\n<>\"'`") {
+		return false
+	}
+	// A colon after the path starts is not a scheme
+	colon := strings.IndexByte(href, ':')
+	if path := strings.IndexAny(href, "/?#"); colon < 0 || (path >= 0 && path < colon) {
+		return true
+	}
+	scheme := strings.ToLower(href[:colon])
+	for _, safe := range mdSafeSchemes {
+		if scheme == safe {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	cases := []struct {
+		source string
+		want   string
+	}{
+		{"修复了崩溃\n优化启动速度", "<p>修复了崩溃<br>\n优化启动速度</p>\n"},
+		{"## 新功能", "<h4>新功能</h4>\n"},
+		{"- 一\n- **二**\n  - 三", "<ul>\n<li>一\n</li>\n<li><strong>二</strong>\n<ul>\n<li>三\n</li>\n</ul>\n</li>\n</ul>\n"},
+		{"3. c\n4. d", "<ol start=\"3\">\n<li>c\n</li>\n<li>d\n</li>\n</ol>\n"},
+		{"> 注意", "<blockquote>\n<p>注意</p>\n</blockquote>\n"},
+		{"```\n<b>x</b>\n```", "<pre><code>&lt;b&gt;x&lt;/b&gt;</code></pre>\n"},
+		{"调用 `init()` 与 *重试*", "<p>调用 <code>init()</code> 与 <em>重试</em></p>\n"},
+		{"修改 max_upload_size 参数", "<p>修改 max_upload_size 参数</p>\n"},
+		{"[文档](https://example.com/a?b=1&c=2)", `<p><a href="https://example.com/a?b=1&amp;c=2" rel="nofollow noopener noreferrer" target="_blank">文档</a></p>` + "\n"},
+		{`\*不是强调\*`, "<p>*不是强调*</p>\n"},
+	}
+	for _, tc := range cases {
+		if got := string(renderMarkdown(tc.source)); got != tc.want {
+			t.Errorf("renderMarkdown(%q)\n got %q\nwant %q", tc.source, got, tc.want)
+		}
+	}
+}
+
+func TestRenderMarkdownSanitizes(t *testing.T) {
+	attacks := []string{
+		"<script>alert(1)</script>",
+		"<img src=x onerror=alert(1)>",
+		"[点我](javascript:alert(1))",
+		"[点我](JaVaScRiPt:alert(1))",
+		"[点我](data:text/html;base64,PHNjcmlwdD4=)",
+		`[点我](https://example.com" onmouseover="alert(1))`,
+		"**<svg onload=alert(1)>**",
+		"# <iframe src=//evil>",
+		"- `</code><script>x</script>`",
+		"> <a href=javascript:alert(1)>x</a>",
+	}
+	// Text is escaped, so every "<" left in the output opens a tag the
+	// renderer wrote itself
+	allowedTags := map[string]bool{
+		"p": true, "br": true, "h3": true, "h4": true, "h5": true, "h6": true, "hr": true,
+		"ul": true, "ol": true, "li": true, "blockquote": true, "pre": true, "code": true,
+		"strong": true, "em": true, "a": true,
+	}
+	tag := regexp.MustCompile(`</?([^\s>/]*)([^>]*)>`)
+	attribute := regexp.MustCompile(`\s([a-z]+)="([^"]*)"`)
+	for _, source := range attacks {
+		got := string(renderMarkdown(source))
+		for _, m := range tag.FindAllStringSubmatch(got, -1) {
+			if !allowedTags[m[1]] {
+				t.Errorf("renderMarkdown(%q) = %q has tag %q", source, got, m[1])
+			}
+			rest := attribute.ReplaceAllStringFunc(m[2], func(attr string) string {
+				a := attribute.FindStringSubmatch(attr)
+				if a[1] == "href" && !strings.HasPrefix(a[2], "https://") {
+					t.Errorf("renderMarkdown(%q) = %q links to %q", source, got, a[2])
+				}
+				return ""
+			})
+			if strings.TrimSpace(rest) != "" {
+				t.Errorf("renderMarkdown(%q) = %q has attributes %q", source, got, rest)
+			}
+		}
+	}
+
+	// Without Markdown the notes are escaped plain text
+	config.MarkdownNotes = false
+	defer func() { config.MarkdownNotes = true }()
+	if got := string(renderMarkdown("**a**\n<b>")); got != "<p>**a**<br>\n&lt;b&gt;</p>" {
+		t.Errorf("plain rendering = %q", got)
+	}
+}
+
+func TestReleaseNotesHTMLInAPI(t *testing.T) {
+	router := setupTestServer(t)
+	build := testBuild("a.apk", "stable")
+	build.ReleaseNotes = "- **修复**崩溃\n- <b>优化</b>"
+	if err := repo.UpsertBuild("Demo", testApp("com.example.notes"), build); err != nil {
+		t.Fatal(err)
+	}
+	wantHTML := "<ul>\n<li><strong>修复</strong>崩溃\n</li>\n<li>&lt;b&gt;优化&lt;/b&gt;\n</li>\n</ul>\n"
+
+	rec := serve(router, http.MethodGet, "/api/apps/com.example.notes/builds")
+	var body struct {
+		Builds []BuildInfo `json:"builds"`
+	}
+	decodeJSON(t, rec, &body)
+	if len(body.Builds) != 1 || body.Builds[0].ReleaseNotes != build.ReleaseNotes || body.Builds[0].ReleaseNotesHTML != wantHTML {
+		t.Fatalf("builds: status %d, %+v", rec.Code, body.Builds)
+	}
+
+	rec = serve(router, http.MethodGet, "/api/check-update?packageName=com.example.notes&versionCode=0")
+	var update UpdateCheck
+	decodeJSON(t, rec, &update)
+	if update.Latest.ReleaseNotes != build.ReleaseNotes || update.Latest.ReleaseNotesHTML != wantHTML {
+		t.Fatalf("check-update: status %d, %+v", rec.Code, update.Latest)
+	}
+
+	// The rendered notes are not stored
+	data, err := os.ReadFile(metadataFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "releaseNotesHtml") {
+		t.Errorf("metadata.json stores rendered notes: %s", data)
+	}
+}
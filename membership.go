@@ -0,0 +1,348 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errNotMember is returned when removing a user a project does not list
+var errNotMember = errors.New("该用户不是项目成员")
+
+// accessKey is the context key requestAccess caches its result under
+const accessKey = "access"
+
+// access is who a request acts as
+type access struct {
+	admin bool
+	user  string // the logged-in user, "" for the admin and anonymous requests
+}
+
+// requestAccess returns who c acts as: the admin (session, X-Admin-Password
+// or basic auth with the admin password), a user (session or basic auth with
+// the user's name and password) or nobody. The result is cached on c, so
+// passwords are checked once per request.
+func requestAccess(c *gin.Context) access {
+	if cached, ok := c.Get(accessKey); ok {
+		return cached.(access)
+	}
+	a := access{admin: isAdmin(c)}
+	if !a.admin {
+		a.user = requestUser(c)
+	}
+	c.Set(accessKey, a)
+	return a
+}
+
+// requestUser returns the user of a session cookie or of HTTP basic auth
+// credentials, "" when there is none
+func requestUser(c *gin.Context) string {
+	if id, err := c.Cookie(sessionCookieName); err == nil {
+		if user, ok := sessions.lookup(id); ok && user != "" {
+			return user
+		}
+	}
+	if name, password, ok := c.Request.BasicAuth(); ok && accounts.authenticate(name, password) {
+		return name
+	}
+	return ""
+}
+
+// projectRole returns the role of user in project: the higher of its own
+// membership and the one in the project's organization
+func projectRole(user string, project Project) string {
+	if user == "" {
+		return ""
+	}
+	role := memberRole(project.Members, user)
+	if orgRole := accounts.organizationRole(project.Organization, user); roleRank(orgRole) > roleRank(role) {
+		role = orgRole
+	}
+	return role
+}
+
+// canViewProject reports whether c may see project. Without
+// APPDIST_MULTI_TENANT everybody may; otherwise the admin and the project's
+// members may.
+func canViewProject(c *gin.Context, project Project) bool {
+	if !config.MultiTenant {
+		return true
+	}
+	a := requestAccess(c)
+	return a.admin || roleRank(projectRole(a.user, project)) >= roleRank(roleViewer)
+}
+
+// hasProjectRole reports whether c holds at least role in projectName under
+// APPDIST_MULTI_TENANT; the admin holds every role. Without multi-tenancy
+// only the admin does, since roles are not used then.
+func hasProjectRole(c *gin.Context, projectName, role string) bool {
+	a := requestAccess(c)
+	if a.admin {
+		return true
+	}
+	if !config.MultiTenant || a.user == "" {
+		return false
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	i := findProject(projectName)
+	return i >= 0 && roleRank(projectRole(a.user, allProjects[i])) >= roleRank(role)
+}
+
+// projectVisible is canViewProject by name; unknown projects are visible so
+// handlers report them as not found. The caller must hold the mutex.
+func projectVisible(c *gin.Context, projectName string) bool {
+	if !config.MultiTenant {
+		return true
+	}
+	i := findProject(projectName)
+	return i < 0 || canViewProject(c, allProjects[i])
+}
+
+// requireMembership hides the apps and projects a request may not see
+// under APPDIST_MULTI_TENANT: routes naming one by the packageName or
+// projectName parameter, or the packageName or project query parameter,
+// answer 404 as if it did not exist. Listings filter with canViewProject.
+func requireMembership() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !config.MultiTenant {
+			c.Next()
+			return
+		}
+		// Check the credentials once, before any handler takes the lock
+		requestAccess(c)
+		packageName := c.Param("packageName")
+		if packageName == "" {
+			packageName = c.Query("packageName")
+		}
+		projectName := c.Param("projectName")
+		if projectName == "" {
+			projectName = c.Query("project")
+		}
+		if packageName == "" && projectName == "" {
+			c.Next()
+			return
+		}
+
+		visible := true
+		mutex.Lock()
+		if packageName != "" {
+			if i, _, found := findApp(packageName); found {
+				visible = canViewProject(c, allProjects[i])
+			}
+		}
+		if visible && projectName != "" {
+			visible = projectVisible(c, projectName)
+		}
+		mutex.Unlock()
+
+		if !visible {
+			if packageName != "" {
+				respondText(c, http.StatusNotFound, "应用未找到")
+			} else {
+				respondText(c, http.StatusNotFound, "项目未找到")
+			}
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// uploadProjectError returns why c may not upload into projectName, or ""
+// when it may. API tokens are limited to their project; under
+// APPDIST_MULTI_TENANT other requests need the admin or the uploader role
+// in an existing project.
+func uploadProjectError(c *gin.Context, projectName string) string {
+	if msg := tokenProjectError(c, projectName); msg != "" {
+		return msg
+	}
+	if !config.MultiTenant || c.GetString(apiTokenKey) != "" {
+		return ""
+	}
+	if a := requestAccess(c); a.admin {
+		return ""
+	} else if a.user == "" {
+		return "上传需要登录或 API 令牌"
+	}
+	if !hasProjectRole(c, projectName, roleUploader) {
+		return fmt.Sprintf("没有向项目 %s 上传的权限", projectName)
+	}
+	return ""
+}
+
+// checkProjectOwner lets the admin and the project's owners manage its
+// members, writing a 401 or 403 response otherwise
+func checkProjectOwner(c *gin.Context, projectName string) bool {
+	a := requestAccess(c)
+	switch {
+	case a.admin:
+		return true
+	case a.user == "":
+		respondError(c, http.StatusUnauthorized, "需要管理员或项目所有者登录")
+		return false
+	case !hasProjectRole(c, projectName, roleOwner):
+		respondError(c, http.StatusForbidden, "只有项目所有者可以管理成员")
+		return false
+	}
+	return true
+}
+
+// handleListProjectMembers serves GET /api/projects/:projectName/members,
+// the project's own members and its organization
+func handleListProjectMembers(c *gin.Context) {
+	projectName := c.Param("projectName")
+	if !checkProjectOwner(c, projectName) {
+		return
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	i := findProject(projectName)
+	if i < 0 {
+		respondError(c, http.StatusNotFound, "项目未找到")
+		return
+	}
+	members := slices.Clone(allProjects[i].Members)
+	if members == nil {
+		members = []Member{}
+	}
+	c.JSON(http.StatusOK, gin.H{"projectName": projectName, "organization": allProjects[i].Organization, "members": members})
+}
+
+// handleSetProjectMember serves PUT /api/projects/:projectName/members/:user
+// with {"role": "owner" | "uploader" | "viewer"}
+func handleSetProjectMember(c *gin.Context) {
+	projectName, user := c.Param("projectName"), c.Param("user")
+	if !checkProjectOwner(c, projectName) {
+		return
+	}
+	role, ok := bindMemberRole(c)
+	if !ok {
+		return
+	}
+	if !accounts.exists(user) {
+		respondError(c, http.StatusNotFound, "用户未找到")
+		return
+	}
+
+	_, err := repo.UpdateProject(projectName, func(project *Project) error {
+		project.Members = setMember(project.Members, user, role)
+		return nil
+	})
+	if err != nil {
+		respondRepositoryError(c, err)
+		return
+	}
+	logf(c, "项目 %s 的成员 %s 已设置为 %s", projectName, user, role)
+	audit.record(c, AuditEntry{Action: auditProjectMember, ProjectName: projectName, Detail: user + " " + role})
+	c.JSON(http.StatusOK, gin.H{"projectName": projectName, "user": user, "role": role})
+}
+
+// handleRemoveProjectMember serves DELETE /api/projects/:projectName/members/:user.
+// Roles held through the project's organization are not affected.
+func handleRemoveProjectMember(c *gin.Context) {
+	projectName, user := c.Param("projectName"), c.Param("user")
+	if !checkProjectOwner(c, projectName) {
+		return
+	}
+	_, err := repo.UpdateProject(projectName, func(project *Project) error {
+		var removed bool
+		if project.Members, removed = removeMember(project.Members, user); !removed {
+			return errNotMember
+		}
+		return nil
+	})
+	if errors.Is(err, errNotMember) {
+		respondError(c, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondRepositoryError(c, err)
+		return
+	}
+	logf(c, "已将 %s 移出项目 %s", user, projectName)
+	audit.record(c, AuditEntry{Action: auditProjectMember, ProjectName: projectName, Detail: user + " removed"})
+	c.JSON(http.StatusOK, gin.H{"message": "成员已移除"})
+}
+
+// handleSetProjectOrganization assigns an existing project to an
+// organization with {"organization": "..."}; an empty name detaches it.
+func handleSetProjectOrganization(c *gin.Context) {
+	if !checkAdmin(c) {
+		return
+	}
+	var req struct {
+		Organization *string `json:"organization"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Organization == nil {
+		respondError(c, http.StatusBadRequest, "请求体格式错误，需要 organization 字段")
+		return
+	}
+	orgName := strings.TrimSpace(*req.Organization)
+	if orgName != "" {
+		accounts.mu.Lock()
+		found := accounts.findOrganization(orgName) >= 0
+		accounts.mu.Unlock()
+		if !found {
+			respondError(c, http.StatusNotFound, "组织未找到")
+			return
+		}
+	}
+	projectName := c.Param("projectName")
+
+	_, err := repo.UpdateProject(projectName, func(project *Project) error {
+		project.Organization = orgName
+		return nil
+	})
+	if err != nil {
+		respondRepositoryError(c, err)
+		return
+	}
+	logf(c, "项目 %s 的组织已设置为 %q", projectName, orgName)
+	audit.record(c, AuditEntry{Action: auditProjectMember, ProjectName: projectName, Detail: "organization " + orgName})
+	c.JSON(http.StatusOK, gin.H{"projectName": projectName, "organization": orgName})
+}
+
+// ProjectRole is a project the requesting user may see and its role there
+type ProjectRole struct {
+	ProjectName string `json:"projectName"`
+	Role        string `json:"role"`
+}
+
+// handleWhoAmI serves GET /api/me: who the request acts as and, for a
+// user, its organizations and projects
+func handleWhoAmI(c *gin.Context) {
+	a := requestAccess(c)
+	if !a.admin && a.user == "" {
+		respondError(c, http.StatusUnauthorized, "未登录")
+		return
+	}
+	if a.admin {
+		c.JSON(http.StatusOK, gin.H{"admin": true, "multiTenant": config.MultiTenant})
+		return
+	}
+
+	accounts.mu.Lock()
+	organizations := []gin.H{}
+	for _, org := range accounts.Organizations {
+		if role := memberRole(org.Members, a.user); role != "" {
+			organizations = append(organizations, gin.H{"name": org.Name, "role": role})
+		}
+	}
+	accounts.mu.Unlock()
+
+	mutex.Lock()
+	projects := []ProjectRole{}
+	for _, project := range allProjects {
+		if role := projectRole(a.user, project); role != "" {
+			projects = append(projects, ProjectRole{ProjectName: project.ProjectName, Role: role})
+		}
+	}
+	mutex.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"user": a.user, "admin": false, "multiTenant": config.MultiTenant, "organizations": organizations, "projects": projects})
+}
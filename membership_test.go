@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// asUser serves a request with the basic auth credentials of user, or the
+// admin password when user is "admin"
+func asUser(router *gin.Engine, user, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	switch user {
+	case "admin":
+		req.Header.Set(adminPasswordHeader, testAdminPassword)
+	case "":
+	default:
+		req.SetBasicAuth(user, user+"-password")
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func visibleProjects(t *testing.T, router *gin.Engine, user string) []string {
+	t.Helper()
+	var result struct {
+		Projects []ProjectSummary `json:"projects"`
+	}
+	decodeJSON(t, asUser(router, user, http.MethodGet, "/api/projects", ""), &result)
+	var names []string
+	for _, project := range result.Projects {
+		names = append(names, project.ProjectName+":"+project.Role)
+	}
+	return names
+}
+
+func TestMultiTenantMembership(t *testing.T) {
+	router := setupTestServer(t)
+	config.MultiTenant = true
+	if err := accounts.load(filepath.Join(t.TempDir(), "users.json")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { accounts.load("") })
+	for _, project := range []string{"Alpha", "Beta"} {
+		packageName := "com.example." + strings.ToLower(project)
+		if err := repo.UpsertBuild(project, testApp(packageName), testBuild(packageName+".apk", "beta")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, user := range []string{"alice", "bob"} {
+		if rec := asUser(router, "admin", http.MethodPost, "/api/admin/users", `{"name":"`+user+`","password":"`+user+`-password"}`); rec.Code != http.StatusCreated {
+			t.Fatalf("create %s: status %d: %s", user, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := asUser(router, "alice", http.MethodPost, "/api/admin/users", `{"name":"eve","password":"eve-password"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("user creating users: status %d, want 401", rec.Code)
+	}
+	if rec := asUser(router, "admin", http.MethodPost, "/api/admin/organizations", `{"name":"acme","owner":"alice"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("create organization: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := asUser(router, "admin", http.MethodPut, "/api/projects/Alpha/organization", `{"organization":"acme"}`); rec.Code != http.StatusOK {
+		t.Fatalf("assign organization: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Alice owns Alpha through acme, Bob sees nothing yet, and neither do
+	// anonymous visitors
+	if got := strings.Join(visibleProjects(t, router, "alice"), ","); got != "Alpha:owner" {
+		t.Errorf("alice sees %s", got)
+	}
+	if got := visibleProjects(t, router, "bob"); len(got) != 0 {
+		t.Errorf("bob sees %v", got)
+	}
+	if got := visibleProjects(t, router, ""); len(got) != 0 {
+		t.Errorf("anonymous sees %v", got)
+	}
+	if got := strings.Join(visibleProjects(t, router, "admin"), ","); got != "Alpha:,Beta:" {
+		t.Errorf("admin sees %s", got)
+	}
+	if rec := asUser(router, "bob", http.MethodGet, "/api/apps/com.example.alpha/builds", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("bob reading Alpha: status %d, want 404", rec.Code)
+	}
+	if rec := asUser(router, "bob", http.MethodGet, "/downloads/com.example.alpha.apk", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("bob downloading from Alpha: status %d, want 404", rec.Code)
+	}
+	if rec := asUser(router, "bob", http.MethodGet, "/api/search?q=example", ""); !strings.Contains(rec.Body.String(), `"total":0`) {
+		t.Errorf("bob searching: %s", rec.Body.String())
+	}
+
+	// Only owners manage members, and the organization's owner is not an
+	// owner of other projects
+	if rec := asUser(router, "alice", http.MethodPut, "/api/projects/Beta/members/bob", `{"role":"viewer"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("alice managing Beta: status %d, want 404", rec.Code)
+	}
+	if rec := asUser(router, "alice", http.MethodPut, "/api/projects/Alpha/members/bob", `{"role":"admin"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown role: status %d, want 400", rec.Code)
+	}
+	if rec := asUser(router, "alice", http.MethodPut, "/api/projects/Alpha/members/bob", `{"role":"viewer"}`); rec.Code != http.StatusOK {
+		t.Fatalf("alice adding bob: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := strings.Join(visibleProjects(t, router, "bob"), ","); got != "Alpha:viewer" {
+		t.Errorf("bob sees %s", got)
+	}
+	if rec := asUser(router, "bob", http.MethodPut, "/api/projects/Alpha/members/bob", `{"role":"owner"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer promoting itself: status %d, want 403", rec.Code)
+	}
+	if rec := asUser(router, "bob", http.MethodDelete, "/api/apps/com.example.alpha", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("viewer deleting: status %d, want 401", rec.Code)
+	}
+
+	upload := func(user string) int {
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		w.WriteField("projectName", "Alpha")
+		w.WriteField("channel", "beta")
+		part, _ := w.CreateFormFile("file", "helloworld.apk")
+		part.Write(fixtureAPK(t))
+		w.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/upload", &body)
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		req.SetBasicAuth(user, user+"-password")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := upload("bob"); code != http.StatusForbidden {
+		t.Errorf("viewer uploading: status %d, want 403", code)
+	}
+	if rec := asUser(router, "alice", http.MethodPut, "/api/projects/Alpha/members/bob", `{"role":"uploader"}`); rec.Code != http.StatusOK {
+		t.Fatalf("alice promoting bob: status %d", rec.Code)
+	}
+	if code := upload("bob"); code != http.StatusOK {
+		t.Errorf("uploader uploading: status %d, want 200", code)
+	}
+
+	// Owners delete builds in their projects
+	if rec := asUser(router, "alice", http.MethodDelete, "/api/builds/com.example.alpha/com.example.alpha.apk", ""); rec.Code != http.StatusOK {
+		t.Errorf("owner deleting: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// A session login works like basic auth
+	rec := asUser(router, "", http.MethodPost, "/api/admin/login", `{"username":"alice","password":"alice-password"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: status %d: %s", rec.Code, rec.Body.String())
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+	for _, cookie := range rec.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	me := httptest.NewRecorder()
+	router.ServeHTTP(me, req)
+	if !strings.Contains(me.Body.String(), `"user":"alice"`) || !strings.Contains(me.Body.String(), `"name":"acme","role":"owner"`) {
+		t.Errorf("me = %s", me.Body.String())
+	}
+	// The session is no admin session
+	req = httptest.NewRequest(http.MethodGet, "/api/admin/users", nil)
+	for _, cookie := range rec.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	users := httptest.NewRecorder()
+	router.ServeHTTP(users, req)
+	if users.Code != http.StatusUnauthorized {
+		t.Errorf("user session on admin endpoint: status %d, want 401", users.Code)
+	}
+
+	if rec := asUser(router, "admin", http.MethodDelete, "/api/admin/organizations/acme", ""); rec.Code != http.StatusConflict {
+		t.Errorf("deleting organization in use: status %d, want 409", rec.Code)
+	}
+	if rec := asUser(router, "admin", http.MethodDelete, "/api/admin/users/bob", ""); rec.Code != http.StatusOK {
+		t.Fatalf("delete bob: status %d", rec.Code)
+	}
+	if got := visibleProjects(t, router, "bob"); len(got) != 0 {
+		t.Errorf("deleted user sees %v", got)
+	}
+	mutex.Lock()
+	members := allProjects[findProject("Alpha")].Members
+	mutex.Unlock()
+	if len(members) != 0 {
+		t.Errorf("deleted user still a member: %+v", members)
+	}
+}
+
+func TestMultiTenantDownloads(t *testing.T) {
+	router := setupTestServer(t)
+	if rec := uploadFixture(router, fixtureAPK(t), "Alpha", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	build := appBuilds(t, router, fixturePackage)[0]
+	config.MultiTenant = true
+	if err := accounts.load(filepath.Join(t.TempDir(), "users.json")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { accounts.load("") })
+	if rec := asUser(router, "admin", http.MethodPost, "/api/admin/users", `{"name":"bob","password":"bob-password"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("create bob: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The package, its checksum and a guess at either do not exist for
+	// non-members
+	for _, target := range []string{build.DownloadURL, build.DownloadURL + checksumSuffix} {
+		if rec := asUser(router, "bob", http.MethodGet, target, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("bob fetching %s: status %d, want 404", target, rec.Code)
+		}
+		if rec := asUser(router, "", http.MethodGet, target, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("anonymous fetching %s: status %d, want 404", target, rec.Code)
+		}
+		if rec := asUser(router, "admin", http.MethodGet, target, ""); rec.Code != http.StatusOK {
+			t.Errorf("admin fetching %s: status %d", target, rec.Code)
+		}
+	}
+	// A signed link to the package is shared deliberately
+	if rec := asUser(router, "bob", http.MethodGet, signPath(build.DownloadURL, time.Now().Add(time.Hour)), ""); rec.Code != http.StatusOK {
+		t.Errorf("bob following a signed link: status %d", rec.Code)
+	}
+
+	// A project bob may see shares the file through a duplicate upload
+	if err := repo.UpsertBuild("Beta", testApp(fixturePackage), build); err != nil {
+		t.Fatal(err)
+	}
+	if rec := asUser(router, "admin", http.MethodPut, "/api/projects/Beta/members/bob", `{"role":"viewer"}`); rec.Code != http.StatusOK {
+		t.Fatalf("add bob to Beta: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := asUser(router, "bob", http.MethodGet, build.DownloadURL, ""); rec.Code != http.StatusOK {
+		t.Errorf("bob downloading the shared file: status %d", rec.Code)
+	}
+}
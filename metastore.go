@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Values of Config.MetadataStore
+const (
+	metadataStoreJSON   = "json"
+	metadataStoreSQLite = "sqlite"
+)
+
+// MetadataStore persists the catalog. The catalog is kept in allProjects
+// and guarded by mutex; stores only load it at startup and save it after
+// each change, so they need no locking of their own beyond saveMutex.
+type MetadataStore interface {
+	// Load returns the stored catalog, empty when nothing was stored yet
+	Load() ([]Project, error)
+	// Save replaces the stored catalog with projects. A failed save leaves
+	// the previous catalog stored.
+	Save(projects []Project) error
+}
+
+// metadataStore holds the catalog; main replaces it according to the
+// configuration
+var metadataStore MetadataStore = jsonMetadataStore{}
+
+// newMetadataStore returns the store selected by cfg.MetadataStore
+func newMetadataStore(cfg Config) (MetadataStore, error) {
+	switch cfg.MetadataStore {
+	case metadataStoreSQLite:
+		return openSQLiteMetadataStore(cfg.SQLitePath, cfg.MetadataPath)
+	default:
+		return jsonMetadataStore{}, nil
+	}
+}
+
+// jsonMetadataStore keeps the catalog in the JSON file at metadataFilePath,
+// replaced as a whole on every save with a backup mechanism
+type jsonMetadataStore struct{}
+
+func (jsonMetadataStore) Load() ([]Project, error) {
+	return readMetadataJSON(metadataFilePath)
+}
+
+// readMetadataJSON reads a catalog JSON file; a missing file is an empty
+// catalog
+func readMetadataJSON(path string) ([]Project, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Project{}, nil
+		}
+		return nil, err
+	}
+	var projects []Project
+	if err := json.Unmarshal(data, &projects); err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+func (jsonMetadataStore) Save(projects []Project) error {
+	data, err := json.MarshalIndent(projects, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := verifyMetadataJSON(data); err != nil {
+		return fmt.Errorf("元数据校验失败，已保留原文件: %w", err)
+	}
+
+	// Write the new content next to the live file first, so the live file is
+	// only ever replaced by a complete, verified copy.
+	tmpPath := metadataFilePath + ".tmp"
+	if err := retrySave(func() error { return writeFileSync(tmpPath, data) }); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("写入元数据文件失败: %w", err)
+	}
+
+	// Create a backup before replacing
+	backupPath := metadataFilePath + ".bak"
+	if _, err := os.Stat(metadataFilePath); err == nil {
+		if err := retrySave(func() error { return os.Rename(metadataFilePath, backupPath) }); err != nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("创建元数据备份失败: %w", err)
+		}
+	}
+
+	if err := retrySave(func() error { return os.Rename(tmpPath, metadataFilePath) }); err != nil {
+		// Attempt to restore from backup on rename error
+		os.Rename(backupPath, metadataFilePath)
+		os.Remove(tmpPath)
+		return fmt.Errorf("替换元数据文件失败: %w", err)
+	}
+
+	// If successful, remove the backup
+	os.Remove(backupPath)
+	return nil
+}
+
+// verifyMetadataJSON checks that data decodes back into the catalog and
+// re-encodes to the same bytes, guarding against writing a truncated or
+// otherwise corrupted file.
+func verifyMetadataJSON(data []byte) error {
+	var decoded []Project
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	roundTrip, err := json.MarshalIndent(decoded, "", "  ")
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(roundTrip, data) {
+		return fmt.Errorf("序列化结果往返不一致")
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSQLiteMetadataStore(t *testing.T) {
+	router := setupTestServer(t)
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	jsonCatalog := repo.GetAll()
+
+	// A new database imports metadata.json
+	dbPath := filepath.Join(t.TempDir(), "metadata db")
+	store, err := openSQLiteMetadataStore(dbPath, metadataFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadataStore = store
+	t.Cleanup(func() {
+		metadataStore = jsonMetadataStore{}
+		store.db.Close()
+	})
+	if err := loadMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	if imported := repo.GetAll(); !reflect.DeepEqual(imported, jsonCatalog) {
+		t.Fatalf("imported catalog differs:\n%+v\n%+v", imported, jsonCatalog)
+	}
+
+	// Changes are saved to the database, not the JSON file
+	if rec := uploadFixture(router, fixtureAPK(t), "Other", "beta"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	// Delete the build of Demo, the first project with the package
+	var build BuildInfo
+	for _, b := range appBuilds(t, router, fixturePackage) {
+		if b.Channel == "stable" {
+			build = b
+		}
+	}
+	rec := serveAdmin(router, http.MethodDelete, "/api/builds/"+fixturePackage+"/"+build.FileName)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete: status %d: %s", rec.Code, rec.Body.String())
+	}
+	want := repo.GetAll()
+	if fromJSON, _ := readMetadataJSON(metadataFilePath); !reflect.DeepEqual(fromJSON, jsonCatalog) {
+		t.Error("metadata.json changed while the SQLite store was in use")
+	}
+
+	// Reopening loads the saved catalog without importing the JSON file again
+	store.db.Close()
+	if store, err = openSQLiteMetadataStore(dbPath, metadataFilePath); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("reloaded catalog differs:\n%+v\n%+v", got, want)
+	}
+}
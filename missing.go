@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MissingFilesApp lists the builds of one app whose file is not in the
+// uploads directory
+type MissingFilesApp struct {
+	ProjectName string      `json:"projectName"`
+	PackageName string      `json:"packageName"`
+	AppName     string      `json:"appName"`
+	Builds      []BuildInfo `json:"builds"`
+}
+
+// MissingFilesReport is the response of GET /api/admin/missing-files
+type MissingFilesReport struct {
+	Builds int               `json:"builds"` // catalog entries with a broken download link
+	Files  int               `json:"files"`  // distinct missing files; promoted entries share one
+	Apps   []MissingFilesApp `json:"apps"`
+}
+
+// findMissingFiles cross-checks the catalog against the uploads directory.
+// It only reads: each file is looked up once, outside the lock.
+func findMissingFiles() MissingFilesReport {
+	projects := repo.GetAll()
+
+	missing := make(map[string]bool)
+	for _, project := range projects {
+		for _, app := range project.Apps {
+			for _, build := range app.Builds {
+				if _, checked := missing[build.FileName]; checked {
+					continue
+				}
+				_, err := storage.Stat(build.FileName)
+				missing[build.FileName] = errors.Is(err, os.ErrNotExist)
+			}
+		}
+	}
+
+	report := MissingFilesReport{Apps: []MissingFilesApp{}}
+	counted := make(map[string]bool)
+	for _, project := range projects {
+		for _, app := range project.Apps {
+			entry := MissingFilesApp{ProjectName: project.ProjectName, PackageName: app.PackageName, AppName: app.AppName}
+			for _, build := range app.Builds {
+				if !missing[build.FileName] {
+					continue
+				}
+				entry.Builds = append(entry.Builds, build)
+				report.Builds++
+				if !counted[build.FileName] {
+					counted[build.FileName] = true
+					report.Files++
+				}
+			}
+			if len(entry.Builds) > 0 {
+				report.Apps = append(report.Apps, entry)
+			}
+		}
+	}
+	return report
+}
+
+// handleMissingFiles lists the builds whose file is gone, e.g. after a
+// volume failed to mount, so they can be re-uploaded or deleted
+func handleMissingFiles(c *gin.Context) {
+	c.JSON(http.StatusOK, findMissingFiles())
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Values of Config.ReleaseNotesPolicy
+const (
+	notesTruncate = "truncate"
+	notesReject   = "reject"
+)
+
+// truncatedNotesMarker is appended to release notes cut to the limit
+const truncatedNotesMarker = "\n…（更新说明过长，已截断）"
+
+// The homepage only shows the start of the newest release notes
+const (
+	notesPreviewRunes = 120
+	notesPreviewLines = 3
+)
+
+// releaseNotesTooLong reports whether notes exceed config.MaxReleaseNotes
+func releaseNotesTooLong(notes string) bool {
+	return config.MaxReleaseNotes > 0 && utf8.RuneCountInString(notes) > config.MaxReleaseNotes
+}
+
+// releaseNotesFieldError rejects overlong notes under the "reject" policy;
+// the "truncate" policy shortens them later, see limitReleaseNotes.
+func releaseNotesFieldError(notes string) (FieldError, bool) {
+	if config.ReleaseNotesPolicy != notesReject || !releaseNotesTooLong(notes) {
+		return FieldError{}, false
+	}
+	message := fmt.Sprintf("更新说明过长（%d 字），上限为 %d 字", utf8.RuneCountInString(notes), config.MaxReleaseNotes)
+	return FieldError{"releaseNotes", message}, true
+}
+
+// limitReleaseNotes cuts notes to config.MaxReleaseNotes characters and
+// marks the cut, returning a warning for the uploader when it did.
+func limitReleaseNotes(notes string) (string, string) {
+	if !releaseNotesTooLong(notes) {
+		return notes, ""
+	}
+	length := utf8.RuneCountInString(notes)
+	cut := []rune(notes)[:config.MaxReleaseNotes]
+	warning := fmt.Sprintf("更新说明过长（%d 字），已截断为 %d 字", length, config.MaxReleaseNotes)
+	return strings.TrimRight(string(cut), " \t\r\n") + truncatedNotesMarker, warning
+}
+
+// notesPreview returns the first lines of notes for the homepage and
+// whether anything was left out
+func notesPreview(notes string) (string, bool) {
+	notes = strings.TrimSpace(notes)
+	preview := notes
+	if lines := strings.SplitN(preview, "\n", notesPreviewLines+1); len(lines) > notesPreviewLines {
+		preview = strings.Join(lines[:notesPreviewLines], "\n")
+	}
+	if runes := []rune(preview); len(runes) > notesPreviewRunes {
+		preview = string(runes[:notesPreviewRunes])
+	}
+	preview = strings.TrimRight(preview, " \t\r\n")
+	return preview, len(preview) < len(notes)
+}
+
+// notesHTML renders release notes for API responses, "" for empty notes
+func notesHTML(notes string) string {
+	if strings.TrimSpace(notes) == "" {
+		return ""
+	}
+	return string(renderMarkdown(notes))
+}
+
+// withNotesHTML fills in ReleaseNotesHTML on copies of catalog builds about
+// to be returned, so API clients get the notes both as Markdown and as
+// sanitized HTML. It must not be called on allProjects itself.
+func withNotesHTML(builds []BuildInfo) []BuildInfo {
+	for i := range builds {
+		builds[i].ReleaseNotesHTML = notesHTML(builds[i].ReleaseNotes)
+	}
+	return builds
+}
+
+// Values of the notes endpoint's format parameter
+const (
+	notesFormatRaw  = "raw"
+	notesFormatHTML = "html"
+)
+
+// handleReleaseNotes serves the full release notes of a build for the
+// homepage's "more" link. channel picks one entry of a promoted build;
+// without it the newest entry of the file is used. format=html returns the
+// notes rendered as sanitized HTML instead of the Markdown source.
+func handleReleaseNotes(c *gin.Context) {
+	packageName := c.Param("packageName")
+	fileName := c.Param("fileName")
+	channel := c.Query("channel")
+	format := c.DefaultQuery("format", notesFormatRaw)
+	if format != notesFormatRaw && format != notesFormatHTML {
+		respondError(c, http.StatusBadRequest, "format 只能是 raw 或 html")
+		return
+	}
+
+	_, app, found := repo.FindApp(packageName)
+	if !found {
+		respondError(c, http.StatusNotFound, "应用未找到")
+		return
+	}
+	for _, build := range app.Builds {
+		if build.FileName == fileName && (channel == "" || build.Channel == channel) {
+			notes := build.ReleaseNotes
+			if format == notesFormatHTML {
+				notes = string(renderMarkdown(notes))
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"packageName":  packageName,
+				"fileName":     fileName,
+				"channel":      build.Channel,
+				"version":      build.Version,
+				"format":       format,
+				"releaseNotes": notes,
+			})
+			return
+		}
+	}
+	respondError(c, http.StatusNotFound, "构建版本未找到")
+}
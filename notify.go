@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"mime"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// emailRetryAttempts bounds how often a notification email is retried
+const emailRetryAttempts = 3
+
+// BuildEvent describes a newly published build for notifications
+type BuildEvent struct {
+	ProjectName string
+	AppName     string
+	PackageName string
+	Build       BuildInfo
+	DetailURL   string // absolute URL of the app detail page
+	DownloadURL string // absolute URL of the APK
+	QRCodeURL   string // absolute URL of a QR code image for DownloadURL
+}
+
+// newBuildEvent assembles a BuildEvent with absolute links under baseURL
+func newBuildEvent(baseURL, projectName string, appInfo AppInfo, build BuildInfo) BuildEvent {
+	downloadURL := baseURL + build.DownloadURL
+	return BuildEvent{
+		ProjectName: projectName,
+		AppName:     appInfo.AppName,
+		PackageName: appInfo.PackageName,
+		Build:       build,
+		DetailURL:   baseURL + "/app/" + url.PathEscape(appInfo.PackageName),
+		DownloadURL: downloadURL,
+		QRCodeURL:   baseURL + "/qr?url=" + url.QueryEscape(downloadURL),
+	}
+}
+
+// notifyNewBuild dispatches all configured notifications for event.
+// It never blocks the caller and never fails the upload.
+func notifyNewBuild(event BuildEvent) {
+	if config.SMTPHost != "" && len(config.EmailRecipients) > 0 {
+		go sendBuildEmail(event)
+	}
+	if hooks := projectWebhooks(event.ProjectName); len(hooks) > 0 {
+		go deliverWebhooks(hooks, uploadWebhookPayload(event))
+	}
+	notifyChatBots(event)
+}
+
+var buildEmailTemplate = template.Must(template.New("build-email").Funcs(template.FuncMap{"formatTime": formatTime}).Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<body style="font-family: -apple-system, 'Segoe UI', Roboto, Arial, sans-serif; color: #212529;">
+    <h2 style="margin-bottom: 4px;">{{.AppName}} 发布了新版本</h2>
+    <p style="color: #6c757d; margin-top: 0;">{{.ProjectName}} · {{.PackageName}}</p>
+    <table style="border-collapse: collapse; margin: 16px 0;">
+        <tr><td style="padding: 4px 12px 4px 0; color: #6c757d;">版本</td><td>{{.Build.Version}}</td></tr>
+        <tr><td style="padding: 4px 12px 4px 0; color: #6c757d;">渠道</td><td>{{.Build.Channel}}</td></tr>
+        <tr><td style="padding: 4px 12px 4px 0; color: #6c757d;">上传时间</td><td>{{formatTime .Build.UploadTime ""}}</td></tr>
+    </table>
+    {{if .Build.ReleaseNotes}}
+    <p><strong>更新说明：</strong></p>
+    <p style="white-space: pre-wrap;">{{.Build.ReleaseNotes}}</p>
+    {{end}}
+    <p><img src="{{.QRCodeURL}}" alt="扫码安装" width="160" height="160"></p>
+    <p>
+        <a href="{{.DownloadURL}}" style="background: #007bff; color: #fff; padding: 8px 16px; border-radius: 6px; text-decoration: none;">下载安装</a>
+        &nbsp;<a href="{{.DetailURL}}">查看全部版本</a>
+    </p>
+</body>
+</html>
+`))
+
+// sendBuildEmail renders and sends the new-build email, retrying with
+// backoff. Failures are logged only.
+func sendBuildEmail(event BuildEvent) {
+	var body bytes.Buffer
+	if err := buildEmailTemplate.Execute(&body, event); err != nil {
+		slog.Warn("渲染通知邮件失败", "error", err)
+		return
+	}
+	subject := fmt.Sprintf("[%s] %s %s (%s) 已发布", event.ProjectName, event.AppName, event.Build.Version, event.Build.Channel)
+	message := buildEmailMessage(config.SMTPFrom, config.EmailRecipients, subject, body.String())
+
+	addr := fmt.Sprintf("%s:%d", config.SMTPHost, config.SMTPPort)
+	var auth smtp.Auth
+	if config.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, config.SMTPHost)
+	}
+
+	var err error
+	for attempt := 1; attempt <= emailRetryAttempts; attempt++ {
+		if err = smtp.SendMail(addr, auth, config.SMTPFrom, config.EmailRecipients, message); err == nil {
+			slog.Info("通知邮件已发送", "subject", subject)
+			return
+		}
+		slog.Warn("发送通知邮件失败", "attempt", attempt, "error", err)
+		time.Sleep(time.Duration(attempt) * 2 * time.Second)
+	}
+	slog.Error("通知邮件最终发送失败", "error", err)
+}
+
+// buildEmailMessage assembles an RFC 5322 HTML message
+func buildEmailMessage(from string, to []string, subject, htmlBody string) []byte {
+	var msg strings.Builder
+	msg.WriteString("From: " + from + "\r\n")
+	msg.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	msg.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", subject) + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(htmlBody)
+	return []byte(msg.String())
+}
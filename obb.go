@@ -0,0 +1,286 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Games larger than the Play Store APK limit ship their assets in APK
+// expansion files, which the app expects at
+// <shared storage>/Android/obb/<package>/<kind>.<versionCode>.<package>.obb.
+// They are attached to a build after upload and stored under the name the
+// device needs, in a directory per build file.
+
+// Kinds of expansion files; an app has at most one of each per version
+const (
+	expansionMain  = "main"
+	expansionPatch = "patch"
+)
+
+// ExpansionFile is an APK expansion (OBB) file attached to a build
+type ExpansionFile struct {
+	Kind        string `json:"kind"`     // "main" or "patch"
+	FileName    string `json:"fileName"` // <kind>.<versionCode>.<package>.obb
+	FileSize    int64  `json:"fileSize"`
+	FileHash    string `json:"fileHash"`
+	UploadTime  string `json:"uploadTime"`
+	DownloadURL string `json:"downloadURL"`
+}
+
+// expansionFileName returns the name Android expects for an expansion file
+func expansionFileName(kind string, versionCode int32, packageName string) string {
+	return fmt.Sprintf("%s.%d.%s.obb", kind, versionCode, packageName)
+}
+
+// expansionDirName is the directory below uploads/obb holding the expansion
+// files of a build file
+func expansionDirName(buildFileName string) string {
+	return strings.TrimSuffix(buildFileName, ".apk")
+}
+
+// expansionDir returns the directory holding the expansion files of a build file
+func expansionDir(buildFileName string) string {
+	return filepath.Join(config.UploadDir, "obb", expansionDirName(buildFileName))
+}
+
+// expansionPath returns where an expansion file of a build file is stored
+func expansionPath(buildFileName, fileName string) string {
+	return filepath.Join(expansionDir(buildFileName), fileName)
+}
+
+// obbPlacement is the device path an expansion file has to be copied to
+func obbPlacement(packageName, fileName string) string {
+	return fmt.Sprintf("/sdcard/Android/obb/%s/%s", packageName, fileName)
+}
+
+// handleUploadExpansion attaches an expansion file to a build. The multipart
+// field "file" holds the .obb and "kind" selects "main" (default) or
+// "patch"; uploading the same kind again replaces the file. Every entry of
+// the build file, e.g. promoted ones, lists the attachment.
+func handleUploadExpansion(c *gin.Context) {
+	if !checkAdmin(c) {
+		return
+	}
+	packageName := c.Param("packageName")
+	buildFileName := c.Param("fileName")
+
+	kind := strings.ToLower(strings.TrimSpace(c.DefaultPostForm("kind", expansionMain)))
+	if kind != expansionMain && kind != expansionPatch {
+		respondError(c, http.StatusBadRequest, "kind 只能为 main 或 patch")
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "获取表单文件错误: "+err.Error())
+		return
+	}
+	if !strings.EqualFold(filepath.Ext(file.Filename), ".obb") {
+		respondError(c, http.StatusBadRequest, "扩展文件必须是 .obb 文件")
+		return
+	}
+	if file.Size == 0 {
+		respondError(c, http.StatusBadRequest, "上传的文件为空")
+		return
+	}
+	if config.MaxExpansionSize > 0 && file.Size > config.MaxExpansionSize {
+		respondError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("扩展文件大小 %s 超过上限 %s", formatSize(file.Size), formatSize(config.MaxExpansionSize)))
+		return
+	}
+
+	projectName, app, found := repo.FindApp(packageName)
+	if !found {
+		respondError(c, http.StatusNotFound, "应用未找到")
+		return
+	}
+	var versionCode int32
+	buildFound := false
+	for _, build := range app.Builds {
+		if build.FileName == buildFileName {
+			versionCode, buildFound = build.VersionCode, true
+			break
+		}
+	}
+	if !buildFound {
+		respondError(c, http.StatusNotFound, "构建版本未找到")
+		return
+	}
+	if versionCode == 0 {
+		respondError(c, http.StatusConflict, "该构建没有记录 versionCode，请先重新解析构建")
+		return
+	}
+
+	incomingPath, fileHash, err := saveIncomingUpload(c, file)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "保存文件错误: "+err.Error())
+		return
+	}
+	defer os.Remove(incomingPath) // no-op once the file has been moved
+
+	expansion := ExpansionFile{
+		Kind:       kind,
+		FileName:   expansionFileName(kind, versionCode, packageName),
+		FileSize:   file.Size,
+		FileHash:   fileHash,
+		UploadTime: timestamp(time.Now()),
+	}
+	expansion.DownloadURL = fmt.Sprintf("/downloads/obb/%s/%s", expansionDirName(buildFileName), expansion.FileName)
+	target := expansionPath(buildFileName, expansion.FileName)
+
+	// The build may have been deleted while the file was received
+	var stored, replaced bool
+	var storeErr error
+	_, _, err = repo.UpdateApp(packageName, func(app *AppEntry) error {
+		if fileReferenceCount(buildFileName) == 0 {
+			return errBuildNotFound
+		}
+		var freed int64
+		for _, build := range app.Builds {
+			if build.FileName != buildFileName {
+				continue
+			}
+			for _, existing := range build.Expansions {
+				if existing.Kind == kind {
+					freed = existing.FileSize
+				}
+			}
+		}
+		if err := checkProjectQuota(projectName, file.Size, freed); err != nil {
+			return err
+		}
+		_, err := os.Stat(target)
+		replaced = err == nil
+		if storeErr = storeExpansionFile(incomingPath, target); storeErr != nil {
+			return storeErr
+		}
+		stored = true
+
+		for k := range app.Builds {
+			build := &app.Builds[k]
+			if build.FileName != buildFileName {
+				continue
+			}
+			expansions := []ExpansionFile{expansion}
+			for _, existing := range build.Expansions {
+				if existing.Kind != kind {
+					expansions = append(expansions, existing)
+				}
+			}
+			// Keep main before patch, the order they are installed in
+			if len(expansions) == 2 && expansions[0].Kind == expansionPatch {
+				expansions[0], expansions[1] = expansions[1], expansions[0]
+			}
+			build.Expansions = expansions
+		}
+		return nil
+	})
+	var quotaErr *quotaExceededError
+	switch {
+	case err == nil:
+	case errors.As(err, &quotaErr):
+		warnf(c, "%s", quotaErr.Error())
+		respondQuotaExceeded(c, quotaErr)
+		return
+	case storeErr != nil:
+		respondError(c, http.StatusInternalServerError, "无法保存扩展文件: "+storeErr.Error())
+		return
+	default:
+		if stored && !replaced {
+			os.Remove(target)
+		}
+		respondRepositoryError(c, err)
+		return
+	}
+	logf(c, "扩展文件已保存为: %s", target)
+	audit.record(c, AuditEntry{Action: auditUploadExpansion, ProjectName: projectName, PackageName: packageName, FileName: buildFileName,
+		Detail: expansion.FileName})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "扩展文件已上传",
+		"expansion": expansion,
+		"placement": obbPlacement(packageName, expansion.FileName),
+	})
+}
+
+// storeExpansionFile moves an accepted expansion file into place, replacing
+// an earlier upload of the same kind. Like storeBuildFile it falls back to
+// copying when the incoming directory is on another file system.
+func storeExpansionFile(incomingPath, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(incomingPath, target); err == nil {
+		return os.Chmod(target, 0644)
+	}
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	err = copyIncoming(incomingPath, dst)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(target)
+	}
+	return err
+}
+
+// storedExpansionHash returns the FileHash recorded for an expansion file,
+// or "" when it is unknown. dirName is its directory below uploads/obb.
+func storedExpansionHash(dirName, fileName string) string {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, project := range allProjects {
+		for _, app := range project.Apps {
+			for _, build := range app.Builds {
+				if expansionDirName(build.FileName) != dirName {
+					continue
+				}
+				for _, expansion := range build.Expansions {
+					if expansion.FileName == fileName {
+						return expansion.FileHash
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// handleExpansionDownload serves the expansion files below
+// /downloads/obb/:build/:fileName like handleDownload serves packages,
+// including the ".sha256" companion.
+func handleExpansionDownload(c *gin.Context) {
+	dirName, name := c.Param("build"), c.Param("fileName")
+	for _, part := range []string{dirName, name} {
+		if part == "" || part != filepath.Base(part) || part == "." || part == ".." {
+			c.Status(http.StatusNotFound)
+			return
+		}
+	}
+	path := filepath.Join(config.UploadDir, "obb", dirName, name)
+
+	if fileName, ok := strings.CutSuffix(name, checksumSuffix); ok {
+		if _, err := os.Stat(path); err != nil {
+			serveChecksum(c, fileName, storedExpansionHash(dirName, fileName), func() (string, error) {
+				return hashFile(filepath.Join(config.UploadDir, "obb", dirName, fileName))
+			})
+			return
+		}
+	}
+
+	if privateExpansion(c, dirName) && !checkLinkSignature(c, downloadPath(c)) {
+		return
+	}
+	if hash := storedExpansionHash(dirName, name); hash != "" {
+		c.Header("X-Checksum-SHA256", hash)
+	}
+	serveStoredFile(c, path)
+}
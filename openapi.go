@@ -0,0 +1,350 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPIVersion is the version of the API the document describes; bump it
+// when endpoints change incompatibly
+const openAPIVersion = "1.0.0"
+
+// schemaBuilder derives JSON schemas from the Go types the handlers encode,
+// so the document follows the structs as they change. Struct types become
+// components referenced by name.
+type schemaBuilder struct {
+	components map[string]any
+}
+
+// schema returns the schema of t, registering struct types as components
+func (b *schemaBuilder) schema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int32, reflect.Uint32, reflect.Int16, reflect.Uint16, reflect.Int8, reflect.Uint8:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		name := componentName(t)
+		if _, ok := b.components[name]; !ok {
+			// Reserve the name first, so recursive types terminate
+			b.components[name] = nil
+			properties := map[string]any{}
+			var required []string
+			b.addFields(t, properties, &required)
+			object := map[string]any{"type": "object", "properties": properties}
+			if len(required) > 0 {
+				object["required"] = required
+			}
+			b.components[name] = object
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// addFields adds the JSON fields of struct t to properties, flattening
+// embedded structs the way encoding/json does. Fields without omitempty
+// are always present, so they are required.
+func (b *schemaBuilder) addFields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			b.addFields(field.Type, properties, required)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.schema(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// componentName names the component of a struct type, capitalized for the
+// unexported ones
+func componentName(t reflect.Type) string {
+	name := []rune(t.Name())
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
+
+// schemaFor is schema of the type of v
+func (b *schemaBuilder) schemaFor(v any) map[string]any {
+	return b.schema(reflect.TypeOf(v))
+}
+
+// object is the schema of a JSON object with the given properties, all
+// required unless listed in optional
+func object(properties map[string]any, optional ...string) map[string]any {
+	var required []string
+	for name := range properties {
+		if !slices.Contains(optional, name) {
+			required = append(required, name)
+		}
+	}
+	slices.Sort(required)
+	return map[string]any{"type": "object", "properties": properties, "required": required}
+}
+
+// Parameter helpers
+func pathParam(name, description string) map[string]any {
+	return map[string]any{"name": name, "in": "path", "required": true, "description": description, "schema": map[string]any{"type": "string"}}
+}
+
+func queryParam(name, description string, schema map[string]any) map[string]any {
+	return map[string]any{"name": name, "in": "query", "description": description, "schema": schema}
+}
+
+var (
+	stringSchema  = map[string]any{"type": "string"}
+	booleanSchema = map[string]any{"type": "boolean"}
+	pageParams    = []any{
+		queryParam("page", "页码，从 1 开始", map[string]any{"type": "integer", "minimum": 1}),
+		queryParam("pageSize", "每页条数，省略时返回全部", map[string]any{"type": "integer", "minimum": 1, "maximum": maxPageSize}),
+	}
+	sortParam = queryParam("sort", "排序方式", map[string]any{"type": "string", "enum": []string{sortByName, sortByRecent}})
+)
+
+// jsonResponse describes a JSON response with schema
+func jsonResponse(description string, schema map[string]any) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content":     map[string]any{"application/json": map[string]any{"schema": schema}},
+	}
+}
+
+// errorResponse describes an error, JSON with the "error" field
+func errorResponse(description string) map[string]any {
+	return jsonResponse(description, map[string]any{"$ref": "#/components/schemas/Error"})
+}
+
+// textErrorResponse describes an error of the handlers answering browser
+// form posts, plain text for API clients
+func textErrorResponse(description string) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content":     map[string]any{"text/plain": map[string]any{"schema": stringSchema}},
+	}
+}
+
+// openAPIDocument describes the upload, delete, listing and stats
+// endpoints, with serverURL as the base of every path
+func openAPIDocument(serverURL string) map[string]any {
+	b := &schemaBuilder{components: map[string]any{}}
+	b.components["Error"] = object(map[string]any{
+		"error":      stringSchema,
+		"requestId":  stringSchema,
+		"fields":     map[string]any{"type": "array", "items": b.schemaFor(FieldError{})},
+		"violations": map[string]any{"type": "array", "items": b.schemaFor(PolicyViolation{})},
+		"duplicate":  b.schemaFor(DuplicateBuild{}),
+	}, "fields", "violations", "duplicate")
+	adminSecurity := []any{
+		map[string]any{"adminPassword": []string{}},
+		map[string]any{"adminBasic": []string{}},
+		map[string]any{"adminSession": []string{}},
+	}
+	packageParam := pathParam("packageName", "应用包名")
+	pageWith := func(params ...any) []any { return append(params, pageParams...) }
+	paged := func(properties map[string]any) map[string]any {
+		properties["total"] = map[string]any{"type": "integer"}
+		properties["page"] = map[string]any{"type": "integer"}
+		properties["pageSize"] = map[string]any{"type": "integer"}
+		return object(properties, "page", "pageSize")
+	}
+	deleted := object(map[string]any{
+		"message":    stringSchema,
+		"appRemoved": booleanSchema,
+		"dryRun":     booleanSchema,
+		"plan":       b.schemaFor(DeletePlan{}),
+	}, "appRemoved", "dryRun", "plan")
+
+	paths := map[string]any{
+		"/api/upload": map[string]any{
+			"post": map[string]any{
+				"operationId": "uploadBuild",
+				"summary":     "上传 APK、AAB 或 IPA 构建",
+				"security":    []any{map[string]any{"uploadToken": []string{}}},
+				"requestBody": map[string]any{
+					"required": true,
+					"content": map[string]any{"multipart/form-data": map[string]any{
+						"schema": object(map[string]any{
+							"projectName":       stringSchema,
+							"channel":           stringSchema,
+							"file":              map[string]any{"type": "string", "format": "binary"},
+							"releaseNotes":      stringSchema,
+							"allowDowngrade":    booleanSchema,
+							"force":             booleanSchema,
+							"allowSignerChange": booleanSchema,
+							"extra":             map[string]any{"type": "string", "description": "自定义字段的 JSON 对象；也可用 extra_<key> 字段逐个提交"},
+						}, "releaseNotes", "allowDowngrade", "force", "allowSignerChange", "extra"),
+					}},
+				},
+				"responses": map[string]any{
+					"200": jsonResponse("上传成功", object(map[string]any{
+						"message":  stringSchema,
+						"warnings": map[string]any{"type": "array", "items": stringSchema},
+					})),
+					"400": errorResponse("表单字段校验失败，或安装包无法解析"),
+					"401": textErrorResponse("缺少或无效的 API 令牌"),
+					"403": textErrorResponse("API 令牌不能上传到该项目"),
+					"409": errorResponse("版本降级、签名变化或重复的构建"),
+					"422": errorResponse("上传未通过策略检查，violations 列出原因"),
+					"503": errorResponse("解析队列繁忙或维护模式"),
+				},
+			},
+		},
+		"/api/projects": map[string]any{
+			"get": map[string]any{
+				"operationId": "listProjects",
+				"summary":     "列出项目",
+				"parameters":  pageWith(sortParam),
+				"responses": map[string]any{
+					"200": jsonResponse("项目列表", paged(map[string]any{
+						"projects": map[string]any{"type": "array", "items": b.schemaFor(ProjectSummary{})},
+					})),
+					"400": errorResponse("分页参数无效"),
+				},
+			},
+		},
+		"/api/projects/{projectName}/apps": map[string]any{
+			"get": map[string]any{
+				"operationId": "listProjectApps",
+				"summary":     "列出项目中的应用",
+				"parameters":  pageWith(pathParam("projectName", "项目名称"), sortParam),
+				"responses": map[string]any{
+					"200": jsonResponse("应用列表", paged(map[string]any{
+						"projectName": stringSchema,
+						"apps":        map[string]any{"type": "array", "items": b.schemaFor(AppSummary{})},
+					})),
+					"400": errorResponse("分页参数无效"),
+					"404": errorResponse("项目未找到"),
+				},
+			},
+		},
+		"/api/apps/{packageName}/builds": map[string]any{
+			"get": map[string]any{
+				"operationId": "listBuilds",
+				"summary":     "列出应用的构建，默认按上传时间从新到旧",
+				"parameters": pageWith(packageParam,
+					queryParam("channel", "只列出该渠道的构建", stringSchema),
+					map[string]any{"name": "tag", "in": "query", "description": "只列出带有全部这些标签的构建", "style": "form", "explode": true,
+						"schema": map[string]any{"type": "array", "items": stringSchema}},
+					queryParam("sort", "upload 按上传时间、version 按 versionCode 与版本号从新到旧，默认由 APPDIST_BUILD_ORDER 决定", map[string]any{"type": "string", "enum": []string{sortByUpload, sortByVersion}}),
+				),
+				"responses": map[string]any{
+					"200": jsonResponse("构建列表", paged(map[string]any{
+						"packageName": stringSchema,
+						"appName":     stringSchema,
+						"builds":      map[string]any{"type": "array", "items": b.schemaFor(BuildInfo{})},
+					})),
+					"400": errorResponse("分页参数无效"),
+					"404": errorResponse("应用未找到"),
+				},
+			},
+		},
+		"/api/stats/{packageName}": map[string]any{
+			"get": map[string]any{
+				"operationId": "getAppStats",
+				"summary":     "应用的下载与安装统计",
+				"parameters": []any{packageParam,
+					queryParam("days", "按天统计的天数", map[string]any{"type": "integer", "minimum": 1, "maximum": 366, "default": 30})},
+				"responses": map[string]any{
+					"200": jsonResponse("统计数据", object(map[string]any{
+						"packageName":    stringSchema,
+						"totalDownloads": map[string]any{"type": "integer"},
+						"totalInstalls":  map[string]any{"type": "integer"},
+						"builds":         map[string]any{"type": "array", "items": b.schemaFor(buildStatsEntry{})},
+						"channels":       map[string]any{"type": "array", "items": b.schemaFor(channelStats{})},
+						"daily":          map[string]any{"type": "array", "items": b.schemaFor(dailyStats{})},
+					})),
+					"400": errorResponse("days 参数无效"),
+					"404": errorResponse("应用未找到"),
+				},
+			},
+		},
+		"/api/builds/{packageName}/{fileName}": map[string]any{
+			"delete": map[string]any{
+				"operationId": "deleteBuild",
+				"summary":     "删除构建，文件不再被引用时一并删除",
+				"security":    adminSecurity,
+				"parameters": []any{packageParam, pathParam("fileName", "构建的文件名"),
+					queryParam("channel", "只删除该渠道的条目", stringSchema),
+					queryParam("keepApp", "删除最后一个构建后保留应用", booleanSchema),
+					queryParam("dryRun", "只返回删除计划，不删除", booleanSchema),
+					queryParam("force", "同时删除受保护的构建", booleanSchema)},
+				"responses": map[string]any{
+					"200": jsonResponse("已删除，或 dryRun 时的删除计划", deleted),
+					"401": errorResponse("需要管理员或项目密码"),
+					"404": errorResponse("应用或构建未找到"),
+					"409": errorResponse("构建受保护，需要 force"),
+					"503": errorResponse("维护模式"),
+				},
+			},
+		},
+		"/api/apps/{packageName}": map[string]any{
+			"delete": map[string]any{
+				"operationId": "deleteApp",
+				"summary":     "删除应用及其全部构建",
+				"security":    adminSecurity,
+				"parameters": []any{packageParam,
+					queryParam("dryRun", "只返回删除计划，不删除", booleanSchema),
+					queryParam("force", "同时删除受保护的构建", booleanSchema)},
+				"responses": map[string]any{
+					"200": jsonResponse("已删除，或 dryRun 时的删除计划", deleted),
+					"401": errorResponse("需要管理员或项目密码"),
+					"404": errorResponse("应用未找到"),
+					"409": errorResponse("应用有受保护的构建，需要 force"),
+					"503": errorResponse("维护模式"),
+				},
+			},
+		},
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   config.SiteTitle + " API",
+			"version": openAPIVersion,
+		},
+		"servers": []any{map[string]any{"url": serverURL}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": b.components,
+			"securitySchemes": map[string]any{
+				"uploadToken":   map[string]any{"type": "http", "scheme": "bearer", "description": "管理员创建的 API 令牌"},
+				"adminPassword": map[string]any{"type": "apiKey", "in": "header", "name": adminPasswordHeader, "description": "管理员密码，删除时也可以是项目密码"},
+				"adminBasic":    map[string]any{"type": "http", "scheme": "basic"},
+				"adminSession":  map[string]any{"type": "apiKey", "in": "cookie", "name": sessionCookieName},
+			},
+		},
+	}
+}
+
+// handleOpenAPI serves GET /api/openapi.json
+func handleOpenAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, openAPIDocument(requestBaseURL(c)))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+	router := setupTestServer(t)
+	rec := serve(router, http.MethodGet, "/api/openapi.json")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	decodeJSON(t, rec, &doc)
+	if !strings.HasPrefix(doc.OpenAPI, "3.") || len(doc.Servers) != 1 || doc.Servers[0].URL != "http://example.com" {
+		t.Errorf("openapi %q, servers %+v", doc.OpenAPI, doc.Servers)
+	}
+
+	// Every documented operation is a registered route
+	routes := map[string]bool{}
+	for _, route := range router.Routes() {
+		routes[route.Method+" "+route.Path] = true
+	}
+	param := regexp.MustCompile(`\{(\w+)\}`)
+	for path, operations := range doc.Paths {
+		ginPath := param.ReplaceAllString(path, ":$1")
+		for method := range operations {
+			if !routes[strings.ToUpper(method)+" "+ginPath] {
+				t.Errorf("%s %s is documented but not routed", strings.ToUpper(method), path)
+			}
+		}
+	}
+	for _, want := range []string{"/api/upload", "/api/builds/{packageName}/{fileName}", "/api/apps/{packageName}/builds", "/api/stats/{packageName}"} {
+		if doc.Paths[want] == nil {
+			t.Errorf("%s is not documented", want)
+		}
+	}
+
+	// Every reference resolves, and schemas follow the struct tags
+	for _, ref := range regexp.MustCompile(`"#/components/schemas/(\w+)"`).FindAllStringSubmatch(rec.Body.String(), -1) {
+		if doc.Components.Schemas[ref[1]] == nil {
+			t.Errorf("unresolved reference to %s", ref[1])
+		}
+	}
+	var build struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+		Required   []string                   `json:"required"`
+	}
+	if err := json.Unmarshal(doc.Components.Schemas["BuildInfo"], &build); err != nil {
+		t.Fatal(err)
+	}
+	if build.Properties["versionCode"] == nil || build.Properties["expansions"] == nil || !strings.Contains(strings.Join(build.Required, ","), "fileName") {
+		t.Errorf("BuildInfo schema = %+v", build)
+	}
+	var statsEntry struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	json.Unmarshal(doc.Components.Schemas["BuildStatsEntry"], &statsEntry)
+	if statsEntry.Properties["downloads"] == nil {
+		t.Errorf("embedded BuildStats fields are not flattened: %+v", statsEntry.Properties)
+	}
+}
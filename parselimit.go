@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseLimiter bounds how many APKs are parsed at once. Opening an APK and
+// decoding its icons take a lot of CPU and memory, so a burst of uploads
+// queues here instead of running out of memory.
+type parseLimiter struct {
+	slots chan struct{} // nil means unlimited
+}
+
+// parseSlots is the limiter shared by every parse; main replaces it once
+// the configuration is loaded
+var parseSlots = newParseLimiter(config.MaxConcurrentParses)
+
+// newParseLimiter allows n concurrent parses; n <= 0 disables the limit
+func newParseLimiter(n int) *parseLimiter {
+	if n <= 0 {
+		return &parseLimiter{}
+	}
+	return &parseLimiter{slots: make(chan struct{}, n)}
+}
+
+// acquire waits up to config.ParseQueueTimeout for a parse slot. It returns
+// the function releasing the slot, or false when the wait timed out or the
+// client went away; the caller must then answer without parsing.
+func (l *parseLimiter) acquire(c *gin.Context) (func(), bool) {
+	if l.slots == nil {
+		return func() {}, true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, true
+	default:
+	}
+
+	logf(c, "解析队列已满，等待空闲的解析槽位")
+	timer := time.NewTimer(config.ParseQueueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, true
+	case <-timer.C:
+	case <-c.Request.Context().Done():
+	}
+	return nil, false
+}
+
+// respondParseBusy reports that no parse slot became free in time
+func respondParseBusy(c *gin.Context) {
+	warnf(c, "等待解析槽位超时 (%s)", config.ParseQueueTimeout)
+	respondUploadError(c, parseBusyError())
+}
+
+// parseBusyError is the uploadError of an upload that got no parse slot
+func parseBusyError() *uploadError {
+	return &uploadError{status: http.StatusServiceUnavailable, message: "服务器正忙于解析其他上传，请稍后重试",
+		retryAfter: max(int(config.ParseQueueTimeout.Seconds()), 1)}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseQueueTimeout(t *testing.T) {
+	router := setupTestServer(t)
+	config.ParseQueueTimeout = 50 * time.Millisecond
+	previous := parseSlots
+	parseSlots = newParseLimiter(1)
+	t.Cleanup(func() { parseSlots = previous })
+
+	// A parse holding the only slot makes the upload time out in the queue
+	release, ok := parseSlots.acquire(nil)
+	if !ok {
+		t.Fatal("no free slot in an idle limiter")
+	}
+	start := time.Now()
+	rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "1" ||
+		!strings.Contains(rec.Body.String(), "服务器正忙于解析其他上传，请稍后重试") {
+		t.Fatalf("busy upload: status %d, Retry-After %q: %s", rec.Code, rec.Header().Get("Retry-After"), rec.Body.String())
+	}
+	if waited := time.Since(start); waited < config.ParseQueueTimeout {
+		t.Errorf("answered after %s, before the queue timeout", waited)
+	}
+	if entries, _ := os.ReadDir(config.IncomingDir); len(entries) != 0 {
+		t.Errorf("staged files left behind: %v", entries)
+	}
+	if held := len(parseSlots.slots); held != 1 {
+		t.Fatalf("%d slots held after the timeout, want only the blocking one", held)
+	}
+
+	// A client that goes away while queued does not take a slot either
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	if _, ok := parseSlots.acquire(c); ok {
+		t.Fatal("acquired a slot for a cancelled request")
+	}
+
+	release()
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("upload after release: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if held := len(parseSlots.slots); held != 0 {
+		t.Errorf("%d slots held after the upload finished", held)
+	}
+}
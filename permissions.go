@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PermissionChanges is the response of GET /api/apps/:packageName/permissions:
+// the manifest details of a build and the permissions it adds or drops
+// compared with an earlier build
+type PermissionChanges struct {
+	PackageName string    `json:"packageName"`
+	Build       BuildInfo `json:"build"`
+	// Base is the build compared against, nil when there is no earlier one;
+	// every permission then counts as added
+	Base    *BuildInfo `json:"base,omitempty"`
+	Added   []string   `json:"added"`
+	Removed []string   `json:"removed"`
+}
+
+// diffPermissions returns the sorted permissions of current missing from
+// base, and those of base missing from current
+func diffPermissions(base, current []string) (added, removed []string) {
+	added, removed = []string{}, []string{}
+	for _, permission := range current {
+		if !slices.Contains(base, permission) && !slices.Contains(added, permission) {
+			added = append(added, permission)
+		}
+	}
+	for _, permission := range base {
+		if !slices.Contains(current, permission) && !slices.Contains(removed, permission) {
+			removed = append(removed, permission)
+		}
+	}
+	slices.Sort(added)
+	slices.Sort(removed)
+	return added, removed
+}
+
+// handlePermissionChanges serves
+// GET /api/apps/:packageName/permissions?channel=&version=&base=, comparing
+// the newest build of version (the newest build without one) with the
+// build of base, or else with the build before it in the channel
+func handlePermissionChanges(c *gin.Context) {
+	packageName := c.Param("packageName")
+	_, builds, found := buildsOfPackage(packageName)
+	if !found {
+		respondError(c, http.StatusNotFound, "应用未找到")
+		return
+	}
+
+	channel := c.Query("channel")
+	inChannel := channelBuilds(builds, channel, "")
+	build, ok := newestBuild(inChannel, c.Query("version"))
+	if !ok {
+		respondError(c, http.StatusNotFound, "构建版本未找到")
+		return
+	}
+
+	var base BuildInfo
+	if version := c.Query("base"); version != "" {
+		if base, ok = newestBuild(inChannel, version); !ok {
+			respondError(c, http.StatusNotFound, "对比的构建版本未找到")
+			return
+		}
+	} else {
+		base, ok = previousBuild(inChannel, "", "", build.Version)
+	}
+
+	changes := PermissionChanges{PackageName: packageName, Build: build}
+	if ok {
+		changes.Base = &base
+		changes.Added, changes.Removed = diffPermissions(base.Permissions, build.Permissions)
+	} else {
+		changes.Added, changes.Removed = diffPermissions(nil, build.Permissions)
+	}
+	c.JSON(http.StatusOK, changes)
+}
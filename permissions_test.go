@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestPermissionChanges(t *testing.T) {
+	router := setupTestServer(t)
+	for _, build := range []BuildInfo{
+		{Version: "1.0", VersionCode: 1, Channel: "stable", FileName: "a.apk", UploadTime: "2024-01-01T00:00:00Z",
+			Permissions: []string{"android.permission.INTERNET", "android.permission.CAMERA"}},
+		{Version: "1.1", VersionCode: 2, Channel: "stable", FileName: "b.apk", UploadTime: "2024-01-02T00:00:00Z",
+			Permissions: []string{"android.permission.INTERNET", "android.permission.RECORD_AUDIO"}, TargetSDK: 34},
+		{Version: "1.2", VersionCode: 3, Channel: "beta", FileName: "c.apk", UploadTime: "2024-01-03T00:00:00Z"},
+	} {
+		if err := repo.UpsertBuild("Demo", testApp("com.example.perm"), build); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(query string) (int, PermissionChanges) {
+		rec := serve(router, http.MethodGet, "/api/apps/com.example.perm/permissions"+query)
+		var changes PermissionChanges
+		if rec.Code == http.StatusOK {
+			decodeJSON(t, rec, &changes)
+		}
+		return rec.Code, changes
+	}
+
+	code, changes := get("?channel=stable")
+	if code != http.StatusOK || changes.Build.FileName != "b.apk" || changes.Build.TargetSDK != 34 ||
+		changes.Base == nil || changes.Base.FileName != "a.apk" ||
+		!slices.Equal(changes.Added, []string{"android.permission.RECORD_AUDIO"}) ||
+		!slices.Equal(changes.Removed, []string{"android.permission.CAMERA"}) {
+		t.Fatalf("stable: status %d, %+v", code, changes)
+	}
+	// The oldest build has nothing to compare against
+	if code, changes := get("?version=1"); code != http.StatusOK || changes.Base != nil || len(changes.Added) != 2 {
+		t.Errorf("first build: status %d, %+v", code, changes)
+	}
+	// Across channels the beta build drops every permission of 1.0
+	if code, changes := get("?base=1.0"); code != http.StatusOK || changes.Build.FileName != "c.apk" ||
+		len(changes.Added) != 0 || len(changes.Removed) != 2 {
+		t.Errorf("base: status %d, %+v", code, changes)
+	}
+	for _, query := range []string{"?version=9.9", "?base=9.9", "?channel=nightly"} {
+		if code, _ := get(query); code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", query, code)
+		}
+	}
+}
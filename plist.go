@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Property lists are decoded into map[string]any, []any, string, int64,
+// float64, bool and []byte. Only what Info.plist needs is supported; dates
+// are kept as their string form.
+
+// plistMaxDepth bounds the nesting of containers, so a crafted plist cannot
+// exhaust the stack
+const plistMaxDepth = 64
+
+// binaryPlistMagic starts every binary property list
+const binaryPlistMagic = "bplist00"
+
+// parsePlist decodes an XML or binary property list
+func parsePlist(data []byte) (any, error) {
+	if bytes.HasPrefix(data, []byte(binaryPlistMagic)) {
+		return parseBinaryPlist(data)
+	}
+	return parseXMLPlist(data)
+}
+
+// parseXMLPlist decodes the <plist> document in data
+func parseXMLPlist(data []byte) (any, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, fmt.Errorf("plist: %w", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			if start.Name.Local != "plist" {
+				return nil, fmt.Errorf("plist: unexpected <%s>", start.Name.Local)
+			}
+			break
+		}
+	}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, fmt.Errorf("plist: %w", err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			return xmlPlistValue(d, tok, 0)
+		case xml.EndElement:
+			return nil, errors.New("plist: empty document")
+		}
+	}
+}
+
+// xmlPlistValue decodes the value element opened by start
+func xmlPlistValue(d *xml.Decoder, start xml.StartElement, depth int) (any, error) {
+	if depth > plistMaxDepth {
+		return nil, errors.New("plist: nested too deeply")
+	}
+	switch start.Name.Local {
+	case "dict":
+		dict := map[string]any{}
+		key, haveKey := "", false
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				return nil, fmt.Errorf("plist: %w", err)
+			}
+			switch tok := tok.(type) {
+			case xml.StartElement:
+				if tok.Name.Local == "key" {
+					if err := d.DecodeElement(&key, &tok); err != nil {
+						return nil, fmt.Errorf("plist: %w", err)
+					}
+					haveKey = true
+					continue
+				}
+				if !haveKey {
+					return nil, errors.New("plist: dict value without key")
+				}
+				value, err := xmlPlistValue(d, tok, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				dict[key] = value
+				haveKey = false
+			case xml.EndElement:
+				return dict, nil
+			}
+		}
+	case "array":
+		array := []any{}
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				return nil, fmt.Errorf("plist: %w", err)
+			}
+			switch tok := tok.(type) {
+			case xml.StartElement:
+				value, err := xmlPlistValue(d, tok, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, value)
+			case xml.EndElement:
+				return array, nil
+			}
+		}
+	case "true", "false":
+		if err := d.Skip(); err != nil {
+			return nil, fmt.Errorf("plist: %w", err)
+		}
+		return start.Name.Local == "true", nil
+	}
+
+	var text string
+	if err := d.DecodeElement(&text, &start); err != nil {
+		return nil, fmt.Errorf("plist: %w", err)
+	}
+	text = strings.TrimSpace(text)
+	switch start.Name.Local {
+	case "string", "date":
+		return text, nil
+	case "integer":
+		n, err := strconv.ParseInt(text, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("plist: invalid integer %q", text)
+		}
+		return n, nil
+	case "real":
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("plist: invalid real %q", text)
+		}
+		return f, nil
+	case "data":
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+		if err != nil {
+			return nil, fmt.Errorf("plist: invalid data: %w", err)
+		}
+		return decoded, nil
+	}
+	return nil, fmt.Errorf("plist: unknown element <%s>", start.Name.Local)
+}
+
+// binaryPlist decodes the objects of a binary property list on demand
+type binaryPlist struct {
+	data       []byte
+	offsets    []uint64
+	refSize    int
+	inProgress map[uint64]bool // objects being decoded, to reject cycles
+}
+
+// parseBinaryPlist decodes a bplist00 document from its trailer: the sizes
+// of offsets and object references, the object count, the top object and
+// where the offset table starts
+func parseBinaryPlist(data []byte) (any, error) {
+	if len(data) < len(binaryPlistMagic)+32 {
+		return nil, errors.New("plist: truncated binary plist")
+	}
+	trailer := data[len(data)-32:]
+	offsetSize := int(trailer[6])
+	refSize := int(trailer[7])
+	numObjects := binary.BigEndian.Uint64(trailer[8:])
+	topObject := binary.BigEndian.Uint64(trailer[16:])
+	tableOffset := binary.BigEndian.Uint64(trailer[24:])
+	if offsetSize < 1 || offsetSize > 8 || refSize < 1 || refSize > 8 ||
+		numObjects > uint64(len(data)) || topObject >= numObjects ||
+		tableOffset > uint64(len(data)) || numObjects*uint64(offsetSize) > uint64(len(data))-tableOffset {
+		return nil, errors.New("plist: invalid binary plist trailer")
+	}
+
+	p := &binaryPlist{data: data, refSize: refSize, inProgress: map[uint64]bool{}}
+	p.offsets = make([]uint64, numObjects)
+	for i := range p.offsets {
+		start := tableOffset + uint64(i*offsetSize)
+		p.offsets[i] = readBigEndian(data[start : start+uint64(offsetSize)])
+	}
+	return p.object(topObject, 0)
+}
+
+// readBigEndian reads an unsigned integer of up to 8 bytes
+func readBigEndian(b []byte) uint64 {
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n
+}
+
+// bytes returns n bytes at offset, failing when they run past the data
+func (p *binaryPlist) bytes(offset, n uint64) ([]byte, error) {
+	if offset > uint64(len(p.data)) || n > uint64(len(p.data))-offset {
+		return nil, errors.New("plist: object runs past the end")
+	}
+	return p.data[offset : offset+n], nil
+}
+
+// count returns the element count encoded in the low nibble of a marker,
+// reading the following integer object when it is 0xF, and the offset
+// after it
+func (p *binaryPlist) count(marker byte, offset uint64) (uint64, uint64, error) {
+	if n := marker & 0x0F; n != 0x0F {
+		return uint64(n), offset, nil
+	}
+	head, err := p.bytes(offset, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	if head[0]&0xF0 != 0x10 {
+		return 0, 0, errors.New("plist: invalid count")
+	}
+	size := uint64(1) << (head[0] & 0x0F)
+	if size > 8 {
+		return 0, 0, errors.New("plist: invalid count")
+	}
+	b, err := p.bytes(offset+1, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	return readBigEndian(b), offset + 1 + size, nil
+}
+
+// refs reads n object references starting at offset
+func (p *binaryPlist) refs(offset, n uint64) ([]uint64, error) {
+	if n > uint64(len(p.data)) {
+		return nil, errors.New("plist: object runs past the end")
+	}
+	b, err := p.bytes(offset, n*uint64(p.refSize))
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]uint64, n)
+	for i := range refs {
+		refs[i] = readBigEndian(b[i*p.refSize : (i+1)*p.refSize])
+	}
+	return refs, nil
+}
+
+// object decodes object number ref
+func (p *binaryPlist) object(ref uint64, depth int) (any, error) {
+	if ref >= uint64(len(p.offsets)) {
+		return nil, errors.New("plist: invalid object reference")
+	}
+	if depth > plistMaxDepth || p.inProgress[ref] {
+		return nil, errors.New("plist: nested too deeply")
+	}
+	offset := p.offsets[ref]
+	head, err := p.bytes(offset, 1)
+	if err != nil {
+		return nil, err
+	}
+	marker := head[0]
+	offset++
+
+	switch marker >> 4 {
+	case 0x0:
+		switch marker {
+		case 0x08:
+			return false, nil
+		case 0x09:
+			return true, nil
+		}
+		return nil, nil
+	case 0x1:
+		size := uint64(1) << (marker & 0x0F)
+		if size > 8 {
+			return nil, errors.New("plist: integer too large")
+		}
+		b, err := p.bytes(offset, size)
+		if err != nil {
+			return nil, err
+		}
+		return int64(readBigEndian(b)), nil
+	case 0x2:
+		size := uint64(1) << (marker & 0x0F)
+		b, err := p.bytes(offset, size)
+		if err != nil {
+			return nil, err
+		}
+		switch size {
+		case 4:
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+		case 8:
+			return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+		}
+		return nil, errors.New("plist: invalid real")
+	case 0x3:
+		b, err := p.bytes(offset, 8)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.FormatFloat(math.Float64frombits(binary.BigEndian.Uint64(b)), 'f', -1, 64), nil
+	case 0x4, 0x5, 0x6:
+		n, start, err := p.count(marker, offset)
+		if err != nil {
+			return nil, err
+		}
+		if marker>>4 == 0x6 {
+			if n > uint64(len(p.data)) {
+				return nil, errors.New("plist: object runs past the end")
+			}
+			b, err := p.bytes(start, 2*n)
+			if err != nil {
+				return nil, err
+			}
+			units := make([]uint16, n)
+			for i := range units {
+				units[i] = binary.BigEndian.Uint16(b[2*i:])
+			}
+			return string(utf16.Decode(units)), nil
+		}
+		b, err := p.bytes(start, n)
+		if err != nil {
+			return nil, err
+		}
+		if marker>>4 == 0x4 {
+			return append([]byte(nil), b...), nil
+		}
+		return string(b), nil
+	case 0x8:
+		b, err := p.bytes(offset, uint64(marker&0x0F)+1)
+		if err != nil {
+			return nil, err
+		}
+		return int64(readBigEndian(b)), nil
+	case 0xA, 0xD:
+		n, start, err := p.count(marker, offset)
+		if err != nil {
+			return nil, err
+		}
+		p.inProgress[ref] = true
+		defer delete(p.inProgress, ref)
+		if marker>>4 == 0xA {
+			refs, err := p.refs(start, n)
+			if err != nil {
+				return nil, err
+			}
+			array := make([]any, 0, len(refs))
+			for _, r := range refs {
+				value, err := p.object(r, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, value)
+			}
+			return array, nil
+		}
+		// Keys come first, then the values
+		refs, err := p.refs(start, 2*n)
+		if err != nil {
+			return nil, err
+		}
+		dict := make(map[string]any, n)
+		for i := uint64(0); i < n; i++ {
+			key, err := p.object(refs[i], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, errors.New("plist: dict key is not a string")
+			}
+			if dict[name], err = p.object(refs[n+i], depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	}
+	return nil, fmt.Errorf("plist: unknown object type 0x%02x", marker)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Values of Config.DowngradePolicy
+const (
+	downgradeOff    = "off"
+	downgradeWarn   = "warn"
+	downgradeStrict = "strict"
+)
+
+// PolicyViolation describes one upload policy rule a package failed
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Rules of a ChannelPolicy as written in APPDIST_CHANNEL_POLICIES; they
+// double as the Rule of the violations they report
+const (
+	ruleRequireNotes    = "requireNotes"
+	ruleIncreaseVersion = "increaseVersion"
+	ruleMaxSize         = "maxSize"
+	ruleMinSDK          = "minSdk"
+)
+
+// ChannelPolicy holds the extra upload rules of one channel. They add to
+// the global MaxUploadSize and MinSDK, the stricter limit winning.
+type ChannelPolicy struct {
+	RequireNotes    bool  // release notes must not be empty
+	IncreaseVersion bool  // versionCode must exceed every stored build of the app
+	MaxSize         int64 // largest accepted package in bytes, 0 means no extra limit
+	MinSDK          int   // lowest accepted minSdkVersion, 0 means no extra limit
+}
+
+// parseChannelPolicies parses "stable:requireNotes;increaseVersion;maxSize=104857600"
+// items of APPDIST_CHANNEL_POLICIES into a map keyed by the lower-cased
+// channel, since channels are matched case-insensitively as on the detail page.
+func parseChannelPolicies(items []string) (map[string]ChannelPolicy, error) {
+	policies := make(map[string]ChannelPolicy, len(items))
+	for _, item := range items {
+		channel, rules, ok := strings.Cut(item, ":")
+		channel = strings.ToLower(strings.TrimSpace(channel))
+		if !ok || channel == "" {
+			return nil, fmt.Errorf("环境变量 APPDIST_CHANNEL_POLICIES 取值无效: %s", item)
+		}
+		policy := policies[channel]
+		for _, rule := range strings.Split(rules, ";") {
+			name, value, hasValue := strings.Cut(strings.TrimSpace(rule), "=")
+			var err error
+			switch {
+			case name == "":
+				continue
+			case name == ruleRequireNotes && !hasValue:
+				policy.RequireNotes = true
+			case name == ruleIncreaseVersion && !hasValue:
+				policy.IncreaseVersion = true
+			case name == ruleMaxSize && hasValue:
+				policy.MaxSize, err = strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			case name == ruleMinSDK && hasValue:
+				policy.MinSDK, err = strconv.Atoi(strings.TrimSpace(value))
+			default:
+				err = errors.New("unknown rule")
+			}
+			if err != nil || policy.MaxSize < 0 || policy.MinSDK < 0 {
+				return nil, fmt.Errorf("环境变量 APPDIST_CHANNEL_POLICIES 取值无效: %s", rule)
+			}
+		}
+		policies[channel] = policy
+	}
+	return policies, nil
+}
+
+// uploadPolicy returns the rules that apply to uploads into channel: the
+// global limits merged with the channel's own policy.
+func uploadPolicy(channel string) ChannelPolicy {
+	policy := config.ChannelPolicies[strings.ToLower(channel)]
+	if config.MaxUploadSize > 0 && (policy.MaxSize == 0 || config.MaxUploadSize < policy.MaxSize) {
+		policy.MaxSize = config.MaxUploadSize
+	}
+	if config.MinSDK > policy.MinSDK {
+		policy.MinSDK = config.MinSDK
+	}
+	return policy
+}
+
+// checkUploadPolicy evaluates the upload policy of channel against a parsed
+// package and its release notes and returns every violated rule. An empty
+// result means the upload is acceptable. Every upload entry point runs it
+// after parsing, so the web form, the API and from-url uploads are held to
+// the same rules.
+func checkUploadPolicy(details ApkDetails, fileSize int64, channel, releaseNotes string) []PolicyViolation {
+	policy := uploadPolicy(channel)
+	var violations []PolicyViolation
+	if policy.MaxSize > 0 && fileSize > policy.MaxSize {
+		violations = append(violations, PolicyViolation{
+			Rule:    ruleMaxSize,
+			Message: fmt.Sprintf("文件大小 %s 超过上限 %s", formatSize(fileSize), formatSize(policy.MaxSize)),
+		})
+	}
+	// minSdk is an Android API level; it does not apply to IPAs and HAPs
+	if policy.MinSDK > 0 && platformName(details.Platform) == platformAndroid && int(details.MinSDK) < policy.MinSDK {
+		violations = append(violations, PolicyViolation{
+			Rule:    ruleMinSDK,
+			Message: fmt.Sprintf("minSdkVersion %d 低于要求的 %d", details.MinSDK, policy.MinSDK),
+		})
+	}
+	if policy.RequireNotes && strings.TrimSpace(releaseNotes) == "" {
+		violations = append(violations, PolicyViolation{
+			Rule:    ruleRequireNotes,
+			Message: fmt.Sprintf("渠道 %s 要求填写更新说明", channel),
+		})
+	}
+	if policy.IncreaseVersion {
+		mutex.Lock()
+		highest, found := highestVersionCode(details.PackageName, details.Platform, "")
+		mutex.Unlock()
+		if found && details.VersionCode <= highest {
+			violations = append(violations, PolicyViolation{
+				Rule:    ruleIncreaseVersion,
+				Message: fmt.Sprintf("渠道 %s 要求 versionCode 递增: 上传的 %d 不高于当前最高的 %d", channel, details.VersionCode, highest),
+			})
+		}
+	}
+	return violations
+}
+
+// detectDowngrade compares versionCode with the highest versionCode already
+// stored for packageName, or only in channel under APPDIST_DOWNGRADE_SCOPE
+// "channel". It returns a warning message when the upload is a downgrade
+// and the policy is not "off", and reports whether the policy requires the
+// upload to be rejected.
+func detectDowngrade(packageName, platform, channel string, versionCode int32, allowDowngrade bool) (warning string, reject bool) {
+	if config.DowngradePolicy == downgradeOff {
+		return "", false
+	}
+	if config.DowngradeScope != downgradeScopeChannel {
+		channel = ""
+	}
+
+	mutex.Lock()
+	highest, found := highestVersionCode(packageName, platform, channel)
+	mutex.Unlock()
+
+	if !found || versionCode >= highest {
+		return "", false
+	}
+	if channel != "" {
+		warning = fmt.Sprintf("版本降级: 上传的 versionCode %d 低于渠道 %s 当前最高的 %d", versionCode, channel, highest)
+	} else {
+		warning = fmt.Sprintf("版本降级: 上传的 versionCode %d 低于当前最高的 %d", versionCode, highest)
+	}
+	return warning, config.DowngradePolicy == downgradeStrict && !allowDowngrade
+}
+
+// highestVersionCode returns the largest versionCode among the stored builds
+// of packageName for platform in channel ("" for any); Android versionCodes
+// and iOS build numbers are not comparable. Builds uploaded before
+// versionCode was recorded are ignored. The caller must hold the mutex.
+func highestVersionCode(packageName, platform, channel string) (int32, bool) {
+	var highest int32
+	found := false
+	for _, project := range allProjects {
+		for _, app := range project.Apps {
+			if app.PackageName != packageName {
+				continue
+			}
+			for _, build := range app.Builds {
+				if build.VersionCode == 0 || buildPlatform(build) != platformName(platform) || (channel != "" && build.Channel != channel) {
+					continue
+				}
+				if !found || build.VersionCode > highest {
+					highest, found = build.VersionCode, true
+				}
+			}
+		}
+	}
+	return highest, found
+}
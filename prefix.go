@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// packagePrefixError is returned by Repository.UpsertBuild and MoveApp when
+// a package does not belong in the project it is added to
+type packagePrefixError struct {
+	ProjectName string
+	Prefix      string
+	PackageName string
+}
+
+func (e *packagePrefixError) Error() string {
+	return fmt.Sprintf("包名 %s 不符合项目 %s 要求的前缀 %s", e.PackageName, e.ProjectName, e.Prefix)
+}
+
+// parsePackagePrefixes parses "项目A=com.acme.,项目B=com.other." items of
+// APPDIST_PACKAGE_PREFIXES into a project name to prefix map.
+func parsePackagePrefixes(items []string) (map[string]string, error) {
+	prefixes := make(map[string]string, len(items))
+	for _, item := range items {
+		projectName, prefix, ok := strings.Cut(item, "=")
+		projectName, prefix = strings.TrimSpace(projectName), strings.TrimSpace(prefix)
+		if !ok || projectName == "" || prefix == "" {
+			return nil, fmt.Errorf("环境变量 APPDIST_PACKAGE_PREFIXES 取值无效: %s", item)
+		}
+		prefixes[projectName] = prefix
+	}
+	return prefixes, nil
+}
+
+// projectPackagePrefix returns the package name prefix required by
+// projectName: the one set through the API wins over the configured one, and
+// "" means any package is accepted. The caller must hold the mutex.
+func projectPackagePrefix(projectName string) string {
+	if i := findProject(projectName); i >= 0 && allProjects[i].PackagePrefix != "" {
+		return allProjects[i].PackagePrefix
+	}
+	return config.PackagePrefixes[projectName]
+}
+
+// checkPackagePrefix reports whether packageName may be uploaded into
+// projectName. The caller must hold the mutex.
+func checkPackagePrefix(projectName, packageName string) error {
+	prefix := projectPackagePrefix(projectName)
+	if prefix == "" || strings.HasPrefix(packageName, prefix) {
+		return nil
+	}
+	return &packagePrefixError{ProjectName: projectName, Prefix: prefix, PackageName: packageName}
+}
+
+// handleSetPackagePrefix sets the package name prefix of an existing project
+// with {"packagePrefix": "com.acme."}; an empty prefix falls back to the
+// configured one, if any. Apps already in the project are not moved, but
+// the ones that do not match are listed so they can be cleaned up.
+func handleSetPackagePrefix(c *gin.Context) {
+	if !checkAdmin(c) {
+		return
+	}
+	var req struct {
+		PackagePrefix *string `json:"packagePrefix"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.PackagePrefix == nil {
+		respondError(c, http.StatusBadRequest, "请求体格式错误，需要 packagePrefix 字段")
+		return
+	}
+	prefix := strings.TrimSpace(*req.PackagePrefix)
+	if strings.ContainsAny(prefix, " \t/") {
+		respondError(c, http.StatusBadRequest, "包名前缀格式错误")
+		return
+	}
+	projectName := c.Param("projectName")
+
+	project, err := repo.UpdateProject(projectName, func(project *Project) error {
+		project.PackagePrefix = prefix
+		return nil
+	})
+	if err != nil {
+		respondRepositoryError(c, err)
+		return
+	}
+
+	effective := project.PackagePrefix
+	if effective == "" {
+		effective = config.PackagePrefixes[projectName]
+	}
+	mismatched := []string{}
+	for _, app := range project.Apps {
+		if effective != "" && !strings.HasPrefix(app.PackageName, effective) {
+			mismatched = append(mismatched, app.PackageName)
+		}
+	}
+	logf(c, "项目 %s 的包名前缀已设置为 %q", projectName, prefix)
+	audit.record(c, AuditEntry{Action: auditPackagePrefix, ProjectName: projectName, Detail: prefix})
+	c.JSON(http.StatusOK, gin.H{
+		"projectName":    projectName,
+		"packagePrefix":  effective,
+		"mismatchedApps": mismatched,
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PreviousBuild is the response of the rollback lookup
+type PreviousBuild struct {
+	PackageName string    `json:"packageName"`
+	AppName     string    `json:"appName"`
+	Build       BuildInfo `json:"build"`
+	DownloadURL string    `json:"downloadURL"`
+	InstallURL  string    `json:"installURL"`
+	QRCodeURL   string    `json:"qrCodeURL"`
+}
+
+// buildsOfPackage returns the app name and a copy of every build of
+// packageName across all projects.
+func buildsOfPackage(packageName string) (string, []BuildInfo, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var appName string
+	var builds []BuildInfo
+	found := false
+	for _, project := range allProjects {
+		for _, app := range project.Apps {
+			if app.PackageName == packageName {
+				appName = app.AppName
+				builds = append(builds, app.Builds...)
+				found = true
+			}
+		}
+	}
+	return appName, builds, found
+}
+
+// buildBefore reports whether a sorts before b: by versionCode, then by
+// version name (see compareVersions) and upload time for builds that share
+// one or predate versionCode tracking.
+func buildBefore(a, b BuildInfo) bool {
+	if a.VersionCode != b.VersionCode {
+		return a.VersionCode < b.VersionCode
+	}
+	if c := compareVersions(a.Version, b.Version); c != 0 {
+		return c < 0
+	}
+	return a.UploadTime < b.UploadTime
+}
+
+// previousBuild returns the build of channel and platform ("" for any)
+// immediately prior to the version before. An empty before means the newest
+// build, so the result is the one to roll back to. Only builds of the
+// platform of that reference build are compared, since versionCodes of
+// Android and iOS builds are unrelated.
+func previousBuild(builds []BuildInfo, channel, platform, before string) (BuildInfo, bool) {
+	inChannel := channelBuilds(builds, channel, platform)
+	reference, found := newestBuild(inChannel, before)
+	if !found {
+		return BuildInfo{}, false
+	}
+
+	var prior BuildInfo
+	found = false
+	for _, build := range inChannel {
+		if buildPlatform(build) != buildPlatform(reference) ||
+			build.Version == reference.Version || !buildBefore(build, reference) {
+			continue
+		}
+		if !found || buildBefore(prior, build) {
+			prior, found = build, true
+		}
+	}
+	return prior, found
+}
+
+// channelBuilds returns the builds of channel and platform; "" matches
+// every channel or platform
+func channelBuilds(builds []BuildInfo, channel, platform string) []BuildInfo {
+	var inChannel []BuildInfo
+	for _, build := range builds {
+		if (channel == "" || build.Channel == channel) && (platform == "" || buildPlatform(build) == platform) {
+			inChannel = append(inChannel, build)
+		}
+	}
+	return inChannel
+}
+
+// newestBuild returns the newest build of version, matched against the
+// version name or versionCode; "" matches every build. Of several uploads of
+// the same version, the newest wins.
+func newestBuild(builds []BuildInfo, version string) (BuildInfo, bool) {
+	var newest BuildInfo
+	found := false
+	for _, build := range builds {
+		if version != "" && build.Version != version && strconv.Itoa(int(build.VersionCode)) != version {
+			continue
+		}
+		if !found || buildBefore(newest, build) {
+			newest, found = build, true
+		}
+	}
+	return newest, found
+}
+
+// handlePreviousBuild serves
+// GET /api/apps/:packageName/previous?channel=&platform=&before= with the
+// build to roll back to, including its download and QR code links.
+func handlePreviousBuild(c *gin.Context) {
+	platform := c.Query("platform")
+	if platform != "" && platform != platformAndroid && platform != platformIOS && platform != platformHarmony {
+		respondError(c, http.StatusBadRequest, "platform 参数只能是 android、ios 或 harmonyos")
+		return
+	}
+	packageName := c.Param("packageName")
+	appName, builds, found := buildsOfPackage(packageName)
+	if !found {
+		respondError(c, http.StatusNotFound, "应用未找到")
+		return
+	}
+
+	build, ok := previousBuild(builds, c.Query("channel"), platform, c.Query("before"))
+	if !ok {
+		respondError(c, http.StatusNotFound, "没有更早的构建版本")
+		return
+	}
+
+	install := installURL(packageName, build.FileName)
+	build.ReleaseNotesHTML = notesHTML(build.ReleaseNotes)
+	c.JSON(http.StatusOK, PreviousBuild{
+		PackageName: packageName,
+		AppName:     appName,
+		Build:       build,
+		DownloadURL: withBasePath(build.DownloadURL),
+		InstallURL:  withBasePath(install),
+		QRCodeURL:   withBasePath("/qr?url=" + url.QueryEscape(requestBaseURL(c)+install)),
+	})
+}
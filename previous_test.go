@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPreviousBuildPerPlatform(t *testing.T) {
+	router := setupTestServer(t)
+	// iOS build numbers run far ahead of the Android versionCodes
+	for _, build := range []BuildInfo{
+		{Version: "1.0", VersionCode: 10, Channel: "stable", FileName: "a10.apk", UploadTime: "2024-01-01T00:00:00Z"},
+		{Version: "1.0", VersionCode: 300, Channel: "stable", FileName: "i300.ipa", Platform: platformIOS, UploadTime: "2024-01-02T00:00:00Z"},
+		{Version: "1.1", VersionCode: 11, Channel: "stable", FileName: "a11.apk", UploadTime: "2024-01-03T00:00:00Z"},
+		{Version: "1.1", VersionCode: 400, Channel: "stable", FileName: "i400.ipa", Platform: platformIOS, UploadTime: "2024-01-04T00:00:00Z"},
+	} {
+		if err := repo.UpsertBuild("Demo", testApp("com.example.multi"), build); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	previous := func(query string) (int, string) {
+		rec := serve(router, http.MethodGet, "/api/apps/com.example.multi/previous?channel=stable"+query)
+		var result PreviousBuild
+		if rec.Code == http.StatusOK {
+			decodeJSON(t, rec, &result)
+		}
+		return rec.Code, result.Build.FileName
+	}
+	for query, want := range map[string]string{
+		"":                          "i300.ipa",
+		"&platform=android":         "a10.apk",
+		"&platform=ios":             "i300.ipa",
+		"&before=11":                "a10.apk",
+		"&platform=android&before=": "a10.apk",
+	} {
+		if code, got := previous(query); code != http.StatusOK || got != want {
+			t.Errorf("%q: status %d, build %q, want %q", query, code, got, want)
+		}
+	}
+	for query, want := range map[string]int{
+		"&platform=windows":   http.StatusBadRequest,
+		"&platform=harmonyos": http.StatusNotFound,
+		"&before=300":         http.StatusNotFound, // not a11.apk
+	} {
+		if code, _ := previous(query); code != want {
+			t.Errorf("%q: status %d, want %d", query, code, want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// moveStoredFile gives the stored package oldName the name newName, or a
+// numbered variant of it when taken, and returns the name used. Local
+// packages are renamed in place; remote ones are copied and deleted.
+func moveStoredFile(oldName, newName string) (string, error) {
+	path, release, err := localCopy(oldName)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	name, err := storeBuildFile(path, newName)
+	if err != nil {
+		return "", err
+	}
+	if _, local := localStoragePath(oldName); !local {
+		if err := storage.Delete(oldName); err != nil {
+			slog.Warn("删除重命名前的文件失败", "file", oldName, "error", err)
+		}
+	}
+	return name, nil
+}
+
+// renameBuildFile stores the package of build under a name for channel, as
+// an upload to that channel would be named, along with its universal APK
+// and expansion files, and returns the build with the new names. undo moves
+// the files back.
+func renameBuildFile(packageName string, build BuildInfo, channel string) (renamed BuildInfo, undo func(), err error) {
+	details := ApkDetails{PackageName: packageName, Version: build.Version, Platform: build.Platform, Format: build.Format}
+	renamed = build
+	if renamed.FileName, err = moveStoredFile(build.FileName, buildFileName(details, channel, time.Now())); err != nil {
+		return build, nil, err
+	}
+	renamed.DownloadURL = "/downloads/" + renamed.FileName
+	undos := []func(){func() { moveStoredFile(renamed.FileName, build.FileName) }}
+	undo = func() {
+		for k := len(undos) - 1; k >= 0; k-- {
+			undos[k]()
+		}
+	}
+
+	if build.UniversalAPK != "" {
+		if renamed.UniversalAPK, err = moveStoredFile(build.UniversalAPK, universalAPKName(renamed.FileName)); err != nil {
+			undo()
+			return build, nil, err
+		}
+		undos = append(undos, func() { moveStoredFile(renamed.UniversalAPK, build.UniversalAPK) })
+	}
+	if len(build.Expansions) > 0 {
+		if err = os.Rename(expansionDir(build.FileName), expansionDir(renamed.FileName)); err != nil {
+			undo()
+			return build, nil, err
+		}
+		undos = append(undos, func() { os.Rename(expansionDir(renamed.FileName), expansionDir(build.FileName)) })
+		renamed.Expansions = make([]ExpansionFile, len(build.Expansions))
+		for k, expansion := range build.Expansions {
+			expansion.DownloadURL = strings.Replace(expansion.DownloadURL, "/"+expansionDirName(build.FileName)+"/", "/"+expansionDirName(renamed.FileName)+"/", 1)
+			renamed.Expansions[k] = expansion
+		}
+	}
+	return renamed, undo, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// promote posts body to the promote endpoint of fileName
+func promote(router *gin.Engine, fileName, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/builds/"+fixturePackage+"/"+fileName+"/promote", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(adminPasswordHeader, testAdminPassword)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPromoteMoveAndRename(t *testing.T) {
+	router := setupTestServer(t)
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "beta"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	fileName := appBuilds(t, router, fixturePackage)[0].FileName
+
+	if rec := promote(router, fileName, `{"channel":"rc"}`); rec.Code != http.StatusOK {
+		t.Fatalf("copy: status %d: %s", rec.Code, rec.Body.String())
+	}
+	// Renaming a file two entries share, or without moving, is refused
+	if rec := promote(router, fileName, `{"channel":"stable","from":"rc","move":true,"rename":true}`); rec.Code != http.StatusConflict {
+		t.Errorf("rename shared file: status %d, want 409", rec.Code)
+	}
+	if rec := promote(router, fileName, `{"channel":"stable","rename":true}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("rename without move: status %d, want 400", rec.Code)
+	}
+
+	if rec := promote(router, fileName, `{"channel":"qa","from":"beta","move":true}`); rec.Code != http.StatusOK {
+		t.Fatalf("move: status %d: %s", rec.Code, rec.Body.String())
+	}
+	channels := map[string]bool{}
+	for _, build := range appBuilds(t, router, fixturePackage) {
+		channels[build.Channel] = true
+	}
+	if len(channels) != 2 || !channels["rc"] || !channels["qa"] {
+		t.Fatalf("channels after move = %v", channels)
+	}
+
+	if rec := serveAdmin(router, http.MethodDelete, "/api/builds/"+fixturePackage+"/"+fileName+"?channel=rc"); rec.Code != http.StatusOK {
+		t.Fatalf("delete rc entry: status %d", rec.Code)
+	}
+	rec := promote(router, fileName, `{"channel":"stable","move":true,"rename":true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("move and rename: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var result struct {
+		Build BuildInfo `json:"build"`
+	}
+	decodeJSON(t, rec, &result)
+	renamed := result.Build
+	if !strings.Contains(renamed.FileName, "-stable-") || renamed.PromotedFrom != "qa" || renamed.DownloadURL != "/downloads/"+renamed.FileName {
+		t.Fatalf("renamed build = %+v", renamed)
+	}
+	if _, err := os.Stat(filepath.Join("uploads", fileName)); !os.IsNotExist(err) {
+		t.Errorf("old file still present: %v", err)
+	}
+	if builds := appBuilds(t, router, fixturePackage); len(builds) != 1 || builds[0].FileName != renamed.FileName {
+		t.Errorf("builds after rename = %+v", builds)
+	}
+	if rec := serve(router, http.MethodGet, renamed.DownloadURL); rec.Code != http.StatusOK {
+		t.Errorf("download renamed file: status %d", rec.Code)
+	}
+}
+
+func TestPromoteRenameSharedAcrossProjects(t *testing.T) {
+	router := setupTestServer(t)
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "beta"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	// The same content in another project references the stored file
+	if rec := uploadFixture(router, fixtureAPK(t), "Other", "beta"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "已复用其文件") {
+		t.Fatalf("duplicate upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	fileName := appBuilds(t, router, fixturePackage)[0].FileName
+
+	// Renaming would break the download of the other project's entry
+	if rec := promote(router, fileName, `{"channel":"stable","move":true,"rename":true}`); rec.Code != http.StatusConflict {
+		t.Fatalf("rename file shared across projects: status %d, want 409: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join("uploads", fileName)); err != nil {
+		t.Errorf("shared file after refused rename: %v", err)
+	}
+	if rec := serve(router, http.MethodGet, "/downloads/"+fileName); rec.Code != http.StatusOK {
+		t.Errorf("download of the shared file: status %d", rec.Code)
+	}
+
+	// Moving without renaming keeps the file where both entries find it
+	if rec := promote(router, fileName, `{"channel":"stable","move":true}`); rec.Code != http.StatusOK {
+		t.Fatalf("move: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve(router, http.MethodGet, "/downloads/"+fileName); rec.Code != http.StatusOK {
+		t.Errorf("download after move: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isForced reports whether a request sets force=true, which deletes
+// protected builds and accepts uploads the downgrade check rejects
+func isForced(value string) bool {
+	return value == "1" || strings.EqualFold(value, "true")
+}
+
+// hasProtected reports whether any of builds is protected
+func hasProtected(builds []BuildInfo) bool {
+	for _, build := range builds {
+		if build.Protected {
+			return true
+		}
+	}
+	return false
+}
+
+// forcedDetail notes in the audit log that a delete removed protected builds
+func forcedDetail(plan DeletePlan) string {
+	if hasProtected(plan.Builds) {
+		return "force: protected builds removed"
+	}
+	return ""
+}
+
+// handleSetProtected pins a build with {"protected": true}, or unpins it
+// with false. Protected builds are skipped by the retention policies and
+// the delete endpoints refuse them without ?force=true. channel picks one
+// entry of a promoted build, without it every entry of the file changes.
+func handleSetProtected(c *gin.Context) {
+	if !checkAdmin(c) {
+		return
+	}
+	var req struct {
+		Protected *bool `json:"protected"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Protected == nil {
+		respondError(c, http.StatusBadRequest, "请求体格式错误，需要 protected 字段")
+		return
+	}
+	packageName := c.Param("packageName")
+	fileName := c.Param("fileName")
+	channel := c.Query("channel")
+
+	projectName, edited, err := updateBuilds(packageName, fileName, channel, func(build *BuildInfo) error {
+		build.Protected = *req.Protected
+		return nil
+	})
+	if err != nil {
+		respondRepositoryError(c, err)
+		return
+	}
+	state := "已取消保护"
+	if *req.Protected {
+		state = "已设为受保护"
+	}
+	logf(c, "构建 %s %s", fileName, state)
+	audit.record(c, AuditEntry{Action: auditProtect, ProjectName: projectName, PackageName: packageName, FileName: fileName, Channel: channel,
+		Detail: strconv.FormatBool(*req.Protected)})
+
+	c.Header("ETag", catalogVersionETag())
+	c.JSON(http.StatusOK, gin.H{"message": "构建保护状态已更新", "builds": withNotesHTML(edited)})
+}
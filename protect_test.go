@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProtectedBuilds(t *testing.T) {
+	router := setupTestServer(t)
+	old := timestamp(time.Now().AddDate(0, 0, -30))
+	for _, build := range []BuildInfo{
+		{Version: "1.0", Channel: "beta", FileName: "rc.apk", UploadTime: old},
+		{Version: "1.1", Channel: "beta", FileName: "new.apk", UploadTime: timestamp(time.Now())},
+	} {
+		if err := os.WriteFile(filepath.Join(config.UploadDir, build.FileName), []byte("apk"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.UpsertBuild("Demo", testApp("com.example.rc"), build); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	put := func(target, body string) int {
+		req := httptest.NewRequest(http.MethodPut, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(adminPasswordHeader, testAdminPassword)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := put("/api/builds/com.example.rc/rc.apk/protected", `{}`); code != http.StatusBadRequest {
+		t.Errorf("missing field: status %d, want 400", code)
+	}
+	if code := put("/api/builds/com.example.rc/missing.apk/protected", `{"protected": true}`); code != http.StatusNotFound {
+		t.Errorf("unknown build: status %d, want 404", code)
+	}
+	if code := put("/api/builds/com.example.rc/rc.apk/protected", `{"protected": true}`); code != http.StatusOK {
+		t.Fatalf("protect: status %d", code)
+	}
+	if builds := appBuilds(t, router, "com.example.rc"); len(builds) != 2 || !builds[1].Protected {
+		t.Fatalf("builds after protecting = %+v", builds)
+	}
+
+	// Retention keeps the protected build although it is expired
+	if code := put("/api/projects/Demo/retention", `{"keepPerChannel": 1}`); code != http.StatusOK {
+		t.Fatalf("set policy: status %d", code)
+	}
+	if expired := findExpiredBuilds(time.Now()); len(expired) != 0 {
+		t.Errorf("expired = %+v, want none", expired)
+	}
+
+	// Deletes, including dry runs, need force
+	for _, target := range []string{
+		"/api/builds/com.example.rc/rc.apk",
+		"/api/builds/com.example.rc/rc.apk?dryRun=true",
+		"/api/apps/com.example.rc",
+	} {
+		if rec := serveAdmin(router, http.MethodDelete, target); rec.Code != http.StatusConflict {
+			t.Errorf("DELETE %s: status %d, want 409", target, rec.Code)
+		}
+	}
+	if builds := appBuilds(t, router, "com.example.rc"); len(builds) != 2 {
+		t.Fatalf("builds after refused deletes = %+v", builds)
+	}
+	if rec := serveAdmin(router, http.MethodDelete, "/api/builds/com.example.rc/rc.apk?force=true"); rec.Code != http.StatusOK {
+		t.Fatalf("forced delete: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(config.UploadDir, "rc.apk")); !os.IsNotExist(err) {
+		t.Errorf("forced delete kept the file: %v", err)
+	}
+	// Unprotected builds delete as before
+	if rec := serveAdmin(router, http.MethodDelete, "/api/apps/com.example.rc"); rec.Code != http.StatusOK {
+		t.Errorf("delete app: status %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestBaseURL returns the scheme, host and base path the client used to
+// reach us; append a root-relative path such as BuildInfo.DownloadURL to it.
+// Config.ExternalURL wins; otherwise the forwarded headers of a trusted
+// reverse proxy are honored, as it may terminate TLS or rewrite the host.
+func requestBaseURL(c *gin.Context) string {
+	if config.ExternalURL != "" {
+		return config.ExternalURL + config.BasePath
+	}
+	host := c.Request.Host
+	if fromTrustedProxy(c) {
+		if forwarded := firstHeaderValue(c, "X-Forwarded-Host"); forwarded != "" {
+			host = forwarded
+		}
+	}
+	return fmt.Sprintf("%s://%s%s", requestScheme(c), host, config.BasePath)
+}
+
+// requestScheme returns "https" when the client connected over TLS, either
+// directly, through a trusted proxy reporting X-Forwarded-Proto, or as
+// implied by Config.ExternalURL
+func requestScheme(c *gin.Context) string {
+	if config.ExternalURL != "" {
+		return strings.SplitN(config.ExternalURL, ":", 2)[0]
+	}
+	if c.Request.TLS != nil {
+		return "https"
+	}
+	if fromTrustedProxy(c) {
+		if proto := strings.ToLower(firstHeaderValue(c, "X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			return proto
+		}
+	}
+	return "http"
+}
+
+// fromTrustedProxy reports whether the peer of the connection is one of
+// Config.TrustedProxies
+func fromTrustedProxy(c *gin.Context) bool {
+	if len(config.trustedProxies) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(c.RemoteIP())
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range config.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstHeaderValue returns the first entry of a comma separated header that
+// proxy chains append to
+func firstHeaderValue(c *gin.Context, name string) string {
+	value, _, _ := strings.Cut(c.GetHeader(name), ",")
+	return strings.TrimSpace(value)
+}
+
+// parseTrustedProxies turns IPs and CIDRs into prefixes
+func parseTrustedProxies(items []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range items {
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, err
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// normalizeExternalURL checks an absolute http(s) URL without path, query
+// or fragment and drops a trailing "/"
+func normalizeExternalURL(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("需要以 http:// 或 https:// 开头的地址: " + raw)
+	}
+	if strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", errors.New("地址不能包含路径，请用 APPDIST_BASE_PATH 设置前缀: " + raw)
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
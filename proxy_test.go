@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestBaseURL(t *testing.T) {
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config = defaultConfig()
+	config.BasePath = "/apps"
+
+	baseURL := func(remoteAddr string) string {
+		req := httptest.NewRequest("GET", "http://internal:8080/apps/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", "apps.example.com, internal")
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = req
+		return requestBaseURL(c)
+	}
+
+	if got := baseURL("10.0.0.5:4000"); got != "http://internal:8080/apps" {
+		t.Errorf("without trusted proxies: %s", got)
+	}
+
+	var err error
+	config.TrustedProxies = []string{"10.0.0.0/8", "::1"}
+	if config.trustedProxies, err = parseTrustedProxies(config.TrustedProxies); err != nil {
+		t.Fatal(err)
+	}
+	if got := baseURL("10.0.0.5:4000"); got != "https://apps.example.com/apps" {
+		t.Errorf("from a trusted proxy: %s", got)
+	}
+	if got := baseURL("[::1]:4000"); got != "https://apps.example.com/apps" {
+		t.Errorf("from a trusted IPv6 proxy: %s", got)
+	}
+	if got := baseURL("192.0.2.1:4000"); got != "http://internal:8080/apps" {
+		t.Errorf("from an untrusted peer: %s", got)
+	}
+
+	config.ExternalURL = "https://dl.example.com"
+	if got := baseURL("192.0.2.1:4000"); got != "https://dl.example.com/apps" {
+		t.Errorf("with an external URL: %s", got)
+	}
+}
+
+func TestExternalURLConfig(t *testing.T) {
+	for raw, want := range map[string]string{
+		"":                          "",
+		"https://apps.example.com/": "https://apps.example.com",
+		"http://10.0.0.2:8080":      "http://10.0.0.2:8080",
+	} {
+		if got, err := normalizeExternalURL(raw); err != nil || got != want {
+			t.Errorf("normalizeExternalURL(%q) = %q, %v, want %q", raw, got, err, want)
+		}
+	}
+	for _, raw := range []string{"apps.example.com", "ftp://apps.example.com", "https://apps.example.com/apps"} {
+		if _, err := normalizeExternalURL(raw); err == nil {
+			t.Errorf("normalizeExternalURL(%q) accepted", raw)
+		}
+	}
+	if _, err := parseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("invalid CIDR accepted")
+	}
+}
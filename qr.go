@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+// Sizes in pixels accepted by the QR code endpoints
+const (
+	qrDefaultSize = 256
+	qrMinSize     = 64
+	qrMaxSize     = 2048
+)
+
+// qrLevels maps the level query value to the error correction level; a
+// higher level survives more damage, or a logo on top, at the cost of
+// denser codes
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// writeQR answers c with a PNG QR code of content, sized and corrected as
+// the size and level query values ask
+func writeQR(c *gin.Context, content string) {
+	size := qrDefaultSize
+	if value := c.Query("size"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < qrMinSize || n > qrMaxSize {
+			respondText(c, http.StatusBadRequest, "size 参数应为 %d 到 %d 之间的整数", qrMinSize, qrMaxSize)
+			return
+		}
+		size = n
+	}
+	level := qrcode.Medium
+	if value := c.Query("level"); value != "" {
+		var ok bool
+		if level, ok = qrLevels[strings.ToUpper(value)]; !ok {
+			respondText(c, http.StatusBadRequest, "level 参数只能是 L、M、Q 或 H")
+			return
+		}
+	}
+
+	png, err := qrcode.Encode(content, level, size)
+	if err != nil {
+		respondText(c, http.StatusInternalServerError, "无法生成二维码")
+		return
+	}
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+// handleQR serves GET /qr?url= with a QR code of an arbitrary URL
+func handleQR(c *gin.Context) {
+	urlToEncode := c.Query("url")
+	if urlToEncode == "" {
+		respondText(c, http.StatusBadRequest, "URL 参数缺失")
+		return
+	}
+	writeQR(c, urlToEncode)
+}
+
+// handleBuildQR serves GET /qr/build/:packageName/:fileName with a QR code
+// of the install link of one build, for posters and chat messages
+func handleBuildQR(c *gin.Context) {
+	packageName, fileName := c.Param("packageName"), c.Param("fileName")
+	_, app, found := repo.FindApp(packageName)
+	if !found {
+		respondText(c, http.StatusNotFound, "应用未找到")
+		return
+	}
+	if !publicLinkAllowed(c, app) {
+		return
+	}
+	for _, build := range app.Builds {
+		if build.FileName == fileName {
+			writeQR(c, requestBaseURL(c)+installURL(packageName, fileName))
+			return
+		}
+	}
+	respondText(c, http.StatusNotFound, "构建版本未找到")
+}
+
+// handleLatestQR serves GET /qr/latest/:packageName/:channel with a QR code
+// of the install link of the newest installable build in the channel. The
+// platform query value picks among builds of an app with several; it
+// defaults to the app's only platform, or android.
+func handleLatestQR(c *gin.Context) {
+	packageName, channel := c.Param("packageName"), c.Param("channel")
+	_, app, found := repo.FindApp(packageName)
+	if !found {
+		respondText(c, http.StatusNotFound, "应用未找到")
+		return
+	}
+	if !publicLinkAllowed(c, app) {
+		return
+	}
+	platform := c.Query("platform")
+	if platform == "" {
+		platform = defaultPlatform(app)
+	}
+	build, ok := latestBuild(app.Builds, channel, platformName(platform))
+	if !ok {
+		respondText(c, http.StatusNotFound, "该渠道没有可用的构建版本")
+		return
+	}
+	// The code points at a fixed build, so it must not outlive the next upload
+	c.Header("Cache-Control", "no-cache")
+	writeQR(c, requestBaseURL(c)+installURL(packageName, build.FileName))
+}
+
+// publicLinkAllowed refuses shareable links and QR codes for private apps,
+// whose install links only work when signed, writing a 403 response
+func publicLinkAllowed(c *gin.Context, app AppEntry) bool {
+	if app.Private {
+		respondText(c, http.StatusForbidden, "应用 %s 为私有应用，请使用签名下载链接", app.PackageName)
+		return false
+	}
+	return true
+}
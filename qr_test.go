@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBuildQR(t *testing.T) {
+	router := setupTestServer(t)
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	fileName := someBuildFile(fixturePackage)
+
+	for target, wantSize := range map[string]int{
+		"/qr/build/" + fixturePackage + "/" + fileName:              qrDefaultSize,
+		"/qr/latest/" + fixturePackage + "/stable?size=512&level=h": 512,
+		"/qr?url=https://example.com&size=128":                      128,
+	} {
+		rec := serve(router, http.MethodGet, target)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+			t.Errorf("%s: status %d: %s", target, rec.Code, rec.Body.String())
+			continue
+		}
+		img, err := png.Decode(bytes.NewReader(rec.Body.Bytes()))
+		if err != nil || img.Bounds().Dx() != wantSize {
+			t.Errorf("%s: %v, size %v", target, err, img)
+		}
+	}
+
+	for target, want := range map[string]int{
+		"/qr/build/" + fixturePackage + "/missing.apk":              http.StatusNotFound,
+		"/qr/latest/" + fixturePackage + "/beta":                    http.StatusNotFound,
+		"/qr/latest/com.example.missing/stable":                     http.StatusNotFound,
+		"/qr/build/" + fixturePackage + "/" + fileName + "?size=10": http.StatusBadRequest,
+		"/qr/build/" + fixturePackage + "/" + fileName + "?level=X": http.StatusBadRequest,
+	} {
+		if rec := serve(router, http.MethodGet, target); rec.Code != want {
+			t.Errorf("%s: status %d, want %d", target, rec.Code, want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/api/apps/"+fixturePackage+"/private", strings.NewReader(`{"private":true}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(adminPasswordHeader, testAdminPassword)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("set private: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve(router, http.MethodGet, "/qr/latest/"+fixturePackage+"/stable"); rec.Code != http.StatusForbidden {
+		t.Errorf("QR of a private app: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// quotaUnlimited set as a project's quota lifts APPDIST_PROJECT_QUOTA for it
+const quotaUnlimited = -1
+
+// quotaExceededError is returned by checkProjectQuota when storing more
+// bytes would take a project over its quota
+type quotaExceededError struct {
+	ProjectName string
+	UsedBytes   int64
+	QuotaBytes  int64
+	FileSize    int64
+}
+
+func (e *quotaExceededError) Error() string {
+	return fmt.Sprintf("项目 %s 的存储空间不足: 已用 %s，配额 %s，本次上传 %s",
+		e.ProjectName, formatSize(e.UsedBytes), formatSize(e.QuotaBytes), formatSize(e.FileSize))
+}
+
+// AppQuotaUsage is the storage used by one app
+type AppQuotaUsage struct {
+	PackageName string `json:"packageName"`
+	AppName     string `json:"appName"`
+	Files       int    `json:"files"`
+	UsedBytes   int64  `json:"usedBytes"`
+}
+
+// ProjectQuotaUsage is the storage used by a project and its quota
+type ProjectQuotaUsage struct {
+	ProjectName string `json:"projectName"`
+	Files       int    `json:"files"`
+	UsedBytes   int64  `json:"usedBytes"`
+	// QuotaBytes is 0 when the project is unlimited
+	QuotaBytes     int64           `json:"quotaBytes"`
+	RemainingBytes *int64          `json:"remainingBytes,omitempty"`
+	Apps           []AppQuotaUsage `json:"apps"`
+}
+
+// projectQuota returns the quota of projectName in bytes, 0 meaning
+// unlimited: the one set through the API wins over APPDIST_PROJECT_QUOTA.
+// The caller must hold the mutex.
+func projectQuota(projectName string) int64 {
+	if i := findProject(projectName); i >= 0 && allProjects[i].QuotaBytes != 0 {
+		return max(allProjects[i].QuotaBytes, 0)
+	}
+	return config.ProjectQuota
+}
+
+// addFile accounts for a build file or expansion file unless seen already
+func addFile(seen map[string]bool, fileName string, size int64, files *int, bytes *int64) {
+	if seen[fileName] {
+		return
+	}
+	seen[fileName] = true
+	*files++
+	*bytes += size
+}
+
+// projectQuotaUsage sums the sizes recorded in the metadata for the build
+// and expansion files of a project. Files shared by several entries, such as
+// promoted ones, count once. The caller must hold the mutex.
+func projectQuotaUsage(project Project) ProjectQuotaUsage {
+	usage := ProjectQuotaUsage{ProjectName: project.ProjectName, QuotaBytes: projectQuota(project.ProjectName), Apps: []AppQuotaUsage{}}
+	seenProject := make(map[string]bool)
+	for _, app := range project.Apps {
+		appUsage := AppQuotaUsage{PackageName: app.PackageName, AppName: app.AppName}
+		seenApp := make(map[string]bool)
+		for _, build := range app.Builds {
+			addFile(seenApp, build.FileName, build.FileSize, &appUsage.Files, &appUsage.UsedBytes)
+			addFile(seenProject, build.FileName, build.FileSize, &usage.Files, &usage.UsedBytes)
+			for _, expansion := range build.Expansions {
+				name := expansionPath(build.FileName, expansion.FileName)
+				addFile(seenApp, name, expansion.FileSize, &appUsage.Files, &appUsage.UsedBytes)
+				addFile(seenProject, name, expansion.FileSize, &usage.Files, &usage.UsedBytes)
+			}
+		}
+		usage.Apps = append(usage.Apps, appUsage)
+	}
+	if usage.QuotaBytes > 0 {
+		remaining := max(usage.QuotaBytes-usage.UsedBytes, 0)
+		usage.RemainingBytes = &remaining
+	}
+	return usage
+}
+
+// checkProjectQuota reports whether size more bytes fit into the quota of
+// projectName; freed is what the upload replaces, e.g. an expansion file of
+// the same kind. The caller must hold the mutex.
+func checkProjectQuota(projectName string, size, freed int64) error {
+	quota := projectQuota(projectName)
+	if quota <= 0 {
+		return nil
+	}
+	var used int64
+	if i := findProject(projectName); i >= 0 {
+		used = projectQuotaUsage(allProjects[i]).UsedBytes
+	}
+	if used-freed+size <= quota {
+		return nil
+	}
+	return &quotaExceededError{ProjectName: projectName, UsedBytes: used, QuotaBytes: quota, FileSize: size}
+}
+
+// respondQuotaExceeded answers an upload rejected by checkProjectQuota with
+// 413, listing the numbers for API clients
+func respondQuotaExceeded(c *gin.Context, err *quotaExceededError) {
+	respondUploadError(c, quotaError(err))
+}
+
+// quotaError is the uploadError of an upload rejected by checkProjectQuota
+func quotaError(err *quotaExceededError) *uploadError {
+	return &uploadError{status: http.StatusRequestEntityTooLarge, message: err.Error(), details: []string{"请删除旧构建或联系管理员提高项目配额"},
+		fields: gin.H{"projectName": err.ProjectName, "usedBytes": err.UsedBytes, "quotaBytes": err.QuotaBytes, "fileSize": err.FileSize}}
+}
+
+// handleStorageQuotaUsage serves GET /api/storage/usage, the bytes used by
+// every project and app against the project quotas; ?project= narrows it
+// to one project.
+func handleStorageQuotaUsage(c *gin.Context) {
+	projectName := c.Query("project")
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	var total int64
+	projects := []ProjectQuotaUsage{}
+	for _, project := range allProjects {
+		if (projectName != "" && project.ProjectName != projectName) || !canViewProject(c, project) {
+			continue
+		}
+		usage := projectQuotaUsage(project)
+		total += usage.UsedBytes
+		projects = append(projects, usage)
+	}
+	if projectName != "" && len(projects) == 0 {
+		respondError(c, http.StatusNotFound, "项目未找到")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"usedBytes": total, "projects": projects})
+}
+
+// handleSetQuota sets the storage quota of an existing project with
+// {"quotaBytes": 10737418240}; 0 falls back to APPDIST_PROJECT_QUOTA and -1
+// makes the project unlimited. Builds already stored are kept even when they
+// exceed the new quota.
+func handleSetQuota(c *gin.Context) {
+	if !checkAdmin(c) {
+		return
+	}
+	var req struct {
+		QuotaBytes *int64 `json:"quotaBytes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.QuotaBytes == nil {
+		respondError(c, http.StatusBadRequest, "请求体格式错误，需要 quotaBytes 字段")
+		return
+	}
+	if *req.QuotaBytes < quotaUnlimited {
+		respondError(c, http.StatusBadRequest, "quotaBytes 必须大于等于 -1")
+		return
+	}
+	projectName := c.Param("projectName")
+
+	project, err := repo.UpdateProject(projectName, func(project *Project) error {
+		project.QuotaBytes = *req.QuotaBytes
+		return nil
+	})
+	if err != nil {
+		respondRepositoryError(c, err)
+		return
+	}
+	logf(c, "项目 %s 的存储配额已设置为 %d 字节", projectName, *req.QuotaBytes)
+	audit.record(c, AuditEntry{Action: auditQuota, ProjectName: projectName, Detail: strconv.FormatInt(*req.QuotaBytes, 10)})
+	mutex.Lock()
+	usage := projectQuotaUsage(project)
+	mutex.Unlock()
+	c.JSON(http.StatusOK, usage)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestProjectQuota(t *testing.T) {
+	router := setupTestServer(t)
+	config.DuplicateUploads = duplicateStore
+	apk := fixtureAPK(t)
+
+	setQuota := func(body string) int {
+		req := httptest.NewRequest(http.MethodPut, "/api/projects/Demo/quota", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(adminPasswordHeader, testAdminPassword)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := setQuota(`{"quotaBytes": 1}`); code != http.StatusNotFound {
+		t.Errorf("unknown project: status %d, want 404", code)
+	}
+	if rec := uploadFixture(router, apk, "Demo", "beta"); rec.Code != http.StatusOK {
+		t.Fatalf("first upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if code := setQuota(`{"quotaBytes": -2}`); code != http.StatusBadRequest {
+		t.Errorf("invalid quota: status %d, want 400", code)
+	}
+	// Room for one more copy of the fixture, not two
+	if code := setQuota(`{"quotaBytes": ` + strconv.Itoa(2*len(apk)+len(apk)/2) + `}`); code != http.StatusOK {
+		t.Fatalf("set quota: status %d", code)
+	}
+
+	usage := func() ProjectQuotaUsage {
+		var result struct {
+			UsedBytes int64               `json:"usedBytes"`
+			Projects  []ProjectQuotaUsage `json:"projects"`
+		}
+		decodeJSON(t, serve(router, http.MethodGet, "/api/storage/usage?project=Demo"), &result)
+		if len(result.Projects) != 1 || result.UsedBytes != result.Projects[0].UsedBytes {
+			t.Fatalf("usage = %+v", result)
+		}
+		return result.Projects[0]
+	}
+	if got := usage(); got.UsedBytes != int64(len(apk)) || got.Files != 1 || len(got.Apps) != 1 || got.Apps[0].UsedBytes != int64(len(apk)) {
+		t.Fatalf("usage after one upload = %+v", got)
+	}
+
+	if rec := uploadFixture(router, apk, "Demo", "beta"); rec.Code != http.StatusOK {
+		t.Fatalf("second upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	rec := uploadFixture(router, apk, "Demo", "beta")
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("upload over quota: status %d, want 413: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		UsedBytes  int64 `json:"usedBytes"`
+		QuotaBytes int64 `json:"quotaBytes"`
+		FileSize   int64 `json:"fileSize"`
+	}
+	decodeJSON(t, rec, &body)
+	if body.UsedBytes != int64(2*len(apk)) || body.FileSize != int64(len(apk)) || body.QuotaBytes == 0 {
+		t.Errorf("413 body = %+v", body)
+	}
+	got := usage()
+	if got.Files != 2 || got.RemainingBytes == nil || *got.RemainingBytes != got.QuotaBytes-got.UsedBytes {
+		t.Errorf("usage after rejection = %+v", got)
+	}
+
+	// Other projects only have the configured default
+	config.ProjectQuota = int64(len(apk) / 2)
+	if rec := uploadFixture(router, apk, "Other", "beta"); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("upload over default quota: status %d, want 413", rec.Code)
+	}
+	if code := setQuota(`{"quotaBytes": -1}`); code != http.StatusOK {
+		t.Fatalf("lift quota: status %d", code)
+	}
+	if rec := uploadFixture(router, apk, "Demo", "beta"); rec.Code != http.StatusOK {
+		t.Errorf("upload without quota: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := usage(); got.QuotaBytes != 0 || got.RemainingBytes != nil {
+		t.Errorf("unlimited usage = %+v", got)
+	}
+
+	if rec := serve(router, http.MethodGet, "/api/storage/usage?project=Missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown project usage: status %d, want 404", rec.Code)
+	}
+}
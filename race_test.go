@@ -0,0 +1,404 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	_ "embed"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const fixturePackage = "com.example.helloworld"
+
+// testAdminPassword is the admin password of the test server
+const testAdminPassword = "test-admin-password"
+
+// testAdminPasswordHash is testAdminPassword hashed at the lowest cost, so
+// the many admin requests of the tests stay fast
+var testAdminPasswordHash = func() string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(testAdminPassword), bcrypt.MinCost)
+	if err != nil {
+		panic(err)
+	}
+	return string(hash)
+}()
+
+// setupTestServer runs the server state in a fresh temporary directory with
+// an empty catalog and returns a router serving it.
+func setupTestServer(t *testing.T) *gin.Engine {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	for _, sub := range []string{"uploads", "static"} {
+		if err := os.MkdirAll(sub, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	config = defaultConfig()
+	config.UploadTokensRequired = false
+	config.AdminPasswordHash = testAdminPasswordHash
+	config.StatsPath = filepath.Join(dir, "stats.json")
+	metadataFilePath = filepath.Join(dir, "metadata.json")
+	maintenance.set(false, "")
+
+	mutex.Lock()
+	allProjects = nil
+	rebuildIndexes()
+	mutex.Unlock()
+	if err := stats.load(config.StatsPath); err != nil {
+		t.Fatal(err)
+	}
+	return newRouter()
+}
+
+// helloworldAPK is a minimal APK, embedded so tests can run from the
+// temporary directory setupTestServer switches to
+//
+//go:embed testdata/helloworld.apk
+var helloworldAPK []byte
+
+// fixtureAPK returns a copy of the embedded fixture APK
+func fixtureAPK(t *testing.T) []byte {
+	t.Helper()
+	if len(helloworldAPK) == 0 {
+		t.Fatal("embedded fixture APK is empty")
+	}
+	return append([]byte(nil), helloworldAPK...)
+}
+
+// fixtureVariant returns the fixture with a distinct zip comment: an APK that
+// parses identically but has a different hash, like a rebuilt package.
+func fixtureVariant(t *testing.T, apk []byte, n int) []byte {
+	t.Helper()
+	// Without a comment the archive ends with the 22 byte end of central
+	// directory record, whose last field is the comment length
+	const eocdLen = 22
+	if len(apk) < eocdLen || !bytes.Equal(apk[len(apk)-eocdLen:len(apk)-eocdLen+4], []byte("PK\x05\x06")) {
+		t.Fatal("fixture APK must end with a comment-less end of central directory record")
+	}
+	comment := []byte(fmt.Sprintf("variant-%d", n))
+	out := append([]byte(nil), apk...)
+	binary.LittleEndian.PutUint16(out[len(out)-2:], uint16(len(comment)))
+	return append(out, comment...)
+}
+
+// uploadFixture posts the fixture APK to /api/upload
+func uploadFixture(router *gin.Engine, apk []byte, projectName, channel string) *httptest.ResponseRecorder {
+	return uploadFile(router, "helloworld.apk", apk, projectName, channel)
+}
+
+// uploadFile uploads data under the client file name fileName
+func uploadFile(router *gin.Engine, fileName string, data []byte, projectName, channel string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("projectName", projectName)
+	w.WriteField("channel", channel)
+	part, _ := w.CreateFormFile("file", fileName)
+	part.Write(data)
+	w.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/upload", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func serve(router *gin.Engine, method, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+// serveAdmin is serve with the admin password header
+func serveAdmin(router *gin.Engine, method, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set(adminPasswordHeader, testAdminPassword)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// someBuildFile returns the file name of any stored build of packageName
+func someBuildFile(packageName string) string {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, project := range allProjects {
+		for _, app := range project.Apps {
+			if app.PackageName == packageName && len(app.Builds) > 0 {
+				return app.Builds[len(app.Builds)-1].FileName
+			}
+		}
+	}
+	return ""
+}
+
+// checkCatalogConsistency verifies that memory, metadata.json and the
+// uploads directory agree: every build's file exists with the recorded hash,
+// every stored file is referenced, no upload is left in the incoming
+// directory and every icon is a complete image.
+func checkCatalogConsistency(t *testing.T) {
+	t.Helper()
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	referenced := make(map[string]bool)
+	builds := 0
+	for _, project := range allProjects {
+		for _, app := range project.Apps {
+			for _, build := range app.Builds {
+				builds++
+				referenced[build.FileName] = true
+				hash, err := hashFile(filepath.Join("uploads", build.FileName))
+				if err != nil {
+					t.Errorf("build %s/%s references missing file %s", project.ProjectName, build.Channel, build.FileName)
+				} else if hash != build.FileHash {
+					t.Errorf("file %s was overwritten by another upload", build.FileName)
+				}
+			}
+			checkIcon(t, app.IconPath)
+			for _, path := range app.Icons {
+				checkIcon(t, path)
+			}
+		}
+	}
+
+	entries, err := os.ReadDir("uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if !referenced[entry.Name()] {
+			t.Errorf("file %s is not referenced by any build", entry.Name())
+		}
+	}
+	if incoming, err := os.ReadDir(config.IncomingDir); err == nil && len(incoming) > 0 {
+		t.Errorf("%d uploads were left in the incoming directory", len(incoming))
+	}
+
+	data, err := os.ReadFile(metadataFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved []Project
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	savedBuilds := 0
+	for _, project := range saved {
+		for _, app := range project.Apps {
+			savedBuilds += len(app.Builds)
+		}
+	}
+	if savedBuilds != builds {
+		t.Errorf("metadata.json has %d builds, memory has %d", savedBuilds, builds)
+	}
+}
+
+func checkIcon(t *testing.T, path string) {
+	t.Helper()
+	if path == "" {
+		return
+	}
+	f, err := os.Open(filepath.FromSlash(path))
+	if err != nil {
+		t.Errorf("icon %s: %v", path, err)
+		return
+	}
+	defer f.Close()
+	if _, _, err := image.Decode(f); err != nil {
+		t.Errorf("icon %s is corrupt: %v", path, err)
+	}
+}
+
+func TestConcurrentUploadsAndDeletes(t *testing.T) {
+	router := setupTestServer(t)
+	apk := fixtureAPK(t)
+
+	const uploaders, uploadsEach, deleters = 6, 4, 3
+	var wg sync.WaitGroup
+	for i := 0; i < uploaders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < uploadsEach; j++ {
+				// Overlapping projects and channels upload distinct builds of
+				// the same package, usually within the same second
+				variant := fixtureVariant(t, apk, i*uploadsEach+j)
+				rec := uploadFixture(router, variant, fmt.Sprintf("p%d", i%2), fmt.Sprintf("c%d", j%2))
+				if rec.Code != http.StatusOK {
+					t.Errorf("upload: status %d: %s", rec.Code, rec.Body.String())
+				}
+			}
+		}(i)
+	}
+	for i := 0; i < deleters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < uploadsEach; j++ {
+				fileName := someBuildFile(fixturePackage)
+				if fileName == "" {
+					continue
+				}
+				rec := serveAdmin(router, http.MethodDelete, "/api/builds/"+fixturePackage+"/"+fileName)
+				if rec.Code != http.StatusOK && rec.Code != http.StatusNotFound {
+					t.Errorf("delete build: status %d: %s", rec.Code, rec.Body.String())
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		rec := serveAdmin(router, http.MethodDelete, "/api/apps/"+fixturePackage)
+		if rec.Code != http.StatusOK && rec.Code != http.StatusNotFound {
+			t.Errorf("delete app: status %d: %s", rec.Code, rec.Body.String())
+		}
+	}()
+	wg.Wait()
+
+	checkCatalogConsistency(t)
+}
+
+func TestReadsDuringWrites(t *testing.T) {
+	router := setupTestServer(t)
+	apk := fixtureAPK(t)
+	if rec := uploadFixture(router, apk, "p", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("seed upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	readTargets := []string{
+		"/",
+		"/app/" + fixturePackage,
+		"/app/" + fixturePackage + "/card",
+		"/api/search?q=hello",
+		"/api/export.csv",
+		"/api/stats/" + fixturePackage,
+		"/api/apps/" + fixturePackage + "/previous",
+		"/api/apps/" + fixturePackage + "/bundle.zip",
+		"/api/admin/storage",
+	}
+
+	done := make(chan struct{})
+	var writers, readers sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		writers.Add(1)
+		go func(i int) {
+			defer writers.Done()
+			for j := 0; j < 3; j++ {
+				uploadFixture(router, fixtureVariant(t, apk, i*3+j), "p", fmt.Sprintf("c%d", i))
+				if fileName := someBuildFile(fixturePackage); fileName != "" {
+					serveAdmin(router, http.MethodDelete, "/api/builds/"+fixturePackage+"/"+fileName+"?keepApp=true")
+				}
+			}
+		}(i)
+	}
+	for _, target := range readTargets {
+		readers.Add(1)
+		go func(target string) {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				rec := serveAdmin(router, http.MethodGet, target)
+				if rec.Code != http.StatusOK && rec.Code != http.StatusNotFound {
+					t.Errorf("GET %s: status %d", target, rec.Code)
+					return
+				}
+			}
+		}(target)
+	}
+	writers.Wait()
+	close(done)
+	readers.Wait()
+
+	checkCatalogConsistency(t)
+}
+
+func TestRejectedUploadIsNotStored(t *testing.T) {
+	router := setupTestServer(t)
+
+	// A zip archive passes the file checks but is not a parsable APK
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	w, _ := zw.Create("classes.dex")
+	w.Write([]byte("not really dex"))
+	zw.Close()
+
+	if rec := uploadFixture(router, archive.Bytes(), "p", "stable"); rec.Code == http.StatusOK {
+		t.Fatalf("unparsable upload was accepted: %s", rec.Body.String())
+	}
+	for _, dir := range []string{"uploads", config.IncomingDir} {
+		if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+			t.Errorf("rejected upload left %d files in %s", len(entries), dir)
+		}
+	}
+}
+
+func TestConcurrentConditionalEdits(t *testing.T) {
+	router := setupTestServer(t)
+	apk := fixtureAPK(t)
+	if rec := uploadFixture(router, apk, "p", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("seed upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	etag := serve(router, http.MethodGet, "/api/apps/"+fixturePackage+"/builds").Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("read response has no ETag")
+	}
+	target := "/api/builds/" + fixturePackage + "/" + someBuildFile(fixturePackage)
+
+	// Every editor read the same version, so only one of them may win
+	const editors = 5
+	codes := make(chan int, editors)
+	var wg sync.WaitGroup
+	for i := 0; i < editors; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"releaseNotes": "edit %d"}`, i)
+			req := httptest.NewRequest(http.MethodPatch, target, bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("If-Match", etag)
+			req.Header.Set(adminPasswordHeader, testAdminPassword)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			codes <- rec.Code
+		}(i)
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusOK] != 1 || counts[http.StatusPreconditionFailed] != editors-1 {
+		t.Fatalf("status counts = %v, want one 200 and %d 412", counts, editors-1)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Kinds of files the reconciler finds that no build references
+const (
+	orphanPackage   = "package"   // a package in the uploads directory
+	orphanExpansion = "expansion" // an expansion (OBB) directory of a removed build
+	orphanTemp      = "temp"      // a temporary file left by an interrupted write
+	orphanIcon      = "icon"      // an icon of a removed app
+)
+
+// OrphanFile is a file on disk the metadata does not account for
+type OrphanFile struct {
+	Path    string `json:"path"`
+	Kind    string `json:"kind"`
+	Size    int64  `json:"size"`
+	ModTime string `json:"modTime"`
+	Deleted bool   `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ReconcileReport is the result of one reconciler run
+type ReconcileReport struct {
+	CheckedAt   string       `json:"checkedAt"`
+	Delete      bool         `json:"delete"` // whether orphans were to be deleted
+	Orphans     []OrphanFile `json:"orphans"`
+	OrphanBytes int64        `json:"orphanBytes"`
+	// MissingFiles counts the distinct files the metadata lists but the
+	// storage lacks, see GET /api/admin/missing-files
+	MissingFiles int `json:"missingFiles"`
+	// RemoteStorage is set when packages live in remote storage, whose
+	// contents are not listed
+	RemoteStorage bool `json:"remoteStorage,omitempty"`
+}
+
+// lastReconcile keeps the report of the latest run for GET /api/admin/reconcile
+var lastReconcile struct {
+	mu     sync.Mutex
+	report *ReconcileReport
+}
+
+// tempFileName reports whether name is a temporary file of an atomic write:
+// the temp-* and .tmp-* files of interrupted copies and icon encodes, and
+// the *.tmp files of metadata-style saves
+func tempFileName(name string) bool {
+	return strings.HasPrefix(name, "temp-") || strings.HasPrefix(name, ".tmp-") || strings.HasSuffix(name, ".tmp")
+}
+
+// referencedFiles returns the package file names, expansion directory names
+// and icon paths the catalog uses. The caller must hold the mutex.
+func referencedFiles() (packages, expansions, icons map[string]bool) {
+	packages, expansions, icons = make(map[string]bool), make(map[string]bool), make(map[string]bool)
+	for _, project := range allProjects {
+		for _, app := range project.Apps {
+			// Icons are rewritten in the configured format, so any format of
+			// a live app's icon is kept
+			for _, baseName := range iconBaseNames(app.PackageName) {
+				for _, ext := range iconExtensions {
+					icons[filepath.Join(iconDir, baseName+ext)] = true
+				}
+			}
+			if app.IconPath != "" {
+				icons[filepath.FromSlash(app.IconPath)] = true
+			}
+			for _, path := range app.Icons {
+				icons[filepath.FromSlash(path)] = true
+			}
+			for _, build := range app.Builds {
+				packages[build.FileName] = true
+				if build.UniversalAPK != "" {
+					packages[build.UniversalAPK] = true
+				}
+				if len(build.Expansions) > 0 {
+					expansions[expansionDirName(build.FileName)] = true
+				}
+			}
+		}
+	}
+	return packages, expansions, icons
+}
+
+// findOrphans lists the files below the uploads and icon directories that
+// the metadata does not reference, skipping those modified within
+// config.ReconcileGrace, which may belong to an upload in progress. The
+// caller must hold the mutex.
+func findOrphans(now time.Time) ([]OrphanFile, bool) {
+	packages, expansions, icons := referencedFiles()
+	var orphans []OrphanFile
+	add := func(path, kind string, info fs.FileInfo) {
+		if now.Sub(info.ModTime()) < config.ReconcileGrace {
+			return
+		}
+		orphans = append(orphans, OrphanFile{Path: path, Kind: kind, Size: info.Size(), ModTime: timestamp(info.ModTime())})
+	}
+
+	local, ok := storage.(*localStorage)
+	if ok {
+		entries, _ := os.ReadDir(local.dir)
+		for _, entry := range entries {
+			path := filepath.Join(local.dir, entry.Name())
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			switch {
+			case entry.IsDir() && entry.Name() == "obb":
+				dirs, _ := os.ReadDir(path)
+				for _, dir := range dirs {
+					if dirInfo, err := dir.Info(); err == nil && !expansions[dir.Name()] {
+						add(filepath.Join(path, dir.Name()), orphanExpansion, dirInfo)
+					}
+				}
+				// A directory reports its own size; count the files in it
+				for i := range orphans {
+					if orphans[i].Kind == orphanExpansion {
+						orphans[i].Size = dirSize(orphans[i].Path)
+					}
+				}
+			case entry.IsDir():
+			case tempFileName(entry.Name()):
+				add(path, orphanTemp, info)
+			case !packages[entry.Name()]:
+				add(path, orphanPackage, info)
+			}
+		}
+	}
+
+	entries, _ := os.ReadDir(iconDir)
+	for _, entry := range entries {
+		path := filepath.Join(iconDir, entry.Name())
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		switch {
+		case tempFileName(entry.Name()):
+			add(path, orphanTemp, info)
+		case !icons[path]:
+			add(path, orphanIcon, info)
+		}
+	}
+
+	sort.Slice(orphans, func(a, b int) bool { return orphans[a].Path < orphans[b].Path })
+	return orphans, !ok
+}
+
+// reconcile compares the uploads and icon directories with the metadata and,
+// when remove is set, deletes the orphans. It holds the mutex while deleting,
+// so no upload can start referencing a file in between.
+func reconcile(c *gin.Context, remove bool) ReconcileReport {
+	report := ReconcileReport{CheckedAt: timestamp(time.Now()), Delete: remove, Orphans: []OrphanFile{}}
+	report.MissingFiles = findMissingFiles().Files
+
+	mutex.Lock()
+	orphans, remote := findOrphans(time.Now())
+	report.RemoteStorage = remote
+	deleted := 0
+	for _, orphan := range orphans {
+		report.OrphanBytes += orphan.Size
+		if remove {
+			if err := os.RemoveAll(orphan.Path); err != nil {
+				orphan.Error = err.Error()
+				warnf(c, "删除孤立文件 %s 失败: %v", orphan.Path, err)
+			} else {
+				orphan.Deleted = true
+				deleted++
+			}
+		}
+		report.Orphans = append(report.Orphans, orphan)
+	}
+	mutex.Unlock()
+
+	if len(orphans) > 0 || report.MissingFiles > 0 {
+		logf(c, "一致性检查: %d 个孤立文件（%s），已删除 %d 个，%d 个文件缺失", len(orphans), formatSize(report.OrphanBytes), deleted, report.MissingFiles)
+	}
+	if deleted > 0 {
+		audit.record(c, AuditEntry{Action: auditReconcile, Detail: strings.Join(deletedPaths(report.Orphans), " ")})
+	}
+
+	lastReconcile.mu.Lock()
+	lastReconcile.report = &report
+	lastReconcile.mu.Unlock()
+	return report
+}
+
+// dirSize returns the total size of the files below dir
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// deletedPaths lists the paths of the deleted orphans
+func deletedPaths(orphans []OrphanFile) []string {
+	var paths []string
+	for _, orphan := range orphans {
+		if orphan.Deleted {
+			paths = append(paths, orphan.Path)
+		}
+	}
+	return paths
+}
+
+// startReconciler runs the consistency check every
+// APPDIST_RECONCILE_INTERVAL, deleting the orphans when
+// APPDIST_RECONCILE_DELETE is set
+func startReconciler() {
+	if config.ReconcileInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(config.ReconcileInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if maintenance.get().Enabled {
+				continue
+			}
+			reconcile(nil, config.ReconcileDelete)
+		}
+	}()
+}
+
+// handleReconcile serves POST /api/admin/reconcile, running the
+// consistency check now; orphans are only deleted with ?delete=true.
+func handleReconcile(c *gin.Context) {
+	remove := c.Query("delete")
+	c.JSON(http.StatusOK, reconcile(c, remove == "1" || strings.EqualFold(remove, "true")))
+}
+
+// handleLastReconcile serves GET /api/admin/reconcile, the report of the
+// latest run
+func handleLastReconcile(c *gin.Context) {
+	lastReconcile.mu.Lock()
+	report := lastReconcile.report
+	lastReconcile.mu.Unlock()
+	if report == nil {
+		respondError(c, http.StatusNotFound, "尚未执行过一致性检查")
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
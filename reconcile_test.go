@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReconcile(t *testing.T) {
+	router := setupTestServer(t)
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "beta"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	stored := someBuildFile(fixturePackage)
+
+	for path, data := range map[string]string{
+		filepath.Join("uploads", "leftover.apk"):           "apk",
+		filepath.Join("uploads", "temp-123"):               "partial",
+		filepath.Join("uploads", "obb", "gone-1", "x.obb"): "obb",
+		filepath.Join("static", "icons", "com.gone.png"):   "png",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Everything so far is older than the grace period
+	old := time.Now().Add(-2 * time.Hour)
+	for _, dir := range []string{"uploads", filepath.Join("uploads", "obb"), iconDir} {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			os.Chtimes(filepath.Join(dir, entry.Name()), old, old)
+		}
+	}
+	if err := os.WriteFile(filepath.Join("uploads", "fresh.apk"), []byte("apk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lastReconcile.report = nil
+	if rec := serveAdmin(router, http.MethodGet, "/api/admin/reconcile"); rec.Code != http.StatusNotFound {
+		t.Errorf("before any run: status %d, want 404", rec.Code)
+	}
+	orphanPaths := func(report ReconcileReport) string {
+		var paths []string
+		for _, orphan := range report.Orphans {
+			paths = append(paths, orphan.Kind+":"+filepath.ToSlash(orphan.Path))
+		}
+		return strings.Join(paths, ",")
+	}
+	want := "icon:static/icons/com.gone.png,package:uploads/leftover.apk,expansion:uploads/obb/gone-1,temp:uploads/temp-123"
+
+	var report ReconcileReport
+	decodeJSON(t, serveAdmin(router, http.MethodPost, "/api/admin/reconcile"), &report)
+	if got := orphanPaths(report); got != want {
+		t.Fatalf("orphans = %s, want %s", got, want)
+	}
+	if report.Delete || report.OrphanBytes != int64(len("apk")+len("partial")+len("obb")+len("png")) {
+		t.Errorf("report = %+v", report)
+	}
+	if _, err := os.Stat(filepath.Join("uploads", "leftover.apk")); err != nil {
+		t.Errorf("report-only run removed a file: %v", err)
+	}
+
+	decodeJSON(t, serveAdmin(router, http.MethodPost, "/api/admin/reconcile?delete=true"), &report)
+	for _, orphan := range report.Orphans {
+		if !orphan.Deleted {
+			t.Errorf("not deleted: %+v", orphan)
+		}
+		if _, err := os.Stat(orphan.Path); !os.IsNotExist(err) {
+			t.Errorf("%s still present: %v", orphan.Path, err)
+		}
+	}
+	for _, path := range []string{filepath.Join("uploads", stored), filepath.Join("uploads", "fresh.apk"), filepath.Join(iconDir, fixturePackage+".png")} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("referenced or recent file %s removed: %v", path, err)
+		}
+	}
+
+	var last ReconcileReport
+	decodeJSON(t, serveAdmin(router, http.MethodGet, "/api/admin/reconcile"), &last)
+	if !last.Delete || orphanPaths(last) != want {
+		t.Errorf("last report = %+v", last)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shogo82148/androidbinary/apk"
+)
+
+// handleReparseBuild re-extracts the manifest details, signer and icons of a
+// stored build, so fixes to the parsing code can be applied without
+// re-uploading.
+// Every entry sharing the file is updated; the app's name and icons are only
+// taken over from the newest build or when the app has no icon yet, so
+// reparsing an old build does not roll them back.
+func handleReparseBuild(c *gin.Context) {
+	if !checkAdmin(c) {
+		return
+	}
+
+	packageName := c.Param("packageName")
+	fileName := c.Param("fileName")
+
+	_, current, found := repo.FindApp(packageName)
+	var fileHash, platform string
+	var updateApp, buildFound bool
+	for k, build := range current.Builds {
+		if build.FileName == fileName {
+			fileHash, platform, buildFound = build.FileHash, buildPlatform(build), true
+			updateApp = k == 0 || current.IconPath == ""
+			break
+		}
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, "应用未找到")
+		return
+	}
+	if !buildFound {
+		respondError(c, http.StatusNotFound, "构建版本未找到")
+		return
+	}
+
+	release, ok := parseSlots.acquire(c)
+	if !ok {
+		respondParseBusy(c)
+		return
+	}
+	defer release()
+	path, releaseCopy, err := localCopy(fileName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			respondError(c, http.StatusGone, "构建文件已不存在")
+			return
+		}
+		warnf(c, "读取构建文件 %s 失败: %v", fileName, err)
+		respondError(c, http.StatusInternalServerError, "无法读取构建文件")
+		return
+	}
+	defer releaseCopy()
+
+	// Bypass the parse cache: the point is to run the current parsing code
+	var pkg *apk.Apk
+	var details ApkDetails
+	bundle := platform == platformAndroid && isAppBundle(path)
+	if platform != platformAndroid || bundle {
+		parse := parseIpaDetails
+		switch {
+		case bundle:
+			parse = parseBundleDetails
+		case platform == platformHarmony:
+			parse = parseHarmonyDetails
+		}
+		if details, err = parse(path); err != nil {
+			respondError(c, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+	} else {
+		if pkg, err = apk.OpenFile(path); err != nil {
+			respondError(c, http.StatusInternalServerError, "解析APK失败: "+err.Error())
+			return
+		}
+		defer pkg.Close()
+		if details, err = extractApkDetails(pkg); err != nil {
+			respondError(c, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+	}
+	if details.PackageName != packageName {
+		respondError(c, http.StatusConflict, "文件包名 "+details.PackageName+" 与应用包名不一致")
+		return
+	}
+
+	var cert *SigningCertificate
+	if platform == platformAndroid {
+		if cert, err = apkSigningCertificate(path); err != nil {
+			warnf(c, "无法读取 %s 的签名证书: %v", fileName, err)
+		}
+	}
+	signer := certificateSHA256(cert)
+
+	var iconPath, newIconHash string
+	var icons map[string]string
+	if updateApp {
+		var icon image.Image
+		switch {
+		case pkg != nil:
+			icon, err = pkg.Icon(nil)
+		case bundle:
+			icon, err = bundleIcon(path)
+		case platform == platformHarmony:
+			icon, err = harmonyIcon(path)
+		default:
+			icon, err = ipaIcon(path)
+		}
+		if err != nil {
+			warnf(c, "无法提取应用 '%s' 的图标: %v", details.AppName, err)
+		} else {
+			if iconPath, err = saveIcon(packageName, icon); err != nil {
+				respondError(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+			newIconHash = iconHash(icon)
+			if pkg != nil {
+				icons = saveIconDensities(pkg, packageName)
+			}
+		}
+	}
+
+	// The catalog may have changed while the file was parsed
+	projectName, app, err := repo.UpdateApp(packageName, func(app *AppEntry) error {
+		found := false
+		for k := range app.Builds {
+			build := &app.Builds[k]
+			if build.FileName != fileName {
+				continue
+			}
+			build.Version = details.Version
+			build.VersionCode = details.VersionCode
+			build.MinSDK = details.MinSDK
+			build.TargetSDK = details.TargetSDK
+			build.MinOSVersion = details.MinOSVersion
+			build.Permissions = details.Permissions
+			build.SignerSHA256 = signer
+			build.Certificate = cert
+			found = true
+		}
+		if !found {
+			return errBuildNotFound
+		}
+		if updateApp {
+			app.AppName = details.AppName
+			if signer != "" {
+				app.SignerSHA256 = signer
+			}
+			if iconPath != "" {
+				app.IconPath = iconPath
+				app.IconHash = newIconHash
+				app.Icons = icons
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		respondRepositoryError(c, err)
+		return
+	}
+	var refreshed []BuildInfo
+	for _, build := range app.Builds {
+		if build.FileName == fileName {
+			refreshed = append(refreshed, build)
+		}
+	}
+	parseCache.Add(fileHash, details)
+	logf(c, "已重新解析构建 %s (%s)", fileName, packageName)
+	audit.record(c, AuditEntry{Action: auditReparse, ProjectName: projectName, PackageName: packageName, FileName: fileName})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "构建版本已重新解析",
+		"app":     app,
+		"builds":  refreshed,
+	})
+}
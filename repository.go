@@ -0,0 +1,376 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Repository is the catalog storage the upload, delete and page handlers go
+// through. Implementations are safe for concurrent use, and everything they
+// return is a copy the caller may keep and modify.
+type Repository interface {
+	// GetAll returns every project with its apps and builds
+	GetAll() []Project
+	// FindApp returns the first app with packageName and its project's name
+	FindApp(packageName string) (projectName string, app AppEntry, ok bool)
+	// UpsertBuild adds build as the newest build of the app, creating the
+	// project and the app when needed and refreshing the app's details
+	UpsertBuild(projectName string, app AppInfo, build BuildInfo) error
+	// DeleteBuild removes the entries of fileName from the app and returns
+	// what changed; files are left for the caller to remove, see
+	// applyDeletePlan
+	DeleteBuild(packageName, fileName string, opts DeleteOptions) (DeletePlan, error)
+	// DeleteApp removes the app, and its project once it is empty. Apps
+	// with protected builds are only removed when force is set.
+	DeleteApp(packageName string, dryRun, force bool) (DeletePlan, error)
+	// MoveApp moves the app with all its builds into targetProject, creating
+	// the project when needed and removing the source project once empty
+	MoveApp(packageName, targetProject string) error
+	// UpdateApp applies update to a copy of the app and stores the result,
+	// returning the app's project name and the stored app. An error from
+	// update abandons the change and is returned as is.
+	UpdateApp(packageName string, update func(app *AppEntry) error) (projectName string, app AppEntry, err error)
+	// UpdateProject is UpdateApp for the settings of a project: its
+	// webhooks, members, policies and the like
+	UpdateProject(projectName string, update func(project *Project) error) (Project, error)
+}
+
+// DeleteOptions narrows what Repository.DeleteBuild removes
+type DeleteOptions struct {
+	Channel string // only remove the entry of this channel, "" removes every entry of the file
+	KeepApp bool   // keep the app entry and its icon once its last build is gone
+	DryRun  bool   // compute the DeletePlan without changing anything
+	Force   bool   // remove protected entries too
+}
+
+// Errors returned by Repository implementations
+var (
+	errProjectNotFound = errors.New("项目未找到")
+	errAppNotFound     = errors.New("应用未找到")
+	errBuildNotFound   = errors.New("构建版本未找到")
+	errAppExists       = errors.New("目标项目中已有该应用")
+	errProtected       = errors.New("构建版本受保护，需要 force=true 才能删除")
+)
+
+// respondRepositoryError maps an error of a Repository call to a response
+func respondRepositoryError(c *gin.Context, err error) {
+	var prefixErr *packagePrefixError
+	switch {
+	case errors.Is(err, errProjectNotFound), errors.Is(err, errAppNotFound), errors.Is(err, errBuildNotFound):
+		respondError(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, errAppExists), errors.Is(err, errProtected):
+		respondError(c, http.StatusConflict, err.Error())
+	case errors.As(err, &prefixErr):
+		respondError(c, http.StatusBadRequest, err.Error())
+	default:
+		errorf(c, "更新元数据错误: %v", err)
+		respondError(c, http.StatusInternalServerError, "更新元数据失败")
+	}
+}
+
+// updateBuilds applies edit to the entries of fileName in the app, only to
+// the one of channel when it is set, and returns the stored entries. An
+// error from edit abandons the whole change.
+func updateBuilds(packageName, fileName, channel string, edit func(*BuildInfo) error) (string, []BuildInfo, error) {
+	matches := func(build BuildInfo) bool {
+		return build.FileName == fileName && (channel == "" || build.Channel == channel)
+	}
+	projectName, app, err := repo.UpdateApp(packageName, func(app *AppEntry) error {
+		found := false
+		for k := range app.Builds {
+			if !matches(app.Builds[k]) {
+				continue
+			}
+			if err := edit(&app.Builds[k]); err != nil {
+				return err
+			}
+			found = true
+		}
+		if !found {
+			return errBuildNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	var edited []BuildInfo
+	for _, build := range app.Builds {
+		if matches(build) {
+			edited = append(edited, build)
+		}
+	}
+	return projectName, edited, nil
+}
+
+// repo is the active catalog storage
+var repo Repository = jsonRepository{}
+
+// jsonRepository is the built-in Repository: the catalog lives in
+// allProjects, guarded by mutex, and every change is written to the
+// MetadataStore by saveMetadata. A change whose save fails is rolled back
+// in memory.
+//
+// Read-only helpers such as search and the stats pages still scan
+// allProjects directly under the mutex; every change goes through here.
+type jsonRepository struct{}
+
+func (jsonRepository) GetAll() []Project {
+	mutex.Lock()
+	defer mutex.Unlock()
+	projects := make([]Project, len(allProjects))
+	for i, project := range allProjects {
+		projects[i] = project.clone()
+	}
+	return projects
+}
+
+func (jsonRepository) FindApp(packageName string) (string, AppEntry, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	i, j, found := findApp(packageName)
+	if !found {
+		return "", AppEntry{}, false
+	}
+	return allProjects[i].ProjectName, allProjects[i].Apps[j].clone(), true
+}
+
+func (jsonRepository) UpsertBuild(projectName string, appInfo AppInfo, newBuild BuildInfo) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if err := checkPackagePrefix(projectName, appInfo.PackageName); err != nil {
+		return err
+	}
+
+	previousProjects := allProjects
+	i := findProject(projectName)
+	if i < 0 {
+		allProjects = append(allProjects, Project{ProjectName: projectName, Apps: []AppEntry{}})
+		i = len(allProjects) - 1
+	}
+	previousApps := allProjects[i].Apps
+
+	j := findAppInProject(i, appInfo.PackageName)
+	appExisted := j >= 0
+	var previousApp AppEntry
+	if !appExisted {
+		allProjects[i].Apps = append(allProjects[i].Apps, AppEntry{
+			AppName:      appInfo.AppName,
+			PackageName:  appInfo.PackageName,
+			IconPath:     appInfo.IconPath,
+			IconHash:     appInfo.IconHash,
+			Icons:        appInfo.Icons,
+			SignerSHA256: appInfo.SignerSHA256,
+			Builds:       []BuildInfo{},
+		})
+		j = len(allProjects[i].Apps) - 1
+	} else {
+		previousApp = allProjects[i].Apps[j].clone()
+		allProjects[i].Apps[j].AppName = appInfo.AppName
+		if appInfo.IconPath != "" {
+			allProjects[i].Apps[j].IconPath = appInfo.IconPath
+			allProjects[i].Apps[j].IconHash = appInfo.IconHash
+			allProjects[i].Apps[j].Icons = appInfo.Icons
+		}
+		if appInfo.SignerSHA256 != "" {
+			allProjects[i].Apps[j].SignerSHA256 = appInfo.SignerSHA256
+		}
+	}
+
+	allProjects[i].Apps[j].Builds = append([]BuildInfo{newBuild}, allProjects[i].Apps[j].Builds...)
+
+	if err := saveMetadata(); err != nil {
+		if appExisted {
+			allProjects[i].Apps[j] = previousApp
+		}
+		allProjects[i].Apps = previousApps
+		allProjects = previousProjects
+		rebuildIndexes()
+		return err
+	}
+	return nil
+}
+
+func (jsonRepository) DeleteBuild(packageName, fileName string, opts DeleteOptions) (DeletePlan, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	i, j, found := findApp(packageName)
+	if !found {
+		return DeletePlan{}, errBuildNotFound
+	}
+	var removed []BuildInfo
+	remaining := []BuildInfo{}
+	for _, build := range allProjects[i].Apps[j].Builds {
+		if build.FileName == fileName && (opts.Channel == "" || build.Channel == opts.Channel) {
+			removed = append(removed, build)
+		} else {
+			remaining = append(remaining, build)
+		}
+	}
+	if len(removed) == 0 {
+		return DeletePlan{}, errBuildNotFound
+	}
+	if !opts.Force && hasProtected(removed) {
+		return DeletePlan{}, errProtected
+	}
+
+	apps, previousBuilds := allProjects[i].Apps, allProjects[i].Apps[j].Builds
+	restore := func() {
+		allProjects[i].Apps = apps
+		allProjects[i].Apps[j].Builds = previousBuilds
+	}
+	allProjects[i].Apps[j].Builds = remaining
+	plan := DeletePlan{Builds: removed, Icons: []string{}, AppRemoved: len(remaining) == 0 && !opts.KeepApp}
+	if plan.AppRemoved {
+		allProjects[i].Apps = append(apps[:j:j], apps[j+1:]...)
+	}
+	planFileRemovals(&plan, removed)
+	if plan.AppRemoved && !packageExists(packageName) {
+		plan.Icons = iconFiles(packageName)
+	}
+
+	if opts.DryRun {
+		restore()
+		return plan, nil
+	}
+	if err := saveMetadata(); err != nil {
+		restore()
+		rebuildIndexes()
+		return DeletePlan{}, err
+	}
+	return plan, nil
+}
+
+func (jsonRepository) DeleteApp(packageName string, dryRun, force bool) (DeletePlan, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	i, j, found := findApp(packageName)
+	if !found {
+		return DeletePlan{}, errAppNotFound
+	}
+	removed := allProjects[i].Apps[j].Builds
+	if !force && hasProtected(removed) {
+		return DeletePlan{}, errProtected
+	}
+	projects, apps := allProjects, allProjects[i].Apps
+	restore := func() {
+		allProjects = projects
+		allProjects[i].Apps = apps
+	}
+	allProjects[i].Apps = append(apps[:j:j], apps[j+1:]...)
+
+	// If the project has no more apps, remove the project itself
+	plan := DeletePlan{Builds: removed, Icons: []string{}, AppRemoved: true}
+	if len(allProjects[i].Apps) == 0 {
+		plan.ProjectRemoved = true
+		allProjects = append(allProjects[:i:i], allProjects[i+1:]...)
+	}
+	planFileRemovals(&plan, removed)
+	// The icons are shared with the package in other projects
+	if !packageExists(packageName) {
+		plan.Icons = iconFiles(packageName)
+	}
+
+	if dryRun {
+		restore()
+		return plan, nil
+	}
+	if err := saveMetadata(); err != nil {
+		restore()
+		rebuildIndexes()
+		return DeletePlan{}, err
+	}
+	return plan, nil
+}
+
+func (jsonRepository) MoveApp(packageName, targetProject string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	i, j, found := findApp(packageName)
+	if !found {
+		return errAppNotFound
+	}
+	if allProjects[i].ProjectName == targetProject {
+		return nil
+	}
+	if t := findProject(targetProject); t >= 0 && findAppInProject(t, packageName) >= 0 {
+		return errAppExists
+	}
+	if err := checkPackagePrefix(targetProject, packageName); err != nil {
+		return err
+	}
+
+	app := allProjects[i].Apps[j]
+	projects, apps := allProjects, allProjects[i].Apps
+	allProjects = append([]Project(nil), allProjects...)
+	allProjects[i].Apps = append(apps[:j:j], apps[j+1:]...)
+	t := findProject(targetProject)
+	if t < 0 {
+		allProjects = append(allProjects, Project{ProjectName: targetProject, Apps: []AppEntry{}})
+		t = len(allProjects) - 1
+	}
+	allProjects[t].Apps = append(allProjects[t].Apps[:len(allProjects[t].Apps):len(allProjects[t].Apps)], app)
+	if len(allProjects[i].Apps) == 0 {
+		allProjects = append(allProjects[:i:i], allProjects[i+1:]...)
+	}
+
+	if err := saveMetadata(); err != nil {
+		allProjects = projects
+		rebuildIndexes()
+		return err
+	}
+	return nil
+}
+
+// The update functions run with the mutex held, so they may use the
+// catalog helpers that expect it but must not call the repository.
+
+func (jsonRepository) UpdateApp(packageName string, update func(*AppEntry) error) (string, AppEntry, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	i, j, found := findApp(packageName)
+	if !found {
+		return "", AppEntry{}, errAppNotFound
+	}
+	previous := allProjects[i].Apps[j]
+	app := previous.clone()
+	if err := update(&app); err != nil {
+		return "", AppEntry{}, err
+	}
+	allProjects[i].Apps[j] = app
+	if err := saveMetadata(); err != nil {
+		allProjects[i].Apps[j] = previous
+		rebuildIndexes()
+		return "", AppEntry{}, err
+	}
+	return allProjects[i].ProjectName, app.clone(), nil
+}
+
+func (jsonRepository) UpdateProject(projectName string, update func(*Project) error) (Project, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	i := findProject(projectName)
+	if i < 0 {
+		return Project{}, errProjectNotFound
+	}
+	previous := allProjects[i]
+	project := previous.clone()
+	if err := update(&project); err != nil {
+		return Project{}, err
+	}
+	allProjects[i] = project
+	if err := saveMetadata(); err != nil {
+		allProjects[i] = previous
+		rebuildIndexes()
+		return Project{}, err
+	}
+	return project.clone(), nil
+}
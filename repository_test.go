@@ -0,0 +1,335 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// setupTestRepository points the JSON repository at an empty catalog in a
+// fresh temporary directory.
+func setupTestRepository(t *testing.T) jsonRepository {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	config = defaultConfig()
+	metadataFilePath = filepath.Join(dir, "metadata.json")
+	mutex.Lock()
+	allProjects = nil
+	rebuildIndexes()
+	mutex.Unlock()
+	return jsonRepository{}
+}
+
+func testApp(packageName string) AppInfo {
+	return AppInfo{AppName: "App " + packageName, PackageName: packageName}
+}
+
+func testBuild(fileName, channel string) BuildInfo {
+	return BuildInfo{Version: "1.0", Channel: channel, FileName: fileName, DownloadURL: "/downloads/" + fileName}
+}
+
+func mustUpsert(t *testing.T, r jsonRepository, projectName, packageName string, build BuildInfo) {
+	t.Helper()
+	if err := r.UpsertBuild(projectName, testApp(packageName), build); err != nil {
+		t.Fatalf("UpsertBuild(%s, %s): %v", projectName, packageName, err)
+	}
+}
+
+// projectNames lists the projects of the catalog in order
+func projectNames(projects []Project) []string {
+	var names []string
+	for _, project := range projects {
+		names = append(names, project.ProjectName)
+	}
+	return names
+}
+
+func TestJSONRepositoryUpsertBuild(t *testing.T) {
+	r := setupTestRepository(t)
+	mustUpsert(t, r, "A", "com.a", testBuild("a1.apk", "dev"))
+	mustUpsert(t, r, "A", "com.a", testBuild("a2.apk", "dev"))
+	mustUpsert(t, r, "B", "com.b", testBuild("b1.apk", "beta"))
+
+	projects := r.GetAll()
+	if got := projectNames(projects); !reflect.DeepEqual(got, []string{"A", "B"}) {
+		t.Fatalf("projects = %v, want [A B]", got)
+	}
+	projectName, app, ok := r.FindApp("com.a")
+	if !ok || projectName != "A" {
+		t.Fatalf("FindApp(com.a) = %q, %v", projectName, ok)
+	}
+	if len(app.Builds) != 2 || app.Builds[0].FileName != "a2.apk" {
+		t.Fatalf("builds of com.a = %+v, want a2.apk first", app.Builds)
+	}
+
+	// Every change is persisted
+	if err := loadMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded := r.GetAll(); !reflect.DeepEqual(reloaded, projects) {
+		t.Fatalf("reloaded catalog differs:\n%+v\n%+v", reloaded, projects)
+	}
+}
+
+func TestJSONRepositoryReturnsCopies(t *testing.T) {
+	r := setupTestRepository(t)
+	mustUpsert(t, r, "A", "com.a", testBuild("a1.apk", "dev"))
+
+	projects := r.GetAll()
+	projects[0].Apps[0].Builds[0].Channel = "changed"
+	_, app, _ := r.FindApp("com.a")
+	app.Builds[0].Version = "changed"
+
+	_, app, _ = r.FindApp("com.a")
+	if build := app.Builds[0]; build.Channel != "dev" || build.Version != "1.0" {
+		t.Fatalf("catalog changed through a returned copy: %+v", build)
+	}
+}
+
+func TestJSONRepositoryUpsertRejectsPackagePrefix(t *testing.T) {
+	r := setupTestRepository(t)
+	config.PackagePrefixes = map[string]string{"A": "com.acme."}
+
+	err := r.UpsertBuild("A", testApp("com.other"), testBuild("x.apk", "dev"))
+	var prefixErr *packagePrefixError
+	if !errors.As(err, &prefixErr) {
+		t.Fatalf("UpsertBuild error = %v, want a packagePrefixError", err)
+	}
+	if len(r.GetAll()) != 0 {
+		t.Fatal("rejected build was added to the catalog")
+	}
+	mustUpsert(t, r, "A", "com.acme.app", testBuild("y.apk", "dev"))
+}
+
+func TestJSONRepositoryDeleteBuild(t *testing.T) {
+	r := setupTestRepository(t)
+	mustUpsert(t, r, "A", "com.a", testBuild("a1.apk", "beta"))
+	// A promoted entry shares the file of the original build
+	promoted := testBuild("a1.apk", "prod")
+	promoted.PromotedFrom = "beta"
+	mustUpsert(t, r, "A", "com.a", promoted)
+	before := r.GetAll()
+
+	plan, err := r.DeleteBuild("com.a", "a1.apk", DeleteOptions{Channel: "prod", DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Builds) != 1 || len(plan.Files) != 0 || len(plan.KeptFiles) != 1 || plan.AppRemoved {
+		t.Fatalf("dry run plan = %+v, want one entry and a kept file", plan)
+	}
+	if after := r.GetAll(); !reflect.DeepEqual(after, before) {
+		t.Fatal("dry run changed the catalog")
+	}
+
+	if _, err := r.DeleteBuild("com.a", "a1.apk", DeleteOptions{Channel: "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	plan, err = r.DeleteBuild("com.a", "a1.apk", DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join("uploads", "a1.apk")}; !reflect.DeepEqual(plan.Files, want) || !plan.AppRemoved {
+		t.Fatalf("plan = %+v, want %v removed with the app", plan, want)
+	}
+	if _, _, ok := r.FindApp("com.a"); ok {
+		t.Fatal("app without builds was kept")
+	}
+
+	if _, err := r.DeleteBuild("com.a", "a1.apk", DeleteOptions{}); !errors.Is(err, errBuildNotFound) {
+		t.Fatalf("deleting a missing build: %v, want errBuildNotFound", err)
+	}
+}
+
+func TestJSONRepositoryDeleteBuildKeepApp(t *testing.T) {
+	r := setupTestRepository(t)
+	mustUpsert(t, r, "A", "com.a", testBuild("a1.apk", "dev"))
+
+	plan, err := r.DeleteBuild("com.a", "a1.apk", DeleteOptions{KeepApp: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, app, ok := r.FindApp("com.a")
+	if plan.AppRemoved || !ok || len(app.Builds) != 0 {
+		t.Fatalf("KeepApp: plan = %+v, app = %+v", plan, app)
+	}
+}
+
+func TestJSONRepositoryDeleteApp(t *testing.T) {
+	r := setupTestRepository(t)
+	mustUpsert(t, r, "A", "com.a", testBuild("a1.apk", "dev"))
+	mustUpsert(t, r, "A", "com.a2", testBuild("a2.apk", "dev"))
+	mustUpsert(t, r, "B", "com.b", testBuild("b1.apk", "dev"))
+
+	plan, err := r.DeleteApp("com.b", true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.ProjectRemoved || len(r.GetAll()) != 2 {
+		t.Fatalf("dry run: plan = %+v, projects = %v", plan, projectNames(r.GetAll()))
+	}
+
+	if plan, err = r.DeleteApp("com.a", false, false); err != nil {
+		t.Fatal(err)
+	}
+	if plan.ProjectRemoved || len(plan.Files) != 1 {
+		t.Fatalf("plan = %+v, want one file and the project kept", plan)
+	}
+	if _, err = r.DeleteApp("com.b", false, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := projectNames(r.GetAll()); !reflect.DeepEqual(got, []string{"A"}) {
+		t.Fatalf("projects = %v, want [A]", got)
+	}
+	if _, err := r.DeleteApp("com.b", false, false); !errors.Is(err, errAppNotFound) {
+		t.Fatalf("deleting a missing app: %v, want errAppNotFound", err)
+	}
+}
+
+func TestJSONRepositoryDeleteAppSharedIcons(t *testing.T) {
+	r := setupTestRepository(t)
+	mustUpsert(t, r, "A", "com.a", testBuild("a1.apk", "dev"))
+	mustUpsert(t, r, "B", "com.a", testBuild("a2.apk", "dev"))
+	if err := os.MkdirAll(iconDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	icon := filepath.Join(iconDir, "com.a.png")
+	if err := os.WriteFile(icon, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The listing in B still shows the icon
+	plan, err := r.DeleteApp("com.a", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Icons) != 0 {
+		t.Fatalf("icons = %v while B still lists the package", plan.Icons)
+	}
+	if plan, err = r.DeleteApp("com.a", false, false); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{icon}; !reflect.DeepEqual(plan.Icons, want) {
+		t.Fatalf("icons = %v, want %v with the last listing", plan.Icons, want)
+	}
+}
+
+func TestJSONRepositoryMoveApp(t *testing.T) {
+	r := setupTestRepository(t)
+	mustUpsert(t, r, "A", "com.a", testBuild("a1.apk", "dev"))
+	mustUpsert(t, r, "B", "com.b", testBuild("b1.apk", "dev"))
+
+	if err := r.MoveApp("com.a", "C"); err != nil {
+		t.Fatal(err)
+	}
+	if got := projectNames(r.GetAll()); !reflect.DeepEqual(got, []string{"B", "C"}) {
+		t.Fatalf("projects after move = %v, want [B C]", got)
+	}
+	if projectName, app, _ := r.FindApp("com.a"); projectName != "C" || len(app.Builds) != 1 {
+		t.Fatalf("moved app is in %q with %d builds", projectName, len(app.Builds))
+	}
+
+	// FindApp and MoveApp act on the first listing of a package
+	mustUpsert(t, r, "D", "com.a", testBuild("a2.apk", "dev"))
+	if err := r.MoveApp("com.a", "D"); !errors.Is(err, errAppExists) {
+		t.Fatalf("moving onto an existing app: %v, want errAppExists", err)
+	}
+	config.PackagePrefixes = map[string]string{"E": "com.e."}
+	var prefixErr *packagePrefixError
+	if err := r.MoveApp("com.b", "E"); !errors.As(err, &prefixErr) {
+		t.Fatalf("moving into a project with another prefix: %v", err)
+	}
+	if err := r.MoveApp("com.missing", "B"); !errors.Is(err, errAppNotFound) {
+		t.Fatalf("moving a missing app: %v, want errAppNotFound", err)
+	}
+}
+
+func TestJSONRepositoryUpdate(t *testing.T) {
+	r := setupTestRepository(t)
+	mustUpsert(t, r, "A", "com.a", testBuild("a1.apk", "dev"))
+
+	projectName, app, err := r.UpdateApp("com.a", func(app *AppEntry) error {
+		app.Private = true
+		app.Builds[0].Tags = []string{"qa"}
+		return nil
+	})
+	if err != nil || projectName != "A" || !app.Private {
+		t.Fatalf("UpdateApp = %q, %+v, %v", projectName, app, err)
+	}
+	app.Builds[0].Tags[0] = "changed"
+	if _, stored, _ := r.FindApp("com.a"); !stored.Private || stored.Builds[0].Tags[0] != "qa" {
+		t.Fatalf("stored app = %+v", stored)
+	}
+
+	// An error from the update function leaves the catalog alone
+	before := r.GetAll()
+	errRefused := errors.New("refused")
+	if _, _, err := r.UpdateApp("com.a", func(app *AppEntry) error {
+		app.Builds = nil
+		return errRefused
+	}); !errors.Is(err, errRefused) {
+		t.Fatalf("refused UpdateApp: %v", err)
+	}
+	if _, err := r.UpdateProject("A", func(project *Project) error {
+		project.QuotaBytes = 1
+		return errRefused
+	}); !errors.Is(err, errRefused) {
+		t.Fatalf("refused UpdateProject: %v", err)
+	}
+	if after := r.GetAll(); !reflect.DeepEqual(after, before) {
+		t.Fatalf("refused updates changed the catalog:\n%+v\n%+v", after, before)
+	}
+
+	project, err := r.UpdateProject("A", func(project *Project) error {
+		project.Members = append(project.Members, Member{User: "ann", Role: roleViewer})
+		return nil
+	})
+	if err != nil || len(project.Members) != 1 || len(r.GetAll()[0].Members) != 1 {
+		t.Fatalf("UpdateProject = %+v, %v", project, err)
+	}
+	if _, _, err := r.UpdateApp("com.missing", func(*AppEntry) error { return nil }); !errors.Is(err, errAppNotFound) {
+		t.Fatalf("updating a missing app: %v, want errAppNotFound", err)
+	}
+	if _, err := r.UpdateProject("Missing", func(*Project) error { return nil }); !errors.Is(err, errProjectNotFound) {
+		t.Fatalf("updating a missing project: %v, want errProjectNotFound", err)
+	}
+}
+
+func TestJSONRepositoryRollsBackFailedSave(t *testing.T) {
+	r := setupTestRepository(t)
+	mustUpsert(t, r, "A", "com.a", testBuild("a1.apk", "dev"))
+	before := r.GetAll()
+
+	metadataFilePath = filepath.Join(t.TempDir(), "missing", "metadata.json")
+	if err := r.UpsertBuild("A", testApp("com.a"), testBuild("a2.apk", "dev")); err == nil {
+		t.Fatal("UpsertBuild succeeded without a writable metadata file")
+	}
+	if err := r.UpsertBuild("B", testApp("com.b"), testBuild("b1.apk", "dev")); err == nil {
+		t.Fatal("UpsertBuild succeeded without a writable metadata file")
+	}
+	if _, err := r.DeleteApp("com.a", false, false); err == nil {
+		t.Fatal("DeleteApp succeeded without a writable metadata file")
+	}
+	if err := r.MoveApp("com.a", "B"); err == nil {
+		t.Fatal("MoveApp succeeded without a writable metadata file")
+	}
+	if _, _, err := r.UpdateApp("com.a", func(app *AppEntry) error { app.Private = true; return nil }); err == nil {
+		t.Fatal("UpdateApp succeeded without a writable metadata file")
+	}
+	if _, err := r.UpdateProject("A", func(project *Project) error { project.QuotaBytes = 1; return nil }); err == nil {
+		t.Fatal("UpdateProject succeeded without a writable metadata file")
+	}
+	if after := r.GetAll(); !reflect.DeepEqual(after, before) {
+		t.Fatalf("failed saves changed the catalog:\n%+v\n%+v", after, before)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	requestIDKey    = "requestID"
+	maxRequestIDLen = 128
+)
+
+// requestIDMiddleware honors a sane incoming X-Request-ID or generates a new
+// one, stores it in the context and echoes it back in the response header.
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// validRequestID accepts short printable ASCII IDs so that client supplied
+// values cannot inject anything into log lines or headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestID returns the ID of the current request, or "" outside of one
+func requestID(c *gin.Context) string {
+	if c == nil {
+		return ""
+	}
+	return c.GetString(requestIDKey)
+}
+
+// errorBody is the JSON body of an API error
+func errorBody(c *gin.Context, message string) gin.H {
+	return gin.H{"error": message, "requestId": requestID(c)}
+}
+
+// respondError writes a JSON error carrying the request ID
+func respondError(c *gin.Context, status int, message string) {
+	c.JSON(status, errorBody(c, message))
+}
+
+// respondText writes a plain text error with the request ID appended, for
+// handlers that answer browser form posts and page loads. Browsers get the
+// styled error page instead, see wantsHTML.
+func respondText(c *gin.Context, status int, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	if wantsHTML(c) {
+		renderErrorPage(c, status, message, nil)
+		return
+	}
+	c.String(status, "%s\n请求 ID: %s", message, requestID(c))
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RetentionPolicy bounds how many builds a project keeps. A build entry
+// expires once it fails every configured rule: it is not among the
+// KeepPerChannel newest of its channel and is older than MaxAgeDays. The
+// newest build of every channel and builds tagged with one of
+// APPDIST_RETENTION_EXEMPT_TAGS never expire.
+type RetentionPolicy struct {
+	KeepPerChannel int `json:"keepPerChannel,omitempty"` // 0 disables the count rule
+	MaxAgeDays     int `json:"maxAgeDays,omitempty"`     // 0 disables the age rule
+}
+
+// maxRetentionValue bounds both retention rules
+const maxRetentionValue = 10000
+
+// ExpiredBuild is a catalog entry removed by the retention janitor
+type ExpiredBuild struct {
+	ProjectName string `json:"projectName"`
+	PackageName string `json:"packageName"`
+	FileName    string `json:"fileName"`
+	Channel     string `json:"channel"`
+	Version     string `json:"version"`
+	UploadTime  string `json:"uploadTime"`
+}
+
+// retentionExempt reports whether build is protected from retention, by
+// its protected flag or one of its tags
+func retentionExempt(build BuildInfo) bool {
+	if build.Protected {
+		return true
+	}
+	for _, tag := range build.Tags {
+		if slices.Contains(config.RetentionExemptTags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// expiredBuilds returns the entries of the app that policy expires at now
+func expiredBuilds(projectName string, app AppEntry, policy RetentionPolicy, now time.Time) []ExpiredBuild {
+	byChannel := make(map[string][]BuildInfo)
+	for _, build := range app.Builds {
+		byChannel[build.Channel] = append(byChannel[build.Channel], build)
+	}
+	cutoff := now.AddDate(0, 0, -policy.MaxAgeDays)
+
+	var expired []ExpiredBuild
+	for _, builds := range byChannel {
+		sortBuilds(builds, config.BuildOrder)
+		kept := 0
+		for k, build := range builds {
+			if retentionExempt(build) {
+				continue
+			}
+			kept++
+			if k == 0 || (policy.KeepPerChannel > 0 && kept <= policy.KeepPerChannel) {
+				continue
+			}
+			if policy.MaxAgeDays > 0 {
+				uploaded, err := parseTimestamp(build.UploadTime)
+				if err != nil || uploaded.After(cutoff) {
+					continue
+				}
+			}
+			expired = append(expired, ExpiredBuild{
+				ProjectName: projectName,
+				PackageName: app.PackageName,
+				FileName:    build.FileName,
+				Channel:     build.Channel,
+				Version:     build.Version,
+				UploadTime:  build.UploadTime,
+			})
+		}
+	}
+	sort.Slice(expired, func(a, b int) bool { return expired[a].UploadTime < expired[b].UploadTime })
+	return expired
+}
+
+// findExpiredBuilds applies the retention policy of every project
+func findExpiredBuilds(now time.Time) []ExpiredBuild {
+	var expired []ExpiredBuild
+	for _, project := range repo.GetAll() {
+		if project.Retention == nil {
+			continue
+		}
+		for _, app := range project.Apps {
+			expired = append(expired, expiredBuilds(project.ProjectName, app, *project.Retention, now)...)
+		}
+	}
+	return expired
+}
+
+// applyRetention deletes the expired builds and their unreferenced files,
+// like the delete endpoint does for each entry, and returns those removed
+func applyRetention(c *gin.Context, now time.Time) []ExpiredBuild {
+	removed := []ExpiredBuild{}
+	for _, build := range findExpiredBuilds(now) {
+		_, app, _ := repo.FindApp(build.PackageName)
+		hooks := projectWebhooks(build.ProjectName)
+		// The newest build of every channel stays, so the app never empties
+		plan, err := repo.DeleteBuild(build.PackageName, build.FileName, DeleteOptions{Channel: build.Channel, KeepApp: true})
+		if err != nil {
+			warnf(c, "按保留策略删除 %s 失败: %v", build.FileName, err)
+			continue
+		}
+		applyDeletePlan(c, plan)
+		events.publish(CatalogEvent{Type: eventDelete, PackageName: build.PackageName, FileName: build.FileName, Channel: build.Channel})
+		audit.record(c, AuditEntry{Action: auditDeleteBuild, ProjectName: build.ProjectName, PackageName: build.PackageName,
+			FileName: build.FileName, Channel: build.Channel, Detail: "retention policy"})
+		notifyDelete(hooks, build.ProjectName, app, plan, false)
+		logf(c, "保留策略: 已删除 %s 渠道 %s 的构建 %s (%s)", build.PackageName, build.Channel, build.Version, build.FileName)
+		removed = append(removed, build)
+	}
+	return removed
+}
+
+// startRetentionJanitor periodically applies the retention policies,
+// pausing while maintenance mode is on
+func startRetentionJanitor() {
+	if config.RetentionInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(config.RetentionInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if maintenance.get().Enabled {
+				continue
+			}
+			if removed := applyRetention(nil, time.Now()); len(removed) > 0 {
+				slog.Info("保留策略清理完成", "removed", len(removed))
+			}
+		}
+	}()
+}
+
+// handleSetRetention sets the retention policy of a project with
+// {"keepPerChannel": 10, "maxAgeDays": 30}; both 0 remove it.
+func handleSetRetention(c *gin.Context) {
+	if !checkAdmin(c) {
+		return
+	}
+	var policy RetentionPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		respondError(c, http.StatusBadRequest, "请求体格式错误，需要 keepPerChannel 或 maxAgeDays 字段")
+		return
+	}
+	if policy.KeepPerChannel < 0 || policy.MaxAgeDays < 0 ||
+		policy.KeepPerChannel > maxRetentionValue || policy.MaxAgeDays > maxRetentionValue {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("keepPerChannel 和 maxAgeDays 必须在 0 到 %d 之间", maxRetentionValue))
+		return
+	}
+	projectName := c.Param("projectName")
+
+	// The policy is replaced rather than modified, so copies of the
+	// project may share it
+	project, err := repo.UpdateProject(projectName, func(project *Project) error {
+		project.Retention = nil
+		if policy != (RetentionPolicy{}) {
+			project.Retention = &policy
+		}
+		return nil
+	})
+	if err != nil {
+		respondRepositoryError(c, err)
+		return
+	}
+	logf(c, "项目 %s 的保留策略已设置为 %+v", projectName, policy)
+	audit.record(c, AuditEntry{Action: auditRetention, ProjectName: projectName, Detail: fmt.Sprintf("%+v", policy)})
+	c.JSON(http.StatusOK, gin.H{"projectName": projectName, "retention": project.Retention})
+}
+
+// handleRunRetention serves POST /api/admin/retention, applying the
+// retention policies now; with ?dryRun=true it only lists what would go.
+func handleRunRetention(c *gin.Context) {
+	now := time.Now()
+	if isDryRun(c.Query("dryRun")) {
+		expired := findExpiredBuilds(now)
+		if expired == nil {
+			expired = []ExpiredBuild{}
+		}
+		c.JSON(http.StatusOK, gin.H{"dryRun": true, "builds": expired})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"builds": applyRetention(c, now)})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetention(t *testing.T) {
+	router := setupTestServer(t)
+	now := time.Now()
+	day := func(n int) string { return timestamp(now.AddDate(0, 0, -n)) }
+	// Upserts prepend, so the oldest build goes first
+	for _, build := range []BuildInfo{
+		{Version: "1.0", Channel: "beta", FileName: "b1.apk", UploadTime: day(50)},
+		{Version: "1.1", Channel: "beta", FileName: "b2.apk", UploadTime: day(40), Tags: []string{"pinned"}},
+		{Version: "1.2", Channel: "beta", FileName: "b3.apk", UploadTime: day(20)},
+		{Version: "1.3", Channel: "beta", FileName: "b4.apk", UploadTime: day(10)},
+		{Version: "1.4", Channel: "beta", FileName: "b5.apk", UploadTime: day(1)},
+		{Version: "1.0", Channel: "stable", FileName: "s1.apk", UploadTime: day(90)},
+	} {
+		if err := os.WriteFile(filepath.Join(config.UploadDir, build.FileName), []byte("apk"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.UpsertBuild("Demo", testApp("com.example.keep"), build); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	setPolicy := func(body string) int {
+		req := httptest.NewRequest(http.MethodPut, "/api/projects/Demo/retention", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(adminPasswordHeader, testAdminPassword)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := setPolicy(`{"keepPerChannel": -1}`); code != http.StatusBadRequest {
+		t.Errorf("negative policy: status %d, want 400", code)
+	}
+	// Keep two per channel, and anything younger than 15 days
+	if code := setPolicy(`{"keepPerChannel": 2, "maxAgeDays": 15}`); code != http.StatusOK {
+		t.Fatalf("set policy: status %d", code)
+	}
+
+	rec := serveAdmin(router, http.MethodPost, "/api/admin/retention?dryRun=true")
+	var result struct {
+		Builds []ExpiredBuild `json:"builds"`
+	}
+	decodeJSON(t, rec, &result)
+	// b5 and b4 are the two newest, b2 is pinned, and the lone stable
+	// build is the newest of its channel
+	if len(result.Builds) != 2 || result.Builds[0].FileName != "b1.apk" || result.Builds[1].FileName != "b3.apk" {
+		t.Fatalf("dry run = %+v", result.Builds)
+	}
+	if builds := appBuilds(t, router, "com.example.keep"); len(builds) != 6 {
+		t.Fatalf("dry run removed builds: %d left", len(builds))
+	}
+
+	rec = serveAdmin(router, http.MethodPost, "/api/admin/retention")
+	decodeJSON(t, rec, &result)
+	if len(result.Builds) != 2 {
+		t.Fatalf("run = %+v", result.Builds)
+	}
+	var left []string
+	for _, build := range appBuilds(t, router, "com.example.keep") {
+		left = append(left, build.FileName)
+	}
+	if strings.Join(left, ",") != "b5.apk,b4.apk,b2.apk,s1.apk" {
+		t.Errorf("builds left = %v", left)
+	}
+	if _, err := os.Stat(filepath.Join(config.UploadDir, "b1.apk")); !os.IsNotExist(err) {
+		t.Errorf("expired file still present: %v", err)
+	}
+
+	if code := setPolicy(`{}`); code != http.StatusOK {
+		t.Fatalf("clear policy: status %d", code)
+	}
+	if rec := serveAdmin(router, http.MethodPost, "/api/admin/retention"); !strings.Contains(rec.Body.String(), `"builds":[]`) {
+		t.Errorf("without policy: %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3MaxURLExpiry is the longest validity SigV4 allows for presigned URLs
+const s3MaxURLExpiry = 7 * 24 * time.Hour
+
+// s3UnsignedPayload stands in for the body hash: packages are streamed
+// without reading them twice, and TLS protects them in transit
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// s3Storage keeps packages in an S3 bucket or any service speaking its API,
+// such as MinIO. Requests use path-style addressing and SigV4 signatures,
+// and downloads are redirected to presigned URLs unless a public URL is
+// configured.
+type s3Storage struct {
+	endpoint  *url.URL // scheme and host of the service
+	region    string
+	bucket    string
+	prefix    string // prepended to every object key, e.g. "packages/"
+	accessKey string
+	secretKey string
+	publicURL string // base URL objects are publicly readable below
+	urlExpiry time.Duration
+	client    *http.Client
+}
+
+func newS3Storage(cfg Config) (*s3Storage, error) {
+	endpoint, err := url.Parse(cfg.S3Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("APPDIST_S3_ENDPOINT 不是有效的 http(s) 地址: %s", cfg.S3Endpoint)
+	}
+	return &s3Storage{
+		endpoint:  &url.URL{Scheme: endpoint.Scheme, Host: endpoint.Host},
+		region:    cfg.S3Region,
+		bucket:    cfg.S3Bucket,
+		prefix:    cfg.S3Prefix,
+		accessKey: cfg.S3AccessKey,
+		secretKey: cfg.S3SecretKey,
+		publicURL: strings.TrimSuffix(cfg.S3PublicURL, "/"),
+		urlExpiry: cfg.S3URLExpiry,
+		client:    &http.Client{},
+	}, nil
+}
+
+// s3Escape percent-encodes s as SigV4 requires: everything but unreserved
+// characters, and "/" too unless keepSlash is set
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && keepSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// key returns the object key of a stored package
+func (s *s3Storage) key(name string) string {
+	return s.prefix + path.Base(name)
+}
+
+// objectPath returns the escaped request path of a stored package
+func (s *s3Storage) objectPath(name string) string {
+	return "/" + s3Escape(s.bucket, false) + "/" + s3Escape(s.key(name), true)
+}
+
+// signingKey derives the SigV4 key for date, formatted as 20060102
+func (s *s3Storage) signingKey(date string) []byte {
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{date, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return key
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// signature signs a canonical request made at amzDate
+func (s *s3Storage) signature(canonicalRequest, amzDate string) (scope, signature string) {
+	scope = amzDate[:8] + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex(canonicalRequest)
+	return scope, hex.EncodeToString(hmacSHA256(s.signingKey(amzDate[:8]), stringToSign))
+}
+
+// canonicalQuery sorts and encodes query parameters for signing
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, s3Escape(key, false)+"="+s3Escape(value, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// request sends a signed request for a stored package
+func (s *s3Storage) request(method, name string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	target := *s.endpoint
+	target.Opaque = "//" + s.endpoint.Host + s.objectPath(name)
+	req, err := http.NewRequest(method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	amzDate := time.Now().UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+
+	// Sign the host and every header set above
+	signed := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		signed[strings.ToLower(key)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := method + "\n" + s.objectPath(name) + "\n\n" + canonicalHeaders.String() + "\n" + signedHeaders + "\n" + s3UnsignedPayload
+	scope, signature := s.signature(canonicalRequest, amzDate)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+	return s.client.Do(req)
+}
+
+// s3Error turns a failed response into an error, wrapping os.ErrNotExist
+// for missing objects and os.ErrExist for failed If-None-Match writes
+func s3Error(resp *http.Response, name string) error {
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body)
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	case http.StatusPreconditionFailed:
+		return fmt.Errorf("%s: %w", name, os.ErrExist)
+	}
+	if body.Code != "" {
+		return fmt.Errorf("S3 %s: %s %s: %s", name, resp.Status, body.Code, body.Message)
+	}
+	return fmt.Errorf("S3 %s: %s", name, resp.Status)
+}
+
+// Put relies on If-None-Match, which S3 and MinIO honour for writes; a
+// service ignoring it overwrites an existing object instead
+func (s *s3Storage) Put(name string, r io.Reader, size int64) error {
+	header := http.Header{}
+	header.Set("If-None-Match", "*")
+	header.Set("Content-Type", "application/octet-stream")
+	if strings.EqualFold(path.Ext(name), ".apk") {
+		header.Set("Content-Type", "application/vnd.android.package-archive")
+	}
+	resp, err := s.request(http.MethodPut, name, r, size, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return s3Error(resp, name)
+	}
+	return nil
+}
+
+func (s *s3Storage) Get(name string) (io.ReadCloser, error) {
+	resp, err := s.request(http.MethodGet, name, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, s3Error(resp, name)
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Storage) Stat(name string) (int64, error) {
+	resp, err := s.request(http.MethodHead, name, nil, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, s3Error(resp, name)
+	}
+	return resp.ContentLength, nil
+}
+
+func (s *s3Storage) Delete(name string) error {
+	resp, err := s.request(http.MethodDelete, name, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp, name)
+	}
+	return nil
+}
+
+// URL returns the public URL of the object, or a presigned GET URL valid
+// for the configured expiry. Private objects are always presigned: the
+// public URL would never expire.
+func (s *s3Storage) URL(name string, private bool) string {
+	if s.publicURL != "" && !private {
+		return s.publicURL + "/" + s3Escape(s.key(name), true)
+	}
+	amzDate := time.Now().UTC().Format("20060102T150405Z")
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.accessKey + "/" + amzDate[:8] + "/" + s.region + "/s3/aws4_request"},
+		"X-Amz-Date":          {amzDate},
+		"X-Amz-Expires":       {strconv.Itoa(int(s.urlExpiry.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	canonicalRequest := "GET\n" + s.objectPath(name) + "\n" + canonicalQuery(query) + "\nhost:" + s.endpoint.Host + "\n\nhost\n" + s3UnsignedPayload
+	_, signature := s.signature(canonicalRequest, amzDate)
+	return s.endpoint.String() + s.objectPath(name) + "?" + canonicalQuery(query) + "&X-Amz-Signature=" + signature
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bounds of the release-note matches returned per app
+const (
+	searchMaxBuildHits = 5
+	searchSnippetRunes = 40 // context kept on each side of the match
+)
+
+// searchDoc is the pre-lowercased, searchable form of one app
+type searchDoc struct {
+	projectName string
+	project     string
+	appName     string
+	packageName string
+	iconPath    string
+	latestBuild *BuildInfo
+	versions    []string
+	channels    []string
+	notes       []searchNotes
+}
+
+// searchNotes is the release notes of one build entry. lower is notes
+// lowercased rune by rune, so rune offsets into it are offsets into notes.
+type searchNotes struct {
+	fileName   string
+	version    string
+	channel    string
+	uploadTime string
+	notes      string
+	lower      string
+}
+
+// SearchBuildHit is a build whose release notes match the query, with an
+// excerpt around the first match
+type SearchBuildHit struct {
+	FileName   string `json:"fileName"`
+	Version    string `json:"version"`
+	Channel    string `json:"channel"`
+	UploadTime string `json:"uploadTime"`
+	Snippet    string `json:"snippet"`
+	matched    int
+}
+
+// SearchResult is one ranked hit returned by the search API
+type SearchResult struct {
+	ProjectName string     `json:"projectName"`
+	AppName     string     `json:"appName"`
+	PackageName string     `json:"packageName"`
+	IconPath    string     `json:"iconPath"`
+	LatestBuild *BuildInfo `json:"latestBuild,omitempty"`
+	Score       int        `json:"score"`
+	MatchedOn   []string   `json:"matchedOn"`
+	// Builds lists the builds with matching release notes, best first
+	Builds []SearchBuildHit `json:"builds,omitempty"`
+}
+
+// searchIndex is guarded by mutex and rebuilt whenever allProjects is loaded or saved
+var searchIndex []searchDoc
+
+// rebuildSearchIndex regenerates searchIndex from allProjects.
+// The caller must hold the mutex.
+func rebuildSearchIndex() {
+	docs := make([]searchDoc, 0, len(searchIndex))
+	for _, project := range allProjects {
+		for _, app := range project.Apps {
+			doc := searchDoc{
+				projectName: project.ProjectName,
+				project:     strings.ToLower(project.ProjectName),
+				appName:     app.AppName,
+				packageName: app.PackageName,
+				iconPath:    app.IconPath,
+			}
+			if latest, ok := firstBuild(app.Builds); ok {
+				latest.ReleaseNotesHTML = notesHTML(latest.ReleaseNotes)
+				doc.latestBuild = &latest
+			}
+			for _, build := range app.Builds {
+				doc.versions = appendUnique(doc.versions, strings.ToLower(build.Version))
+				doc.channels = appendUnique(doc.channels, strings.ToLower(build.Channel))
+				if notes := strings.TrimSpace(build.ReleaseNotes); notes != "" {
+					doc.notes = append(doc.notes, searchNotes{
+						fileName:   build.FileName,
+						version:    build.Version,
+						channel:    build.Channel,
+						uploadTime: build.UploadTime,
+						notes:      notes,
+						lower:      strings.Map(unicode.ToLower, notes),
+					})
+				}
+			}
+			docs = append(docs, doc)
+		}
+	}
+	searchIndex = docs
+}
+
+// searchCatalog ranks apps against query. Every whitespace-separated token
+// must match at least one field, case-insensitively and as a substring:
+// the app name, package name, project name, a version, a channel or the
+// release notes of a build. The caller must hold the mutex.
+func searchCatalog(query string) []SearchResult {
+	tokens := strings.Fields(strings.ToLower(query))
+	results := []SearchResult{}
+	if len(tokens) == 0 {
+		return results
+	}
+	for _, doc := range searchIndex {
+		score, matched := scoreSearchDoc(doc, tokens)
+		if score == 0 {
+			continue
+		}
+		results = append(results, SearchResult{
+			ProjectName: doc.projectName,
+			AppName:     doc.appName,
+			PackageName: doc.packageName,
+			IconPath:    doc.iconPath,
+			LatestBuild: doc.latestBuild,
+			Score:       score,
+			MatchedOn:   matched,
+			Builds:      searchBuildHits(doc, tokens),
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].AppName < results[j].AppName
+	})
+	return results
+}
+
+// scoreSearchDoc returns the score of doc for tokens and the fields that
+// matched, or 0 when some token matches nothing.
+func scoreSearchDoc(doc searchDoc, tokens []string) (int, []string) {
+	name := strings.ToLower(doc.appName)
+	pkg := strings.ToLower(doc.packageName)
+	total := 0
+	var matched []string
+	for _, token := range tokens {
+		best, field := 0, ""
+		consider := func(score int, f string) {
+			if score > best {
+				best, field = score, f
+			}
+		}
+		consider(matchScore(name, token, 100), "appName")
+		consider(matchScore(pkg, token, 80), "packageName")
+		consider(matchScore(doc.project, token, 60), "projectName")
+		for _, version := range doc.versions {
+			consider(matchScore(version, token, 40), "version")
+		}
+		for _, channel := range doc.channels {
+			consider(matchScore(channel, token, 30), "channel")
+		}
+		for _, notes := range doc.notes {
+			consider(notesScore(notes.lower, token), "releaseNotes")
+		}
+		if best == 0 {
+			return 0, nil
+		}
+		total += best
+		matched = appendUnique(matched, field)
+	}
+	return total, matched
+}
+
+// matchScore grades how well token matches value: an exact match scores
+// weight, a prefix match half of it and any other substring a quarter.
+func matchScore(value, token string, weight int) int {
+	switch {
+	case value == token:
+		return weight
+	case strings.HasPrefix(value, token):
+		return weight / 2
+	case strings.Contains(value, token):
+		return weight / 4
+	}
+	return 0
+}
+
+// notesScore grades a match of token in release notes: 20 when it starts
+// a word, 10 anywhere else. Notes weigh less than the other fields, as
+// they mention many things besides the app itself.
+func notesScore(lower, token string) int {
+	score := 0
+	for i := strings.Index(lower, token); i >= 0; i = nextIndex(lower, token, i) {
+		before, _ := utf8.DecodeLastRuneInString(lower[:i])
+		if i == 0 || !unicode.IsLetter(before) && !unicode.IsDigit(before) {
+			return 20
+		}
+		score = 10
+	}
+	return score
+}
+
+// nextIndex returns the index of the next token in s after the match at i,
+// or -1
+func nextIndex(s, token string, i int) int {
+	j := strings.Index(s[i+1:], token)
+	if j < 0 {
+		return -1
+	}
+	return i + 1 + j
+}
+
+// searchBuildHits returns the builds of doc whose release notes contain
+// any of tokens, those matching the most tokens first and otherwise in
+// catalog order, newest first
+func searchBuildHits(doc searchDoc, tokens []string) []SearchBuildHit {
+	var hits []SearchBuildHit
+	for _, notes := range doc.notes {
+		first, matched := -1, 0
+		for _, token := range tokens {
+			if i := strings.Index(notes.lower, token); i >= 0 {
+				matched++
+				if first < 0 || i < first {
+					first = i
+				}
+			}
+		}
+		if matched == 0 {
+			continue
+		}
+		hits = append(hits, SearchBuildHit{
+			FileName:   notes.fileName,
+			Version:    notes.version,
+			Channel:    notes.channel,
+			UploadTime: notes.uploadTime,
+			Snippet:    notesSnippet(notes, first),
+			matched:    matched,
+		})
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].matched > hits[j].matched })
+	if len(hits) > searchMaxBuildHits {
+		hits = hits[:searchMaxBuildHits]
+	}
+	return hits
+}
+
+// notesSnippet returns the notes around the byte offset at of their
+// lowercased form on one line, marking cut ends with an ellipsis
+func notesSnippet(notes searchNotes, at int) string {
+	runes := []rune(notes.notes)
+	center := utf8.RuneCountInString(notes.lower[:at])
+	start, end := max(center-searchSnippetRunes, 0), min(center+2*searchSnippetRunes, len(runes))
+	snippet := strings.Join(strings.Fields(string(runes[start:end])), " ")
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(runes) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+func handleSearch(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		respondError(c, http.StatusBadRequest, "查询参数 q 不能为空")
+		return
+	}
+
+	mutex.Lock()
+	results := slices.DeleteFunc(searchCatalog(query), func(result SearchResult) bool {
+		return !projectVisible(c, result.ProjectName)
+	})
+	mutex.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"query": query, "total": len(results), "results": results})
+}
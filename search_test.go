@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSearchReleaseNotes(t *testing.T) {
+	router := setupTestServer(t)
+	notes := func(build BuildInfo, text string) BuildInfo {
+		build.ReleaseNotes = text
+		return build
+	}
+	mutex.Lock()
+	allProjects = []Project{
+		{ProjectName: "Payments", Apps: []AppEntry{{
+			AppName:     "Wallet",
+			PackageName: "com.example.wallet",
+			Builds: []BuildInfo{
+				notes(testBuild("wallet-3.apk", "beta"), "Faster checkout.\nFixed a crash when scanning receipts offline."),
+				notes(testBuild("wallet-2.apk", "stable"), "New receipt export"),
+				testBuild("wallet-1.apk", "stable"),
+			},
+		}}},
+		{ProjectName: "Media", Apps: []AppEntry{{
+			AppName:     "Crashpad Viewer",
+			PackageName: "com.example.crashpad",
+			Builds:      []BuildInfo{testBuild("viewer-1.apk", "stable")},
+		}}},
+	}
+	rebuildIndexes()
+	mutex.Unlock()
+
+	search := func(query string) []SearchResult {
+		t.Helper()
+		var body struct {
+			Results []SearchResult `json:"results"`
+		}
+		decodeJSON(t, serve(router, http.MethodGet, "/api/search?q="+url.QueryEscape(query)), &body)
+		return body.Results
+	}
+
+	// The app name outranks a mention in the release notes
+	results := search("crash")
+	if len(results) != 2 || results[0].PackageName != "com.example.crashpad" || results[1].PackageName != "com.example.wallet" {
+		t.Fatalf("crash: %+v", results)
+	}
+	hits := results[1].Builds
+	if results[1].MatchedOn[0] != "releaseNotes" || len(hits) != 1 || hits[0].FileName != "wallet-3.apk" ||
+		!strings.Contains(hits[0].Snippet, "Fixed a crash") || strings.Contains(hits[0].Snippet, "\n") {
+		t.Errorf("crash hits: %+v", results[1])
+	}
+
+	// Builds matching more tokens come first; every token must match the app
+	results = search("RECEIPT offline")
+	if len(results) != 1 || len(results[0].Builds) != 2 || results[0].Builds[0].FileName != "wallet-3.apk" {
+		t.Errorf("receipt offline: %+v", results)
+	}
+	if results := search("receipt missing"); len(results) != 0 {
+		t.Errorf("receipt missing: %+v", results)
+	}
+
+	if results := search("payments"); len(results) != 1 || results[0].MatchedOn[0] != "projectName" || results[0].Builds != nil {
+		t.Errorf("payments: %+v", results)
+	}
+}
+
+func TestNotesSnippet(t *testing.T) {
+	text := strings.Repeat("前言 ", 30) + "修复了闪退问题" + strings.Repeat(" 其他", 40)
+	doc := searchNotes{notes: text, lower: text}
+	snippet := notesSnippet(doc, strings.Index(text, "闪退"))
+	if !strings.HasPrefix(snippet, "…") || !strings.HasSuffix(snippet, "…") || !strings.Contains(snippet, "修复了闪退问题") {
+		t.Errorf("snippet = %q", snippet)
+	}
+	if got := notesScore("fixed a crash", "crash"); got != 20 {
+		t.Errorf("word match scored %d", got)
+	}
+	if got := notesScore("crashpad uncrash", "rash"); got != 10 {
+		t.Errorf("inner match scored %d", got)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// runServer serves handler until SIGINT or SIGTERM and then shuts down
+// gracefully, see shutdown. With HTTPS configured it also runs the plain
+// HTTP redirect port, if any.
+func runServer(handler http.Handler) error {
+	tlsConfig, redirect, err := serverTLS()
+	if err != nil {
+		return err
+	}
+	listener, err := listen()
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		slog.Info("已启用 HTTPS")
+		if config.HTTPRedirectPort > 0 {
+			redirectServer := &http.Server{
+				Addr:              fmt.Sprintf(":%d", config.HTTPRedirectPort),
+				Handler:           redirect,
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+			go func() {
+				if err := redirectServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+					slog.Warn("HTTP 跳转端口无法使用", "port", config.HTTPRedirectPort, "error", err)
+				}
+			}()
+			defer redirectServer.Close()
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return serveUntil(ctx, &http.Server{Handler: handler, TLSConfig: tlsConfig}, listener)
+}
+
+// listen returns the listener the server accepts connections on. Under
+// systemd socket activation it is the inherited socket, which systemd
+// keeps open across restarts, so connections made while the service
+// restarts wait instead of being refused; otherwise a new socket on
+// config.Port.
+func listen() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") == strconv.Itoa(os.Getpid()) && os.Getenv("LISTEN_FDS") != "" {
+		// Keep the sockets from being taken over by child processes
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+		// Inherited sockets start at file descriptor 3
+		f := os.NewFile(3, "systemd-socket")
+		defer f.Close()
+		listener, err := net.FileListener(f)
+		if err != nil {
+			return nil, fmt.Errorf("无法使用 systemd 传入的套接字: %w", err)
+		}
+		slog.Info("服务器已启动，使用 systemd 传入的套接字", "addr", listener.Addr().String())
+		return listener, nil
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", config.Port))
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("服务器已启动", "port", config.Port)
+	return listener, nil
+}
+
+// serveUntil serves srv on listener until ctx is done, then shuts down.
+// It serves HTTPS when srv has a TLS configuration.
+func serveUntil(ctx context.Context, srv *http.Server, listener net.Listener) error {
+	errc := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			errc <- srv.ServeTLS(listener, "", "")
+		} else {
+			errc <- srv.Serve(listener)
+		}
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	shutdown(srv)
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// shutdown stops accepting connections and waits, up to
+// config.ShutdownTimeout, for in-flight requests and queued background
+// uploads to finish. It then flushes the catalog and counters, closes the
+// metadata store and removes leftover staged uploads. Unfinished chunked
+// uploads are kept, as clients can resume them after the restart.
+func shutdown(srv *http.Server) {
+	slog.Info("正在关闭服务器，等待进行中的请求与上传完成", "timeout", config.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	// Event streams never end on their own
+	srv.RegisterOnShutdown(events.closeAll)
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("等待进行中的请求超时", "error", err)
+	}
+	if err := uploadJobs.wait(ctx); err != nil {
+		slog.Warn("等待后台上传任务超时", "error", err)
+	}
+
+	mutex.Lock()
+	if err := saveMetadata(); err != nil {
+		slog.Error("关闭前保存元数据失败", "error", err)
+	}
+	mutex.Unlock()
+	stats.mu.Lock()
+	if err := stats.save(); err != nil {
+		slog.Error("关闭前保存统计数据失败", "error", err)
+	}
+	stats.mu.Unlock()
+	if closer, ok := metadataStore.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			slog.Warn("关闭元数据存储失败", "error", err)
+		}
+	}
+	cleanIncoming()
+	slog.Info("服务器已关闭")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+	router := setupTestServer(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	router.GET("/slow", func(c *gin.Context) {
+		close(started)
+		<-release
+		c.String(http.StatusOK, "done")
+	})
+	if err := os.MkdirAll(config.IncomingDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	staged := filepath.Join(config.IncomingDir, "leftover.apk")
+	if err := os.WriteFile(staged, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serveUntil(ctx, &http.Server{Handler: router}, listener) }()
+	base := "http://" + listener.Addr().String()
+
+	stream, err := http.Get(base + "/api/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	type result struct {
+		body string
+		err  error
+	}
+	slow := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(base + "/slow")
+		if err != nil {
+			slow <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		slow <- result{string(body), err}
+	}()
+	<-started
+
+	cancel()
+	select {
+	case err := <-served:
+		t.Fatalf("server stopped with a request in flight: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if r := <-slow; r.err != nil || r.body != "done" {
+		t.Errorf("in-flight request = %q, %v", r.body, r.err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serveUntil: %v", err)
+	}
+	// The event stream was ended rather than holding up the shutdown
+	if _, err := io.ReadAll(stream.Body); err != nil {
+		t.Errorf("event stream: %v", err)
+	}
+	if _, err := os.Stat(staged); !os.IsNotExist(err) {
+		t.Errorf("staged upload left behind: %v", err)
+	}
+	if _, err := os.Stat(metadataFilePath); err != nil {
+		t.Errorf("metadata not flushed: %v", err)
+	}
+}
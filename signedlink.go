@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultLinkTTL is the lifetime of a signed download link without ?ttl=
+const defaultLinkTTL = 24 * time.Hour
+
+// ephemeralLinkKey signs download links when APPDIST_LINK_SIGNING_KEY is
+// unset; links then stop working when the process restarts
+var ephemeralLinkKey = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+// SignedLink is the response of GET /api/builds/:packageName/:fileName/link
+type SignedLink struct {
+	FileName  string `json:"fileName"`
+	URL       string `json:"url"`
+	ExpiresAt string `json:"expiresAt"`
+	// BundleURL is the original .aab of an App Bundle whose URL is the
+	// universal APK
+	BundleURL string `json:"bundleUrl,omitempty"`
+	// Expansions holds the links of the build's expansion (OBB) files, keyed
+	// by file name
+	Expansions map[string]string `json:"expansions,omitempty"`
+}
+
+// linkSignature returns the hex HMAC-SHA256 authorizing a download of the
+// root-relative path until the Unix time expires
+func linkSignature(path string, expires int64) string {
+	key := ephemeralLinkKey
+	if config.LinkSigningKey != "" {
+		key = []byte(config.LinkSigningKey)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(path + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signPath appends the expires and signature parameters to a root-relative
+// download path
+func signPath(path string, expires time.Time) string {
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", linkSignature(path, expires.Unix()))
+	return path + "?" + query.Encode()
+}
+
+// checkLinkSignature reports whether the request carries an unexpired
+// signature for path, writing a 403 response when it does not
+func checkLinkSignature(c *gin.Context, path string) bool {
+	if message := linkSignatureError(c, path); message != "" {
+		respondText(c, http.StatusForbidden, "%s", message)
+		return false
+	}
+	return true
+}
+
+// linkSignatureError returns why the request carries no unexpired
+// signature for path, or "" when it does
+func linkSignatureError(c *gin.Context, path string) string {
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	signature := c.Query("signature")
+	switch {
+	case err != nil || signature == "":
+		return "该应用为私有应用，只能通过签名下载链接下载"
+	case !hmac.Equal([]byte(signature), []byte(linkSignature(path, expires))):
+		return "下载链接签名无效"
+	case time.Now().Unix() > expires:
+		return "下载链接已过期"
+	}
+	return ""
+}
+
+// privateDownload reports whether the stored package fileName, or the
+// universal APK of a bundle, belongs to a private app. Projects the request
+// may not see are left to downloadVisible.
+func privateDownload(fileName string) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, project := range allProjects {
+		for _, app := range project.Apps {
+			if !app.Private {
+				continue
+			}
+			for _, build := range app.Builds {
+				if build.FileName == fileName || build.UniversalAPK == fileName {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// privateExpansion reports whether the expansion directory dirName belongs
+// to a build of a private app or of a project c may not see
+func privateExpansion(c *gin.Context, dirName string) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, project := range allProjects {
+		hidden := !canViewProject(c, project)
+		for _, app := range project.Apps {
+			if !app.Private && !hidden {
+				continue
+			}
+			for _, build := range app.Builds {
+				if expansionDirName(build.FileName) == dirName {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// handleBuildLink serves GET /api/builds/:packageName/:fileName/link?ttl=24h
+// with download links of the build signed for ttl (at most
+// APPDIST_MAX_LINK_TTL). Only downloads of private apps check them, but
+// links can be made for any build.
+func handleBuildLink(c *gin.Context) {
+	if !checkAdmin(c) {
+		return
+	}
+	ttl := defaultLinkTTL
+	if value := c.Query("ttl"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 || d > config.MaxLinkTTL {
+			respondError(c, http.StatusBadRequest, "ttl 必须是不超过 "+config.MaxLinkTTL.String()+" 的正时长，例如 24h")
+			return
+		}
+		ttl = d
+	}
+
+	fileName := c.Param("fileName")
+	_, app, found := repo.FindApp(c.Param("packageName"))
+	if !found {
+		respondError(c, http.StatusNotFound, "应用未找到")
+		return
+	}
+	i := slices.IndexFunc(app.Builds, func(build BuildInfo) bool { return build.FileName == fileName })
+	if i < 0 {
+		respondError(c, http.StatusNotFound, "构建版本未找到")
+		return
+	}
+
+	build := app.Builds[i]
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	baseURL := requestBaseURL(c)
+	link := SignedLink{
+		FileName:  fileName,
+		URL:       baseURL + signPath(installableURL(build), expires),
+		ExpiresAt: timestamp(expires),
+	}
+	if installableURL(build) != build.DownloadURL {
+		link.BundleURL = baseURL + signPath(build.DownloadURL, expires)
+	}
+	for _, expansion := range build.Expansions {
+		if link.Expansions == nil {
+			link.Expansions = map[string]string{}
+		}
+		link.Expansions[expansion.FileName] = baseURL + signPath(expansion.DownloadURL, expires)
+	}
+	c.JSON(http.StatusOK, link)
+}
+
+// handleSetPrivate marks an app private or public with {"private": true}.
+// Downloads of private apps need a signed link, see handleBuildLink.
+func handleSetPrivate(c *gin.Context) {
+	if !checkAdmin(c) {
+		return
+	}
+	var req struct {
+		Private *bool `json:"private"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Private == nil {
+		respondError(c, http.StatusBadRequest, "请求体格式错误，需要 private 字段")
+		return
+	}
+	packageName := c.Param("packageName")
+
+	projectName, _, err := repo.UpdateApp(packageName, func(app *AppEntry) error {
+		app.Private = *req.Private
+		return nil
+	})
+	if err != nil {
+		respondRepositoryError(c, err)
+		return
+	}
+	state := "公开"
+	if *req.Private {
+		state = "私有"
+	}
+	logf(c, "应用 %s 已设为%s", packageName, state)
+	audit.record(c, AuditEntry{Action: auditSetPrivate, ProjectName: projectName, PackageName: packageName, Detail: strconv.FormatBool(*req.Private)})
+	c.JSON(http.StatusOK, gin.H{"packageName": packageName, "private": *req.Private})
+}
+
+// downloadPath is the root-relative path a download request was made for,
+// the one its link signature covers
+func downloadPath(c *gin.Context) string {
+	return strings.TrimPrefix(c.Request.URL.Path, config.BasePath)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrivateAppSignedLinks(t *testing.T) {
+	router := setupTestServer(t)
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	build := appBuilds(t, router, fixturePackage)[0]
+	if rec := serve(router, http.MethodGet, build.DownloadURL); rec.Code != http.StatusOK {
+		t.Fatalf("public download: status %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/api/apps/"+fixturePackage+"/private", strings.NewReader(`{"private":true}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(adminPasswordHeader, testAdminPassword)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("set private: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve(router, http.MethodGet, build.DownloadURL); rec.Code != http.StatusForbidden {
+		t.Errorf("unsigned download: status %d, want 403", rec.Code)
+	}
+	if rec := serve(router, http.MethodGet, build.DownloadURL+checksumSuffix); rec.Code != http.StatusForbidden {
+		t.Errorf("unsigned checksum: status %d, want 403", rec.Code)
+	}
+
+	linkURL := "/api/builds/" + fixturePackage + "/" + build.FileName + "/link"
+	if rec := serve(router, http.MethodGet, linkURL); rec.Code != http.StatusUnauthorized {
+		t.Errorf("link without admin: status %d, want 401", rec.Code)
+	}
+	if rec := serveAdmin(router, http.MethodGet, linkURL+"?ttl=9999h"); rec.Code != http.StatusBadRequest {
+		t.Errorf("ttl above maximum: status %d, want 400", rec.Code)
+	}
+	rec = serveAdmin(router, http.MethodGet, linkURL+"?ttl=1h")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("link: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var link SignedLink
+	decodeJSON(t, rec, &link)
+	signed := strings.TrimPrefix(link.URL, "http://example.com")
+	if !strings.HasPrefix(signed, build.DownloadURL+"?") {
+		t.Fatalf("link = %+v", link)
+	}
+	if rec := serve(router, http.MethodGet, signed); rec.Code != http.StatusOK {
+		t.Errorf("signed download: status %d", rec.Code)
+	}
+	// The signature of the package covers its checksum
+	checksum := strings.Replace(signed, "?", checksumSuffix+"?", 1)
+	if rec := serve(router, http.MethodGet, checksum); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), build.FileName) {
+		t.Errorf("signed checksum: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve(router, http.MethodGet, strings.Replace(signed, "expires=", "expires=1", 1)); rec.Code != http.StatusForbidden {
+		t.Errorf("tampered expiry: status %d, want 403", rec.Code)
+	}
+	if rec := serve(router, http.MethodGet, signPath(build.DownloadURL, time.Now().Add(-time.Minute))); rec.Code != http.StatusForbidden {
+		t.Errorf("expired link: status %d, want 403", rec.Code)
+	}
+}
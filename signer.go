@@ -0,0 +1,336 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Android verifies an update against the signing certificate of the installed
+// app, so builds signed with different keys (e.g. debug and release) cannot
+// be installed over each other. Signers are identified like apksigner does,
+// by the SHA-256 digest of the signing certificate.
+
+// Values of the APK Signing Block, see
+// https://source.android.com/docs/security/features/apksigning/v2
+const (
+	apkSigBlockMagic     = "APK Sig Block 42"
+	apkSignatureV2ID     = 0x7109871a
+	apkSignatureV3ID     = 0xf05368c0
+	eocdRecordLen        = 22
+	maxZipCommentLen     = 0xffff
+	maxSigningBlockBytes = 64 << 20
+)
+
+// Values of Config.SignerPolicy; they mirror the downgrade policy
+const (
+	signerOff    = "off"
+	signerWarn   = "warn"
+	signerStrict = "strict"
+)
+
+// androidDebugSubject is the common name of the certificate the Android
+// SDK generates to sign debug builds with
+const androidDebugSubject = "Android Debug"
+
+// SigningCertificate describes the certificate that signed an APK. The
+// fingerprints are hex digests of the DER certificate, as printed by
+// apksigner and keytool.
+type SigningCertificate struct {
+	SHA256    string `json:"sha256"`
+	SHA1      string `json:"sha1"`
+	Subject   string `json:"subject,omitempty"`
+	Issuer    string `json:"issuer,omitempty"`
+	NotBefore string `json:"notBefore,omitempty"`
+	NotAfter  string `json:"notAfter,omitempty"`
+	// Debug marks the SDK's debug certificate, which release builds should
+	// never be signed with
+	Debug bool `json:"debug,omitempty"`
+}
+
+// apkSigningCertificate returns the certificate that signed the APK at
+// path, preferring the v3 and v2 signature schemes over v1 JAR signing. It
+// returns nil without error for unsigned packages. A certificate Go cannot
+// parse still gets its fingerprints.
+func apkSigningCertificate(path string) (*SigningCertificate, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := signingBlockCertificate(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	if der == nil {
+		if der, err = jarSigningCertificate(f, info.Size()); err != nil {
+			return nil, err
+		}
+	}
+	if der == nil {
+		return nil, nil
+	}
+	sum256, sum1 := sha256.Sum256(der), sha1.Sum(der)
+	signing := &SigningCertificate{SHA256: hex.EncodeToString(sum256[:]), SHA1: hex.EncodeToString(sum1[:])}
+	if cert, err := x509.ParseCertificate(der); err == nil {
+		signing.Subject = cert.Subject.String()
+		signing.Issuer = cert.Issuer.String()
+		signing.NotBefore = timestamp(cert.NotBefore)
+		signing.NotAfter = timestamp(cert.NotAfter)
+		signing.Debug = cert.Subject.CommonName == androidDebugSubject
+	}
+	return signing, nil
+}
+
+// certificateSHA256 returns the signer digest of cert, "" for unsigned
+// packages
+func certificateSHA256(cert *SigningCertificate) string {
+	if cert == nil {
+		return ""
+	}
+	return cert.SHA256
+}
+
+// signingBlockCertificate returns the first signer's certificate from the
+// APK Signing Block that precedes the zip central directory, or nil when the
+// package has no v2/v3 signature.
+func signingBlockCertificate(r io.ReaderAt, size int64) ([]byte, error) {
+	cdOffset, err := centralDirectoryOffset(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if cdOffset < int64(24) {
+		return nil, nil
+	}
+	footer := make([]byte, 24)
+	if _, err := r.ReadAt(footer, cdOffset-24); err != nil {
+		return nil, err
+	}
+	if string(footer[8:]) != apkSigBlockMagic {
+		return nil, nil
+	}
+	blockSize := binary.LittleEndian.Uint64(footer[:8])
+	if blockSize < 24 || blockSize > maxSigningBlockBytes || int64(blockSize)+8 > cdOffset {
+		return nil, errors.New("APK 签名块大小无效")
+	}
+	// The block is its size, the ID-value pairs, its size again and the magic
+	pairs := make([]byte, blockSize-24)
+	if _, err := r.ReadAt(pairs, cdOffset-int64(blockSize)); err != nil {
+		return nil, err
+	}
+
+	values := map[uint32][]byte{}
+	for len(pairs) > 0 {
+		if len(pairs) < 8 {
+			return nil, errors.New("APK 签名块格式错误")
+		}
+		pairLen := binary.LittleEndian.Uint64(pairs[:8])
+		if pairLen < 4 || pairLen > uint64(len(pairs)-8) {
+			return nil, errors.New("APK 签名块格式错误")
+		}
+		pair := pairs[8 : 8+pairLen]
+		values[binary.LittleEndian.Uint32(pair[:4])] = pair[4:]
+		pairs = pairs[8+pairLen:]
+	}
+	for _, id := range []uint32{apkSignatureV3ID, apkSignatureV2ID} {
+		if value, ok := values[id]; ok {
+			return schemeFirstCertificate(value)
+		}
+	}
+	return nil, nil
+}
+
+// schemeFirstCertificate walks a v2/v3 signature scheme value:
+// signers > signer > signed data > (digests, certificates > certificate).
+func schemeFirstCertificate(value []byte) ([]byte, error) {
+	signers, _, err := lengthPrefixed(value)
+	if err != nil {
+		return nil, err
+	}
+	signer, _, err := lengthPrefixed(signers)
+	if err != nil {
+		return nil, err
+	}
+	signedData, _, err := lengthPrefixed(signer)
+	if err != nil {
+		return nil, err
+	}
+	_, rest, err := lengthPrefixed(signedData) // digests
+	if err != nil {
+		return nil, err
+	}
+	certificates, _, err := lengthPrefixed(rest)
+	if err != nil {
+		return nil, err
+	}
+	cert, _, err := lengthPrefixed(certificates)
+	return cert, err
+}
+
+// lengthPrefixed splits a uint32 little endian length-prefixed value off b
+func lengthPrefixed(b []byte) (value, rest []byte, err error) {
+	if len(b) < 4 {
+		return nil, nil, errors.New("APK 签名数据格式错误")
+	}
+	n := binary.LittleEndian.Uint32(b[:4])
+	if uint64(n) > uint64(len(b)-4) {
+		return nil, nil, errors.New("APK 签名数据格式错误")
+	}
+	return b[4 : 4+n], b[4+n:], nil
+}
+
+// centralDirectoryOffset locates the end of central directory record and
+// returns the central directory offset it records
+func centralDirectoryOffset(r io.ReaderAt, size int64) (int64, error) {
+	tailLen := min(size, int64(eocdRecordLen+maxZipCommentLen))
+	tail := make([]byte, tailLen)
+	if _, err := r.ReadAt(tail, size-tailLen); err != nil {
+		return 0, err
+	}
+	for i := len(tail) - eocdRecordLen; i >= 0; i-- {
+		if !bytes.Equal(tail[i:i+4], []byte("PK\x05\x06")) {
+			continue
+		}
+		commentLen := int(binary.LittleEndian.Uint16(tail[i+20 : i+22]))
+		if i+eocdRecordLen+commentLen != len(tail) {
+			continue
+		}
+		return int64(binary.LittleEndian.Uint32(tail[i+16 : i+20])), nil
+	}
+	return 0, errors.New("未找到 zip 目录结尾记录")
+}
+
+// pkcs7ContentInfo and pkcs7SignedData are the parts of a PKCS #7 signature
+// (RFC 2315) needed to reach its certificates
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+// jarSigningCertificate returns the first certificate of the v1 (JAR)
+// signature in META-INF, or nil when there is none
+func jarSigningCertificate(r io.ReaderAt, size int64) ([]byte, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range zr.File {
+		dir, name := path.Split(file.Name)
+		ext := strings.ToUpper(path.Ext(name))
+		if dir != "META-INF/" || (ext != ".RSA" && ext != ".DSA" && ext != ".EC") {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, maxSigningBlockBytes))
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		return pkcs7FirstCertificate(data)
+	}
+	return nil, nil
+}
+
+// pkcs7FirstCertificate returns the DER encoding of the first certificate in
+// a PKCS #7 SignedData structure
+func pkcs7FirstCertificate(data []byte) ([]byte, error) {
+	var info pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("解析 v1 签名失败: %w", err)
+	}
+	var signed pkcs7SignedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signed); err != nil {
+		return nil, fmt.Errorf("解析 v1 签名失败: %w", err)
+	}
+	var cert asn1.RawValue
+	if _, err := asn1.Unmarshal(signed.Certificates.Bytes, &cert); err != nil {
+		return nil, fmt.Errorf("v1 签名中没有证书: %w", err)
+	}
+	return cert.FullBytes, nil
+}
+
+// detectSignerChange compares the signing certificate of an upload with
+// the signer stored for packageName. It returns a warning when they differ
+// and the policy is not "off", and reports whether the policy requires the
+// upload to be rejected. Apps without a recorded signer are never flagged.
+// The warning names the subjects, so a debug-signed upload stands out.
+func detectSignerChange(packageName string, cert *SigningCertificate, allowSignerChange bool) (warning string, reject bool) {
+	if config.SignerPolicy == signerOff || cert == nil {
+		return "", false
+	}
+
+	mutex.Lock()
+	stored, storedSubject := "", ""
+	if i, j, found := findApp(packageName); found {
+		app := allProjects[i].Apps[j]
+		stored = app.SignerSHA256
+		for _, build := range app.Builds {
+			if build.Certificate != nil && build.Certificate.SHA256 == stored {
+				storedSubject = build.Certificate.Subject
+				break
+			}
+		}
+	}
+	mutex.Unlock()
+
+	if stored == "" || stored == cert.SHA256 {
+		return "", false
+	}
+	warning = fmt.Sprintf("签名变化: 新构建的签名证书 (%s) 与已有构建 (%s) 不同，无法覆盖安装已安装的版本",
+		describeCertificate(cert.SHA256, cert.Subject), describeCertificate(stored, storedSubject))
+	return warning, config.SignerPolicy == signerStrict && !allowSignerChange
+}
+
+// debugSignerWarning warns about uploads signed with the SDK's debug
+// certificate unless the signer policy is "off"
+func debugSignerWarning(cert *SigningCertificate) string {
+	if config.SignerPolicy == signerOff || cert == nil || !cert.Debug {
+		return ""
+	}
+	return "该构建使用 Android 调试证书签名，通常是误传了 debug 包"
+}
+
+// describeCertificate names a certificate by its short fingerprint and,
+// when known, its subject
+func describeCertificate(digest, subject string) string {
+	if subject == "" {
+		return shortFingerprint(digest)
+	}
+	return shortFingerprint(digest) + ", " + subject
+}
+
+// shortFingerprint abbreviates a certificate digest for display
+func shortFingerprint(digest string) string {
+	if len(digest) <= 16 {
+		return strings.ToUpper(digest)
+	}
+	return strings.ToUpper(digest[:16])
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// v1SignedFixture returns the fixture APK with a v1 signature block whose
+// certificate has the common name commonName. Only the certificate is
+// real; nothing verifies the signature.
+func v1SignedFixture(t *testing.T, commonName string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"Android"}, Country: []string{"US"}},
+		NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emptySet := asn1.RawValue{FullBytes: []byte{0x31, 0x00}}
+	dataInfo, _ := asn1.Marshal(struct{ ContentType asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}})
+	certs, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der})
+	signed, err := asn1.Marshal(struct {
+		Version                                           int
+		DigestAlgorithms, ContentInfo, Certs, SignerInfos asn1.RawValue
+	}{1, emptySet, asn1.RawValue{FullBytes: dataInfo}, asn1.RawValue{FullBytes: certs}, emptySet})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := zip.NewReader(bytes.NewReader(helloworldAPK), int64(len(helloworldAPK)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	w := zip.NewWriter(&out)
+	for _, f := range src.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst, _ := w.Create(f.Name)
+		io.Copy(dst, rc)
+		rc.Close()
+	}
+	dst, _ := w.Create("META-INF/CERT.RSA")
+	dst.Write(block)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestSigningCertificate(t *testing.T) {
+	router := setupTestServer(t)
+
+	if rec := uploadFixture(router, v1SignedFixture(t, "Example Release"), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("release upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	build := appBuilds(t, router, fixturePackage)[0]
+	cert := build.Certificate
+	if cert == nil || cert.SHA256 != build.SignerSHA256 || len(cert.SHA1) != 40 || cert.Debug ||
+		cert.Subject != "CN=Example Release,O=Android,C=US" || !strings.HasPrefix(cert.NotAfter, "2050-01-01") {
+		t.Fatalf("certificate = %+v", cert)
+	}
+
+	// A debug-signed upload is flagged both as debug-signed and as a change
+	rec := uploadFixture(router, v1SignedFixture(t, androidDebugSubject), "Demo", "beta")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("debug upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Warnings []string `json:"warnings"`
+	}
+	decodeJSON(t, rec, &body)
+	joined := strings.Join(body.Warnings, "\n")
+	if !strings.Contains(joined, "调试证书") || !strings.Contains(joined, "CN=Android Debug") || !strings.Contains(joined, "CN=Example Release") {
+		t.Errorf("warnings = %q", body.Warnings)
+	}
+	if builds := appBuilds(t, router, fixturePackage); builds[0].Certificate == nil || !builds[0].Certificate.Debug {
+		t.Errorf("debug build = %+v", builds[0])
+	}
+	page := serve(router, http.MethodGet, "/app/"+fixturePackage).Body.String()
+	if !strings.Contains(page, "该构建使用 Android 调试证书签名") || !strings.Contains(page, "SHA-1: "+cert.SHA1) {
+		t.Error("detail page lacks the certificate details")
+	}
+
+	config.SignerPolicy = signerStrict
+	if rec := uploadFixture(router, v1SignedFixture(t, "Another Key"), "Demo", "dev"); rec.Code != http.StatusConflict {
+		t.Errorf("strict policy: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	snapshotPrefix     = "metadata-"
+	snapshotTimeLayout = "20060102-150405"
+)
+
+// SnapshotInfo describes one metadata snapshot on disk
+type SnapshotInfo struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// createSnapshot writes the current catalog to a timestamped file in the
+// snapshot directory and prunes old snapshots beyond the retention limit.
+func createSnapshot() (SnapshotInfo, error) {
+	mutex.Lock()
+	data, err := json.MarshalIndent(allProjects, "", "  ")
+	mutex.Unlock()
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	if err := os.MkdirAll(config.SnapshotDir, 0755); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("无法创建快照目录: %w", err)
+	}
+	now := time.Now()
+	name := snapshotPrefix + now.Format(snapshotTimeLayout) + ".json"
+	if err := writeFileSync(filepath.Join(config.SnapshotDir, name), data); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("写入快照失败: %w", err)
+	}
+	if err := pruneSnapshots(); err != nil {
+		slog.Warn("清理旧快照失败", "error", err)
+	}
+	return SnapshotInfo{Name: name, Size: int64(len(data)), CreatedAt: timestamp(now)}, nil
+}
+
+// listSnapshots returns the snapshots on disk, newest first.
+func listSnapshots() ([]SnapshotInfo, error) {
+	entries, err := os.ReadDir(config.SnapshotDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []SnapshotInfo{}, nil
+		}
+		return nil, err
+	}
+	snapshots := []SnapshotInfo{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), ".json")
+		createdAt, err := time.ParseInLocation(snapshotTimeLayout, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, SnapshotInfo{
+			Name:      name,
+			Size:      info.Size(),
+			CreatedAt: timestamp(createdAt),
+		})
+	}
+	// The timestamp layout sorts lexically in chronological order
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Name > snapshots[j].Name })
+	return snapshots, nil
+}
+
+// pruneSnapshots removes the oldest snapshots beyond config.SnapshotRetain.
+func pruneSnapshots() error {
+	if config.SnapshotRetain <= 0 {
+		return nil
+	}
+	snapshots, err := listSnapshots()
+	if err != nil {
+		return err
+	}
+	for _, snapshot := range snapshots[min(len(snapshots), config.SnapshotRetain):] {
+		if err := os.Remove(filepath.Join(config.SnapshotDir, snapshot.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startSnapshotScheduler writes a snapshot every config.SnapshotInterval.
+// It does nothing when the interval is not positive.
+func startSnapshotScheduler() {
+	if config.SnapshotInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(config.SnapshotInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if snapshot, err := createSnapshot(); err != nil {
+				slog.Error("定时快照失败", "error", err)
+			} else {
+				slog.Info("已生成元数据快照", "name", snapshot.Name)
+			}
+		}
+	}()
+}
+
+func handleCreateSnapshot(c *gin.Context) {
+	snapshot, err := createSnapshot()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "快照已创建", "snapshot": snapshot})
+}
+
+func handleListSnapshots(c *gin.Context) {
+	snapshots, err := listSnapshots()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "读取快照目录失败: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"snapshots": snapshots})
+}
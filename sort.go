@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Catalog orders selectable with ?sort= wherever projects or apps are listed.
+// allProjects itself stays in insertion order; listings sort a copy.
+const (
+	sortByName   = "name"   // case-insensitive name, the default
+	sortByRecent = "recent" // most recent upload first, ties by name
+)
+
+// parseCatalogSort returns the order requested by a ?sort= value, falling
+// back to sortByName for empty or unknown values
+func parseCatalogSort(value string) string {
+	if value == sortByRecent {
+		return sortByRecent
+	}
+	return sortByName
+}
+
+// catalogLess orders two listing entries by name or by their latest upload
+// time. Stored upload times are UTC RFC 3339, so they compare as strings.
+func catalogLess(order, nameA, nameB, latestA, latestB string) bool {
+	if order == sortByRecent && latestA != latestB {
+		return latestA > latestB
+	}
+	foldedA, foldedB := strings.ToLower(nameA), strings.ToLower(nameB)
+	if foldedA != foldedB {
+		return foldedA < foldedB
+	}
+	return nameA < nameB
+}
+
+// latestUpload returns the most recent upload time among builds, or ""
+func latestUpload(builds []BuildInfo) string {
+	latest := ""
+	for _, build := range builds {
+		if build.UploadTime > latest {
+			latest = build.UploadTime
+		}
+	}
+	return latest
+}
+
+// sortHomeProjects orders the homepage view in place: the apps of each
+// project, then the projects, whose latest upload is that of their newest app
+func sortHomeProjects(projects []homeProject, order string) {
+	latest := make([]string, len(projects))
+	for i := range projects {
+		apps := projects[i].Apps
+		sort.SliceStable(apps, func(a, b int) bool {
+			return catalogLess(order, apps[a].AppName, apps[b].AppName, apps[a].LatestUpload, apps[b].LatestUpload)
+		})
+		for _, app := range apps {
+			if app.LatestUpload > latest[i] {
+				latest[i] = app.LatestUpload
+			}
+		}
+	}
+	sort.Stable(homeProjectSorter{projects, latest, order})
+}
+
+// homeProjectSorter sorts projects together with their latest upload times
+type homeProjectSorter struct {
+	projects []homeProject
+	latest   []string
+	order    string
+}
+
+func (s homeProjectSorter) Len() int { return len(s.projects) }
+
+func (s homeProjectSorter) Less(a, b int) bool {
+	return catalogLess(s.order, s.projects[a].ProjectName, s.projects[b].ProjectName, s.latest[a], s.latest[b])
+}
+
+func (s homeProjectSorter) Swap(a, b int) {
+	s.projects[a], s.projects[b] = s.projects[b], s.projects[a]
+	s.latest[a], s.latest[b] = s.latest[b], s.latest[a]
+}
+
+// sortManifestApps orders the flat manifest app list consistently with the
+// homepage: by project and then app name, or newest upload first
+func sortManifestApps(apps []ManifestApp, order string) {
+	sort.SliceStable(apps, func(a, b int) bool {
+		if order == sortByName && apps[a].Project != apps[b].Project {
+			return catalogLess(order, apps[a].Project, apps[b].Project, "", "")
+		}
+		return catalogLess(order, apps[a].Name, apps[b].Name, manifestLatest(apps[a]), manifestLatest(apps[b]))
+	})
+}
+
+// manifestLatest returns the newest uploadedAt of a manifest app
+func manifestLatest(app ManifestApp) string {
+	latest := ""
+	for _, version := range app.Versions {
+		if version.UploadedAt > latest {
+			latest = version.UploadedAt
+		}
+	}
+	return latest
+}
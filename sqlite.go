@@ -0,0 +1,246 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteSchemaVersion is stored as the database's user_version. A new
+// database has version 0 until the schema is created and the JSON catalog
+// imported, so the import happens exactly once.
+const sqliteSchemaVersion = 1
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS projects (
+	name     TEXT PRIMARY KEY,
+	position INTEGER NOT NULL,
+	data     TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS apps (
+	project      TEXT NOT NULL,
+	package_name TEXT NOT NULL,
+	position     INTEGER NOT NULL,
+	data         TEXT NOT NULL,
+	PRIMARY KEY (project, package_name)
+);`
+
+// sqliteMetadataStore keeps the catalog in a SQLite database, one row per
+// project and one per app holding its JSON with the builds. A save writes
+// only the rows that changed since the last load or save, in a single
+// transaction, so it stays cheap as the catalog grows.
+type sqliteMetadataStore struct {
+	db *sql.DB
+	// saved maps the key of each stored row to its position and data as
+	// last written, see sqliteRow
+	saved map[string]sqliteRow
+}
+
+// sqliteRow is a row of the projects or apps table
+type sqliteRow struct {
+	project     string
+	packageName string // "" for project rows
+	position    int
+	data        string
+}
+
+func (r sqliteRow) key() string {
+	if r.packageName == "" {
+		return "project\x00" + r.project
+	}
+	return "app\x00" + r.project + "\x00" + r.packageName
+}
+
+// openSQLiteMetadataStore opens or creates the database at path. A new
+// database imports the catalog from the JSON file at jsonPath, if any; the
+// file itself is left in place.
+func openSQLiteMetadataStore(path, jsonPath string) (*sqliteMetadataStore, error) {
+	dsn := "file:" + url.PathEscape(path) + "?_busy_timeout=5000&_journal_mode=WAL&_synchronous=FULL&_txlock=immediate"
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// Saves are serialized by saveMutex anyway; one connection keeps
+	// transactions from waiting on each other's locks
+	db.SetMaxOpenConns(1)
+	s := &sqliteMetadataStore{db: db, saved: map[string]sqliteRow{}}
+	if err := s.migrate(jsonPath); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("初始化 SQLite 数据库 %s 失败: %w", path, err)
+	}
+	return s, nil
+}
+
+// Close closes the database, checkpointing its write-ahead log
+func (s *sqliteMetadataStore) Close() error {
+	return s.db.Close()
+}
+
+// migrate creates the schema of a new database and imports the JSON catalog
+func (s *sqliteMetadataStore) migrate(jsonPath string) error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > sqliteSchemaVersion {
+		return fmt.Errorf("数据库版本 %d 高于当前程序支持的版本 %d", version, sqliteSchemaVersion)
+	}
+	if version == sqliteSchemaVersion {
+		return nil
+	}
+	if _, err := s.db.Exec(sqliteSchema); err != nil {
+		return err
+	}
+
+	projects, err := readMetadataJSON(jsonPath)
+	if err != nil {
+		return fmt.Errorf("读取待导入的 %s 失败: %w", jsonPath, err)
+	}
+	if err := s.Save(projects); err != nil {
+		return err
+	}
+	if len(projects) > 0 {
+		slog.Info("已将 JSON 元数据导入 SQLite 数据库，原文件保留，确认无误后可删除", "path", jsonPath, "projects", len(projects))
+	}
+	// PRAGMA does not take parameters
+	_, err = s.db.Exec("PRAGMA user_version = " + strconv.Itoa(sqliteSchemaVersion))
+	return err
+}
+
+// sqliteRows flattens projects into table rows
+func sqliteRows(projects []Project) ([]sqliteRow, error) {
+	var rows []sqliteRow
+	for i, project := range projects {
+		apps := project.Apps
+		project.Apps = nil
+		data, err := json.Marshal(project)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, sqliteRow{project: project.ProjectName, position: i, data: string(data)})
+		for j, app := range apps {
+			data, err := json.Marshal(app)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, sqliteRow{project: project.ProjectName, packageName: app.PackageName, position: j, data: string(data)})
+		}
+	}
+	return rows, nil
+}
+
+func (s *sqliteMetadataStore) Load() ([]Project, error) {
+	saved := map[string]sqliteRow{}
+	projects := []Project{}
+	index := map[string]int{}
+
+	rows, err := s.db.Query("SELECT name, position, data FROM projects ORDER BY position")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row sqliteRow
+		if err := rows.Scan(&row.project, &row.position, &row.data); err != nil {
+			return nil, err
+		}
+		var project Project
+		if err := json.Unmarshal([]byte(row.data), &project); err != nil {
+			return nil, fmt.Errorf("项目 %s 的数据无效: %w", row.project, err)
+		}
+		project.Apps = []AppEntry{}
+		index[row.project] = len(projects)
+		projects = append(projects, project)
+		saved[row.key()] = row
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Release the only connection before the next query
+	rows.Close()
+
+	appRows, err := s.db.Query("SELECT project, package_name, position, data FROM apps ORDER BY project, position")
+	if err != nil {
+		return nil, err
+	}
+	defer appRows.Close()
+	for appRows.Next() {
+		var row sqliteRow
+		if err := appRows.Scan(&row.project, &row.packageName, &row.position, &row.data); err != nil {
+			return nil, err
+		}
+		i, ok := index[row.project]
+		if !ok {
+			return nil, fmt.Errorf("应用 %s 所属的项目 %s 不存在", row.packageName, row.project)
+		}
+		var app AppEntry
+		if err := json.Unmarshal([]byte(row.data), &app); err != nil {
+			return nil, fmt.Errorf("应用 %s 的数据无效: %w", row.packageName, err)
+		}
+		projects[i].Apps = append(projects[i].Apps, app)
+		saved[row.key()] = row
+	}
+	if err := appRows.Err(); err != nil {
+		return nil, err
+	}
+	s.saved = saved
+	return projects, nil
+}
+
+// Save writes the rows that differ from the stored ones and deletes those
+// no longer in the catalog, all in one transaction
+func (s *sqliteMetadataStore) Save(projects []Project) error {
+	rows, err := sqliteRows(projects)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("写入元数据失败: %w", err)
+	}
+	defer tx.Rollback()
+
+	current := make(map[string]sqliteRow, len(rows))
+	for _, row := range rows {
+		key := row.key()
+		current[key] = row
+		if s.saved[key] == row {
+			continue
+		}
+		if row.packageName == "" {
+			_, err = tx.Exec(`INSERT INTO projects (name, position, data) VALUES (?, ?, ?)
+				ON CONFLICT (name) DO UPDATE SET position = excluded.position, data = excluded.data`,
+				row.project, row.position, row.data)
+		} else {
+			_, err = tx.Exec(`INSERT INTO apps (project, package_name, position, data) VALUES (?, ?, ?, ?)
+				ON CONFLICT (project, package_name) DO UPDATE SET position = excluded.position, data = excluded.data`,
+				row.project, row.packageName, row.position, row.data)
+		}
+		if err != nil {
+			return fmt.Errorf("写入元数据失败: %w", err)
+		}
+	}
+	for key, row := range s.saved {
+		if _, ok := current[key]; ok {
+			continue
+		}
+		if row.packageName == "" {
+			_, err = tx.Exec("DELETE FROM projects WHERE name = ?", row.project)
+		} else {
+			_, err = tx.Exec("DELETE FROM apps WHERE project = ? AND package_name = ?", row.project, row.packageName)
+		}
+		if err != nil {
+			return fmt.Errorf("写入元数据失败: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("写入元数据失败: %w", err)
+	}
+	s.saved = current
+	return nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BuildStats holds the engagement counters of one stored file
+type BuildStats struct {
+	Downloads int64 `json:"downloads"`
+	Installs  int64 `json:"installs"`
+	// Daily counts the downloads per day in the display time zone, keyed
+	// like "2006-01-02"
+	Daily map[string]int64 `json:"daily,omitempty"`
+}
+
+// statsDayLayout keys BuildStats.Daily
+const statsDayLayout = "2006-01-02"
+
+// statsStore persists per-file counters in a JSON file next to the metadata.
+// It has its own lock so counting never contends with catalog writes.
+type statsStore struct {
+	mu    sync.Mutex
+	path  string
+	Files map[string]*BuildStats `json:"files"` // keyed by BuildInfo.FileName
+}
+
+var stats = &statsStore{Files: map[string]*BuildStats{}}
+
+// load reads the stats file at path; a missing file starts empty.
+func (s *statsStore) load(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	s.Files = map[string]*BuildStats{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, s)
+}
+
+// save writes the counters to disk. The caller must hold s.mu.
+func (s *statsStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	if err := writeFileSync(tmpPath, data); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+func (s *statsStore) entry(fileName string) *BuildStats {
+	entry, ok := s.Files[fileName]
+	if !ok {
+		entry = &BuildStats{}
+		s.Files[fileName] = entry
+	}
+	return entry
+}
+
+// recordInstall counts one install intent for fileName.
+func (s *statsStore) recordInstall(fileName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entry(fileName).Installs++
+	if err := s.save(); err != nil {
+		slog.Warn("保存统计数据失败", "error", err)
+	}
+}
+
+// recordDownload counts one download of fileName on today's date.
+func (s *statsStore) recordDownload(fileName string) {
+	day := time.Now().In(displayLocation("")).Format(statsDayLayout)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.entry(fileName)
+	entry.Downloads++
+	if entry.Daily == nil {
+		entry.Daily = map[string]int64{}
+	}
+	entry.Daily[day]++
+	if err := s.save(); err != nil {
+		slog.Warn("保存统计数据失败", "error", err)
+	}
+}
+
+// get returns a copy of the counters of fileName.
+func (s *statsStore) get(fileName string) BuildStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.Files[fileName]; ok {
+		counters := *entry
+		counters.Daily = maps.Clone(entry.Daily)
+		return counters
+	}
+	return BuildStats{}
+}
+
+// rename moves the counters of a file that was renamed.
+func (s *statsStore) rename(from, to string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.Files[from]
+	if !ok {
+		return
+	}
+	delete(s.Files, from)
+	s.Files[to] = entry
+	if err := s.save(); err != nil {
+		slog.Warn("保存统计数据失败", "error", err)
+	}
+}
+
+// forget drops the counters of a file that no longer exists.
+func (s *statsStore) forget(fileName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.Files[fileName]; !ok {
+		return
+	}
+	delete(s.Files, fileName)
+	if err := s.save(); err != nil {
+		slog.Warn("保存统计数据失败", "error", err)
+	}
+}
+
+// installCookieName derives a per-build cookie name used to avoid counting
+// repeated install clicks from the same browser.
+func installCookieName(fileName string) string {
+	sum := sha1.Sum([]byte(fileName))
+	return "appdist_install_" + hex.EncodeToString(sum[:6])
+}
+
+// handleInstallRedirect records an install intent for a build and then
+// redirects to the file itself, or for iOS builds to the itms-services link
+// that installs it over the air.
+func handleInstallRedirect(c *gin.Context) {
+	packageName := c.Param("packageName")
+	fileName := c.Query("fileName")
+
+	var downloadURL, platform string
+	mutex.Lock()
+	for _, project := range allProjects {
+		for _, app := range project.Apps {
+			if app.PackageName != packageName {
+				continue
+			}
+			for _, build := range app.Builds {
+				if build.FileName == fileName {
+					downloadURL, platform = installableURL(build), buildPlatform(build)
+					break
+				}
+			}
+		}
+	}
+	mutex.Unlock()
+
+	if downloadURL == "" {
+		respondText(c, http.StatusNotFound, "构建版本未找到")
+		return
+	}
+
+	cookieName := installCookieName(fileName)
+	if _, err := c.Cookie(cookieName); err != nil || config.InstallDedupWindow <= 0 {
+		stats.recordInstall(fileName)
+		if config.InstallDedupWindow > 0 {
+			c.SetCookie(cookieName, "1", int(config.InstallDedupWindow.Seconds()), "/", "", false, true)
+		}
+	}
+	if platform == platformIOS {
+		c.Redirect(http.StatusFound, itmsServicesURL(requestBaseURL(c), packageName, fileName))
+		return
+	}
+	c.Redirect(http.StatusFound, withBasePath(downloadURL))
+}
+
+// buildStatsEntry is one row of the stats API response
+type buildStatsEntry struct {
+	FileName string `json:"fileName"`
+	Version  string `json:"version"`
+	Channel  string `json:"channel"`
+	BuildStats
+}
+
+// channelStats sums the counters of the builds of one channel
+type channelStats struct {
+	Channel   string `json:"channel"`
+	Downloads int64  `json:"downloads"`
+	Installs  int64  `json:"installs"`
+}
+
+// dailyStats is the download count of the app on one day
+type dailyStats struct {
+	Date      string `json:"date"`
+	Downloads int64  `json:"downloads"`
+}
+
+// handleAppStats serves GET /api/stats/:packageName: the counters of every
+// build, summed per channel and per day over the last ?days= days. A build
+// promoted to several channels shares one file, so it counts toward each of
+// them, while the totals count every file once.
+func handleAppStats(c *gin.Context) {
+	packageName := c.Param("packageName")
+	days := 30
+	if value := c.Query("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 366 {
+			respondError(c, http.StatusBadRequest, "days 参数必须是 1 到 366 之间的整数")
+			return
+		}
+		days = n
+	}
+
+	_, builds, found := buildsOfPackage(packageName)
+	if !found {
+		respondError(c, http.StatusNotFound, "应用未找到")
+		return
+	}
+
+	var totalDownloads, totalInstalls int64
+	entries := []buildStatsEntry{}
+	channels := []channelStats{}
+	channelIndex := make(map[string]int)
+	perDay := make(map[string]int64)
+	seen := make(map[string]bool)
+	for _, build := range builds {
+		counters := stats.get(build.FileName)
+		entries = append(entries, buildStatsEntry{
+			FileName:   build.FileName,
+			Version:    build.Version,
+			Channel:    build.Channel,
+			BuildStats: counters,
+		})
+
+		i, ok := channelIndex[build.Channel]
+		if !ok {
+			i = len(channels)
+			channelIndex[build.Channel] = i
+			channels = append(channels, channelStats{Channel: build.Channel})
+		}
+		channels[i].Downloads += counters.Downloads
+		channels[i].Installs += counters.Installs
+
+		// Promoted entries share a file, so count each file once in the totals
+		if seen[build.FileName] {
+			continue
+		}
+		seen[build.FileName] = true
+		totalDownloads += counters.Downloads
+		totalInstalls += counters.Installs
+		for day, n := range counters.Daily {
+			perDay[day] += n
+		}
+	}
+
+	daily := make([]dailyStats, 0, days)
+	today := time.Now().In(displayLocation(""))
+	for i := days - 1; i >= 0; i-- {
+		day := today.AddDate(0, 0, -i).Format(statsDayLayout)
+		daily = append(daily, dailyStats{Date: day, Downloads: perDay[day]})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"packageName":    packageName,
+		"totalDownloads": totalDownloads,
+		"totalInstalls":  totalInstalls,
+		"builds":         entries,
+		"channels":       channels,
+		"daily":          daily,
+	})
+}
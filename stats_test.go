@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDownloadStats(t *testing.T) {
+	router := setupTestServer(t)
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	build := appBuilds(t, router, fixturePackage)[0]
+
+	download := func(method, rangeHeader string) {
+		req := httptest.NewRequest(method, build.DownloadURL, nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK && rec.Code != http.StatusPartialContent {
+			t.Fatalf("%s %s: status %d", method, rangeHeader, rec.Code)
+		}
+	}
+	download(http.MethodGet, "")
+	download(http.MethodGet, "bytes=0-99")
+	// Neither a HEAD request nor a resumed download counts
+	download(http.MethodHead, "")
+	download(http.MethodGet, "bytes=100-")
+	if rec := serve(router, http.MethodGet, build.DownloadURL+checksumSuffix); rec.Code != http.StatusOK {
+		t.Fatalf("checksum: status %d", rec.Code)
+	}
+
+	var result struct {
+		TotalDownloads int64             `json:"totalDownloads"`
+		Builds         []buildStatsEntry `json:"builds"`
+		Channels       []channelStats    `json:"channels"`
+		Daily          []dailyStats      `json:"daily"`
+	}
+	rec := serve(router, http.MethodGet, "/api/stats/"+fixturePackage+"?days=7")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("stats: status %d: %s", rec.Code, rec.Body.String())
+	}
+	decodeJSON(t, rec, &result)
+	if result.TotalDownloads != 2 || len(result.Builds) != 1 || result.Builds[0].Downloads != 2 {
+		t.Errorf("downloads = %+v", result)
+	}
+	if len(result.Channels) != 1 || result.Channels[0].Channel != "stable" || result.Channels[0].Downloads != 2 {
+		t.Errorf("channels = %+v", result.Channels)
+	}
+	if len(result.Daily) != 7 || result.Daily[6].Downloads != 2 {
+		t.Errorf("daily = %+v", result.Daily)
+	}
+
+	if rec := serve(router, http.MethodGet, "/api/stats/"+fixturePackage+"?days=0"); rec.Code != http.StatusBadRequest {
+		t.Errorf("days=0: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Values of Config.Storage
+const (
+	storageLocal = "local"
+	storageS3    = "s3"
+)
+
+// Storage keeps the build packages, addressed by their file name. Icons,
+// expansion files and the metadata always stay on local disk.
+type Storage interface {
+	// Put stores size bytes read from r under name. It fails with an error
+	// wrapping os.ErrExist when name is taken, so callers reserve unique
+	// names by trying.
+	Put(name string, r io.Reader, size int64) error
+	// Get opens a stored file; the error wraps os.ErrNotExist when it is
+	// missing.
+	Get(name string) (io.ReadCloser, error)
+	// Stat returns the size of a stored file, like Get for missing files
+	Stat(name string) (int64, error)
+	// Delete removes a stored file; deleting a missing file is no error
+	Delete(name string) error
+	// URL returns where clients download name directly, or "" when this
+	// server serves the file itself. A private URL must expire, so it cannot
+	// be passed around in place of a signed link.
+	URL(name string, private bool) string
+}
+
+// storage holds the build packages; main replaces it according to the
+// configuration
+var storage Storage = &localStorage{dir: config.UploadDir}
+
+// newStorage returns the backend selected by cfg.Storage
+func newStorage(cfg Config) (Storage, error) {
+	switch cfg.Storage {
+	case storageS3:
+		return newS3Storage(cfg)
+	default:
+		if err := os.MkdirAll(cfg.UploadDir, 0755); err != nil {
+			return nil, err
+		}
+		return &localStorage{dir: cfg.UploadDir}, nil
+	}
+}
+
+// localStorage keeps packages as files in a directory served by this server
+type localStorage struct {
+	dir string
+}
+
+func (s *localStorage) path(name string) string {
+	return filepath.Join(s.dir, filepath.Base(name))
+}
+
+func (s *localStorage) Put(name string, r io.Reader, size int64) error {
+	path := s.path(name)
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, r)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+func (s *localStorage) Get(name string) (io.ReadCloser, error) {
+	return os.Open(s.path(name))
+}
+
+func (s *localStorage) Stat(name string) (int64, error) {
+	info, err := os.Stat(s.path(name))
+	if err != nil {
+		return 0, err
+	}
+	if info.IsDir() {
+		return 0, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	return info.Size(), nil
+}
+
+func (s *localStorage) Delete(name string) error {
+	if err := os.Remove(s.path(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *localStorage) URL(name string, private bool) string {
+	return ""
+}
+
+// localStoragePath returns the path of a stored package when packages are
+// kept on local disk, so callers can use the file directly instead of
+// copying it through Get
+func localStoragePath(name string) (string, bool) {
+	if local, ok := storage.(*localStorage); ok {
+		return local.path(name), true
+	}
+	return "", false
+}
+
+// localCopy returns the path of a local copy of a stored package and a
+// function that releases it. Remote packages are downloaded into the
+// incoming directory until release is called.
+func localCopy(name string) (string, func(), error) {
+	if path, ok := localStoragePath(name); ok {
+		if _, err := os.Stat(path); err != nil {
+			return "", nil, err
+		}
+		return path, func() {}, nil
+	}
+
+	src, err := storage.Get(name)
+	if err != nil {
+		return "", nil, err
+	}
+	defer src.Close()
+	dst, err := createIncomingFile(name)
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", nil, err
+	}
+	return dst.Name(), func() { os.Remove(dst.Name()) }, nil
+}
+
+// hashStored returns the hex-encoded SHA-256 of a stored package
+func hashStored(name string) (string, error) {
+	if path, ok := localStoragePath(name); ok {
+		return hashFile(path)
+	}
+	r, err := storage.Get(name)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	return hashReader(r)
+}
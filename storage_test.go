@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 keeps objects in memory and answers the requests s3Storage sends
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test-key/") &&
+		r.URL.Query().Get("X-Amz-Signature") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[r.URL.Path]
+	switch r.Method {
+	case http.MethodPut:
+		if ok && r.Header.Get("If-None-Match") == "*" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Path] = body
+	case http.MethodGet, http.MethodHead:
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestS3Storage(t *testing.T) {
+	router := setupTestServer(t)
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	config.Storage = storageS3
+	config.S3Endpoint = server.URL
+	config.S3Bucket = "builds"
+	config.S3Prefix = "apk/"
+	config.S3AccessKey = "test-key"
+	config.S3SecretKey = "test-secret"
+	s3, err := newStorage(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage = s3
+	t.Cleanup(func() { storage = &localStorage{dir: "uploads"} })
+
+	apk := fixtureAPK(t)
+	if rec := uploadFixture(router, apk, "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	build := appBuilds(t, router, fixturePackage)[0]
+	key := "/builds/apk/" + build.FileName
+	if got := fake.objects[key]; string(got) != string(apk) {
+		t.Fatalf("object %s holds %d bytes, want the %d byte APK", key, len(got), len(apk))
+	}
+	if err := storage.Put(build.FileName, strings.NewReader("x"), 1); err == nil {
+		t.Error("Put over an existing object succeeded")
+	}
+
+	// Downloads are redirected to a presigned URL the fake accepts
+	rec := serve(router, http.MethodGet, build.DownloadURL)
+	location := rec.Header().Get("Location")
+	if rec.Code != http.StatusFound || !strings.HasPrefix(location, server.URL+key+"?") {
+		t.Fatalf("download: status %d, Location %q", rec.Code, location)
+	}
+	if query, _ := url.ParseQuery(strings.SplitN(location, "?", 2)[1]); query.Get("X-Amz-Expires") != "3600" {
+		t.Errorf("presigned URL expiry = %q, want 3600", query.Get("X-Amz-Expires"))
+	}
+	if rec.Header().Get("X-Checksum-SHA256") != build.FileHash {
+		t.Errorf("X-Checksum-SHA256 = %q, want %q", rec.Header().Get("X-Checksum-SHA256"), build.FileHash)
+	}
+	if rec := serve(router, http.MethodGet, "/downloads/missing.apk"); rec.Code != http.StatusNotFound {
+		t.Errorf("missing download: status %d, want 404", rec.Code)
+	}
+
+	rec = serveAdmin(router, http.MethodDelete, "/api/builds/"+fixturePackage+"/"+build.FileName)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, ok := fake.objects[key]; ok {
+		t.Error("object still stored after the build was deleted")
+	}
+}
+
+func TestS3PrivateDownloadsArePresigned(t *testing.T) {
+	router := setupTestServer(t)
+	server := httptest.NewServer(&fakeS3{objects: map[string][]byte{}})
+	defer server.Close()
+
+	config.Storage = storageS3
+	config.S3Endpoint = server.URL
+	config.S3Bucket = "builds"
+	config.S3AccessKey = "test-key"
+	config.S3SecretKey = "test-secret"
+	config.S3PublicURL = "https://cdn.example.com/builds"
+	s3, err := newStorage(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage = s3
+	t.Cleanup(func() { storage = &localStorage{dir: "uploads"} })
+
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	build := appBuilds(t, router, fixturePackage)[0]
+	if location := serve(router, http.MethodGet, build.DownloadURL).Header().Get("Location"); location != config.S3PublicURL+"/"+build.FileName {
+		t.Errorf("public app: Location %q", location)
+	}
+
+	if _, _, err := repo.UpdateApp(fixturePackage, func(app *AppEntry) error { app.Private = true; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(router, http.MethodGet, build.DownloadURL); rec.Code != http.StatusForbidden {
+		t.Errorf("unsigned private download: status %d, want 403", rec.Code)
+	}
+	rec := serve(router, http.MethodGet, signPath(build.DownloadURL, time.Now().Add(time.Hour)))
+	location := rec.Header().Get("Location")
+	if rec.Code != http.StatusFound || !strings.HasPrefix(location, server.URL+"/builds/"+build.FileName+"?") ||
+		!strings.Contains(location, "X-Amz-Expires=3600") {
+		t.Errorf("signed private download: status %d, Location %q", rec.Code, location)
+	}
+
+	// Members-only projects are presigned too
+	if _, _, err := repo.UpdateApp(fixturePackage, func(app *AppEntry) error { app.Private = false; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	config.MultiTenant = true
+	location = serveAdmin(router, http.MethodGet, build.DownloadURL).Header().Get("Location")
+	if !strings.HasPrefix(location, server.URL+"/builds/") {
+		t.Errorf("multi-tenant download: Location %q", location)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limits on build tags, such as "hotfix" or "qa-approved"
+const (
+	maxBuildTags = 20
+	maxTagLen    = 32
+)
+
+// errTooManyTags rejects an edit that leaves a build with too many tags
+var errTooManyTags = fmt.Errorf("每个构建最多 %d 个标签", maxBuildTags)
+
+// normalizeTag trims a tag and lower-cases it, so "QA-Approved" and
+// "qa-approved" are the same tag
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// tagError describes what is wrong with a normalized tag, or returns ""
+func tagError(tag string) string {
+	switch {
+	case tag == "":
+		return "标签不能为空"
+	case utf8.RuneCountInString(tag) > maxTagLen:
+		return fmt.Sprintf("标签 %s 过长，上限为 %d 个字符", tag, maxTagLen)
+	case strings.IndexFunc(tag, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_'
+	}) >= 0:
+		return fmt.Sprintf("标签 %s 只能包含字母、数字、- 和 _", tag)
+	}
+	return ""
+}
+
+// editTags returns tags with add added and remove removed, sorted and
+// without duplicates
+func editTags(tags, add, remove []string) []string {
+	edited := make([]string, 0, len(tags)+len(add))
+	for _, tag := range append(slices.Clone(tags), add...) {
+		if !slices.Contains(remove, tag) {
+			edited = append(edited, tag)
+		}
+	}
+	slices.Sort(edited)
+	edited = slices.Compact(edited)
+	if len(edited) == 0 {
+		return nil
+	}
+	return edited
+}
+
+// tagsRequest is the JSON body accepted by handleEditTags
+type tagsRequest struct {
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+// handleEditTags adds and removes free-form tags of a build with
+// {"add": ["qa-approved"], "remove": ["hotfix"]}. Tags are orthogonal to
+// channels: channel picks one entry of a promoted build, without it every
+// entry of the file is tagged.
+func handleEditTags(c *gin.Context) {
+	if !checkAdmin(c) {
+		return
+	}
+	packageName := c.Param("packageName")
+	fileName := c.Param("fileName")
+	channel := c.Query("channel")
+
+	var req tagsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "请求体格式错误: "+err.Error())
+		return
+	}
+	if len(req.Add) == 0 && len(req.Remove) == 0 {
+		respondError(c, http.StatusBadRequest, "请求体需要 add 或 remove 字段")
+		return
+	}
+	var errs []FieldError
+	for _, list := range [][]string{req.Add, req.Remove} {
+		for i, tag := range list {
+			list[i] = normalizeTag(tag)
+			if message := tagError(list[i]); message != "" {
+				errs = append(errs, FieldError{"tags", message})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		body := errorBody(c, "标签校验失败")
+		body["fields"] = errs
+		c.JSON(http.StatusBadRequest, body)
+		return
+	}
+
+	projectName, edited, err := updateBuilds(packageName, fileName, channel, func(build *BuildInfo) error {
+		build.Tags = editTags(build.Tags, req.Add, req.Remove)
+		if len(build.Tags) > maxBuildTags {
+			return errTooManyTags
+		}
+		return nil
+	})
+	if errors.Is(err, errTooManyTags) {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondRepositoryError(c, err)
+		return
+	}
+	logf(c, "构建 %s 的标签已更新: %v", fileName, edited[0].Tags)
+	audit.record(c, AuditEntry{Action: auditEditTags, ProjectName: projectName, PackageName: packageName, FileName: fileName, Channel: channel,
+		Detail: fmt.Sprintf("add %v, remove %v", req.Add, req.Remove)})
+
+	c.Header("ETag", catalogVersionETag())
+	c.JSON(http.StatusOK, gin.H{"message": "标签已更新", "builds": withNotesHTML(edited)})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEditTags(t *testing.T) {
+	router := setupTestServer(t)
+	for _, build := range []BuildInfo{testBuild("a.apk", "beta"), testBuild("a.apk", "stable"), testBuild("b.apk", "beta")} {
+		if err := repo.UpsertBuild("Demo", testApp("com.example.tags"), build); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	edit := func(target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(adminPasswordHeader, testAdminPassword)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	tagsOf := func(fileName, channel string) []string {
+		for _, build := range appBuilds(t, router, "com.example.tags") {
+			if build.FileName == fileName && build.Channel == channel {
+				return build.Tags
+			}
+		}
+		t.Fatalf("no %s entry of %s", channel, fileName)
+		return nil
+	}
+
+	// Tags are trimmed, lower-cased, sorted and deduplicated on every entry
+	if rec := edit("/api/builds/com.example.tags/a.apk/tags", `{"add": [" QA-Approved ", "hotfix", "qa-approved"]}`); rec.Code != http.StatusOK {
+		t.Fatalf("add: status %d: %s", rec.Code, rec.Body.String())
+	}
+	for _, channel := range []string{"beta", "stable"} {
+		if got := tagsOf("a.apk", channel); !slices.Equal(got, []string{"hotfix", "qa-approved"}) {
+			t.Errorf("%s tags = %v", channel, got)
+		}
+	}
+	if rec := edit("/api/builds/com.example.tags/a.apk/tags?channel=stable", `{"remove": ["HOTFIX"]}`); rec.Code != http.StatusOK {
+		t.Fatalf("remove: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := tagsOf("a.apk", "stable"); !slices.Equal(got, []string{"qa-approved"}) {
+		t.Errorf("stable tags after remove = %v", got)
+	}
+	if got := tagsOf("a.apk", "beta"); !slices.Equal(got, []string{"hotfix", "qa-approved"}) {
+		t.Errorf("beta tags after removing from stable = %v", got)
+	}
+
+	tooMany := make([]string, maxBuildTags+1)
+	for i := range tooMany {
+		tooMany[i] = `"t` + strings.Repeat("x", i) + `"`
+	}
+	for body, want := range map[string]int{
+		`{}`:               http.StatusBadRequest,
+		`{"add": ["a b"]}`: http.StatusBadRequest,
+		`{"add": [""]}`:    http.StatusBadRequest,
+		`{"add": ["` + strings.Repeat("x", maxTagLen+1) + `"]}`: http.StatusBadRequest,
+		`{"add": [` + strings.Join(tooMany, ",") + `]}`:         http.StatusBadRequest,
+	} {
+		if rec := edit("/api/builds/com.example.tags/b.apk/tags", body); rec.Code != want {
+			t.Errorf("%.40s: status %d, want %d", body, rec.Code, want)
+		}
+	}
+	if got := tagsOf("b.apk", "beta"); got != nil {
+		t.Errorf("rejected edits left tags %v", got)
+	}
+	if rec := edit("/api/builds/com.example.tags/missing.apk/tags", `{"add": ["qa"]}`); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown build: status %d, want 404", rec.Code)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/builds/com.example.tags/b.apk/tags", strings.NewReader(`{"add": ["qa"]}`)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("without the admin password: status %d, want 401", rec.Code)
+	}
+}
+
+func TestFilterBuildsByTag(t *testing.T) {
+	router := setupTestServer(t)
+	for _, build := range []BuildInfo{testBuild("a.apk", "beta"), testBuild("b.apk", "beta"), testBuild("c.apk", "stable")} {
+		switch build.FileName {
+		case "a.apk":
+			build.Tags = []string{"hotfix", "qa-approved"}
+		case "b.apk", "c.apk":
+			build.Tags = []string{"qa-approved"}
+		}
+		if err := repo.UpsertBuild("Demo", testApp("com.example.tags"), build); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for query, want := range map[string]string{
+		"":                                  "c.apk b.apk a.apk",
+		"?tag=qa-approved":                  "c.apk b.apk a.apk",
+		"?tag=QA-Approved&tag=hotfix":       "a.apk",
+		"?tag=qa-approved&channel=beta":     "b.apk a.apk",
+		"?tag=missing":                      "",
+		"?tag=hotfix&tag=qa-approved&tag=x": "",
+	} {
+		var result struct {
+			Builds []BuildInfo `json:"builds"`
+		}
+		decodeJSON(t, serve(router, http.MethodGet, "/api/apps/com.example.tags/builds"+query), &result)
+		var names []string
+		for _, build := range result.Builds {
+			names = append(names, build.FileName)
+		}
+		if got := strings.Join(names, " "); got != want {
+			t.Errorf("%q: builds %q, want %q", query, got, want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"time"
+	_ "time/tzdata" // time zone names work without the system database
+
+	"github.com/gin-gonic/gin"
+)
+
+// Timestamps such as BuildInfo.UploadTime are stored in UTC as RFC 3339, which
+// also makes them sort chronologically as strings. They are only converted to
+// a time zone when rendered for people.
+
+// legacyTimeLayout is the format timestamps were stored in before they moved
+// to UTC, in server local time
+const legacyTimeLayout = "2006-01-02 15:04:05"
+
+// displayTimeLayout is how formatTime renders a timestamp
+const displayTimeLayout = "2006-01-02 15:04:05 (UTC-07:00)"
+
+// timestamp formats t for storage
+func timestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// parseTimestamp reads a stored timestamp, accepting the legacy local layout
+func parseTimestamp(ts string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, ts); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(legacyTimeLayout, ts, time.Local)
+}
+
+// normalizeUploadTimes rewrites legacy upload times in allProjects as UTC
+// RFC 3339 and reports whether anything changed. The caller must hold the mutex.
+func normalizeUploadTimes() bool {
+	changed := false
+	for i := range allProjects {
+		for j := range allProjects[i].Apps {
+			builds := allProjects[i].Apps[j].Builds
+			for k := range builds {
+				t, err := parseTimestamp(builds[k].UploadTime)
+				if err != nil {
+					continue
+				}
+				if normalized := timestamp(t); normalized != builds[k].UploadTime {
+					builds[k].UploadTime = normalized
+					changed = true
+				}
+			}
+		}
+	}
+	return changed
+}
+
+// displayLocation resolves a time zone name such as "Asia/Shanghai", falling
+// back to config.DisplayTimezone when it is empty or unknown
+func displayLocation(tz string) *time.Location {
+	if tz != "" {
+		if loc, err := time.LoadLocation(tz); err == nil {
+			return loc
+		}
+	}
+	return config.displayLocation
+}
+
+// formatTime renders a stored timestamp in the time zone tz ("" for the
+// configured display zone). Unparsable values are returned unchanged.
+func formatTime(ts, tz string) string {
+	t, err := parseTimestamp(ts)
+	if err != nil {
+		return ts
+	}
+	return t.In(displayLocation(tz)).Format(displayTimeLayout)
+}
+
+// unixTime returns a stored timestamp as seconds since the Unix epoch, or 0
+func unixTime(ts string) int64 {
+	t, err := parseTimestamp(ts)
+	if err != nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+// viewerTimezone returns the time zone a page should be rendered in for this
+// request: the tz query parameter, then the tz cookie, else "" for the default
+func viewerTimezone(c *gin.Context) string {
+	if tz := c.Query("tz"); tz != "" {
+		return tz
+	}
+	tz, _ := c.Cookie("tz")
+	return tz
+}
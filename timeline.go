@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TimelinePoint is one build on an app's release timeline
+type TimelinePoint struct {
+	Version        string `json:"version"`
+	VersionCode    int32  `json:"versionCode,omitempty"`
+	Channel        string `json:"channel"`
+	UploadTime     string `json:"uploadTime"`     // RFC 3339, UTC
+	UploadTimeUnix int64  `json:"uploadTimeUnix"` // seconds, for chart axes
+	Size           int64  `json:"size"`
+	PromotedFrom   string `json:"promotedFrom,omitempty"`
+}
+
+// Timeline is the response of GET /api/apps/:packageName/timeline
+type Timeline struct {
+	PackageName string                     `json:"packageName"`
+	AppName     string                     `json:"appName"`
+	Count       int                        `json:"count"`
+	Builds      []TimelinePoint            `json:"builds"`   // oldest first
+	Channels    map[string][]TimelinePoint `json:"channels"` // the same points per channel
+}
+
+// buildTimeline orders builds chronologically and groups them by channel.
+// Builds whose upload time cannot be parsed are left out.
+func buildTimeline(packageName, appName string, builds []BuildInfo) Timeline {
+	timeline := Timeline{
+		PackageName: packageName,
+		AppName:     appName,
+		Builds:      []TimelinePoint{},
+		Channels:    map[string][]TimelinePoint{},
+	}
+	// The catalog lists builds newest first; walking it backwards keeps
+	// uploads within the same second in upload order after the stable sort
+	for i := len(builds) - 1; i >= 0; i-- {
+		build := builds[i]
+		uploadedAt, err := parseTimestamp(build.UploadTime)
+		if err != nil {
+			continue
+		}
+		timeline.Builds = append(timeline.Builds, TimelinePoint{
+			Version:        build.Version,
+			VersionCode:    build.VersionCode,
+			Channel:        build.Channel,
+			UploadTime:     timestamp(uploadedAt),
+			UploadTimeUnix: uploadedAt.Unix(),
+			Size:           build.FileSize,
+			PromotedFrom:   build.PromotedFrom,
+		})
+	}
+	sort.SliceStable(timeline.Builds, func(i, j int) bool {
+		return timeline.Builds[i].UploadTimeUnix < timeline.Builds[j].UploadTimeUnix
+	})
+	for _, point := range timeline.Builds {
+		timeline.Channels[point.Channel] = append(timeline.Channels[point.Channel], point)
+	}
+	timeline.Count = len(timeline.Builds)
+	return timeline
+}
+
+// handleAppTimeline serves GET /api/apps/:packageName/timeline with every
+// build of the app in upload order, for release cadence and size charts.
+func handleAppTimeline(c *gin.Context) {
+	packageName := c.Param("packageName")
+	appName, builds, found := buildsOfPackage(packageName)
+	if !found {
+		respondError(c, http.StatusNotFound, "应用未找到")
+		return
+	}
+	c.JSON(http.StatusOK, buildTimeline(packageName, appName, builds))
+}
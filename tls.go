@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// serverTLS returns the TLS configuration of the server, nil when it serves
+// plain HTTP, and the handler of the HTTP redirect port. Let's Encrypt
+// validates domains with the TLS-ALPN-01 challenge on port 443, or with
+// HTTP-01 through the redirect port when that is port 80.
+func serverTLS() (*tls.Config, http.Handler, error) {
+	redirect := http.HandlerFunc(redirectToHTTPS)
+	switch {
+	case len(config.AutocertDomains) > 0:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(config.AutocertDomains...),
+			Cache:      autocert.DirCache(config.AutocertCacheDir),
+			Email:      config.AutocertEmail,
+		}
+		return manager.TLSConfig(), manager.HTTPHandler(redirect), nil
+	case config.TLSCert != "":
+		certs := &certReloader{certFile: config.TLSCert, keyFile: config.TLSKey}
+		if _, err := certs.getCertificate(nil); err != nil {
+			return nil, nil, err
+		}
+		return &tls.Config{
+			GetCertificate: certs.getCertificate,
+			NextProtos:     []string{"h2", "http/1.1"},
+		}, redirect, nil
+	default:
+		return nil, nil, nil
+	}
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL over HTTPS
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if config.Port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(config.Port))
+	}
+	status := http.StatusMovedPermanently
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		// Keep the method and body of uploads and other writes
+		status = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+}
+
+// certReloader serves the certificate in certFile and keyFile, loading it
+// again once either file changed, so renewals such as certbot's take
+// effect without a restart. A failed reload keeps the previous certificate.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	modified, err := r.lastModified()
+	if err == nil && r.cert != nil && modified.Equal(r.modified) {
+		return r.cert, nil
+	}
+	cert, loadErr := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err == nil {
+		err = loadErr
+	}
+	if err != nil {
+		if r.cert == nil {
+			return nil, fmt.Errorf("加载 TLS 证书失败: %w", err)
+		}
+		slog.Warn("重新加载 TLS 证书失败，继续使用原证书", "error", err)
+		r.modified = modified
+		return r.cert, nil
+	}
+	if r.cert != nil {
+		slog.Info("已重新加载 TLS 证书", "path", r.certFile)
+	}
+	r.cert, r.modified = &cert, modified
+	return r.cert, nil
+}
+
+// lastModified returns the later modification time of the two files
+func (r *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
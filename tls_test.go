@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 with serial
+// to certFile and keyFile
+func writeTestCert(t *testing.T, certFile, keyFile string, serial int64) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	router := setupTestServer(t)
+	dir := t.TempDir()
+	config.TLSCert, config.TLSKey = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, config.TLSCert, config.TLSKey, 1)
+
+	tlsConfig, _, err := serverTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serveUntil(ctx, &http.Server{Handler: router, TLSConfig: tlsConfig}, listener) }()
+	defer func() {
+		cancel()
+		if err := <-served; err != nil {
+			t.Errorf("serveUntil: %v", err)
+		}
+	}()
+
+	// serial fetches the manifest over a new connection and returns the
+	// serial number of the certificate it was served with
+	serial := func() int64 {
+		t.Helper()
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+		defer client.CloseIdleConnections()
+		resp, err := client.Get("https://" + listener.Addr().String() + "/api/manifest.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status %d", resp.StatusCode)
+		}
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}
+	if got := serial(); got != 1 {
+		t.Errorf("serial = %d, want 1", got)
+	}
+
+	// A renewed certificate is picked up without a restart
+	writeTestCert(t, config.TLSCert, config.TLSKey, 2)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(config.TLSCert, later, later)
+	if got := serial(); got != 2 {
+		t.Errorf("serial after renewal = %d, want 2", got)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	config = defaultConfig()
+	for _, tc := range []struct {
+		method, target string
+		port           int
+		want           string
+		status         int
+	}{
+		{http.MethodGet, "http://apps.example.com/app/com.example?tab=1", 443, "https://apps.example.com/app/com.example?tab=1", http.StatusMovedPermanently},
+		{http.MethodGet, "http://apps.example.com:8080/", 8443, "https://apps.example.com:8443/", http.StatusMovedPermanently},
+		{http.MethodPost, "http://apps.example.com/api/upload", 443, "https://apps.example.com/api/upload", http.StatusPermanentRedirect},
+	} {
+		config.Port = tc.port
+		rec := httptest.NewRecorder()
+		redirectToHTTPS(rec, httptest.NewRequest(tc.method, tc.target, nil))
+		if rec.Code != tc.status || rec.Header().Get("Location") != tc.want {
+			t.Errorf("%s %s: status %d, Location %q", tc.method, tc.target, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
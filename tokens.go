@@ -0,0 +1,284 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiTokenPrefix starts every API token, so leaked tokens are easy to spot
+// in logs and by secret scanners
+const apiTokenPrefix = "apd_"
+
+// apiTokenKey is the context key requireUploadToken stores the ID of the
+// presented token under, "" when tokens are not required
+const apiTokenKey = "apiTokenID"
+
+// apiTokenProjectKey is the context key requireUploadToken stores the
+// project the presented token is restricted to under
+const apiTokenProjectKey = "apiTokenProject"
+
+// apiTokenNameKey is the context key requireUploadToken stores the name
+// of the presented token under
+const apiTokenNameKey = "apiTokenName"
+
+// apiTokenMaxName bounds the descriptive name of a token
+const apiTokenMaxName = 100
+
+// APIToken is a token CI servers present to the upload API. Only the
+// SHA-256 of the secret is stored; the secret is shown once on creation.
+type APIToken struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Project restricts uploads with the token to one project; empty allows
+	// every project
+	Project    string `json:"project,omitempty"`
+	Hash       string `json:"hash,omitempty"`
+	CreatedAt  string `json:"createdAt"`
+	LastUsedAt string `json:"lastUsedAt,omitempty"`
+}
+
+// tokenStore persists the API tokens in a JSON file next to the metadata,
+// with its own lock like statsStore
+type tokenStore struct {
+	mu     sync.Mutex
+	path   string
+	Tokens []APIToken `json:"tokens"`
+}
+
+var tokens = &tokenStore{}
+
+// load reads the token file at path; a missing file starts empty.
+func (s *tokenStore) load(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	s.Tokens = []APIToken{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, s)
+}
+
+// save writes the tokens to disk, readable by the owner only. The caller
+// must hold s.mu.
+func (s *tokenStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	if err := writeFileSync(tmpPath, data); err != nil {
+		return err
+	}
+	os.Chmod(tmpPath, 0600)
+	return os.Rename(tmpPath, s.path)
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// create adds a token named name for project, empty for every project, and
+// returns it with its secret
+func (s *tokenStore) create(name, project string) (APIToken, string, error) {
+	// The ID is drawn separately, so listing tokens reveals nothing of a secret
+	random := make([]byte, 32+6)
+	if _, err := rand.Read(random); err != nil {
+		return APIToken{}, "", err
+	}
+	secret := apiTokenPrefix + hex.EncodeToString(random[:32])
+	token := APIToken{
+		ID:        hex.EncodeToString(random[32:]),
+		Name:      name,
+		Project:   project,
+		Hash:      hashToken(secret),
+		CreatedAt: timestamp(time.Now()),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Tokens = append(s.Tokens, token)
+	if err := s.save(); err != nil {
+		s.Tokens = s.Tokens[:len(s.Tokens)-1]
+		return APIToken{}, "", err
+	}
+	return token, secret, nil
+}
+
+// revoke removes the token with id, reporting whether it existed
+func (s *tokenStore) revoke(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, token := range s.Tokens {
+		if token.ID != id {
+			continue
+		}
+		previous := s.Tokens
+		s.Tokens = append(append([]APIToken{}, s.Tokens[:i]...), s.Tokens[i+1:]...)
+		if err := s.save(); err != nil {
+			s.Tokens = previous
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// list returns the tokens without their hashes
+func (s *tokenStore) list() []APIToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]APIToken, len(s.Tokens))
+	for i, token := range s.Tokens {
+		token.Hash = ""
+		list[i] = token
+	}
+	return list
+}
+
+// verify looks up the token with secret and records its use. Last-use
+// times are kept to the minute, so a busy CI server does not rewrite the
+// file on every upload.
+func (s *tokenStore) verify(secret string) (APIToken, bool) {
+	hash := hashToken(secret)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.Tokens {
+		token := &s.Tokens[i]
+		if token.Hash != hash {
+			continue
+		}
+		now := timestamp(time.Now().Truncate(time.Minute))
+		if token.LastUsedAt != now {
+			token.LastUsedAt = now
+			if err := s.save(); err != nil {
+				slog.Warn("保存令牌使用时间失败", "error", err)
+			}
+		}
+		return *token, true
+	}
+	return APIToken{}, false
+}
+
+// requestToken returns the token a request presents: the Authorization
+// bearer token, or for the web upload form its "token" field
+func requestToken(c *gin.Context) string {
+	if header := c.GetHeader("Authorization"); header != "" {
+		scheme, token, _ := strings.Cut(header, " ")
+		if strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return c.PostForm("token")
+}
+
+// requireUploadToken rejects upload requests without a valid API token,
+// unless APPDIST_UPLOAD_TOKENS_REQUIRED is off
+func requireUploadToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Logged-in users upload as themselves, see uploadProjectError
+		if !config.UploadTokensRequired || (config.MultiTenant && requestAccess(c).user != "") {
+			c.Next()
+			return
+		}
+		secret := requestToken(c)
+		if secret == "" {
+			c.Header("WWW-Authenticate", `Bearer realm="upload"`)
+			respondText(c, http.StatusUnauthorized, "上传需要 API 令牌，请在 Authorization: Bearer 请求头中提供")
+			c.Abort()
+			return
+		}
+		token, ok := tokens.verify(secret)
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer realm="upload", error="invalid_token"`)
+			respondText(c, http.StatusUnauthorized, "API 令牌无效或已被吊销")
+			c.Abort()
+			return
+		}
+		logf(c, "使用 API 令牌 %s (%s) 上传", token.ID, token.Name)
+		c.Set(apiTokenKey, token.ID)
+		c.Set(apiTokenNameKey, token.Name)
+		c.Set(apiTokenProjectKey, token.Project)
+		c.Next()
+	}
+}
+
+// tokenProjectError returns why the request's API token may not upload to
+// projectName, or "" when it may
+func tokenProjectError(c *gin.Context, projectName string) string {
+	if project := c.GetString(apiTokenProjectKey); project != "" && project != projectName {
+		return fmt.Sprintf("该 API 令牌只能上传到项目 %s", project)
+	}
+	return ""
+}
+
+// handleCreateToken serves POST /api/admin/tokens with {"name": "..."} and
+// an optional "project" the token is restricted to. The response carries
+// the secret, which cannot be retrieved later.
+func handleCreateToken(c *gin.Context) {
+	var req struct {
+		Name    string `json:"name"`
+		Project string `json:"project"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "请求体格式错误，需要 name 字段")
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len([]rune(name)) > apiTokenMaxName {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("令牌名称不能为空且不超过 %d 个字符", apiTokenMaxName))
+		return
+	}
+	token, secret, err := tokens.create(name, strings.TrimSpace(req.Project))
+	if err != nil {
+		warnf(c, "创建 API 令牌失败: %v", err)
+		respondError(c, http.StatusInternalServerError, "创建令牌失败")
+		return
+	}
+	logf(c, "已创建 API 令牌 %s (%s)，项目: %q", token.ID, token.Name, token.Project)
+	audit.record(c, AuditEntry{Action: auditCreateToken, ProjectName: token.Project, Detail: token.ID + " " + token.Name})
+	token.Hash = ""
+	c.JSON(http.StatusCreated, gin.H{"message": "令牌已创建，请妥善保存，之后无法再次查看", "token": secret, "info": token})
+}
+
+func handleListTokens(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens.list()})
+}
+
+// handleRevokeToken serves DELETE /api/admin/tokens/:id
+func handleRevokeToken(c *gin.Context) {
+	id := c.Param("id")
+	found, err := tokens.revoke(id)
+	if err != nil {
+		warnf(c, "吊销 API 令牌 %s 失败: %v", id, err)
+		respondError(c, http.StatusInternalServerError, "吊销令牌失败")
+		return
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, "令牌未找到")
+		return
+	}
+	logf(c, "已吊销 API 令牌 %s", id)
+	audit.record(c, AuditEntry{Action: auditRevokeToken, Detail: id})
+	c.JSON(http.StatusOK, gin.H{"message": "令牌已吊销"})
+}
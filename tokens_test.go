@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadWithToken uploads the fixture APK presenting token as a bearer token
+func uploadWithToken(t *testing.T, router *gin.Engine, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("projectName", "Demo")
+	w.WriteField("channel", "stable")
+	part, _ := w.CreateFormFile("file", "app.apk")
+	part.Write(fixtureAPK(t))
+	w.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/upload", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestUploadTokens(t *testing.T) {
+	router := setupTestServer(t)
+	config.UploadTokensRequired = true
+	tokensPath := filepath.Join(t.TempDir(), "tokens.json")
+	if err := tokens.load(tokensPath); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tokens.load("") })
+
+	if rec := uploadWithToken(t, router, ""); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("upload without token: status %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/tokens", strings.NewReader(`{"name":"CI"}`))
+	req.Header.Set(adminPasswordHeader, testAdminPassword)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create token: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var created struct {
+		Token string   `json:"token"`
+		Info  APIToken `json:"info"`
+	}
+	decodeJSON(t, rec, &created)
+	if !strings.HasPrefix(created.Token, apiTokenPrefix) || created.Info.ID == "" || created.Info.Hash != "" {
+		t.Fatalf("created token = %+v", created)
+	}
+
+	if rec := uploadWithToken(t, router, created.Token+"x"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("upload with a wrong token: status %d, want 401", rec.Code)
+	}
+	if rec := uploadWithToken(t, router, created.Token); rec.Code != http.StatusOK {
+		t.Fatalf("upload with token: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Only the hash is stored, and it survives a reload
+	if err := tokens.load(tokensPath); err != nil {
+		t.Fatal(err)
+	}
+	list := tokens.list()
+	if len(list) != 1 || list[0].LastUsedAt == "" || list[0].Hash != "" {
+		t.Errorf("tokens = %+v", list)
+	}
+	if tokens.Tokens[0].Hash != hashToken(created.Token) {
+		t.Error("stored hash does not match the token")
+	}
+
+	if rec := serveAdmin(router, http.MethodDelete, "/api/admin/tokens/"+created.Info.ID); rec.Code != http.StatusOK {
+		t.Fatalf("revoke: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := uploadWithToken(t, router, created.Token); rec.Code != http.StatusUnauthorized {
+		t.Errorf("upload with a revoked token: status %d, want 401", rec.Code)
+	}
+}
+
+func TestProjectScopedTokens(t *testing.T) {
+	router := setupTestServer(t)
+	config.UploadTokensRequired = true
+	if err := tokens.load(filepath.Join(t.TempDir(), "tokens.json")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tokens.load("") })
+
+	_, other, err := tokens.create("other team", "Other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, demo, err := tokens.create("demo team", "Demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := uploadWithToken(t, router, other); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "Other") {
+		t.Errorf("upload to another project: status %d: %s, want 403", rec.Code, rec.Body.String())
+	}
+	if files, _ := filepath.Glob(filepath.Join(config.IncomingDir, "*")); len(files) != 0 {
+		t.Errorf("rejected upload left %v in the incoming directory", files)
+	}
+	if rec := uploadWithToken(t, router, demo); rec.Code != http.StatusOK {
+		t.Fatalf("upload to the token's project: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/upload/chunked",
+		strings.NewReader(`{"fileName":"app.apk","size":100,"projectName":"Demo","channel":"beta"}`))
+	req.Header.Set("Authorization", "Bearer "+other)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("chunked upload to another project: status %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UpdateCheck is the response of the update check an app makes against its
+// own installed versionCode
+type UpdateCheck struct {
+	UpdateAvailable bool         `json:"updateAvailable"`
+	PackageName     string       `json:"packageName"`
+	AppName         string       `json:"appName"`
+	Latest          LatestUpdate `json:"latest"`
+}
+
+// LatestUpdate describes the newest build an updater would install
+type LatestUpdate struct {
+	Version      string `json:"version"`
+	VersionCode  int32  `json:"versionCode"`
+	Channel      string `json:"channel"`
+	MinSDK       int32  `json:"minSdk,omitempty"`
+	FileName     string `json:"fileName"`
+	FileSize     int64  `json:"fileSize"`
+	SHA256       string `json:"sha256,omitempty"`
+	ReleaseNotes string `json:"releaseNotes"`
+	// ReleaseNotesHTML is ReleaseNotes rendered as sanitized HTML
+	ReleaseNotesHTML string `json:"releaseNotesHtml,omitempty"`
+	UploadTime       string `json:"uploadTime"`
+	DownloadURL      string `json:"downloadURL"` // absolute, ready to fetch
+}
+
+// latestBuild returns the newest build of channel ("" for any) and platform,
+// ordered like previousBuild by versionCode, then upload time. App Bundles
+// without a universal APK cannot be installed by an updater and are skipped.
+func latestBuild(builds []BuildInfo, channel, platform string) (BuildInfo, bool) {
+	var latest BuildInfo
+	found := false
+	for _, build := range builds {
+		if (channel != "" && build.Channel != channel) || buildPlatform(build) != platform ||
+			(build.Format == formatAAB && build.UniversalAPK == "") {
+			continue
+		}
+		if !found || buildBefore(latest, build) {
+			latest, found = build, true
+		}
+	}
+	return latest, found
+}
+
+// handleCheckUpdate serves
+// GET /api/check-update?packageName=&channel=&versionCode=&platform=: the
+// newest build of the channel, and whether it is newer than the installed
+// versionCode. The platform defaults to android, so an app with both APKs
+// and IPAs is only offered builds it can install.
+func handleCheckUpdate(c *gin.Context) {
+	packageName := c.Query("packageName")
+	if packageName == "" {
+		respondError(c, http.StatusBadRequest, "缺少 packageName 参数")
+		return
+	}
+	installed, err := strconv.ParseInt(c.Query("versionCode"), 10, 32)
+	if err != nil || installed < 0 {
+		respondError(c, http.StatusBadRequest, "versionCode 参数必须是非负整数")
+		return
+	}
+	platform := platformName(c.Query("platform"))
+	if platform != platformAndroid && platform != platformIOS && platform != platformHarmony {
+		respondError(c, http.StatusBadRequest, "platform 参数只能是 android、ios 或 harmonyos")
+		return
+	}
+
+	appName, builds, found := buildsOfPackage(packageName)
+	if !found {
+		respondError(c, http.StatusNotFound, "应用未找到")
+		return
+	}
+	channel := c.Query("channel")
+	build, ok := latestBuild(builds, channel, platform)
+	if !ok {
+		respondError(c, http.StatusNotFound, "该渠道没有可用的构建版本")
+		return
+	}
+
+	c.JSON(http.StatusOK, UpdateCheck{
+		UpdateAvailable: int64(build.VersionCode) > installed,
+		PackageName:     packageName,
+		AppName:         appName,
+		Latest: LatestUpdate{
+			Version:          build.Version,
+			VersionCode:      build.VersionCode,
+			Channel:          build.Channel,
+			MinSDK:           build.MinSDK,
+			FileName:         build.FileName,
+			FileSize:         build.FileSize,
+			SHA256:           build.FileHash,
+			ReleaseNotes:     build.ReleaseNotes,
+			ReleaseNotesHTML: notesHTML(build.ReleaseNotes),
+			UploadTime:       build.UploadTime,
+			DownloadURL:      requestBaseURL(c) + installableURL(build),
+		},
+	})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckUpdate(t *testing.T) {
+	router := setupTestServer(t)
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	build := appBuilds(t, router, fixturePackage)[0]
+
+	check := func(query string) (int, UpdateCheck) {
+		rec := serve(router, http.MethodGet, "/api/check-update?packageName="+fixturePackage+query)
+		var result UpdateCheck
+		if rec.Code == http.StatusOK {
+			decodeJSON(t, rec, &result)
+		}
+		return rec.Code, result
+	}
+
+	code, result := check(fmt.Sprintf("&channel=stable&versionCode=%d", build.VersionCode-1))
+	if code != http.StatusOK || !result.UpdateAvailable || result.Latest.FileName != build.FileName ||
+		result.Latest.SHA256 != build.FileHash || !strings.HasSuffix(result.Latest.DownloadURL, build.DownloadURL) ||
+		!strings.HasPrefix(result.Latest.DownloadURL, "http://") {
+		t.Fatalf("older install: status %d, %+v", code, result)
+	}
+	if code, result := check(fmt.Sprintf("&versionCode=%d", build.VersionCode)); code != http.StatusOK || result.UpdateAvailable {
+		t.Errorf("current install: status %d, %+v", code, result)
+	}
+
+	for query, want := range map[string]int{
+		"&channel=beta&versionCode=1": http.StatusNotFound,
+		"&platform=ios&versionCode=1": http.StatusNotFound,
+		"&versionCode=abc":            http.StatusBadRequest,
+		"":                            http.StatusBadRequest,
+	} {
+		if code, _ := check(query); code != want {
+			t.Errorf("%q: status %d, want %d", query, code, want)
+		}
+	}
+}
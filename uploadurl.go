@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleUploadURL serves POST /api/upload-url for CI servers: instead of
+// sending the package, the client names where it lives, e.g. a Jenkins
+// artifact link, and the server downloads it from an allowlisted host and
+// publishes it like a regular upload. It always runs as an upload job, whose
+// progress reports the bytes downloaded so far; the client polls the job
+// for the result.
+func handleUploadURL(c *gin.Context) {
+	req, source, ok := bindFromURLRequest(c)
+	if !ok {
+		return
+	}
+	fileName, ok := fromURLFileName(c, req, source)
+	if !ok {
+		return
+	}
+	upload := uploadRequest{
+		ProjectName:       strings.TrimSpace(req.ProjectName),
+		Channel:           strings.TrimSpace(req.Channel),
+		ReleaseNotes:      req.ReleaseNotes,
+		AllowDowngrade:    req.AllowDowngrade || req.Force,
+		AllowSignerChange: req.AllowSignerChange,
+		Extra:             req.Extra,
+		Platform:          uploadPlatform(fileName),
+	}
+	// publishUpload checks again, but a refused upload need not be downloaded
+	if msg := uploadProjectError(c, upload.ProjectName); msg != "" {
+		respondError(c, http.StatusForbidden, msg)
+		return
+	}
+
+	// Size and hash are only known once the package is downloaded
+	uploadJobs.enqueue(c, fileName, upload, pendingBuild(upload, 0, ""), func(c *gin.Context) (uploadResult, error) {
+		id := c.GetString(jobIDKey)
+		uploadJobs.setStatus(id, jobDownloading)
+		logf(c, "开始从 URL 下载构建: %s", source.Redacted())
+		incomingPath, fileHash, size, err := downloadAPK(c, source, fileName, func(received, total int64) {
+			uploadJobs.setProgress(id, received, total)
+		})
+		if err != nil {
+			return uploadResult{}, downloadFailure(c, source, err)
+		}
+		logf(c, "下载完成: %s, 大小: %d", incomingPath, size)
+
+		uploadJobs.setStatus(id, jobProcessing)
+		return publishUpload(c, incomingPath, fileHash, size, upload)
+	})
+}
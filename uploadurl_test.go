@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUploadURL(t *testing.T) {
+	router := setupTestServer(t)
+	apk := fixtureAPK(t)
+	artifacts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/job/app/lastSuccessfulBuild/artifact/app-release.apk" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(apk)))
+		w.Write(apk)
+	}))
+	defer artifacts.Close()
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/upload-url", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	artifact := artifacts.URL + "/job/app/lastSuccessfulBuild/artifact/app-release.apk"
+	if rec := post(`{"url": "` + artifact + `", "projectName": "Demo", "channel": "ci"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("without allowlist: status %d, want 403", rec.Code)
+	}
+	config.FromURLHosts = []string{"127.0.0.1"}
+	if rec := post(`{"url": "` + artifacts.URL + `/job/app/lastSuccessfulBuild/artifact/", "projectName": "Demo", "channel": "ci"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("no package name: status %d, want 400", rec.Code)
+	}
+
+	accept := func(rec *httptest.ResponseRecorder) UploadJob {
+		t.Helper()
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+		}
+		var accepted struct {
+			StatusURL string `json:"statusURL"`
+		}
+		decodeJSON(t, rec, &accepted)
+		return waitForJob(t, router, accepted.StatusURL)
+	}
+	job := accept(post(`{"url": "` + artifact + `", "projectName": "Demo", "channel": "ci", "releaseNotes": "CI 构建"}`))
+	if job.Status != jobDone || job.HTTPStatus != http.StatusOK || job.FileName != "app-release.apk" ||
+		job.Progress == nil || job.Progress.ReceivedBytes != int64(len(apk)) || job.Progress.TotalBytes != int64(len(apk)) {
+		t.Fatalf("job = %+v, progress %+v", job, job.Progress)
+	}
+	builds := appBuilds(t, router, fixturePackage)
+	if len(builds) != 1 || builds[0].Channel != "ci" || builds[0].ReleaseNotes != "CI 构建" {
+		t.Errorf("builds = %+v", builds)
+	}
+
+	// A failed download fails the job with the status the download got
+	job = accept(post(`{"url": "` + artifacts.URL + `/missing", "fileName": "app.apk", "projectName": "Demo", "channel": "ci"}`))
+	if job.Status != jobFailed || job.HTTPStatus != http.StatusBadGateway {
+		t.Errorf("failed job = %+v", job)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StorageUsage summarizes the files of a scope (catalog, project or app).
+// Files shared by several builds, such as promoted entries, count once.
+type StorageUsage struct {
+	Files         int   `json:"files"`
+	MetadataBytes int64 `json:"metadataBytes"` // sum of BuildInfo.FileSize
+	DiskBytes     int64 `json:"diskBytes"`     // sum of the sizes found on disk
+}
+
+// AppStorage is the usage of one app
+type AppStorage struct {
+	PackageName string `json:"packageName"`
+	AppName     string `json:"appName"`
+	StorageUsage
+}
+
+// ProjectStorage is the usage of one project and its apps
+type ProjectStorage struct {
+	ProjectName string `json:"projectName"`
+	StorageUsage
+	Apps []AppStorage `json:"apps"`
+}
+
+// StorageDiscrepancy reports a file whose size on disk differs from the
+// metadata, or which is missing altogether
+type StorageDiscrepancy struct {
+	ProjectName   string `json:"projectName"`
+	PackageName   string `json:"packageName"`
+	FileName      string `json:"fileName"`
+	MetadataBytes int64  `json:"metadataBytes"`
+	DiskBytes     int64  `json:"diskBytes"`
+	Missing       bool   `json:"missing"`
+}
+
+// StorageReport is the response of the storage usage endpoint
+type StorageReport struct {
+	Total         StorageUsage         `json:"total"`
+	Projects      []ProjectStorage     `json:"projects"`
+	Discrepancies []StorageDiscrepancy `json:"discrepancies"`
+}
+
+// add accounts for one file in u unless it was already counted in seen
+func (u *StorageUsage) add(seen map[string]bool, fileName string, metadataBytes, diskBytes int64) {
+	if seen[fileName] {
+		return
+	}
+	seen[fileName] = true
+	u.Files++
+	u.MetadataBytes += metadataBytes
+	u.DiskBytes += diskBytes
+}
+
+// computeStorageUsage aggregates build sizes per app, project and globally,
+// checking each file against the uploads directory.
+func computeStorageUsage() StorageReport {
+	mutex.Lock()
+	projects := make([]Project, len(allProjects))
+	for i, project := range allProjects {
+		projects[i] = Project{ProjectName: project.ProjectName, Apps: make([]AppEntry, len(project.Apps))}
+		for j, app := range project.Apps {
+			projects[i].Apps[j] = app
+			projects[i].Apps[j].Builds = append([]BuildInfo(nil), app.Builds...)
+		}
+	}
+	mutex.Unlock()
+
+	// Stat every file once, outside the lock
+	diskSizes := make(map[string]int64)
+	missing := make(map[string]bool)
+	for _, project := range projects {
+		for _, app := range project.Apps {
+			for _, build := range app.Builds {
+				if _, done := diskSizes[build.FileName]; done || missing[build.FileName] {
+					continue
+				}
+				size, err := storage.Stat(build.FileName)
+				if err != nil {
+					missing[build.FileName] = true
+					continue
+				}
+				diskSizes[build.FileName] = size
+			}
+		}
+	}
+
+	report := StorageReport{Projects: []ProjectStorage{}, Discrepancies: []StorageDiscrepancy{}}
+	seenTotal := make(map[string]bool)
+	reported := make(map[string]bool)
+	for _, project := range projects {
+		projectUsage := ProjectStorage{ProjectName: project.ProjectName, Apps: []AppStorage{}}
+		seenProject := make(map[string]bool)
+		for _, app := range project.Apps {
+			appUsage := AppStorage{PackageName: app.PackageName, AppName: app.AppName}
+			seenApp := make(map[string]bool)
+			for _, build := range app.Builds {
+				diskBytes := diskSizes[build.FileName]
+				appUsage.add(seenApp, build.FileName, build.FileSize, diskBytes)
+				projectUsage.add(seenProject, build.FileName, build.FileSize, diskBytes)
+				report.Total.add(seenTotal, build.FileName, build.FileSize, diskBytes)
+
+				if reported[build.FileName] || (!missing[build.FileName] && diskBytes == build.FileSize) {
+					continue
+				}
+				reported[build.FileName] = true
+				report.Discrepancies = append(report.Discrepancies, StorageDiscrepancy{
+					ProjectName:   project.ProjectName,
+					PackageName:   app.PackageName,
+					FileName:      build.FileName,
+					MetadataBytes: build.FileSize,
+					DiskBytes:     diskBytes,
+					Missing:       missing[build.FileName],
+				})
+			}
+			projectUsage.Apps = append(projectUsage.Apps, appUsage)
+		}
+		report.Projects = append(report.Projects, projectUsage)
+	}
+	return report
+}
+
+func handleStorageUsage(c *gin.Context) {
+	c.JSON(http.StatusOK, computeStorageUsage())
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Values of Config.DowngradeScope: which builds an upload's versionCode is
+// compared with
+const (
+	downgradeScopePackage = "package" // every build of the app on the platform
+	downgradeScopeChannel = "channel" // the builds of the upload's channel
+)
+
+// compareVersions compares two version names such as "1.10.0" and
+// "v1.9.2-beta" the semantic versioning way: numeric parts as numbers,
+// missing parts as 0, and a pre-release suffix before the release it
+// precedes. Parts that are not numbers compare as text. It returns -1, 0
+// or 1.
+func compareVersions(a, b string) int {
+	coreA, preA := splitVersion(a)
+	coreB, preB := splitVersion(b)
+	if c := compareVersionParts(coreA, coreB); c != 0 {
+		return c
+	}
+	switch {
+	case preA == preB:
+		return 0
+	case preA == "":
+		return 1
+	case preB == "":
+		return -1
+	}
+	return compareVersionParts(strings.Split(preA, "."), strings.Split(preB, "."))
+}
+
+// splitVersion splits a version name into its dotted core and pre-release
+// suffix, dropping a leading "v" and build metadata after "+"
+func splitVersion(version string) ([]string, string) {
+	version = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(version)), "v")
+	version, _, _ = strings.Cut(version, "+")
+	core, pre, _ := strings.Cut(version, "-")
+	return strings.Split(core, "."), pre
+}
+
+// compareVersionParts compares dotted parts one by one
+func compareVersionParts(a, b []string) int {
+	for i := range max(len(a), len(b)) {
+		partA, partB := "0", "0"
+		if i < len(a) {
+			partA = a[i]
+		}
+		if i < len(b) {
+			partB = b[i]
+		}
+		if partA == "" {
+			partA = "0"
+		}
+		if partB == "" {
+			partB = "0"
+		}
+		numA, errA := strconv.ParseUint(partA, 10, 64)
+		numB, errB := strconv.ParseUint(partB, 10, 64)
+		switch {
+		case errA == nil && errB == nil:
+			if numA != numB {
+				if numA < numB {
+					return -1
+				}
+				return 1
+			}
+		// Numeric parts sort before textual ones, as in semver
+		case errA == nil:
+			return -1
+		case errB == nil:
+			return 1
+		default:
+			if c := strings.Compare(partA, partB); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+// downgrades maps the file name of every build whose versionCode is lower
+// than that of a build uploaded before it to the higher versionCode. Builds
+// are compared within their platform; those without a versionCode are
+// skipped. builds are newest first, like AppEntry.Builds.
+func downgrades(builds []BuildInfo) map[string]int32 {
+	result := make(map[string]int32)
+	highest := make(map[string]int32)
+	for i := len(builds) - 1; i >= 0; i-- {
+		build := builds[i]
+		if build.VersionCode == 0 {
+			continue
+		}
+		platform := buildPlatform(build)
+		if build.VersionCode < highest[platform] {
+			result[build.FileName] = highest[platform]
+		}
+		highest[platform] = max(highest[platform], build.VersionCode)
+	}
+	return result
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1.10.0", "1.9.2", 1},
+		{"1.2", "1.2.0", 0},
+		{"v2.0.0", "2.0.0", 0},
+		{"2.0.0-beta", "2.0.0", -1},
+		{"2.0.0-beta.2", "2.0.0-beta.10", -1},
+		{"2.0.0-alpha", "2.0.0-beta", -1},
+		{"2.0.0+build.5", "2.0.0", 0},
+		{"1.0.1", "1.0.0-rc.1", 1},
+		{"", "0.1", -1},
+	}
+	for _, tc := range cases {
+		if got := compareVersions(tc.a, tc.b); got != tc.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+		if got := compareVersions(tc.b, tc.a); got != -tc.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tc.b, tc.a, got, -tc.want)
+		}
+	}
+
+	// Builds sharing a versionCode fall back to the version name
+	a := BuildInfo{Version: "1.10.0", UploadTime: "2024-01-01T00:00:00Z"}
+	b := BuildInfo{Version: "1.9.0", UploadTime: "2024-01-02T00:00:00Z"}
+	if !buildBefore(b, a) || buildBefore(a, b) {
+		t.Errorf("buildBefore does not order 1.9.0 before 1.10.0")
+	}
+}
+
+func TestBuildOrderAndDowngrades(t *testing.T) {
+	router := setupTestServer(t)
+	// Upserts prepend, so the oldest build goes first
+	for _, build := range []BuildInfo{
+		{Version: "1.2", VersionCode: 12, Channel: "stable", FileName: "a.apk", UploadTime: "2024-01-01T00:00:00Z"},
+		{Version: "1.1", VersionCode: 11, Channel: "stable", FileName: "b.apk", UploadTime: "2024-01-02T00:00:00Z"},
+		{Version: "1.3", VersionCode: 13, Channel: "stable", FileName: "c.apk", UploadTime: "2024-01-03T00:00:00Z"},
+	} {
+		if err := repo.UpsertBuild("Demo", testApp("com.example.order"), build); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fileNames := func(builds []BuildInfo) string {
+		var names []string
+		for _, build := range builds {
+			names = append(names, build.FileName)
+		}
+		return strings.Join(names, " ")
+	}
+	if got := fileNames(appBuilds(t, router, "com.example.order")); got != "c.apk b.apk a.apk" {
+		t.Errorf("upload order = %s", got)
+	}
+	config.BuildOrder = sortByVersion
+	if got := fileNames(appBuilds(t, router, "com.example.order")); got != "c.apk a.apk b.apk" {
+		t.Errorf("version order = %s", got)
+	}
+	var byUpload struct {
+		Builds []BuildInfo `json:"builds"`
+	}
+	decodeJSON(t, serve(router, http.MethodGet, "/api/apps/com.example.order/builds?sort=upload"), &byUpload)
+	if got := fileNames(byUpload.Builds); got != "c.apk b.apk a.apk" {
+		t.Errorf("?sort=upload = %s", got)
+	}
+
+	// b.apk came after versionCode 12 and is flagged on the detail page
+	groups := groupBuildsByChannel(byUpload.Builds)
+	if len(groups) != 1 || fileNames(groups[0].Builds) != "c.apk a.apk b.apk" ||
+		len(groups[0].Downgrades) != 1 || groups[0].Downgrades["b.apk"] != 12 {
+		t.Fatalf("groups = %+v", groups)
+	}
+	rec := serve(router, http.MethodGet, "/app/com.example.order")
+	if rec.Code != http.StatusOK || strings.Count(rec.Body.String(), "版本降级") != 1 {
+		t.Errorf("detail page: status %d, downgrade notes %d", rec.Code, strings.Count(rec.Body.String(), "版本降级"))
+	}
+}
+
+func TestDowngradeScopeAndForce(t *testing.T) {
+	router := setupTestServer(t)
+	config.DuplicateUploads = duplicateStore
+	config.DowngradePolicy = downgradeStrict
+	config.DowngradeScope = downgradeScopeChannel
+	// The fixture has versionCode 1
+	build := BuildInfo{Version: "2.0", VersionCode: 2, Channel: "stable", FileName: "newer.apk", UploadTime: timestamp(time.Now())}
+	if err := repo.UpsertBuild("Demo", AppInfo{AppName: "Hello", PackageName: fixturePackage}, build); err != nil {
+		t.Fatal(err)
+	}
+
+	upload := func(channel, query string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		w.WriteField("projectName", "Demo")
+		w.WriteField("channel", channel)
+		part, _ := w.CreateFormFile("file", "helloworld.apk")
+		part.Write(fixtureAPK(t))
+		w.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/upload"+query, &body)
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	// Other channels are not compared under the channel scope
+	if rec := upload("beta", ""); rec.Code != http.StatusOK {
+		t.Errorf("beta: status %d: %s", rec.Code, rec.Body.String())
+	}
+	rec := upload("stable", "")
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "渠道 stable") || !strings.Contains(rec.Body.String(), "force=true") {
+		t.Errorf("stable: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := upload("stable", "?force=true"); rec.Code != http.StatusOK {
+		t.Errorf("forced: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The package scope compares with every channel
+	config.DowngradeScope = downgradeScopePackage
+	if rec := upload("alpha", ""); rec.Code != http.StatusConflict {
+		t.Errorf("package scope: status %d, want 409", rec.Code)
+	}
+}
+
+func TestLatestFollowsBuildOrder(t *testing.T) {
+	setupTestServer(t)
+	// The lower versionCode is uploaded last
+	for _, build := range []BuildInfo{
+		{Version: "1.2", VersionCode: 12, Channel: "stable", FileName: "a.apk", UploadTime: "2024-01-01T00:00:00Z"},
+		{Version: "1.1", VersionCode: 11, Channel: "stable", FileName: "b.apk", UploadTime: "2024-01-02T00:00:00Z"},
+	} {
+		if err := repo.UpsertBuild("Demo", testApp("com.example.order"), build); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, app, _ := repo.FindApp("com.example.order")
+
+	for _, tc := range []struct {
+		order, latest string
+	}{
+		{sortByUpload, "b.apk"},
+		{sortByVersion, "a.apk"},
+	} {
+		config.BuildOrder = tc.order
+		if latest, _, _ := latestBuildsByChannel("com.example.order"); latest["stable"].FileName != tc.latest {
+			t.Errorf("%s order: bundle latest = %s, want %s", tc.order, latest["stable"].FileName, tc.latest)
+		}
+		if first, _ := firstBuild(app.Builds); first.FileName != tc.latest {
+			t.Errorf("%s order: firstBuild = %s, want %s", tc.order, first.FileName, tc.latest)
+		}
+		expired := expiredBuilds("Demo", app, RetentionPolicy{KeepPerChannel: 1}, time.Now())
+		if len(expired) != 1 || expired[0].FileName == tc.latest {
+			t.Errorf("%s order: retention expires %+v, want to keep %s", tc.order, expired, tc.latest)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webhookRetryAttempts bounds how often a webhook delivery is retried
+const webhookRetryAttempts = 3
+
+// webhookRetryDelay is the backoff unit between delivery attempts; the
+// n-th retry waits n times as long
+var webhookRetryDelay = 2 * time.Second
+
+// errWebhookNotFound is returned when a project has no webhook of the ID
+var errWebhookNotFound = errors.New("Webhook 未找到")
+
+// Webhook is a URL registered on a project that receives a signed JSON POST
+// whenever a build of the project is uploaded or deleted
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Secret keys the X-Appdist-Signature HMAC; it is only returned when the
+	// webhook is created
+	Secret    string `json:"secret,omitempty"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// WebhookPayload is the JSON body POSTed to webhooks. The build fields are
+// empty when a whole app was deleted; the links only accompany uploads.
+type WebhookPayload struct {
+	Event       string `json:"event"` // "upload" or "delete"
+	ProjectName string `json:"projectName"`
+	AppName     string `json:"appName"`
+	PackageName string `json:"packageName"`
+	Version     string `json:"version,omitempty"`
+	VersionCode int32  `json:"versionCode,omitempty"`
+	Channel     string `json:"channel,omitempty"`
+	FileName    string `json:"fileName,omitempty"`
+	DetailURL   string `json:"detailURL,omitempty"`
+	DownloadURL string `json:"downloadURL,omitempty"`
+	QRCodeURL   string `json:"qrCodeURL,omitempty"`
+	Time        string `json:"time"`
+}
+
+// projectWebhooks returns a copy of the webhooks of projectName
+func projectWebhooks(projectName string) []Webhook {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if i := findProject(projectName); i >= 0 {
+		return slices.Clone(allProjects[i].Webhooks)
+	}
+	return nil
+}
+
+// uploadWebhookPayload describes a newly published build
+func uploadWebhookPayload(event BuildEvent) WebhookPayload {
+	return WebhookPayload{
+		Event:       eventUpload,
+		ProjectName: event.ProjectName,
+		AppName:     event.AppName,
+		PackageName: event.PackageName,
+		Version:     event.Build.Version,
+		VersionCode: event.Build.VersionCode,
+		Channel:     event.Build.Channel,
+		FileName:    event.Build.FileName,
+		DetailURL:   event.DetailURL,
+		DownloadURL: event.DownloadURL,
+		QRCodeURL:   event.QRCodeURL,
+	}
+}
+
+// notifyDelete sends a delete event for every removed catalog entry, or a
+// single one without build fields when a whole app was deleted. hooks are
+// looked up before the delete, since removing the last app also removes the
+// project.
+func notifyDelete(hooks []Webhook, projectName string, app AppEntry, plan DeletePlan, wholeApp bool) {
+	if len(hooks) == 0 {
+		return
+	}
+	base := WebhookPayload{
+		Event:       eventDelete,
+		ProjectName: projectName,
+		AppName:     app.AppName,
+		PackageName: app.PackageName,
+	}
+	if wholeApp {
+		go deliverWebhooks(hooks, base)
+		return
+	}
+	for _, build := range plan.Builds {
+		payload := base
+		payload.Version = build.Version
+		payload.VersionCode = build.VersionCode
+		payload.Channel = build.Channel
+		payload.FileName = build.FileName
+		go deliverWebhooks(hooks, payload)
+	}
+}
+
+// webhookSignature is the value of the X-Appdist-Signature header: the
+// hex HMAC-SHA256 of the body keyed by the webhook's secret
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhooks POSTs payload to every hook, retrying each with backoff
+// until it answers with a 2xx status. Failures are logged only.
+func deliverWebhooks(hooks []Webhook, payload WebhookPayload) {
+	payload.Time = timestamp(time.Now())
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Warn("编码 Webhook 数据失败", "error", err)
+		return
+	}
+	client := &http.Client{Timeout: config.WebhookTimeout}
+	for _, hook := range hooks {
+		go deliverWebhook(client, hook, payload.Event, body)
+	}
+}
+
+func deliverWebhook(client *http.Client, hook Webhook, event string, body []byte) {
+	delivery := newRequestID()
+	var err error
+	for attempt := 1; attempt <= webhookRetryAttempts; attempt++ {
+		if err = postWebhook(client, hook, event, delivery, body); err == nil {
+			return
+		}
+		slog.Warn("推送 Webhook 失败", "url", hook.URL, "attempt", attempt, "error", err)
+		if attempt < webhookRetryAttempts {
+			time.Sleep(time.Duration(attempt) * webhookRetryDelay)
+		}
+	}
+	slog.Error("Webhook 最终推送失败", "url", hook.URL, "error", err)
+}
+
+// postWebhook makes one delivery attempt. Retries of a delivery share its
+// X-Appdist-Delivery ID so receivers can drop duplicates.
+func postWebhook(client *http.Client, hook Webhook, event, delivery string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "app-distributor-webhook")
+	req.Header.Set("X-Appdist-Event", event)
+	req.Header.Set("X-Appdist-Delivery", delivery)
+	req.Header.Set("X-Appdist-Signature", webhookSignature(hook.Secret, body))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// listedWebhooks hides the secrets of hooks
+func listedWebhooks(hooks []Webhook) []Webhook {
+	listed := make([]Webhook, len(hooks))
+	for i, hook := range hooks {
+		hook.Secret = ""
+		listed[i] = hook
+	}
+	return listed
+}
+
+// handleListWebhooks serves GET /api/projects/:projectName/webhooks
+func handleListWebhooks(c *gin.Context) {
+	if !checkAdmin(c) {
+		return
+	}
+	projectName := c.Param("projectName")
+	mutex.Lock()
+	i := findProject(projectName)
+	var hooks []Webhook
+	if i >= 0 {
+		hooks = listedWebhooks(allProjects[i].Webhooks)
+	}
+	mutex.Unlock()
+	if i < 0 {
+		respondError(c, http.StatusNotFound, "项目未找到")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"projectName": projectName, "webhooks": hooks})
+}
+
+// handleCreateWebhook registers a webhook with {"url": "...", "secret": "..."}.
+// Without a secret one is generated; either way it is only returned here.
+func handleCreateWebhook(c *gin.Context) {
+	if !checkAdmin(c) {
+		return
+	}
+	var req struct {
+		URL    string `json:"url"`
+		Secret string `json:"secret"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "请求体格式错误，需要 url 字段")
+		return
+	}
+	target, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		respondError(c, http.StatusBadRequest, "Webhook 地址必须是 http 或 https URL")
+		return
+	}
+	random := make([]byte, 8+24)
+	if _, err := rand.Read(random); err != nil {
+		respondError(c, http.StatusInternalServerError, "无法创建 Webhook")
+		return
+	}
+	hook := Webhook{
+		ID:        hex.EncodeToString(random[:8]),
+		URL:       target.String(),
+		Secret:    req.Secret,
+		CreatedAt: timestamp(time.Now()),
+	}
+	if hook.Secret == "" {
+		hook.Secret = hex.EncodeToString(random[8:])
+	}
+	projectName := c.Param("projectName")
+
+	_, err = repo.UpdateProject(projectName, func(project *Project) error {
+		project.Webhooks = append(project.Webhooks, hook)
+		return nil
+	})
+	if err != nil {
+		respondRepositoryError(c, err)
+		return
+	}
+	logf(c, "项目 %s 已添加 Webhook %s", projectName, hook.URL)
+	c.JSON(http.StatusCreated, hook)
+}
+
+// handleDeleteWebhook serves DELETE /api/projects/:projectName/webhooks/:id
+func handleDeleteWebhook(c *gin.Context) {
+	if !checkAdmin(c) {
+		return
+	}
+	projectName := c.Param("projectName")
+	id := c.Param("id")
+
+	var removed Webhook
+	_, err := repo.UpdateProject(projectName, func(project *Project) error {
+		j := slices.IndexFunc(project.Webhooks, func(hook Webhook) bool { return hook.ID == id })
+		if j < 0 {
+			return errWebhookNotFound
+		}
+		removed = project.Webhooks[j]
+		project.Webhooks = slices.Delete(project.Webhooks, j, j+1)
+		return nil
+	})
+	if errors.Is(err, errWebhookNotFound) {
+		respondError(c, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondRepositoryError(c, err)
+		return
+	}
+	logf(c, "项目 %s 已删除 Webhook %s", projectName, removed.URL)
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook 已删除"})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhooks(t *testing.T) {
+	router := setupTestServer(t)
+	webhookRetryDelay = time.Millisecond
+	t.Cleanup(func() { webhookRetryDelay = 2 * time.Second })
+
+	type delivery struct {
+		payload   WebhookPayload
+		body      []byte
+		signature string
+	}
+	deliveries := make(chan delivery, 4)
+	var attempts atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first attempt fails, so every delivery is retried once
+		if attempts.Add(1)%2 == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var payload WebhookPayload
+		json.Unmarshal(body, &payload)
+		deliveries <- delivery{payload, body, r.Header.Get("X-Appdist-Signature")}
+	}))
+	defer receiver.Close()
+	receive := func() delivery {
+		t.Helper()
+		select {
+		case d := <-deliveries:
+			return d
+		case <-time.After(5 * time.Second):
+			t.Fatal("no webhook delivery")
+			return delivery{}
+		}
+	}
+
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "stable"); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/projects/Demo/webhooks",
+		strings.NewReader(`{"url":"`+receiver.URL+`","secret":"s3cret"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(adminPasswordHeader, testAdminPassword)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create webhook: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var hook Webhook
+	decodeJSON(t, rec, &hook)
+
+	build := appBuilds(t, router, fixturePackage)[0]
+	if rec := serveAdmin(router, http.MethodDelete, "/api/builds/"+fixturePackage+"/"+build.FileName+"?keepApp=true"); rec.Code != http.StatusOK {
+		t.Fatalf("delete: status %d: %s", rec.Code, rec.Body.String())
+	}
+	d := receive()
+	if d.payload.Event != eventDelete || d.payload.FileName != build.FileName || d.payload.ProjectName != "Demo" {
+		t.Errorf("delete payload = %+v", d.payload)
+	}
+	if d.signature != webhookSignature("s3cret", d.body) {
+		t.Errorf("signature %q does not match the body", d.signature)
+	}
+
+	// Keeping the app keeps the project and its webhook for the next upload
+	if rec := uploadFixture(router, fixtureAPK(t), "Demo", "beta"); rec.Code != http.StatusOK {
+		t.Fatalf("second upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	d = receive()
+	if d.payload.Event != eventUpload || d.payload.Channel != "beta" || !strings.HasPrefix(d.payload.DownloadURL, "http://") || d.payload.QRCodeURL == "" {
+		t.Errorf("upload payload = %+v", d.payload)
+	}
+
+	rec = serveAdmin(router, http.MethodGet, "/api/projects/Demo/webhooks")
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "s3cret") {
+		t.Errorf("list: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serveAdmin(router, http.MethodDelete, "/api/projects/Demo/webhooks/"+hook.ID); rec.Code != http.StatusOK {
+		t.Errorf("delete webhook: status %d: %s", rec.Code, rec.Body.String())
+	}
+}